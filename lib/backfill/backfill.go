@@ -0,0 +1,213 @@
+// Package backfill seeds the history tables from Universalis sale history, so
+// a fresh deployment has trends and velocities to work with from the start.
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/universalis"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const day = 24 * time.Hour
+
+// DefaultBatchSize is how many items go in one history request. History
+// responses are much larger than price ones.
+const DefaultBatchSize = 20
+
+// Fetcher is universalis.Client.GetHistory, replaced in tests.
+type Fetcher func(ctx context.Context, worldID int, itemIDs []int, within time.Duration) (*universalis.HistoryData, error)
+
+// Store is where backfilled history goes, implemented by *postgres.Postgres.
+type Store interface {
+	BackfilledItems(ctx context.Context, worldID int, itemIDs []int, since time.Time) (map[int]bool, error)
+	WriteBackfill(ctx context.Context, b *postgres.BackfillBatch) (int, error)
+}
+
+type Config struct {
+	WorldIDs []int
+	ItemIDs  []int
+	// Window is how far back to backfill.
+	Window    time.Duration
+	BatchSize int
+}
+
+type Result struct {
+	Requests int
+	// Skipped counts item and world pairs an earlier run already backfilled.
+	Skipped    int
+	Backfilled int
+	Failed     int
+	Rows       int
+}
+
+type Backfiller struct {
+	store   Store
+	fetch   Fetcher
+	limiter *rate.Limiter
+	now     func() time.Time
+	logger  *zap.SugaredLogger
+}
+
+func NewBackfiller(store Store, fetch Fetcher, logger *zap.SugaredLogger) *Backfiller {
+	return &Backfiller{
+		store:   store,
+		fetch:   fetch,
+		limiter: rate.NewLimiter(universalis.RequestsPerSecond, universalis.RequestBurst),
+		now:     time.Now,
+		logger:  logger,
+	}
+}
+
+// Run backfills every item on every world, skipping pairs already backfilled
+// over the window. It carries on past failed requests, which a rerun retries,
+// and stops early only when ctx is done.
+func (b *Backfiller) Run(ctx context.Context, cfg Config) (*Result, error) {
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	now := b.now().UTC()
+	since := now.Add(-cfg.Window)
+
+	result := &Result{}
+	for _, worldID := range cfg.WorldIDs {
+		done, err := b.store.BackfilledItems(ctx, worldID, cfg.ItemIDs, since)
+		if err != nil {
+			return result, err
+		}
+		var pending []int
+		for _, itemID := range cfg.ItemIDs {
+			if done[itemID] {
+				result.Skipped += 1
+				continue
+			}
+			pending = append(pending, itemID)
+		}
+
+		for start := 0; start < len(pending); start += batchSize {
+			itemIDs := pending[start:min(start+batchSize, len(pending))]
+			if err := b.limiter.Wait(ctx); err != nil {
+				return result, err
+			}
+			result.Requests += 1
+			rows, err := b.backfillBatch(ctx, worldID, itemIDs, since, now, cfg.Window)
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			if err != nil {
+				b.logger.Errorw("failed to backfill batch",
+					"world_id", worldID,
+					"item_ids", itemIDs,
+					"suberror", err)
+				result.Failed += len(itemIDs)
+				continue
+			}
+			result.Backfilled += len(itemIDs)
+			result.Rows += rows
+		}
+		b.logger.Infow("backfilled world",
+			"world_id", worldID,
+			"result", result)
+	}
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%d item and world pairs failed to backfill, rerun to retry them", result.Failed)
+	}
+	return result, nil
+}
+
+func (b *Backfiller) backfillBatch(ctx context.Context, worldID int, itemIDs []int, since time.Time, now time.Time, window time.Duration) (int, error) {
+	history, err := b.fetch(ctx, worldID, itemIDs, window)
+	if err != nil {
+		return 0, err
+	}
+	batch := BuildBatch(worldID, itemIDs, since, now, history)
+	return b.store.WriteBackfill(ctx, batch)
+}
+
+// BuildBatch turns a history response into sales and daily snapshots. Only
+// days wholly inside the window and already over get a snapshot, so a later
+// run never finds a different snapshot for the same day. A snapshot's
+// velocities are that day's sale counts and its prices the cheapest sales.
+func BuildBatch(worldID int, itemIDs []int, since time.Time, now time.Time, history *universalis.HistoryData) *postgres.BackfillBatch {
+	batch := &postgres.BackfillBatch{
+		WorldID: worldID,
+		ItemIDs: itemIDs,
+		Since:   since,
+	}
+	today := now.Truncate(day)
+
+	for _, item := range history.Items {
+		days := make(map[time.Time]*postgres.HistorySnapshot)
+		for _, s := range item.Entries {
+			t := s.Time()
+			if t.Before(since) {
+				continue
+			}
+			batch.Sales = append(batch.Sales, postgres.Sale{
+				ItemID:       item.ItemID,
+				WorldID:      worldID,
+				Time:         t,
+				PricePerUnit: s.PricePerUnit,
+				Quantity:     s.Quantity,
+				HighQuality:  s.Hq,
+			})
+
+			start := t.Truncate(day)
+			if start.Before(since) || !start.Before(today) {
+				continue
+			}
+			snapshot, ok := days[start]
+			if !ok {
+				snapshot = &postgres.HistorySnapshot{
+					PriceID:    postgres.BackfillPriceID(item.ItemID, worldID, start),
+					ItemID:     item.ItemID,
+					WorldID:    worldID,
+					UpdateTime: start.Add(day - time.Second),
+				}
+				days[start] = snapshot
+			}
+			if s.Hq {
+				snapshot.HQSaleVelocity += 1
+				snapshot.MinPriceHQ = minPrice(snapshot.MinPriceHQ, s.PricePerUnit)
+			} else {
+				snapshot.NQSaleVelocity += 1
+				snapshot.MinPriceNQ = minPrice(snapshot.MinPriceNQ, s.PricePerUnit)
+			}
+		}
+		for _, snapshot := range days {
+			batch.Snapshots = append(batch.Snapshots, *snapshot)
+		}
+	}
+
+	sort.Slice(batch.Sales, func(i, j int) bool {
+		a, b := batch.Sales[i], batch.Sales[j]
+		if a.ItemID != b.ItemID {
+			return a.ItemID < b.ItemID
+		}
+		return a.Time.Before(b.Time)
+	})
+	sort.Slice(batch.Snapshots, func(i, j int) bool {
+		a, b := batch.Snapshots[i], batch.Snapshots[j]
+		if a.ItemID != b.ItemID {
+			return a.ItemID < b.ItemID
+		}
+		return a.UpdateTime.Before(b.UpdateTime)
+	})
+	return batch
+}
+
+// minPrice treats zero as no price yet, as the snapshot columns do.
+func minPrice(current int, price int) int {
+	if current == 0 || price < current {
+		return price
+	}
+	return current
+}
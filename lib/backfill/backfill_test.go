@@ -0,0 +1,182 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/universalis"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+var testNow = time.Date(2024, 7, 5, 6, 0, 0, 0, time.UTC)
+
+func sale(t time.Time, price int, hq bool) universalis.Sale {
+	return universalis.Sale{Hq: hq, PricePerUnit: price, Quantity: 1, Timestamp: t.Unix()}
+}
+
+func at(day int, hour int) time.Time {
+	return time.Date(2024, 7, day, hour, 0, 0, 0, time.UTC)
+}
+
+func TestBuildBatch(t *testing.T) {
+	since := testNow.Add(-3 * day)
+	history := &universalis.HistoryData{Items: map[string]universalis.ItemHistory{
+		"44000": {ItemID: 44000, WorldID: 63, Entries: []universalis.Sale{
+			sale(at(5, 1), 95, false),
+			sale(at(4, 12), 210, true),
+			sale(at(3, 20), 200, true),
+			sale(at(3, 15), 90, false),
+			sale(at(3, 9), 100, false),
+			sale(at(2, 10), 120, false),
+			sale(at(2, 5), 80, false),
+		}},
+	}}
+
+	batch := BuildBatch(63, []int{44000, 43976}, since, testNow, history)
+	if len(batch.Sales) != 6 {
+		t.Errorf("got %d sales, want the 6 inside the window", len(batch.Sales))
+	}
+	if first := batch.Sales[0]; !first.Time.Equal(at(2, 10)) || first.WorldID != 63 {
+		t.Errorf("first sale = %+v, want the oldest inside the window", first)
+	}
+
+	// The 2nd starts before the window and the 5th isn't over.
+	want := []postgres.HistorySnapshot{
+		{PriceID: postgres.BackfillPriceID(44000, 63, at(3, 0)), ItemID: 44000, WorldID: 63, UpdateTime: at(3, 0).Add(day - time.Second), NQSaleVelocity: 2, HQSaleVelocity: 1, MinPriceNQ: 90, MinPriceHQ: 200},
+		{PriceID: postgres.BackfillPriceID(44000, 63, at(4, 0)), ItemID: 44000, WorldID: 63, UpdateTime: at(4, 0).Add(day - time.Second), HQSaleVelocity: 1, MinPriceHQ: 210},
+	}
+	if len(batch.Snapshots) != len(want) {
+		t.Fatalf("got snapshots %+v, want %+v", batch.Snapshots, want)
+	}
+	for i := range want {
+		if batch.Snapshots[i] != want[i] {
+			t.Errorf("snapshot %d = %+v, want %+v", i, batch.Snapshots[i], want[i])
+		}
+	}
+}
+
+func TestBackfillPriceIDsDontCollide(t *testing.T) {
+	seen := make(map[int64]bool)
+	for _, itemID := range []int{1, 44000, 1<<20 - 1} {
+		for _, worldID := range []int{1, 63, 4028} {
+			for _, d := range []time.Time{at(1, 0), at(2, 0), at(2, 0).AddDate(5, 0, 0)} {
+				id := postgres.BackfillPriceID(itemID, worldID, d)
+				if id >= 0 || seen[id] {
+					t.Errorf("BackfillPriceID(%d, %d, %v) = %d, want a new negative ID", itemID, worldID, d, id)
+				}
+				seen[id] = true
+			}
+		}
+	}
+}
+
+type progressKey struct {
+	itemID  int
+	worldID int
+}
+
+// fakeStore keeps progress like backfill_progress does, and counts writes.
+type fakeStore struct {
+	progress map[progressKey]time.Time
+	batches  []*postgres.BackfillBatch
+}
+
+func (s *fakeStore) BackfilledItems(ctx context.Context, worldID int, itemIDs []int, since time.Time) (map[int]bool, error) {
+	done := make(map[int]bool)
+	for _, itemID := range itemIDs {
+		if t, ok := s.progress[progressKey{itemID, worldID}]; ok && !t.After(since) {
+			done[itemID] = true
+		}
+	}
+	return done, nil
+}
+
+func (s *fakeStore) WriteBackfill(ctx context.Context, b *postgres.BackfillBatch) (int, error) {
+	s.batches = append(s.batches, b)
+	for _, itemID := range b.ItemIDs {
+		s.progress[progressKey{itemID, b.WorldID}] = b.Since
+	}
+	return len(b.Sales) + len(b.Snapshots), nil
+}
+
+type fakeFetcher struct {
+	requests [][]int
+	failOn   map[int]error
+}
+
+func (f *fakeFetcher) fetch(ctx context.Context, worldID int, itemIDs []int, within time.Duration) (*universalis.HistoryData, error) {
+	f.requests = append(f.requests, itemIDs)
+	if err := f.failOn[worldID]; err != nil {
+		return nil, err
+	}
+	history := &universalis.HistoryData{Items: map[string]universalis.ItemHistory{}}
+	for _, itemID := range itemIDs {
+		history.Items[strconv.Itoa(itemID)] = universalis.ItemHistory{ItemID: itemID, WorldID: worldID, Entries: []universalis.Sale{
+			sale(at(4, 12), 100, false),
+		}}
+	}
+	return history, nil
+}
+
+func newTestBackfiller(s Store, f *fakeFetcher) *Backfiller {
+	b := NewBackfiller(s, f.fetch, zap.NewNop().Sugar())
+	b.limiter = rate.NewLimiter(rate.Inf, 1)
+	b.now = func() time.Time { return testNow }
+	return b
+}
+
+func TestRunResumesAfterFailure(t *testing.T) {
+	s := &fakeStore{progress: make(map[progressKey]time.Time)}
+	f := &fakeFetcher{failOn: map[int]error{40: universalis.ErrRateLimited}}
+	cfg := Config{
+		WorldIDs:  []int{63, 40},
+		ItemIDs:   []int{1, 2, 3},
+		Window:    3 * day,
+		BatchSize: 2,
+	}
+
+	result, err := newTestBackfiller(s, f).Run(context.Background(), cfg)
+	if err == nil {
+		t.Error("expected an error for the failed world")
+	}
+	if result.Requests != 4 || result.Backfilled != 3 || result.Failed != 3 || result.Rows != 6 {
+		t.Errorf("first run = %+v, want 4 requests, 3 backfilled, 3 failed, 6 rows", result)
+	}
+
+	f.failOn = nil
+	result, err = newTestBackfiller(s, f).Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("rerun = %v", err)
+	}
+	if result.Requests != 2 || result.Skipped != 3 || result.Backfilled != 3 {
+		t.Errorf("rerun = %+v, want world 63 skipped and world 40 backfilled in 2 requests", result)
+	}
+
+	// A longer window isn't covered by what's been done.
+	cfg.Window = 7 * day
+	result, _ = newTestBackfiller(s, f).Run(context.Background(), cfg)
+	if result.Skipped != 0 || result.Backfilled != 6 {
+		t.Errorf("run over a longer window = %+v, want everything backfilled again", result)
+	}
+}
+
+func TestRunStopsWhenCancelled(t *testing.T) {
+	s := &fakeStore{progress: make(map[progressKey]time.Time)}
+	f := &fakeFetcher{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := newTestBackfiller(s, f).Run(ctx, Config{WorldIDs: []int{63}, ItemIDs: []int{1}, Window: day})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() = %v, want context.Canceled", err)
+	}
+	if result.Requests != 0 || len(s.batches) != 0 {
+		t.Errorf("Run() = %+v after cancellation, want nothing done", result)
+	}
+}
@@ -0,0 +1,140 @@
+// Package costing prices a recipe for each realistic way of crafting it. The
+// qualities of the materials bought decide the cost, and the quality of the
+// result decides what it sells for.
+package costing
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Prices are the cheapest listings of an item, zero where there are none.
+type Prices struct {
+	NQ int
+	HQ int
+}
+
+type Material struct {
+	Name            string
+	Count           int
+	HighQualityable bool
+	Prices
+	// Free materials cost nothing, e.g. crystals the crafter gathers.
+	Free bool
+}
+
+type Recipe struct {
+	Name string
+	// How many of the result one craft makes.
+	Yield           int
+	HighQualityable bool
+	Prices
+	Materials []*Material
+}
+
+// Strategy is which materials are bought HQ and which quality is sold.
+type Strategy struct {
+	Name string
+	// Names of the materials bought HQ, the rest are bought NQ.
+	HQMaterials []string
+	HQResult    bool
+}
+
+// Strategies lists the ways to craft the recipe:
+//   - NQ materials for an NQ result.
+//   - Every HQ-able material bought HQ, which guarantees an HQ result.
+//   - The mixed materials bought HQ for an HQ result, leaving the rest of the
+//     quality to the crafter. With none that's NQ materials for an HQ result.
+//
+// Results that don't come in HQ only get the first, and the second is left out
+// when no material comes in HQ. Mixed names are matched case insensitively and
+// must be HQ-able materials of the recipe.
+func Strategies(r *Recipe, mixed []string) ([]*Strategy, error) {
+	strategies := []*Strategy{{Name: "NQ materials, NQ result"}}
+	if !r.HighQualityable {
+		if len(mixed) > 0 {
+			return nil, fmt.Errorf("%s doesn't come in HQ, so no materials are worth buying HQ", r.Name)
+		}
+		return strategies, nil
+	}
+
+	var hqable []string
+	for _, m := range r.Materials {
+		if m.HighQualityable {
+			hqable = append(hqable, m.Name)
+		}
+	}
+	if len(hqable) > 0 {
+		strategies = append(strategies, &Strategy{Name: "HQ materials, HQ result", HQMaterials: hqable, HQResult: true})
+	}
+
+	var hqMixed []string
+	for _, name := range mixed {
+		m := r.material(name)
+		if m == nil {
+			return nil, fmt.Errorf("%s isn't a material of %s", strings.TrimSpace(name), r.Name)
+		}
+		if !m.HighQualityable {
+			return nil, fmt.Errorf("%s doesn't come in HQ", m.Name)
+		}
+		hqMixed = append(hqMixed, m.Name)
+	}
+	// Every HQ-able material is the HQ materials strategy again.
+	if len(hqMixed) > 0 && len(hqMixed) == len(hqable) {
+		return strategies, nil
+	}
+	name := "NQ materials, HQ result"
+	if len(hqMixed) > 0 {
+		name = fmt.Sprintf("HQ %s, HQ result", strings.Join(hqMixed, " and "))
+	}
+	return append(strategies, &Strategy{Name: name, HQMaterials: hqMixed, HQResult: true}), nil
+}
+
+func (r *Recipe) material(name string) *Material {
+	for _, m := range r.Materials {
+		if strings.EqualFold(m.Name, strings.TrimSpace(name)) {
+			return m
+		}
+	}
+	return nil
+}
+
+type Costing struct {
+	Strategy *Strategy
+	Cost     int
+	// What the craft's whole yield sells for, before fees.
+	Sale int
+	// Items without the listings the strategy needs, when Cost and Sale are
+	// missing their prices.
+	Missing []string
+}
+
+func (c *Costing) Profit() int {
+	return c.Sale - c.Cost
+}
+
+// Cost prices one craft of the recipe with the strategy.
+func Cost(r *Recipe, s *Strategy) *Costing {
+	c := &Costing{Strategy: s}
+	price := func(name string, p Prices, hq bool) int {
+		if hq {
+			if p.HQ == 0 {
+				c.Missing = append(c.Missing, name+" (HQ)")
+			}
+			return p.HQ
+		}
+		if p.NQ == 0 {
+			c.Missing = append(c.Missing, name)
+		}
+		return p.NQ
+	}
+	c.Sale = r.Yield * price(r.Name, r.Prices, s.HQResult)
+	for _, m := range r.Materials {
+		if m.Free {
+			continue
+		}
+		c.Cost += m.Count * price(m.Name, m.Prices, slices.Contains(s.HQMaterials, m.Name))
+	}
+	return c
+}
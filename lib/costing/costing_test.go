@@ -0,0 +1,144 @@
+package costing
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testRecipe() *Recipe {
+	return &Recipe{
+		Name:            "Rroneek Steak",
+		Yield:           3,
+		HighQualityable: true,
+		Prices:          Prices{NQ: 1000, HQ: 1500},
+		Materials: []*Material{
+			{Name: "Rroneek Chuck", Count: 2, HighQualityable: true, Prices: Prices{NQ: 400, HQ: 600}},
+			{Name: "Dawntrail Trout", Count: 1, HighQualityable: true, Prices: Prices{NQ: 100, HQ: 300}},
+			{Name: "Rock Salt", Count: 1, Prices: Prices{NQ: 10}},
+		},
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	tests := []struct {
+		name   string
+		recipe func(*Recipe)
+		mixed  []string
+		want   []string
+	}{
+		{
+			name: "no mix",
+			want: []string{
+				"NQ materials, NQ result: cost 910, sale 3000",
+				"HQ materials, HQ result: cost 1510, sale 4500",
+				"NQ materials, HQ result: cost 910, sale 4500",
+			},
+		},
+		{
+			name:  "mixed",
+			mixed: []string{" rroneek chuck"},
+			want: []string{
+				"NQ materials, NQ result: cost 910, sale 3000",
+				"HQ materials, HQ result: cost 1510, sale 4500",
+				"HQ Rroneek Chuck, HQ result: cost 1310, sale 4500",
+			},
+		},
+		{
+			name:  "mix of every HQ-able material",
+			mixed: []string{"Dawntrail Trout", "Rroneek Chuck"},
+			want: []string{
+				"NQ materials, NQ result: cost 910, sale 3000",
+				"HQ materials, HQ result: cost 1510, sale 4500",
+			},
+		},
+		{
+			name: "no HQ-able materials",
+			recipe: func(r *Recipe) {
+				r.Materials = r.Materials[2:]
+			},
+			want: []string{
+				"NQ materials, NQ result: cost 10, sale 3000",
+				"NQ materials, HQ result: cost 10, sale 4500",
+			},
+		},
+		{
+			// Free materials cost nothing even without listings.
+			name: "free material",
+			recipe: func(r *Recipe) {
+				r.Materials[2].Free = true
+				r.Materials[2].NQ = 0
+			},
+			want: []string{
+				"NQ materials, NQ result: cost 900, sale 3000",
+				"HQ materials, HQ result: cost 1500, sale 4500",
+				"NQ materials, HQ result: cost 900, sale 4500",
+			},
+		},
+		{
+			name: "result without HQ",
+			recipe: func(r *Recipe) {
+				r.HighQualityable = false
+			},
+			want: []string{"NQ materials, NQ result: cost 910, sale 3000"},
+		},
+		{
+			name: "missing listings",
+			recipe: func(r *Recipe) {
+				r.Materials[1].HQ = 0
+				r.NQ = 0
+			},
+			want: []string{
+				"NQ materials, NQ result: cost 910, sale 0, missing [Rroneek Steak]",
+				"HQ materials, HQ result: cost 1210, sale 4500, missing [Dawntrail Trout (HQ)]",
+				"NQ materials, HQ result: cost 910, sale 4500",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRecipe()
+			if tt.recipe != nil {
+				tt.recipe(r)
+			}
+			strategies, err := Strategies(r, tt.mixed)
+			if err != nil {
+				t.Fatalf("Strategies() = %v", err)
+			}
+			var got []string
+			for _, s := range strategies {
+				c := Cost(r, s)
+				line := fmt.Sprintf("%s: cost %d, sale %d", s.Name, c.Cost, c.Sale)
+				if len(c.Missing) > 0 {
+					line += fmt.Sprintf(", missing %v", c.Missing)
+				}
+				got = append(got, line)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStrategiesRejectsBadMixes(t *testing.T) {
+	tests := []struct {
+		name   string
+		result bool
+		mixed  []string
+		want   string
+	}{
+		{name: "unknown", result: true, mixed: []string{"Salt"}, want: "Salt isn't a material of Rroneek Steak"},
+		{name: "no HQ", result: true, mixed: []string{"rock salt"}, want: "Rock Salt doesn't come in HQ"},
+		{name: "result without HQ", mixed: []string{"Rroneek Chuck"}, want: "Rroneek Steak doesn't come in HQ, so no materials are worth buying HQ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRecipe()
+			r.HighQualityable = tt.result
+			_, err := Strategies(r, tt.mixed)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Strategies() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
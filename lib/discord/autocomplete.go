@@ -0,0 +1,194 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"profiteeringway/lib/itemsearch"
+	"profiteeringway/lib/postgres"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// Discord rejects autocomplete responses with more than 25 choices.
+	maxAutocompleteChoices = 25
+	// Choice names are capped at 100 characters.
+	maxChoiceNameLength = 100
+	// Loose enough to catch a couple of typos, tight enough to skip noise.
+	autocompleteMinScore = 0.2
+	didYouMeanMinScore   = 0.3
+	didYouMeanCount      = 3
+)
+
+// loadItemIndex builds the fuzzy item search index from the items table.
+func (dc *Discord) loadItemIndex(ctx context.Context) error {
+	names, err := dc.store.AllItemNames(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load item names for search index: %w", err)
+	}
+
+	entries := make([]itemsearch.Entry, 0, len(names))
+	for _, n := range names {
+		entries = append(entries, itemsearch.Entry{
+			ItemID: n.ItemID,
+			Name:   n.Name,
+		})
+	}
+	dc.items = itemsearch.NewIndex(entries)
+	dc.logger.Infow("built item search index",
+		"item_count", dc.items.Len())
+	return nil
+}
+
+func (dc *Discord) handleAutocomplete(ctx context.Context, ic *discordgo.InteractionCreate) {
+	commandData := ic.ApplicationCommandData()
+
+	// Subcommand options are nested one level down.
+	options := commandData.Options
+	if len(options) == 1 && options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+		options = options[0].Options
+	}
+	var focused *discordgo.ApplicationCommandInteractionDataOption
+	for _, option := range options {
+		if option.Focused {
+			focused = option
+			break
+		}
+	}
+	if focused == nil {
+		dc.logger.Warnw(logWithEvent(interactionCreateEventName, "autocomplete without a focused option"),
+			"command_name", commandData.Name)
+		return
+	}
+
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	switch focused.Name {
+	case "item_name", "recipe_item":
+		choices = dc.itemNameChoices(focused.StringValue())
+	case "world_name":
+		choices = dc.scopeChoices(focused.StringValue())
+	case "datacenter":
+		choices = dc.scopeChoices(focused.StringValue(), postgres.ScopeDatacenter)
+	case "home_world":
+		choices = dc.scopeChoices(focused.StringValue(), postgres.ScopeWorld)
+	case "home_datacenter":
+		choices = dc.scopeChoices(focused.StringValue(), postgres.ScopeDatacenter)
+	case "home_region":
+		choices = dc.scopeChoices(focused.StringValue(), postgres.ScopeRegion)
+	case "currency_name":
+		choices = dc.currencyChoices(focused.StringValue())
+	default:
+		dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected autocomplete option"),
+			"command_name", commandData.Name,
+			"option_name", focused.Name)
+	}
+
+	dc.respondAutocomplete(ctx, ic, choices)
+}
+
+func (dc *Discord) itemNameChoices(query string) []*discordgo.ApplicationCommandOptionChoice {
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	if dc.items == nil {
+		return choices
+	}
+	for _, match := range dc.items.Search(query, maxAutocompleteChoices, autocompleteMinScore) {
+		name := match.Name
+		if len(name) > maxChoiceNameLength {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  name,
+			Value: name,
+		})
+	}
+	return choices
+}
+
+// loadScopes caches every world, datacenter, and region for autocomplete.
+func (dc *Discord) loadScopes(ctx context.Context) error {
+	scopes, err := dc.store.AllScopes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load worlds for autocomplete: %w", err)
+	}
+	dc.scopes = scopes
+	return nil
+}
+
+// scopeChoices suggests worlds, datacenters, and regions, listing prefix matches
+// ahead of names that merely contain the query. Passing kinds limits suggestions
+// to those kinds of scope.
+func (dc *Discord) scopeChoices(query string, kinds ...postgres.ScopeKind) []*discordgo.ApplicationCommandOptionChoice {
+	query = strings.ToLower(strings.TrimSpace(query))
+	var prefixed, contained []*discordgo.ApplicationCommandOptionChoice
+	for _, scope := range dc.scopes {
+		if len(kinds) > 0 && !slices.Contains(kinds, scope.Kind) {
+			continue
+		}
+		name := strings.ToLower(scope.Name)
+		choice := &discordgo.ApplicationCommandOptionChoice{
+			Name:  fmt.Sprintf("%s (%s)", scope.Name, scope.Kind),
+			Value: scope.Name,
+		}
+		if strings.HasPrefix(name, query) {
+			prefixed = append(prefixed, choice)
+		} else if strings.Contains(name, query) {
+			contained = append(contained, choice)
+		}
+	}
+
+	choices := append(prefixed, contained...)
+	if len(choices) > maxAutocompleteChoices {
+		choices = choices[:maxAutocompleteChoices]
+	}
+	if choices == nil {
+		choices = []*discordgo.ApplicationCommandOptionChoice{}
+	}
+	return choices
+}
+
+// resolveScope validates a world, datacenter, or region option. On failure it
+// responds to the interaction itself, so callers should return without acking.
+func (dc *Discord) resolveScope(ctx context.Context, ic *discordgo.InteractionCreate, name string) (*postgres.Scope, bool) {
+	scope, err := dc.store.ResolveScope(ctx, name)
+	if err == nil {
+		return scope, true
+	}
+	if errors.Is(err, postgres.ErrUnknownScope) {
+		dc.respondInstant(ctx, ic, fmt.Sprintf("`%s` isn't a world, datacenter, or region I know of.", name))
+		return nil, false
+	}
+	dc.logger.Errorw("failed to resolve world scope",
+		"world_name", name,
+		"error", err)
+	dc.respondInstant(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+	return nil, false
+}
+
+// didYouMean returns a short suggestion line for a name that had no exact match,
+// or the empty string when nothing is close enough to suggest.
+func (dc *Discord) didYouMean(itemName string) string {
+	if dc.items == nil {
+		return ""
+	}
+	matches := dc.items.Search(itemName, didYouMeanCount, didYouMeanMinScore)
+	if len(matches) == 0 {
+		return ""
+	}
+	var names []string
+	for _, match := range matches {
+		names = append(names, fmt.Sprintf("`%s`", match.Name))
+	}
+	return fmt.Sprintf(" Did you mean %s?", strings.Join(names, ", "))
+}
+
+// resolveItemName maps a user supplied name onto the canonical item name and ID,
+// ignoring case and punctuation differences.
+func (dc *Discord) resolveItemName(itemName string) (itemsearch.Entry, bool) {
+	if dc.items == nil {
+		return itemsearch.Entry{}, false
+	}
+	return dc.items.Exact(itemName)
+}
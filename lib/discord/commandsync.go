@@ -0,0 +1,234 @@
+package discord
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// CommandChange is one command that differs between the code and Discord.
+type CommandChange struct {
+	Name string
+	// One of "create", "update", or "delete".
+	Action string
+	// For updates, one line per differing field.
+	Details []string
+}
+
+func (c CommandChange) String() string {
+	if len(c.Details) == 0 {
+		return fmt.Sprintf("%s /%s", c.Action, c.Name)
+	}
+	return fmt.Sprintf("%s /%s:\n  %s", c.Action, c.Name, strings.Join(c.Details, "\n  "))
+}
+
+// flattenCommand maps every field Discord stores for a command onto a dotted
+// path, so two definitions can be compared key by key. Fields Discord fills in
+// itself (IDs, versions) are left out, and zero values are omitted so a field
+// Discord echoes back as its default still compares equal to one left unset.
+func flattenCommand(cmd *discordgo.ApplicationCommand) map[string]string {
+	fields := make(map[string]string)
+	put := func(key, value string) {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+
+	cmdType := cmd.Type
+	if cmdType == 0 {
+		cmdType = discordgo.ChatApplicationCommand
+	}
+	put("type", fmt.Sprint(cmdType))
+	put("description", cmd.Description)
+	if cmd.DefaultMemberPermissions != nil {
+		put("default_member_permissions", fmt.Sprint(*cmd.DefaultMemberPermissions))
+	}
+	if cmd.DMPermission != nil && !*cmd.DMPermission {
+		put("dm_permission", "false")
+	}
+	if cmd.NSFW != nil && *cmd.NSFW {
+		put("nsfw", "true")
+	}
+	flattenOptions("options", cmd.Options, put)
+	return fields
+}
+
+func flattenOptions(prefix string, options []*discordgo.ApplicationCommandOption, put func(key, value string)) {
+	var names []string
+	for _, option := range options {
+		names = append(names, option.Name)
+		key := fmt.Sprintf("%s.%s", prefix, option.Name)
+		put(key+".type", fmt.Sprint(option.Type))
+		put(key+".description", option.Description)
+		if option.Required {
+			put(key+".required", "true")
+		}
+		if option.Autocomplete {
+			put(key+".autocomplete", "true")
+		}
+		var choices []string
+		for _, choice := range option.Choices {
+			choices = append(choices, fmt.Sprintf("%s=%s", choice.Name, choiceValue(choice.Value)))
+		}
+		put(key+".choices", strings.Join(choices, ", "))
+		var channelTypes []string
+		for _, channelType := range option.ChannelTypes {
+			channelTypes = append(channelTypes, fmt.Sprint(channelType))
+		}
+		put(key+".channel_types", strings.Join(channelTypes, ", "))
+		if option.MinValue != nil {
+			put(key+".min_value", fmt.Sprint(*option.MinValue))
+		}
+		if option.MaxValue != 0 {
+			put(key+".max_value", fmt.Sprint(option.MaxValue))
+		}
+		if option.MinLength != nil {
+			put(key+".min_length", fmt.Sprint(*option.MinLength))
+		}
+		if option.MaxLength != 0 {
+			put(key+".max_length", fmt.Sprint(option.MaxLength))
+		}
+		flattenOptions(key+".options", option.Options, put)
+	}
+	// Discord shows options in the order they're given, so order is part of the definition.
+	put(prefix, strings.Join(names, ", "))
+}
+
+// choiceValue prints a choice value the same way whether it came from the code
+// or was decoded from Discord's JSON, where every number is a float64.
+func choiceValue(value interface{}) string {
+	switch v := value.(type) {
+	case int:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case int64:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// diffCommand lists the fields of registered that differ from want.
+func diffCommand(want, registered *discordgo.ApplicationCommand) []string {
+	wantFields := flattenCommand(want)
+	registeredFields := flattenCommand(registered)
+
+	var keys []string
+	for key := range wantFields {
+		keys = append(keys, key)
+	}
+	for key := range registeredFields {
+		if _, ok := wantFields[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var details []string
+	for _, key := range keys {
+		if wantFields[key] == registeredFields[key] {
+			continue
+		}
+		details = append(details, fmt.Sprintf("%s: %q -> %q", key, registeredFields[key], wantFields[key]))
+	}
+	return details
+}
+
+// DiffCommands compares the commands the code defines against those Discord has
+// registered, returning nothing when they match. Duplicate registrations of a
+// name count as a change, since overwriting is the only way to clear them.
+func DiffCommands(want, registered []*discordgo.ApplicationCommand) []CommandChange {
+	byName := make(map[string][]*discordgo.ApplicationCommand)
+	for _, cmd := range registered {
+		byName[cmd.Name] = append(byName[cmd.Name], cmd)
+	}
+
+	var changes []CommandChange
+	wanted := make(map[string]bool)
+	for _, cmd := range want {
+		wanted[cmd.Name] = true
+		existing := byName[cmd.Name]
+		if len(existing) == 0 {
+			changes = append(changes, CommandChange{Name: cmd.Name, Action: "create"})
+			continue
+		}
+		details := diffCommand(cmd, existing[0])
+		if len(existing) > 1 {
+			details = append(details, fmt.Sprintf("registered %d times", len(existing)))
+		}
+		if len(details) > 0 {
+			changes = append(changes, CommandChange{Name: cmd.Name, Action: "update", Details: details})
+		}
+	}
+
+	var stale []string
+	for name := range byName {
+		if !wanted[name] {
+			stale = append(stale, name)
+		}
+	}
+	slices.Sort(stale)
+	for _, name := range stale {
+		changes = append(changes, CommandChange{Name: name, Action: "delete"})
+	}
+	return changes
+}
+
+func commandScopeName(guildID string) string {
+	if guildID == "" {
+		return "global"
+	}
+	return fmt.Sprintf("guild %s", guildID)
+}
+
+// SyncCommands brings the commands registered with Discord in line with
+// AllCommands, globally when guildID is empty or else in that one guild. Nothing
+// is written when the definitions already match or when dryRun is set; either
+// way the changes found are returned.
+func (dc *Discord) SyncCommands(guildID string, dryRun bool) ([]CommandChange, error) {
+	registered, err := dc.client.ApplicationCommands(dc.appID, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve %s application commands: %w", commandScopeName(guildID), err)
+	}
+
+	want := AllCommands()
+	changes := DiffCommands(want, registered)
+	if len(changes) == 0 || dryRun {
+		return changes, nil
+	}
+
+	if _, err := dc.client.ApplicationCommandBulkOverwrite(dc.appID, guildID, want); err != nil {
+		return changes, fmt.Errorf("failed to overwrite %s application commands: %w", commandScopeName(guildID), err)
+	}
+	return changes, nil
+}
+
+// syncCommands is SyncCommands for the gateway handlers, which can only log.
+func (dc *Discord) syncCommands(guildID string) {
+	changes, err := dc.SyncCommands(guildID, false)
+	if err != nil {
+		dc.logger.Errorw("failed to sync application commands",
+			"scope", commandScopeName(guildID),
+			"suberror", err,
+		)
+		return
+	}
+	if len(changes) == 0 {
+		dc.logger.Infow("application commands already up to date",
+			"scope", commandScopeName(guildID),
+		)
+		return
+	}
+	for _, change := range changes {
+		dc.logger.Infow("synced application command",
+			"scope", commandScopeName(guildID),
+			"command", change.Name,
+			"action", change.Action,
+			"details", change.Details,
+		)
+	}
+}
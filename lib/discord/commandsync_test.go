@@ -0,0 +1,82 @@
+package discord
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// roundTrip returns the commands as Discord would echo them back.
+func roundTrip(t *testing.T, cmds []*discordgo.ApplicationCommand) []*discordgo.ApplicationCommand {
+	t.Helper()
+	b, err := json.Marshal(cmds)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var out []*discordgo.ApplicationCommand
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	for i, cmd := range out {
+		cmd.ID = string(rune('a' + i))
+		cmd.Version = "1"
+	}
+	return out
+}
+
+func TestDiffCommandsUnchanged(t *testing.T) {
+	registered := roundTrip(t, AllCommands())
+	if changes := DiffCommands(AllCommands(), registered); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changes)
+	}
+}
+
+func TestDiffCommandsDetectsChanges(t *testing.T) {
+	registered := roundTrip(t, []*discordgo.ApplicationCommand{CommandLookup(), CommandLookup(), CommandPollStatus()})
+	registered = append(registered, &discordgo.ApplicationCommand{Name: "retired", Description: "gone"})
+
+	lookup := CommandLookup()
+	lookup.Options[0].Required = true
+	want := []*discordgo.ApplicationCommand{lookup, CommandPollStatus(), CommandSettings()}
+
+	changes := DiffCommands(want, registered)
+	got := make(map[string]CommandChange)
+	for _, change := range changes {
+		got[change.Name] = change
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 changes, got %v", changes)
+	}
+	if c := got[COMMAND_LOOKUP]; c.Action != "update" || len(c.Details) != 2 {
+		t.Errorf("expected lookup update with required and duplicate details, got %v", c)
+	}
+	if c := got[COMMAND_SETTINGS]; c.Action != "create" {
+		t.Errorf("expected settings create, got %v", c)
+	}
+	if c := got["retired"]; c.Action != "delete" {
+		t.Errorf("expected retired delete, got %v", c)
+	}
+}
+
+func TestSyncCommandsOverwritesOnlyOnChange(t *testing.T) {
+	dc, session := newTestDiscord(testStore())
+
+	changes, err := dc.SyncCommands("guild", true)
+	if err != nil || len(changes) != len(AllCommands()) || session.overwrites != 0 {
+		t.Fatalf("dry run: changes %v, err %v, overwrites %d", changes, err, session.overwrites)
+	}
+
+	if _, err := dc.SyncCommands("guild", false); err != nil || session.overwrites != 1 {
+		t.Fatalf("first sync: err %v, overwrites %d", err, session.overwrites)
+	}
+	session.commands["guild"] = roundTrip(t, session.commands["guild"])
+
+	changes, err = dc.SyncCommands("guild", false)
+	if err != nil || len(changes) != 0 || session.overwrites != 1 {
+		t.Fatalf("second sync: changes %v, err %v, overwrites %d", changes, err, session.overwrites)
+	}
+	if changes, _ := dc.SyncCommands("", true); len(changes) == 0 {
+		t.Fatal("expected global commands to be unsynced")
+	}
+}
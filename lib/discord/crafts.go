@@ -0,0 +1,233 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"profiteeringway/lib/postgres"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+const (
+	defaultCraftsLimit = 25
+	maxCraftsLimit     = 500
+)
+
+var craftsSortNames = map[postgres.CraftProfitSort]string{
+	postgres.CraftProfitSortProfit: "profit per craft",
+	postgres.CraftProfitSortDaily:  "profit per day",
+	postgres.CraftProfitSortMargin: "margin",
+}
+
+func CommandCrafts() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Type:        discordgo.ChatApplicationCommand,
+		Name:        COMMAND_CRAFTS,
+		Description: "Ranks every crafted item in a category by profit, pricing down each recipe. (version 2)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "world_name",
+				Description:  "The world, datacenter, or region to buy and sell on, defaults to the server's home region.",
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "category",
+				Description: "The category of items to rank.",
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "Dawntrail crafted equipment", Value: postgres.SelectorDawntrailCraftedEquipment},
+					{Name: "Dawntrail consumables", Value: postgres.SelectorDawntrailConsumables},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "item_type",
+				Description: "Comma separated item types to rank instead of a category, e.g. Meal, Medicine.",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "min_item_level",
+				Description: "Skip items below this item level.",
+				MinValue:    &[]float64{0}[0],
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "max_item_level",
+				Description: "Skip items above this item level.",
+				MinValue:    &[]float64{1}[0],
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "sort_by",
+				Description: "What to rank by (default profit per craft).",
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "Profit per craft", Value: string(postgres.CraftProfitSortProfit)},
+					{Name: "Profit per day", Value: string(postgres.CraftProfitSortDaily)},
+					{Name: "Margin", Value: string(postgres.CraftProfitSortMargin)},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "free_crystals",
+				Description: "Count shards, crystals, and clusters as free, for when you gather your own.",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "limit",
+				Description: fmt.Sprintf("How many items to show (default %d).", defaultCraftsLimit),
+				MinValue:    &[]float64{1}[0],
+				MaxValue:    maxCraftsLimit,
+			},
+			formatOption(),
+		},
+	}
+}
+
+func craftsReport(rows []*postgres.CraftProfitRow, settings *effectiveSettings) *report {
+	r := &report{
+		header:       table.Row{"Item", "Type", "Item Level", "Sell as", "Cost", "Sale", "Gross profit", "Net profit", "Margin", "Sales per day"},
+		filterLabel:  "Type",
+		filterColumn: 1,
+		format:       settings.formatCell,
+	}
+	for _, row := range rows {
+		sellAs := "NQ"
+		if row.BestHighQuality {
+			sellAs = "HQ"
+		}
+		r.rows = append(r.rows, table.Row{
+			row.Name,
+			row.Type,
+			row.ItemLevel,
+			sellAs,
+			row.Cost(),
+			row.Sale(),
+			row.Profit,
+			settings.fees.Net(row.Sale()) - row.Cost(),
+			multiple(row.Margin()),
+			row.SaleVelocity,
+		})
+	}
+	return r
+}
+
+// craftsFilter narrows the items to rank to a category or item types. The
+// message is meant for the user and is non-empty when the options conflict.
+func craftsFilter(category string, itemTypes string, minItemLevel int, maxItemLevel int) (postgres.CraftProfitFilter, string) {
+	var filter postgres.CraftProfitFilter
+	filter.Items.MinItemLevel = minItemLevel
+	filter.Items.MaxItemLevel = maxItemLevel
+	switch {
+	case category != "" && itemTypes != "":
+		return filter, "Use one of `category` and `item_type`, not both."
+	case category == "" && itemTypes == "":
+		return filter, "At least one of `category` and `item_type` must be provided."
+	case category != "":
+		selector, ok := postgres.ItemSelectors[category]
+		if !ok {
+			return filter, fmt.Sprintf("`%s` isn't a category I know of.", category)
+		}
+		// Item levels given narrow the category's.
+		filter.Items.Types = selector.Types
+		filter.Items.MinItemLevel = max(filter.Items.MinItemLevel, selector.MinItemLevel)
+		if filter.Items.MaxItemLevel == 0 {
+			filter.Items.MaxItemLevel = selector.MaxItemLevel
+		}
+	default:
+		for _, itemType := range strings.Split(itemTypes, ",") {
+			if itemType = strings.TrimSpace(itemType); itemType != "" {
+				filter.Items.Types = append(filter.Items.Types, itemType)
+			}
+		}
+	}
+	if filter.Items.MaxItemLevel > 0 && filter.Items.MaxItemLevel < filter.Items.MinItemLevel {
+		return filter, "`max_item_level` can't be below `min_item_level`."
+	}
+	return filter, ""
+}
+
+func (dc *Discord) handleCrafts(ctx context.Context, ic *discordgo.InteractionCreate) {
+	commandData := ic.ApplicationCommandData()
+	var worldName, category, itemTypes, format string
+	var minItemLevel, maxItemLevel int
+	var sortBy postgres.CraftProfitSort
+	var freeCrystals bool
+	limit := defaultCraftsLimit
+	for _, option := range commandData.Options {
+		optName := option.Name
+		switch optName {
+		case "world_name":
+			worldName = option.StringValue()
+		case "category":
+			category = option.StringValue()
+		case "item_type":
+			itemTypes = option.StringValue()
+		case "min_item_level":
+			minItemLevel = int(option.IntValue())
+		case "max_item_level":
+			maxItemLevel = int(option.IntValue())
+		case "sort_by":
+			sortBy = postgres.CraftProfitSort(option.StringValue())
+		case "free_crystals":
+			freeCrystals = option.BoolValue()
+		case "limit":
+			limit = int(option.IntValue())
+		case "format":
+			format = option.StringValue()
+		default:
+			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected option name"),
+				"command_name", commandData.Name,
+				"option_name", optName)
+		}
+	}
+
+	filter, message := craftsFilter(category, itemTypes, minItemLevel, maxItemLevel)
+	if message != "" {
+		dc.respondInstant(ctx, ic, message)
+		return
+	}
+	filter.SortBy = sortBy
+	filter.FreeCrystals = freeCrystals
+	if filter.SortBy == "" {
+		filter.SortBy = postgres.CraftProfitSortProfit
+	}
+
+	settings, ok := dc.settingsFor(ctx, ic)
+	if !ok {
+		return
+	}
+	// Rank by the net profit the report leads with.
+	filter.Fees = settings.fees
+	scope, ok := dc.resolveScopeOrHome(ctx, ic, worldName, settings)
+	if !ok {
+		return
+	}
+
+	// Verified parameters, so ack the message while we compute.
+	dc.respondAck(ctx, ic)
+
+	rows, err := dc.store.CraftProfitRanking(ctx, scope, filter, limit)
+	if err != nil {
+		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to get craft profit ranking"),
+			"command_name", commandData.Name,
+			"database_error", err)
+		dc.respondFollowup(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+		return
+	}
+
+	if len(rows) == 0 {
+		dc.respondFollowup(ctx, ic, fmt.Sprintf("No craftable items matching that can be fully priced in %s right now.", scope.Name))
+		return
+	}
+
+	r := craftsReport(rows, settings)
+	r.title = fmt.Sprintf("Crafts in %s", scope.Name)
+	message = fmt.Sprintf("Crafts in %s by %s, net profits after %s:", scope.Name, craftsSortNames[filter.SortBy], settings.fees)
+	if filter.FreeCrystals {
+		message += " Crystals are counted as gathered, for free."
+	}
+	dc.respondFollowupWithReport(ctx, ic, message, r, settings.priceModel, format)
+}
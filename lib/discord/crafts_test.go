@@ -0,0 +1,114 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"profiteeringway/lib/postgres"
+	"testing"
+)
+
+func testCraftProfits() []*postgres.CraftProfitRow {
+	return []*postgres.CraftProfitRow{{
+		ItemID:          steakID,
+		Name:            "Rroneek Steak",
+		Type:            "Meal",
+		ItemLevel:       710,
+		Yield:           3,
+		HighQualityable: true,
+		MinPriceNQ:      850,
+		MinPriceHQ:      1400,
+		SaleVelocity:    150,
+		CostNQ:          274,
+		CostHQ:          274,
+		Profit:          3926,
+		BestHighQuality: true,
+	}}
+}
+
+func TestCrafts(t *testing.T) {
+	dbErr := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		options []*discordOption
+		rows    []*postgres.CraftProfitRow
+		errs    map[string]error
+		want    []string
+		// The filter the ranking was asked for, unchecked when empty.
+		wantFilter string
+	}{
+		{
+			name: "no items",
+			want: []string{"instant: At least one of `category` and `item_type` must be provided."},
+		},
+		{
+			name:    "category and item type",
+			options: []*discordOption{stringOption("category", postgres.SelectorDawntrailConsumables), stringOption("item_type", "Meal")},
+			want:    []string{"instant: Use one of `category` and `item_type`, not both."},
+		},
+		{
+			name:    "unknown category",
+			options: []*discordOption{stringOption("category", "glamour")},
+			want:    []string{"instant: `glamour` isn't a category I know of."},
+		},
+		{
+			name:    "inverted item levels",
+			options: []*discordOption{stringOption("item_type", "Meal"), intOption("min_item_level", 700), intOption("max_item_level", 690)},
+			want:    []string{"instant: `max_item_level` can't be below `min_item_level`."},
+		},
+		{
+			name:       "category",
+			options:    []*discordOption{stringOption("category", postgres.SelectorDawntrailConsumables), intOption("min_item_level", 720)},
+			rows:       testCraftProfits(),
+			wantFilter: "{Items:{Types:[Meal Medicine] MinItemLevel:720 MaxItemLevel:0} FreeCrystals:false SortBy:profit Fees:5% tax}",
+			want: []string{
+				"ack",
+				"followup: Crafts in North-America by profit per craft, net profits after 5% tax: | Crafts in North-America | Rroneek Steak: **Type**: Meal · **Item Level**: 710 · **Sell as**: HQ · **Cost**: 274 · **Sale**: 4200 · **Gross profit**: 3926 · **Net profit**: 3716 · **Margin**: 14.3x · **Sales per day**: 150",
+			},
+		},
+		{
+			name:       "item types",
+			options:    []*discordOption{stringOption("item_type", "Meal, Medicine,"), stringOption("sort_by", "daily"), boolOption("free_crystals", true)},
+			rows:       testCraftProfits(),
+			wantFilter: "{Items:{Types:[Meal Medicine] MinItemLevel:0 MaxItemLevel:0} FreeCrystals:true SortBy:daily Fees:5% tax}",
+			want: []string{
+				"ack",
+				"followup: Crafts in North-America by profit per day, net profits after 5% tax: Crystals are counted as gathered, for free.",
+			},
+		},
+		{
+			name:    "csv",
+			options: []*discordOption{stringOption("item_type", "Meal"), stringOption("format", "csv")},
+			rows:    testCraftProfits(),
+			want: []string{
+				"ack",
+				"Item,Type,Item Level,Sell as,Cost,Sale,Gross profit,Net profit,Margin,Sales per day\nRroneek Steak,Meal,710,HQ,274,4200,3926,3716,14.328467153284672,150\n",
+			},
+		},
+		{
+			name:    "nothing priced",
+			options: []*discordOption{stringOption("item_type", "Meal"), stringOption("world_name", "Gilgamesh")},
+			want:    []string{"ack", "followup: No craftable items matching that can be fully priced in Gilgamesh right now."},
+		},
+		{
+			name:    "ranking fails",
+			options: []*discordOption{stringOption("item_type", "Meal")},
+			errs:    map[string]error{"CraftProfitRanking": dbErr},
+			want:    []string{"ack", "followup: A database lookup error has occurred."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testStore()
+			dc, session := newTestDiscord(store)
+			store.errs = tt.errs
+			store.craftProfits = tt.rows
+			dispatch(dc, commandInteraction(COMMAND_CRAFTS, tt.options...))
+			checkSent(t, session.sent(), tt.want)
+			if tt.wantFilter != "" {
+				if got := fmt.Sprintf("%+v", store.craftFilter); got != tt.wantFilter {
+					t.Errorf("ranked with %s, want %s", got, tt.wantFilter)
+				}
+			}
+		})
+	}
+}
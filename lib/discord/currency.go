@@ -0,0 +1,181 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"profiteeringway/lib/postgres"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+const (
+	defaultCurrencyMinVelocity = 1
+	defaultCurrencyLimit       = 25
+	maxCurrencyLimit           = 100
+)
+
+func CommandCurrency() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Type:        discordgo.ChatApplicationCommand,
+		Name:        COMMAND_CURRENCY,
+		Description: "Ranks what a tomestone, scrip, or other currency buys by gil per currency. (version 3)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "currency_name",
+				Description:  "The currency to spend, e.g. Purple Crafters' Scrip.",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "world_name",
+				Description:  "The world, datacenter, or region to sell on, defaults to the server's home region.",
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "min_velocity",
+				Description: fmt.Sprintf("Skip items selling fewer than this many a day (default %d).", defaultCurrencyMinVelocity),
+				MinValue:    &[]float64{0}[0],
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "limit",
+				Description: fmt.Sprintf("How many items to show (default %d).", defaultCurrencyLimit),
+				MinValue:    &[]float64{1}[0],
+				MaxValue:    maxCurrencyLimit,
+			},
+			formatOption(),
+		},
+	}
+}
+
+// loadCurrencies caches the special shop currencies for autocomplete.
+func (dc *Discord) loadCurrencies(ctx context.Context) error {
+	currencies, err := dc.store.SpecialCurrencies(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load special currencies: %w", err)
+	}
+	dc.currencies = currencies
+	return nil
+}
+
+func (dc *Discord) currencyChoices(query string) []*discordgo.ApplicationCommandOptionChoice {
+	query = strings.ToLower(strings.TrimSpace(query))
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, currency := range dc.currencies {
+		if !strings.Contains(strings.ToLower(currency.Name), query) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  currency.Name,
+			Value: currency.Name,
+		})
+		if len(choices) == maxAutocompleteChoices {
+			break
+		}
+	}
+	return choices
+}
+
+func (dc *Discord) resolveCurrency(name string) (*postgres.ItemName, bool) {
+	for _, currency := range dc.currencies {
+		if strings.EqualFold(currency.Name, strings.TrimSpace(name)) {
+			return currency, true
+		}
+	}
+	return nil, false
+}
+
+// gilRate is gil per unit of something, shown to a tenth of a gil.
+type gilRate float64
+
+func (g gilRate) String() string {
+	return fmt.Sprintf("%.1f", float64(g))
+}
+
+func currencyValueReport(rows []*postgres.CurrencyValueRow, settings *effectiveSettings) *report {
+	r := &report{
+		header:       table.Row{"Item", "World", "Cost", "Market price", "Gross gil per currency", "Net gil per currency", "Sales per day"},
+		filterLabel:  "World",
+		filterColumn: 1,
+		format:       settings.formatCell,
+	}
+	for _, row := range rows {
+		r.rows = append(r.rows, table.Row{
+			row.Name,
+			row.WorldName,
+			row.CurrencyCount,
+			row.MinPrice,
+			gilRate(row.GilPerCurrency()),
+			gilRate(settings.fees.NetRate(row.GilPerCurrency())),
+			row.SaleVelocity,
+		})
+	}
+	return r
+}
+
+func (dc *Discord) handleCurrency(ctx context.Context, ic *discordgo.InteractionCreate) {
+	commandData := ic.ApplicationCommandData()
+	var currencyName, worldName, format string
+	minVelocity := defaultCurrencyMinVelocity
+	limit := defaultCurrencyLimit
+	for _, option := range commandData.Options {
+		optName := option.Name
+		switch optName {
+		case "currency_name":
+			currencyName = option.StringValue()
+		case "world_name":
+			worldName = option.StringValue()
+		case "min_velocity":
+			minVelocity = int(option.IntValue())
+		case "limit":
+			limit = int(option.IntValue())
+		case "format":
+			format = option.StringValue()
+		default:
+			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected option name"),
+				"command_name", commandData.Name,
+				"option_name", optName)
+		}
+	}
+
+	currency, ok := dc.resolveCurrency(currencyName)
+	if !ok {
+		dc.respondInstant(ctx, ic, fmt.Sprintf("`%s` isn't a currency any shop takes, pick one from the suggestions.", currencyName))
+		return
+	}
+
+	settings, ok := dc.settingsFor(ctx, ic)
+	if !ok {
+		return
+	}
+	scope, ok := dc.resolveScopeOrHome(ctx, ic, worldName, settings)
+	if !ok {
+		return
+	}
+
+	// Verified parameters, so ack the message while we compute.
+	dc.respondAck(ctx, ic)
+
+	rows, err := dc.store.CurrencyValue(ctx, currency.ItemID, scope, minVelocity, limit)
+	if err != nil {
+		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to get currency value"),
+			"command_name", commandData.Name,
+			"database_error", err)
+		dc.respondFollowup(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+		return
+	}
+
+	if len(rows) == 0 {
+		dc.respondFollowup(ctx, ic, fmt.Sprintf("Nothing bought with %s is selling in %s right now.", currency.Name, scope.Name))
+		return
+	}
+
+	r := currencyValueReport(rows, settings)
+	r.title = fmt.Sprintf("%s in %s", currency.Name, scope.Name)
+	dc.respondFollowupWithReport(ctx, ic, fmt.Sprintf("What to spend %s on in %s, net gil after %s:", currency.Name, scope.Name, settings.fees), r, settings.priceModel, format)
+}
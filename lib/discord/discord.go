@@ -3,9 +3,11 @@ package discord
 import (
 	"context"
 	"fmt"
+	"profiteeringway/lib/fees"
+	"profiteeringway/lib/itemsearch"
 	"profiteeringway/lib/postgres"
-	"profiteeringway/secrets"
 	"strings"
+	"sync"
 
 	"github.com/bwmarrin/discordgo"
 	"go.uber.org/zap"
@@ -15,21 +17,80 @@ const (
 	interactionCreateEventName string = "INTERACTION_CREATE"
 	COMMAND_LOOKUP             string = "lookup"
 	COMMAND_PRICEDOWN          string = "pricedown"
+	COMMAND_SHOPPING           string = "shopping"
+	COMMAND_VENDOR             string = "vendor"
+	COMMAND_CURRENCY           string = "currency"
+	COMMAND_GATHERING          string = "gathering"
+	COMMAND_CRAFTS             string = "crafts"
+	COMMAND_POLL_STATUS        string = "pollstatus"
+	COMMAND_SETTINGS           string = "settings"
 )
 
 type Discord struct {
-	client         *discordgo.Session
+	// The gateway connection, nil when only the REST API is used.
+	gateway        *discordgo.Session
+	client         Session
+	appID          string
 	logger         *zap.SugaredLogger
-	updateCommands bool
-	pg             *postgres.Postgres
+	globalCommands bool
+	store          PriceStore
+	items          *itemsearch.Index
+	scopes         []*postgres.Scope
+	currencies     []*postgres.ItemName
+	interest       InterestRecorder
+	limits         *commandLimits
+	feeRates       fees.Rates
+
+	// Reports whose buttons and menus can still be used, by ID.
+	reportsMu sync.Mutex
+	reports   map[string]*reportView
+}
+
+// InterestRecorder is told about items users look up, so the poller can keep
+// them fresher.
+type InterestRecorder interface {
+	RecordInterest(itemID int)
+}
+
+// SetInterestRecorder must be called before Initialize.
+func (dc *Discord) SetInterestRecorder(recorder InterestRecorder) {
+	dc.interest = recorder
+}
+
+// SetGlobalCommands registers commands once for every guild instead of per
+// guild as each comes online. Must be called before Initialize.
+func (dc *Discord) SetGlobalCommands(global bool) {
+	dc.globalCommands = global
+}
+
+// SetFeeRates sets the market tax rates of cities, for users who sell in one.
+// Cities without a rate use fees.DefaultTaxRate.
+func (dc *Discord) SetFeeRates(rates fees.Rates) {
+	dc.feeRates = rates
 }
 
-func NewDiscord(session *discordgo.Session, logger *zap.SugaredLogger, pg *postgres.Postgres) *Discord {
+func (dc *Discord) recordInterest(itemID int) {
+	if dc.interest != nil && itemID > 0 {
+		dc.interest.RecordInterest(itemID)
+	}
+}
+
+func NewDiscord(session *discordgo.Session, appID string, logger *zap.SugaredLogger, store PriceStore) *Discord {
+	dc := newDiscord(session, appID, logger, store)
+	dc.gateway = session
+	return dc
+}
+
+// newDiscord builds a bot without a gateway connection, for calling the
+// handlers directly.
+func newDiscord(client Session, appID string, logger *zap.SugaredLogger, store PriceStore) *Discord {
 	return &Discord{
-		client:         session,
-		logger:         logger,
-		updateCommands: false,
-		pg:             pg,
+		client:  client,
+		appID:   appID,
+		logger:  logger,
+		store:   store,
+		reports: make(map[string]*reportView),
+		limits:  newCommandLimits(DefaultCommandLimitsConfig()),
 	}
 }
 
@@ -37,14 +98,31 @@ func NewDiscord(session *discordgo.Session, logger *zap.SugaredLogger, pg *postg
 // callbacks with closures to the sugared Zap logger.
 func (dc *Discord) Initialize() error {
 	// For GUILD_CREATE, detecting when the bot is acked by a Discord server.
-	dc.client.Identify.Intents = discordgo.IntentsGuilds
+	dc.gateway.Identify.Intents = discordgo.IntentsGuilds
 
 	// Register callbacks for Gateway events.
-	dc.client.AddHandlerOnce(dc.ready())
-	dc.client.AddHandler(dc.guildCreate())
-	dc.client.AddHandler(dc.interactionCreate())
+	dc.gateway.AddHandlerOnce(dc.ready())
+	dc.gateway.AddHandler(dc.guildCreate())
+	dc.gateway.AddHandler(dc.interactionCreate())
+
+	// The bot still works without these, it just can't autocomplete or suggest names.
+	if err := dc.loadItemIndex(context.Background()); err != nil {
+		dc.logger.Errorw("failed to build item search index",
+			"suberror", err,
+		)
+	}
+	if err := dc.loadScopes(context.Background()); err != nil {
+		dc.logger.Errorw("failed to load world scopes",
+			"suberror", err,
+		)
+	}
+	if err := dc.loadCurrencies(context.Background()); err != nil {
+		dc.logger.Errorw("failed to load special currencies",
+			"suberror", err,
+		)
+	}
 
-	err := dc.client.Open()
+	err := dc.gateway.Open()
 	if err != nil {
 		dc.logger.Fatalw("failed to open websocket connection to Discord gateway",
 			"suberror", err,
@@ -59,8 +137,8 @@ func logWithEvent(eventName string, msg string) string {
 	return fmt.Sprintf("%s: %s", eventName, msg)
 }
 
-// ready is fired once upon session initialization. For now we just log the servers
-// the bot is registered in.
+// ready is fired once upon session initialization. We log the servers the bot is
+// registered in, and sync global commands if those are in use.
 func (dc *Discord) ready() func(*discordgo.Session, *discordgo.Ready) {
 	readyEventName := "READY"
 	return func(s *discordgo.Session, r *discordgo.Ready) {
@@ -74,11 +152,14 @@ func (dc *Discord) ready() func(*discordgo.Session, *discordgo.Ready) {
 				"guild_name", guild.Name,
 			)
 		}
+		if dc.globalCommands {
+			dc.syncCommands("")
+		}
 	}
 }
 
-// guildCreate fires upon a guild coming online - we check for all bot commands here
-// and update/create any out of date.
+// guildCreate fires upon a guild coming online - unless commands are global, we
+// sync the guild's commands here.
 func (dc *Discord) guildCreate() func(*discordgo.Session, *discordgo.GuildCreate) {
 	guildCreateEventName := "GUILD_CREATE"
 	return func(s *discordgo.Session, g *discordgo.GuildCreate) {
@@ -87,8 +168,8 @@ func (dc *Discord) guildCreate() func(*discordgo.Session, *discordgo.GuildCreate
 			"guild_name", g.Name,
 			"guild_unavailable", g.Unavailable,
 		)
-		if !g.Unavailable && g.ID != "" {
-			dc.registerCommands(g.ID)
+		if !dc.globalCommands && !g.Unavailable && g.ID != "" {
+			dc.syncCommands(g.ID)
 		}
 	}
 }
@@ -96,7 +177,7 @@ func (dc *Discord) guildCreate() func(*discordgo.Session, *discordgo.GuildCreate
 func (dc *Discord) interactionCreate() func(*discordgo.Session, *discordgo.InteractionCreate) {
 	return func(s *discordgo.Session, ic *discordgo.InteractionCreate) {
 		ctx := context.Background()
-		if ic.AppID != secrets.DiscordApplicationID {
+		if ic.AppID != dc.appID {
 			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "invalid associated application_id"),
 				"application_id", ic.AppID,
 			)
@@ -105,6 +186,10 @@ func (dc *Discord) interactionCreate() func(*discordgo.Session, *discordgo.Inter
 		switch ic.Type {
 		case discordgo.InteractionApplicationCommand:
 			dc.handleApplicationCommand(ctx, ic)
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			dc.handleAutocomplete(ctx, ic)
+		case discordgo.InteractionMessageComponent:
+			dc.handleMessageComponent(ctx, ic)
 		default:
 			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "received unexpected Interaction"),
 				"application_id", ic.AppID,
@@ -114,85 +199,10 @@ func (dc *Discord) interactionCreate() func(*discordgo.Session, *discordgo.Inter
 	}
 }
 
-// Registers all application commands with the associated
-// guild, skipping any whose descriptions are exact matches
-// with the current version of the command.
-func (dc *Discord) registerCommands(guildID string) error {
-	preexistingCmds, err := dc.client.ApplicationCommands(secrets.DiscordApplicationID, guildID)
-	if err != nil {
-		dc.logger.Errorw("failed to retrieve preexisting registered application commands",
-			"guild_id", guildID,
-			"suberror", err,
-		)
-	}
-
-	var found bool
-	var count int
-	for _, command := range AllCommands() {
-		found = false
-		count = 0
-		for _, preexistingCmd := range preexistingCmds {
-			// Remove duplicates.
-			if preexistingCmd.Name == command.Name {
-				found = true
-				count += 1
-				if count > 1 {
-					if err := dc.client.ApplicationCommandDelete(secrets.DiscordApplicationID, guildID, preexistingCmd.ID); err != nil {
-						dc.logger.Errorw("failed to delete duplicate application command",
-							"command", command.Name,
-							"command_id", preexistingCmd.ID,
-							"guild_id", guildID,
-							"suberror", err,
-						)
-					}
-				} else if dc.updateCommands {
-					if _, err := dc.client.ApplicationCommandEdit(secrets.DiscordApplicationID, guildID, preexistingCmd.ID, command); err != nil {
-						dc.logger.Errorw("failed to update application command",
-							"command", command.Name,
-							"command_id", preexistingCmd.ID,
-							"guild_id", guildID,
-							"suberror", err,
-						)
-					}
-					dc.logger.Infow("updated application command",
-						"guild_id", guildID,
-						"command", command.Name,
-						"command_id", preexistingCmd.ID,
-					)
-				}
-				continue
-			}
-			dc.logger.Infof("preexisting command found",
-				"guild_id", guildID,
-				"command_id", preexistingCmd.ID,
-				"command", preexistingCmd.Name,
-				"version", preexistingCmd.Version,
-				"description", preexistingCmd.Description,
-			)
-		}
-
-		if found {
-			continue
-		}
-
-		if _, err := dc.client.ApplicationCommandCreate(secrets.DiscordApplicationID, guildID, command); err != nil {
-			dc.logger.Errorw("failed to create command",
-				"guild_id", guildID,
-				"command", command.Name,
-				"suberror", err,
-			)
-		}
-		dc.logger.Infow("successfully registered command",
-			"guild_id", guildID,
-			"command", command.Name,
-			"version", command.Version,
-		)
-	}
-	return nil
-}
-
 func (dc *Discord) CleanUp() {
-	dc.client.Close()
+	if dc.gateway != nil {
+		dc.gateway.Close()
+	}
 }
 
 func interactionFromInteractionCreate(ic *discordgo.InteractionCreate) *discordgo.Interaction {
@@ -228,6 +238,38 @@ func (dc *Discord) respondInstant(ctx context.Context, ic *discordgo.Interaction
 	return nil
 }
 
+// respondEphemeral is respondInstant, visible only to the user who ran the command.
+func (dc *Discord) respondEphemeral(ctx context.Context, ic *discordgo.InteractionCreate, message string) error {
+	icInteraction := interactionFromInteractionCreate(ic)
+	if err := dc.client.InteractionRespond(icInteraction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	}); err != nil {
+		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to send interaction response"),
+			"suberror", err)
+		return err
+	}
+	return nil
+}
+
+func (dc *Discord) respondAutocomplete(ctx context.Context, ic *discordgo.InteractionCreate, choices []*discordgo.ApplicationCommandOptionChoice) error {
+	icInteraction := interactionFromInteractionCreate(ic)
+	if err := dc.client.InteractionRespond(icInteraction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	}); err != nil {
+		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to send autocomplete response"),
+			"suberror", err)
+		return err
+	}
+	return nil
+}
+
 func (dc *Discord) respondTextFile(ctx context.Context, ic *discordgo.InteractionCreate, message string, text string) error {
 	icInteraction := interactionFromInteractionCreate(ic)
 	if err := dc.client.InteractionRespond(icInteraction, &discordgo.InteractionResponse{
@@ -275,16 +317,18 @@ func (dc *Discord) respondFollowup(ctx context.Context, ic *discordgo.Interactio
 }
 
 func (dc *Discord) respondFollowupWithFile(ctx context.Context, ic *discordgo.InteractionCreate, message string, text string) error {
+	return dc.respondFollowupWithAttachment(ctx, ic, message, &discordgo.File{
+		Name:        "response.txt",
+		ContentType: "text/plain",
+		Reader:      strings.NewReader(text),
+	})
+}
+
+func (dc *Discord) respondFollowupWithAttachment(ctx context.Context, ic *discordgo.InteractionCreate, message string, file *discordgo.File) error {
 	icInteraction := interactionFromInteractionCreate(ic)
 	if _, err := dc.client.FollowupMessageCreate(icInteraction, true, &discordgo.WebhookParams{
 		Content: message,
-		Files: []*discordgo.File{
-			{
-				Name:        "response.txt",
-				ContentType: "text/plain",
-				Reader:      strings.NewReader(text),
-			},
-		},
+		Files:   []*discordgo.File{file},
 	}); err != nil {
 		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to create followup message"),
 			"suberror", err)
@@ -295,20 +339,44 @@ func (dc *Discord) respondFollowupWithFile(ctx context.Context, ic *discordgo.In
 
 func (dc *Discord) handleApplicationCommand(ctx context.Context, ic *discordgo.InteractionCreate) {
 	commandData := ic.ApplicationCommandData()
+	var handler func(context.Context, *discordgo.InteractionCreate)
 	switch name := commandData.Name; name {
 	case COMMAND_LOOKUP:
-		dc.handleLookup(ctx, ic)
+		handler = dc.handleLookup
 	case COMMAND_PRICEDOWN:
-		dc.handlePricedown(ctx, ic)
+		handler = dc.handlePricedown
+	case COMMAND_SHOPPING:
+		handler = dc.handleShopping
+	case COMMAND_VENDOR:
+		handler = dc.handleVendor
+	case COMMAND_CURRENCY:
+		handler = dc.handleCurrency
+	case COMMAND_GATHERING:
+		handler = dc.handleGathering
+	case COMMAND_CRAFTS:
+		handler = dc.handleCrafts
+	case COMMAND_POLL_STATUS:
+		handler = dc.handlePollStatus
+	case COMMAND_SETTINGS:
+		handler = dc.handleSettings
 	default:
 		dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected command received"),
 			"command_name", name)
+		return
 	}
+	dc.withLimits(ctx, ic, handler)
 }
 
 func AllCommands() []*discordgo.ApplicationCommand {
 	return []*discordgo.ApplicationCommand{
 		CommandLookup(),
 		CommandPricedown(),
+		CommandShopping(),
+		CommandVendor(),
+		CommandCurrency(),
+		CommandGathering(),
+		CommandCrafts(),
+		CommandPollStatus(),
+		CommandSettings(),
 	}
 }
@@ -0,0 +1,380 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"profiteeringway/lib/export"
+	"profiteeringway/lib/fees"
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/shopping"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// exporters build the reports the export subcommand can write, reading the
+// command's options from flags named after them.
+var exporters = map[string]func(dc *Discord, ctx context.Context, settings *effectiveSettings, args []string) (*report, error){
+	COMMAND_LOOKUP:    (*Discord).exportLookup,
+	COMMAND_PRICEDOWN: (*Discord).exportPricedown,
+	COMMAND_SHOPPING:  (*Discord).exportShopping,
+	COMMAND_VENDOR:    (*Discord).exportVendor,
+	COMMAND_CURRENCY:  (*Discord).exportCurrency,
+	COMMAND_GATHERING: (*Discord).exportGathering,
+	COMMAND_CRAFTS:    (*Discord).exportCrafts,
+}
+
+// ExportableCommands lists the commands whose results can be exported.
+func ExportableCommands() []string {
+	var names []string
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Export builds a report command's results as the bot would for a server with
+// no settings, for writing with export.Write. args are the command's options as
+// flags, e.g. -item_name "Rroneek Steak".
+func Export(ctx context.Context, store PriceStore, logger *zap.SugaredLogger, rates fees.Rates, command string, args []string) (*export.Table, error) {
+	exporter, ok := exporters[command]
+	if !ok {
+		return nil, fmt.Errorf("can't export %s, pick one of %s", command, strings.Join(ExportableCommands(), ", "))
+	}
+	dc := newDiscord(nil, "", logger, store)
+	dc.SetFeeRates(rates)
+	if err := dc.loadItemIndex(ctx); err != nil {
+		return nil, err
+	}
+	r, err := exporter(dc, ctx, mergeSettings(nil, nil, rates), args)
+	if err != nil {
+		return nil, err
+	}
+	return r.exportTable(), nil
+}
+
+// exportFlags parses a command's options from flags, described like the
+// options in Discord.
+type exportFlags struct {
+	*flag.FlagSet
+	command *discordgo.ApplicationCommand
+}
+
+func newExportFlags(command *discordgo.ApplicationCommand) *exportFlags {
+	return &exportFlags{
+		FlagSet: flag.NewFlagSet(command.Name, flag.ContinueOnError),
+		command: command,
+	}
+}
+
+func (f *exportFlags) usage(name string) string {
+	for _, option := range f.command.Options {
+		if option.Name == name {
+			return option.Description
+		}
+	}
+	return ""
+}
+
+func (f *exportFlags) stringFlag(name string) *string {
+	return f.String(name, "", f.usage(name))
+}
+
+func (f *exportFlags) intFlag(name string, value int) *int {
+	return f.Int(name, value, f.usage(name))
+}
+
+func (f *exportFlags) floatFlag(name string, value float64) *float64 {
+	return f.Float64(name, value, f.usage(name))
+}
+
+func (f *exportFlags) boolFlag(name string) *bool {
+	return f.Bool(name, false, f.usage(name))
+}
+
+// exportScope is resolveScopeOrHome, with an unknown name as the error.
+func (dc *Discord) exportScope(ctx context.Context, name string, settings *effectiveSettings) (*postgres.Scope, error) {
+	if name == "" {
+		return settings.scope, nil
+	}
+	return dc.store.ResolveScope(ctx, name)
+}
+
+// exportItemID resolves -item_id or -item_name to an item ID.
+func (dc *Discord) exportItemID(ctx context.Context, itemID int, itemName string) (int, error) {
+	if itemID != 0 {
+		return itemID, nil
+	}
+	if itemName == "" {
+		return 0, errors.New("one of -item_id and -item_name is needed")
+	}
+	if entry, ok := dc.resolveItemName(itemName); ok {
+		return int(entry.ItemID), nil
+	}
+	convItemID, err := dc.store.ConvertItemNameToItemID(ctx, itemName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find an item for %s.%s", itemName, dc.didYouMean(itemName))
+	}
+	return int(convItemID), nil
+}
+
+func (dc *Discord) exportLookup(ctx context.Context, settings *effectiveSettings, args []string) (*report, error) {
+	f := newExportFlags(CommandLookup())
+	itemIDFlag := f.intFlag("item_id", 0)
+	itemNameFlag := f.stringFlag("item_name")
+	worldName := f.stringFlag("world_name")
+	if err := f.Parse(args); err != nil {
+		return nil, err
+	}
+	itemID, err := dc.exportItemID(ctx, *itemIDFlag, *itemNameFlag)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := dc.exportScope(ctx, *worldName, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	priceData, err := dc.store.GetPriceForItemIDScopedExpensive(ctx, itemID, scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(priceData) == 0 {
+		return nil, fmt.Errorf("no prices for item %d in %s", itemID, scope.Name)
+	}
+	itemName, r := expensivePriceReport(priceData, settings)
+	r.title = fmt.Sprintf("%s in %s", itemName, scope.Name)
+	return r, nil
+}
+
+func (dc *Discord) exportPricedown(ctx context.Context, settings *effectiveSettings, args []string) (*report, error) {
+	f := newExportFlags(CommandPricedown())
+	itemIDFlag := f.intFlag("item_id", 0)
+	itemNameFlag := f.stringFlag("item_name")
+	worldName := f.stringFlag("world_name")
+	hqIngredients := f.stringFlag("hq_ingredients")
+	freeCrystals := f.boolFlag("free_crystals")
+	if err := f.Parse(args); err != nil {
+		return nil, err
+	}
+	itemID, err := dc.exportItemID(ctx, *itemIDFlag, *itemNameFlag)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := dc.exportScope(ctx, *worldName, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	recipe, err := dc.store.RecipesDetailsForItemID(ctx, int32(itemID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find a recipe for item %d: %w", itemID, err)
+	}
+	r, _, err := dc.pricedownReport(ctx, itemID, recipe, scope, settings, *hqIngredients, *freeCrystals)
+	if err != nil {
+		return nil, fmt.Errorf("can't price -hq_ingredients: %w", err)
+	}
+	return r, nil
+}
+
+func (dc *Discord) exportShopping(ctx context.Context, settings *effectiveSettings, args []string) (*report, error) {
+	f := newExportFlags(CommandShopping())
+	datacenter := f.stringFlag("datacenter")
+	itemsText := f.stringFlag("items")
+	file := f.stringFlag("file")
+	recipeItem := f.stringFlag("recipe_item")
+	crafts := f.intFlag("crafts", 1)
+	expand := f.boolFlag("expand_intermediates")
+	travelPenalty := f.intFlag("travel_penalty", defaultTravelPenalty)
+	nqOnly := f.boolFlag("nq_only")
+	if err := f.Parse(args); err != nil {
+		return nil, err
+	}
+	provided := 0
+	for _, s := range []string{*itemsText, *file, *recipeItem} {
+		if s != "" {
+			provided += 1
+		}
+	}
+	if provided != 1 {
+		return nil, errors.New("exactly one of -items, -file, and -recipe_item is needed")
+	}
+	if *datacenter == "" {
+		return nil, errors.New("-datacenter is needed")
+	}
+	scope, err := dc.exportScope(ctx, *datacenter, settings)
+	if err != nil {
+		return nil, err
+	}
+	if scope.Kind != postgres.ScopeDatacenter {
+		return nil, fmt.Errorf("%s is a %s, shopping lists are planned across a datacenter", scope.Name, scope.Kind)
+	}
+
+	var wants []shopping.Want
+	if *recipeItem != "" {
+		entry, ok := dc.resolveItemName(*recipeItem)
+		if !ok {
+			return nil, fmt.Errorf("failed to find an item for %s.%s", *recipeItem, dc.didYouMean(*recipeItem))
+		}
+		wants, err = dc.shoppingListFromRecipe(ctx, entry.ItemID, *crafts, *expand)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a recipe for %s: %w", *recipeItem, err)
+		}
+	} else {
+		text := *itemsText
+		if *file != "" {
+			b, err := os.ReadFile(*file)
+			if err != nil {
+				return nil, err
+			}
+			text = string(b)
+		}
+		var message string
+		wants, message = dc.shoppingListFromText(text)
+		if message != "" {
+			return nil, errors.New(message)
+		}
+	}
+
+	plan, err := dc.planShopping(ctx, wants, scope, shopping.Options{
+		TravelPenalty: *travelPenalty,
+		NQOnly:        *nqOnly,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := shoppingPlanReport(plan, settings)
+	r.title = fmt.Sprintf("Shopping on %s", scope.Name)
+	return r, nil
+}
+
+func (dc *Discord) exportVendor(ctx context.Context, settings *effectiveSettings, args []string) (*report, error) {
+	f := newExportFlags(CommandVendor())
+	worldName := f.stringFlag("world_name")
+	minMarkup := f.floatFlag("min_markup", defaultVendorMinMarkup)
+	limit := f.intFlag("limit", defaultVendorLimit)
+	if err := f.Parse(args); err != nil {
+		return nil, err
+	}
+	scope, err := dc.exportScope(ctx, *worldName, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dc.store.VendorArbitrage(ctx, scope, *minMarkup, *limit)
+	if err != nil {
+		return nil, err
+	}
+	r := vendorArbitrageReport(rows, settings)
+	r.title = fmt.Sprintf("Vendor arbitrage in %s", scope.Name)
+	return r, nil
+}
+
+func (dc *Discord) exportCurrency(ctx context.Context, settings *effectiveSettings, args []string) (*report, error) {
+	f := newExportFlags(CommandCurrency())
+	currencyName := f.stringFlag("currency_name")
+	worldName := f.stringFlag("world_name")
+	minVelocity := f.intFlag("min_velocity", defaultCurrencyMinVelocity)
+	limit := f.intFlag("limit", defaultCurrencyLimit)
+	if err := f.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := dc.loadCurrencies(ctx); err != nil {
+		return nil, err
+	}
+	currency, ok := dc.resolveCurrency(*currencyName)
+	if !ok {
+		var names []string
+		for _, c := range dc.currencies {
+			names = append(names, c.Name)
+		}
+		return nil, fmt.Errorf("%q isn't a currency any shop takes, pick one of %s", *currencyName, strings.Join(names, ", "))
+	}
+	scope, err := dc.exportScope(ctx, *worldName, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dc.store.CurrencyValue(ctx, currency.ItemID, scope, *minVelocity, *limit)
+	if err != nil {
+		return nil, err
+	}
+	r := currencyValueReport(rows, settings)
+	r.title = fmt.Sprintf("%s in %s", currency.Name, scope.Name)
+	return r, nil
+}
+
+func (dc *Discord) exportGathering(ctx context.Context, settings *effectiveSettings, args []string) (*report, error) {
+	f := newExportFlags(CommandGathering())
+	worldName := f.stringFlag("world_name")
+	class := f.stringFlag("class")
+	minItemLevel := f.intFlag("min_item_level", 0)
+	maxItemLevel := f.intFlag("max_item_level", 0)
+	limit := f.intFlag("limit", defaultGatheringLimit)
+	if err := f.Parse(args); err != nil {
+		return nil, err
+	}
+	filter := postgres.GatheringFilter{
+		Class:        postgres.GatheringClass(*class),
+		MinItemLevel: *minItemLevel,
+		MaxItemLevel: *maxItemLevel,
+	}
+	if filter.MaxItemLevel > 0 && filter.MaxItemLevel < filter.MinItemLevel {
+		return nil, errors.New("-max_item_level can't be below -min_item_level")
+	}
+	scope, err := dc.exportScope(ctx, *worldName, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dc.store.GatheringProfitability(ctx, scope, filter, *limit)
+	if err != nil {
+		return nil, err
+	}
+	r := gatheringReport(rows, settings)
+	r.title = fmt.Sprintf("Gathering in %s", scope.Name)
+	return r, nil
+}
+
+func (dc *Discord) exportCrafts(ctx context.Context, settings *effectiveSettings, args []string) (*report, error) {
+	f := newExportFlags(CommandCrafts())
+	worldName := f.stringFlag("world_name")
+	category := f.stringFlag("category")
+	itemTypes := f.stringFlag("item_type")
+	minItemLevel := f.intFlag("min_item_level", 0)
+	maxItemLevel := f.intFlag("max_item_level", 0)
+	sortBy := f.stringFlag("sort_by")
+	freeCrystals := f.boolFlag("free_crystals")
+	limit := f.intFlag("limit", defaultCraftsLimit)
+	if err := f.Parse(args); err != nil {
+		return nil, err
+	}
+	filter, message := craftsFilter(*category, *itemTypes, *minItemLevel, *maxItemLevel)
+	if message != "" {
+		return nil, errors.New(message)
+	}
+	filter.SortBy = postgres.CraftProfitSort(*sortBy)
+	if filter.SortBy == "" {
+		filter.SortBy = postgres.CraftProfitSortProfit
+	}
+	filter.FreeCrystals = *freeCrystals
+	filter.Fees = settings.fees
+	scope, err := dc.exportScope(ctx, *worldName, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dc.store.CraftProfitRanking(ctx, scope, filter, *limit)
+	if err != nil {
+		return nil, err
+	}
+	r := craftsReport(rows, settings)
+	r.title = fmt.Sprintf("Crafts in %s", scope.Name)
+	return r, nil
+}
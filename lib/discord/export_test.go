@@ -0,0 +1,126 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		// The table's header and rows, each row's cells joined by commas.
+		wantHeader []string
+		wantRows   []string
+		// The start of the error, when one is expected.
+		wantErr string
+	}{
+		{
+			name:       "lookup by ID",
+			command:    COMMAND_LOOKUP,
+			args:       []string{"-item_id", fmt.Sprint(steakID)},
+			wantHeader: []string{"Datacenter", "World", "Price per unit (HQ)", "Price per unit (NQ)"},
+			wantRows:   []string{"Aether,Gilgamesh,5000,3000"},
+		},
+		{
+			name:       "lookup by name in a world",
+			command:    COMMAND_LOOKUP,
+			args:       []string{"-item_name", "rroneek steak", "-world_name", "Gilgamesh"},
+			wantHeader: []string{"Datacenter", "World", "Price per unit (HQ)", "Price per unit (NQ)"},
+			wantRows:   []string{"Aether,Gilgamesh,5000,3000"},
+		},
+		{
+			name:       "pricedown",
+			command:    COMMAND_PRICEDOWN,
+			args:       []string{"-item_id", fmt.Sprint(steakID)},
+			wantHeader: []string{"Item", "World (HQ)", "Price per unit (HQ)", "World (NQ)", "Price per unit (NQ)", "Quantity", "Total (HQ)", "Total (NQ)", "Cost", "Sale", "Profit", "Net"},
+			wantRows: []string{
+				"Rroneek Steak,Gilgamesh,5000,Gilgamesh,3000,1,5000,3000",
+				"Rroneek Chuck,,,Jenova,400,2,,800",
+				"Rock Salt,,,NPC vendor,10,1,,10",
+				"Wind Shard,,,Gilgamesh,5,8,,40",
+				"NQ materials, NQ result,,,,,,,,850,3000,2150,2000",
+				"NQ materials, HQ result,,,,,,,,850,5000,4150,3900",
+			},
+		},
+		{
+			name:    "not a report",
+			command: COMMAND_SETTINGS,
+			wantErr: "can't export settings, pick one of crafts, currency, gathering, lookup, pricedown, shopping, vendor",
+		},
+		{
+			name:    "no item",
+			command: COMMAND_LOOKUP,
+			wantErr: "one of -item_id and -item_name is needed",
+		},
+		{
+			name:    "unknown flag",
+			command: COMMAND_LOOKUP,
+			args:    []string{"-item", "Rroneek Steak"},
+			wantErr: "flag provided but not defined: -item",
+		},
+		{
+			name:    "malformed number",
+			command: COMMAND_LOOKUP,
+			args:    []string{"-item_id", "steak"},
+			wantErr: `invalid value "steak" for flag -item_id`,
+		},
+		{
+			name:    "unknown world",
+			command: COMMAND_LOOKUP,
+			args:    []string{"-item_id", fmt.Sprint(steakID), "-world_name", "Atlantis"},
+			wantErr: "Atlantis: no world, datacenter, or region with that name",
+		},
+		{
+			name:    "unknown item",
+			command: COMMAND_PRICEDOWN,
+			args:    []string{"-item_name", "Rroneek Stek"},
+			wantErr: "failed to find an item for Rroneek Stek. Did you mean `Rroneek Steak`",
+		},
+		{
+			name:    "shopping across a world",
+			command: COMMAND_SHOPPING,
+			args:    []string{"-datacenter", "Gilgamesh", "-items", "Rroneek Chuck x2"},
+			wantErr: "Gilgamesh is a world, shopping lists are planned across a datacenter",
+		},
+		{
+			name:    "crafts without a category",
+			command: COMMAND_CRAFTS,
+			wantErr: "At least one of `category` and `item_type` must be provided.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := Export(context.Background(), testStore(), zap.NewNop().Sugar(), nil, tt.command, tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one starting %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(table.Header, tt.wantHeader) {
+				t.Errorf("got header %q, want %q", table.Header, tt.wantHeader)
+			}
+			var rows []string
+			for _, row := range table.Rows {
+				var cells []string
+				for _, cell := range row {
+					cells = append(cells, fmt.Sprint(cell))
+				}
+				rows = append(rows, strings.Join(cells, ","))
+			}
+			if !slices.Equal(rows, tt.wantRows) {
+				t.Errorf("got rows\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(tt.wantRows, "\n"))
+			}
+		})
+	}
+}
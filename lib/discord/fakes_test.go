@@ -0,0 +1,282 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"profiteeringway/lib/postgres"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+const testAppID = "test-app"
+
+// fakeSession records everything the bot sends instead of sending it.
+type fakeSession struct {
+	mu        sync.Mutex
+	responses []*discordgo.InteractionResponse
+	followups []*discordgo.WebhookParams
+	// Registered commands by guild ID, "" for global.
+	commands   map[string][]*discordgo.ApplicationCommand
+	overwrites int
+
+	respondErr  error
+	followupErr error
+}
+
+func (f *fakeSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.respondErr != nil {
+		return f.respondErr
+	}
+	f.responses = append(f.responses, resp)
+	return nil
+}
+
+func (f *fakeSession) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Fail embeds only, so the text file fallback can be exercised.
+	if f.followupErr != nil && len(data.Embeds) > 0 {
+		return nil, f.followupErr
+	}
+	f.followups = append(f.followups, data)
+	return &discordgo.Message{Content: data.Content}, nil
+}
+
+func (f *fakeSession) ApplicationCommands(appID, guildID string, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.commands[guildID], nil
+}
+
+func (f *fakeSession) ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.commands == nil {
+		f.commands = make(map[string][]*discordgo.ApplicationCommand)
+	}
+	f.commands[guildID] = commands
+	f.overwrites++
+	return commands, nil
+}
+
+// sent describes every response and followup in order, one line each, with
+// embeds and files flattened to their text.
+func (f *fakeSession) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var lines []string
+	for _, resp := range f.responses {
+		switch resp.Type {
+		case discordgo.InteractionResponseDeferredChannelMessageWithSource:
+			lines = append(lines, "ack")
+		default:
+			lines = append(lines, "instant: "+resp.Data.Content)
+		}
+	}
+	for _, followup := range f.followups {
+		text := followup.Content
+		for _, embed := range followup.Embeds {
+			text += " | " + embed.Title
+			for _, field := range embed.Fields {
+				text += fmt.Sprintf(" | %s: %s", field.Name, field.Value)
+			}
+		}
+		for _, file := range followup.Files {
+			b, _ := io.ReadAll(file.Reader)
+			text += " | " + string(b)
+		}
+		lines = append(lines, "followup: "+text)
+	}
+	return lines
+}
+
+// fakeStore serves canned data. Any method named in errs fails with that error.
+type fakeStore struct {
+	items    []*postgres.ItemName
+	scopes   []*postgres.Scope
+	prices   map[int][]*postgres.AllWorldsPriceRowExpensive
+	recipes  map[int32]*postgres.RecipeDetails
+	settings map[string]*postgres.Settings
+	// Served by CraftProfitRanking, which records the filter it was asked for.
+	craftProfits []*postgres.CraftProfitRow
+	craftFilter  postgres.CraftProfitFilter
+	errs         map[string]error
+}
+
+func (f *fakeStore) itemID(name string) (int32, bool) {
+	for _, item := range f.items {
+		if strings.EqualFold(item.Name, name) {
+			return item.ItemID, true
+		}
+	}
+	return 0, false
+}
+
+func (f *fakeStore) AllItemNames(ctx context.Context) ([]*postgres.ItemName, error) {
+	return f.items, f.errs["AllItemNames"]
+}
+
+func (f *fakeStore) ConvertItemNameToItemID(ctx context.Context, itemName string) (int32, error) {
+	if err := f.errs["ConvertItemNameToItemID"]; err != nil {
+		return 0, err
+	}
+	if id, ok := f.itemID(itemName); ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("no item named %s", itemName)
+}
+
+func (f *fakeStore) RecipesDetailsForItemID(ctx context.Context, itemID int32) (*postgres.RecipeDetails, error) {
+	if err := f.errs["RecipesDetailsForItemID"]; err != nil {
+		return nil, err
+	}
+	if recipe, ok := f.recipes[itemID]; ok {
+		return recipe, nil
+	}
+	return nil, fmt.Errorf("no recipe for %d", itemID)
+}
+
+func (f *fakeStore) AllScopes(ctx context.Context) ([]*postgres.Scope, error) {
+	return f.scopes, f.errs["AllScopes"]
+}
+
+func (f *fakeStore) ResolveScope(ctx context.Context, name string) (*postgres.Scope, error) {
+	if err := f.errs["ResolveScope"]; err != nil {
+		return nil, err
+	}
+	for _, scope := range f.scopes {
+		if strings.EqualFold(scope.Name, name) {
+			return scope, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", name, postgres.ErrUnknownScope)
+}
+
+func (f *fakeStore) GetPriceForItemIDScopedExpensive(ctx context.Context, itemID int, scope *postgres.Scope) ([]*postgres.AllWorldsPriceRowExpensive, error) {
+	return f.prices[itemID], f.errs["GetPriceForItemIDScopedExpensive"]
+}
+
+func (f *fakeStore) GetPriceForItemNameScopedExpensive(ctx context.Context, itemName string, scope *postgres.Scope) ([]*postgres.AllWorldsPriceRowExpensive, error) {
+	if err := f.errs["GetPriceForItemNameScopedExpensive"]; err != nil {
+		return nil, err
+	}
+	id, _ := f.itemID(itemName)
+	return f.prices[int(id)], nil
+}
+
+func (f *fakeStore) CurrentListingsInDatacenter(ctx context.Context, itemIDs []int32, datacenter string) ([]*postgres.ListingRow, error) {
+	return nil, f.errs["CurrentListingsInDatacenter"]
+}
+
+func (f *fakeStore) VendorArbitrage(ctx context.Context, scope *postgres.Scope, minMarkup float64, limit int) ([]*postgres.VendorArbitrageRow, error) {
+	return nil, f.errs["VendorArbitrage"]
+}
+
+func (f *fakeStore) SpecialCurrencies(ctx context.Context) ([]*postgres.ItemName, error) {
+	return nil, f.errs["SpecialCurrencies"]
+}
+
+func (f *fakeStore) CurrencyValue(ctx context.Context, currencyItemID int32, scope *postgres.Scope, minVelocity int, limit int) ([]*postgres.CurrencyValueRow, error) {
+	return nil, f.errs["CurrencyValue"]
+}
+
+func (f *fakeStore) GatheringProfitability(ctx context.Context, scope *postgres.Scope, filter postgres.GatheringFilter, limit int) ([]*postgres.GatheringRow, error) {
+	return nil, f.errs["GatheringProfitability"]
+}
+
+func (f *fakeStore) CraftProfitRanking(ctx context.Context, scope *postgres.Scope, filter postgres.CraftProfitFilter, limit int) ([]*postgres.CraftProfitRow, error) {
+	f.craftFilter = filter
+	rows := f.craftProfits
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows, f.errs["CraftProfitRanking"]
+}
+
+func (f *fakeStore) GetSettings(ctx context.Context, kind postgres.SettingsOwner, ownerID string) (*postgres.Settings, error) {
+	if err := f.errs["GetSettings"]; err != nil {
+		return nil, err
+	}
+	if s, ok := f.settings[fmt.Sprintf("%s:%s", kind, ownerID)]; ok {
+		return s, nil
+	}
+	return &postgres.Settings{}, nil
+}
+
+func (f *fakeStore) SaveSettings(ctx context.Context, kind postgres.SettingsOwner, ownerID string, s *postgres.Settings) error {
+	if err := f.errs["SaveSettings"]; err != nil {
+		return err
+	}
+	if f.settings == nil {
+		f.settings = make(map[string]*postgres.Settings)
+	}
+	f.settings[fmt.Sprintf("%s:%s", kind, ownerID)] = s
+	return nil
+}
+
+func (f *fakeStore) LoadPollStates(ctx context.Context) ([]*postgres.PollState, error) {
+	return nil, f.errs["LoadPollStates"]
+}
+
+// newTestDiscord is a bot wired to fakes, with its item index built from store.
+func newTestDiscord(store *fakeStore) (*Discord, *fakeSession) {
+	session := &fakeSession{}
+	dc := newDiscord(session, testAppID, zap.NewNop().Sugar(), store)
+	dc.loadItemIndex(context.Background())
+	dc.loadScopes(context.Background())
+	return dc, session
+}
+
+func stringOption(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:  name,
+		Type:  discordgo.ApplicationCommandOptionString,
+		Value: value,
+	}
+}
+
+func intOption(name string, value int) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name: name,
+		Type: discordgo.ApplicationCommandOptionInteger,
+		// Options are decoded from JSON, so integers arrive as float64.
+		Value: float64(value),
+	}
+}
+
+func boolOption(name string, value bool) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:  name,
+		Type:  discordgo.ApplicationCommandOptionBoolean,
+		Value: value,
+	}
+}
+
+func commandInteraction(command string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{
+		Interaction: &discordgo.Interaction{
+			ID:      "interaction",
+			AppID:   testAppID,
+			Type:    discordgo.InteractionApplicationCommand,
+			GuildID: "guild",
+			Member:  &discordgo.Member{User: &discordgo.User{ID: "user"}},
+			Data: discordgo.ApplicationCommandInteractionData{
+				Name:    command,
+				Options: options,
+			},
+		},
+	}
+}
+
+// dispatch runs the interaction through the same path the gateway does.
+func dispatch(dc *Discord, ic *discordgo.InteractionCreate) {
+	dc.interactionCreate()(nil, ic)
+}
+
+type discordOption = discordgo.ApplicationCommandInteractionDataOption
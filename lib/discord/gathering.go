@@ -0,0 +1,146 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"profiteeringway/lib/postgres"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+const (
+	defaultGatheringLimit = 25
+	maxGatheringLimit     = 100
+)
+
+func CommandGathering() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Type:        discordgo.ChatApplicationCommand,
+		Name:        COMMAND_GATHERING,
+		Description: "Ranks gatherable items by price times sale velocity. (version 3)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "world_name",
+				Description:  "The world, datacenter, or region to sell on, defaults to the server's home region.",
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "class",
+				Description: "Only show items this gathering class can gather.",
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "Miner", Value: string(postgres.GatheringMiner)},
+					{Name: "Botanist", Value: string(postgres.GatheringBotanist)},
+					{Name: "Fisher", Value: string(postgres.GatheringFisher)},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "min_item_level",
+				Description: "Skip items below this item level.",
+				MinValue:    &[]float64{0}[0],
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "max_item_level",
+				Description: "Skip items above this item level.",
+				MinValue:    &[]float64{1}[0],
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "limit",
+				Description: fmt.Sprintf("How many items to show (default %d).", defaultGatheringLimit),
+				MinValue:    &[]float64{1}[0],
+				MaxValue:    maxGatheringLimit,
+			},
+			formatOption(),
+		},
+	}
+}
+
+func gatheringReport(rows []*postgres.GatheringRow, settings *effectiveSettings) *report {
+	r := &report{
+		header:       table.Row{"Item", "Type", "Item Level", "World", "Price per unit", "Sales per day", "Gross gil per day", "Net gil per day"},
+		filterLabel:  "World",
+		filterColumn: 3,
+		format:       settings.formatCell,
+	}
+	for _, row := range rows {
+		r.rows = append(r.rows, table.Row{
+			row.Name,
+			row.Type,
+			row.ItemLevel,
+			row.WorldName,
+			row.MinPrice,
+			row.SaleVelocity,
+			row.GilPerDay(),
+			settings.fees.Net(row.GilPerDay()),
+		})
+	}
+	return r
+}
+
+func (dc *Discord) handleGathering(ctx context.Context, ic *discordgo.InteractionCreate) {
+	commandData := ic.ApplicationCommandData()
+	var worldName, format string
+	var filter postgres.GatheringFilter
+	limit := defaultGatheringLimit
+	for _, option := range commandData.Options {
+		optName := option.Name
+		switch optName {
+		case "world_name":
+			worldName = option.StringValue()
+		case "class":
+			filter.Class = postgres.GatheringClass(option.StringValue())
+		case "min_item_level":
+			filter.MinItemLevel = int(option.IntValue())
+		case "max_item_level":
+			filter.MaxItemLevel = int(option.IntValue())
+		case "limit":
+			limit = int(option.IntValue())
+		case "format":
+			format = option.StringValue()
+		default:
+			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected option name"),
+				"command_name", commandData.Name,
+				"option_name", optName)
+		}
+	}
+
+	if filter.MaxItemLevel > 0 && filter.MaxItemLevel < filter.MinItemLevel {
+		dc.respondInstant(ctx, ic, "`max_item_level` can't be below `min_item_level`.")
+		return
+	}
+
+	settings, ok := dc.settingsFor(ctx, ic)
+	if !ok {
+		return
+	}
+	scope, ok := dc.resolveScopeOrHome(ctx, ic, worldName, settings)
+	if !ok {
+		return
+	}
+
+	// Verified parameters, so ack the message while we compute.
+	dc.respondAck(ctx, ic)
+
+	rows, err := dc.store.GatheringProfitability(ctx, scope, filter, limit)
+	if err != nil {
+		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to get gathering profitability"),
+			"command_name", commandData.Name,
+			"database_error", err)
+		dc.respondFollowup(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+		return
+	}
+
+	if len(rows) == 0 {
+		dc.respondFollowup(ctx, ic, fmt.Sprintf("No gatherable items matching that are selling in %s right now.", scope.Name))
+		return
+	}
+
+	r := gatheringReport(rows, settings)
+	r.title = fmt.Sprintf("Gathering in %s", scope.Name)
+	dc.respondFollowupWithReport(ctx, ic, fmt.Sprintf("Gatherable items worth the time in %s, net gil after %s:", scope.Name, settings.fees), r, settings.priceModel, format)
+}
@@ -0,0 +1,42 @@
+package discord
+
+import (
+	"context"
+	"profiteeringway/lib/postgres"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Session is the part of the Discord REST API the handlers use. It's satisfied
+// by *discordgo.Session.
+type Session interface {
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ApplicationCommands(appID, guildID string, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+	ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+}
+
+// PriceStore is everything the bot reads from or writes to the database. It's
+// satisfied by *postgres.Postgres.
+type PriceStore interface {
+	AllItemNames(ctx context.Context) ([]*postgres.ItemName, error)
+	ConvertItemNameToItemID(ctx context.Context, itemName string) (int32, error)
+	RecipesDetailsForItemID(ctx context.Context, itemID int32) (*postgres.RecipeDetails, error)
+
+	AllScopes(ctx context.Context) ([]*postgres.Scope, error)
+	ResolveScope(ctx context.Context, name string) (*postgres.Scope, error)
+
+	GetPriceForItemIDScopedExpensive(ctx context.Context, itemID int, scope *postgres.Scope) ([]*postgres.AllWorldsPriceRowExpensive, error)
+	GetPriceForItemNameScopedExpensive(ctx context.Context, itemName string, scope *postgres.Scope) ([]*postgres.AllWorldsPriceRowExpensive, error)
+	CurrentListingsInDatacenter(ctx context.Context, itemIDs []int32, datacenter string) ([]*postgres.ListingRow, error)
+
+	VendorArbitrage(ctx context.Context, scope *postgres.Scope, minMarkup float64, limit int) ([]*postgres.VendorArbitrageRow, error)
+	SpecialCurrencies(ctx context.Context) ([]*postgres.ItemName, error)
+	CurrencyValue(ctx context.Context, currencyItemID int32, scope *postgres.Scope, minVelocity int, limit int) ([]*postgres.CurrencyValueRow, error)
+	GatheringProfitability(ctx context.Context, scope *postgres.Scope, filter postgres.GatheringFilter, limit int) ([]*postgres.GatheringRow, error)
+	CraftProfitRanking(ctx context.Context, scope *postgres.Scope, filter postgres.CraftProfitFilter, limit int) ([]*postgres.CraftProfitRow, error)
+
+	GetSettings(ctx context.Context, kind postgres.SettingsOwner, ownerID string) (*postgres.Settings, error)
+	SaveSettings(ctx context.Context, kind postgres.SettingsOwner, ownerID string, s *postgres.Settings) error
+	LoadPollStates(ctx context.Context) ([]*postgres.PollState, error)
+}
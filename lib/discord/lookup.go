@@ -4,18 +4,16 @@ import (
 	"context"
 	"fmt"
 	"profiteeringway/lib/postgres"
-	"profiteeringway/secrets"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/jedib0t/go-pretty/table"
+	"github.com/jedib0t/go-pretty/v6/table"
 )
 
 func CommandLookup() *discordgo.ApplicationCommand {
 	return &discordgo.ApplicationCommand{
-		ApplicationID: secrets.DiscordApplicationID,
-		Type:          discordgo.ChatApplicationCommand,
-		Name:          COMMAND_LOOKUP,
-		Description:   "Looks up prices for the specified item. (version 1)",
+		Type:        discordgo.ChatApplicationCommand,
+		Name:        COMMAND_LOOKUP,
+		Description: "Looks up prices for the specified item. (version 5)",
 		Options: []*discordgo.ApplicationCommandOption{
 			{
 				Type:        discordgo.ApplicationCommandOptionInteger,
@@ -23,10 +21,18 @@ func CommandLookup() *discordgo.ApplicationCommand {
 				Description: "The FFXIV internal item ID for the item in question.",
 			},
 			{
-				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "item_name",
-				Description: "The name of the item in question (case insensitive).",
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "item_name",
+				Description:  "The name of the item in question (case insensitive).",
+				Autocomplete: true,
 			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "world_name",
+				Description:  "Only show prices on this world, datacenter, or region, defaults to the server's home region.",
+				Autocomplete: true,
+			},
+			formatOption(),
 		},
 	}
 }
@@ -73,7 +79,8 @@ type expensivePriceTableRow struct {
 	minPriceNQ int
 }
 
-func tabularPrintExpensive(priceRows []*postgres.AllWorldsPriceRowExpensive) (string, string) {
+// expensivePriceReport returns the item name and a row of HQ and NQ prices per world.
+func expensivePriceReport(priceRows []*postgres.AllWorldsPriceRowExpensive, settings *effectiveSettings) (string, *report) {
 	itemName := ""
 
 	// We'll do some finicky stuff to preserve sort order from the query.
@@ -116,24 +123,29 @@ func tabularPrintExpensive(priceRows []*postgres.AllWorldsPriceRowExpensive) (st
 		})
 	}
 
-	t := table.NewWriter()
-
-	t.AppendHeader(table.Row{"Datacenter", "World", "Price per unit (HQ)", "Price per unit (NQ)"})
+	r := &report{
+		header:       table.Row{"Datacenter", "World", "Price per unit (HQ)", "Price per unit (NQ)"},
+		hqColumns:    []int{2},
+		nqColumns:    []int{3},
+		filterLabel:  "Datacenter",
+		filterColumn: 0,
+		format:       settings.formatCell,
+	}
 	for _, pr := range printRows {
-		t.AppendRow(table.Row{
+		r.rows = append(r.rows, table.Row{
 			pr.datacenter,
 			pr.worldName,
 			pr.minPriceHQ,
 			pr.minPriceNQ,
 		})
 	}
-	return itemName, t.Render()
+	return itemName, r
 }
 
 func (dc *Discord) handleLookup(ctx context.Context, ic *discordgo.InteractionCreate) {
 	commandData := ic.ApplicationCommandData()
 	var itemID int
-	var itemName string
+	var itemName, worldName, format string
 	for _, option := range commandData.Options {
 		optName := option.Name
 		switch optName {
@@ -141,6 +153,10 @@ func (dc *Discord) handleLookup(ctx context.Context, ic *discordgo.InteractionCr
 			itemID = int(option.IntValue())
 		case "item_name":
 			itemName = option.StringValue()
+		case "world_name":
+			worldName = option.StringValue()
+		case "format":
+			format = option.StringValue()
 		default:
 			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected option name"),
 				"command_name", commandData.Name,
@@ -153,15 +169,34 @@ func (dc *Discord) handleLookup(ctx context.Context, ic *discordgo.InteractionCr
 		return
 	}
 
+	settings, ok := dc.settingsFor(ctx, ic)
+	if !ok {
+		return
+	}
+	scope, ok := dc.resolveScopeOrHome(ctx, ic, worldName, settings)
+	if !ok {
+		return
+	}
+
+	// Prefer the canonical ID when the name is known, it's cheaper to query.
+	exactName := true
+	if itemID == 0 {
+		if entry, ok := dc.resolveItemName(itemName); ok {
+			itemID = int(entry.ItemID)
+		} else {
+			exactName = false
+		}
+	}
+
 	// Verified parameters, so ack the message while we compute.
 	dc.respondAck(ctx, ic)
 
 	var err error
 	var priceData []*postgres.AllWorldsPriceRowExpensive
 	if itemID > 0 {
-		priceData, err = dc.pg.GetPriceForItemIDExpensive(ctx, itemID)
+		priceData, err = dc.store.GetPriceForItemIDScopedExpensive(ctx, itemID, scope)
 	} else {
-		priceData, err = dc.pg.GetPriceForItemNameExpensive(ctx, itemName)
+		priceData, err = dc.store.GetPriceForItemNameScopedExpensive(ctx, itemName, scope)
 	}
 	if err != nil {
 		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to get item prices"),
@@ -173,11 +208,17 @@ func (dc *Discord) handleLookup(ctx context.Context, ic *discordgo.InteractionCr
 
 	if len(priceData) == 0 {
 		// Now we're pretty sure the item can't be found.
-		dc.respondFollowup(ctx, ic, "No items were found with that lookup.")
+		message := "No items were found with that lookup."
+		if !exactName {
+			message += dc.didYouMean(itemName)
+		}
+		dc.respondFollowup(ctx, ic, message)
 		return
 	}
 
-	var table string
-	itemName, table = tabularPrintExpensive(priceData)
-	dc.respondFollowupWithFile(ctx, ic, fmt.Sprintf("Price data for %s:", itemName), table)
+	dc.recordInterest(itemID)
+
+	itemName, r := expensivePriceReport(priceData, settings)
+	r.title = fmt.Sprintf("%s in %s", itemName, scope.Name)
+	dc.respondFollowupWithReport(ctx, ic, fmt.Sprintf("Price data for %s in %s:", itemName, scope.Name), r, settings.priceModel, format)
 }
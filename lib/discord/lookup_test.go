@@ -0,0 +1,191 @@
+package discord
+
+import (
+	"errors"
+	"profiteeringway/lib/postgres"
+	"strings"
+	"testing"
+)
+
+const (
+	steakID = 100
+	chuckID = 200
+	saltID  = 300
+	shardID = 400
+)
+
+// testStore is a region with one datacenter, prices for a steak and its
+// ingredients, and the recipe linking them.
+func testStore() *fakeStore {
+	return &fakeStore{
+		items: []*postgres.ItemName{
+			{ItemID: steakID, Name: "Rroneek Steak"},
+			{ItemID: chuckID, Name: "Rroneek Chuck"},
+			{ItemID: saltID, Name: "Rock Salt"},
+		},
+		scopes: []*postgres.Scope{
+			{Kind: postgres.ScopeWorld, Name: "Gilgamesh"},
+			{Kind: postgres.ScopeDatacenter, Name: "Aether"},
+			{Kind: postgres.ScopeRegion, Name: postgres.DefaultRegion},
+		},
+		prices: map[int][]*postgres.AllWorldsPriceRowExpensive{
+			steakID: {
+				{Name: "Rroneek Steak", WorldName: "Gilgamesh", Datacenter: "Aether", MinPrice: 5000, HighQuality: true},
+				{Name: "Rroneek Steak", WorldName: "Gilgamesh", Datacenter: "Aether", MinPrice: 3000},
+			},
+			chuckID: {
+				{Name: "Rroneek Chuck", WorldName: "Jenova", Datacenter: "Aether", MinPrice: 400},
+			},
+			saltID: {
+				{Name: "Rock Salt", WorldName: "Jenova", Datacenter: "Aether", MinPrice: 50},
+			},
+			shardID: {
+				{Name: "Wind Shard", WorldName: "Gilgamesh", Datacenter: "Aether", MinPrice: 5},
+			},
+		},
+		recipes: map[int32]*postgres.RecipeDetails{
+			steakID: {
+				CraftedItemName:  "Rroneek Steak",
+				CraftedItemCount: 1,
+				CraftedItemID:    steakID,
+				HighQualityable:  true,
+				Ingredients: []*postgres.Ingredient{
+					{ItemID: chuckID, Name: "Rroneek Chuck", Count: 2},
+					{ItemID: saltID, Name: "Rock Salt", Count: 1, GilPrice: 10},
+				},
+				Crystals: []*postgres.Ingredient{
+					{ItemID: shardID, Name: "Wind Shard", Count: 8},
+				},
+			},
+		},
+	}
+}
+
+// checkSent compares what the bot sent against want, line by line, where each
+// line of want only has to be contained in what was sent.
+func checkSent(t *testing.T, got []string, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("sent %d messages, want %d:\n%s", len(got), len(want), strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Errorf("message %d = %q, want it to contain %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	dbErr := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		options []*discordOption
+		errs    map[string]error
+		want    []string
+	}{
+		{
+			name: "no item",
+			want: []string{"instant: At least one of `item_id` and `item_name` must be provided."},
+		},
+		{
+			name:    "by ID",
+			options: []*discordOption{intOption("item_id", steakID)},
+			want: []string{
+				"ack",
+				"followup: Price data for Rroneek Steak in North-America: | Rroneek Steak in North-America | Aether: **World**: Gilgamesh · **Price per unit (HQ)**: 5000 · **Price per unit (NQ)**: 3000",
+			},
+		},
+		{
+			name:    "by name ignores case",
+			options: []*discordOption{stringOption("item_name", "rroneek steak"), stringOption("world_name", "Gilgamesh")},
+			want: []string{
+				"ack",
+				"followup: Price data for Rroneek Steak in Gilgamesh:",
+			},
+		},
+		{
+			name:    "unknown name suggests matches",
+			options: []*discordOption{stringOption("item_name", "Rroneek Stek")},
+			want: []string{
+				"ack",
+				"followup: No items were found with that lookup. Did you mean `Rroneek Steak`",
+			},
+		},
+		{
+			name:    "as CSV",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("format", "csv")},
+			want: []string{
+				"ack",
+				"followup: Price data for Rroneek Steak in North-America: | Datacenter,World,Price per unit (HQ),Price per unit (NQ)\nAether,Gilgamesh,5000,3000\n",
+			},
+		},
+		{
+			name:    "as a workbook",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("format", "xlsx")},
+			// Workbooks are zip files.
+			want: []string{"ack", "followup: Price data for Rroneek Steak in North-America: | PK"},
+		},
+		{
+			name:    "unknown format",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("format", "pdf")},
+			want:    []string{"ack", "followup: `pdf` isn't a format I can send."},
+		},
+		{
+			name:    "unknown world",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("world_name", "Atlantis")},
+			want:    []string{"instant: `Atlantis` isn't a world, datacenter, or region I know of."},
+		},
+		{
+			name:    "no prices",
+			options: []*discordOption{intOption("item_id", 999)},
+			want:    []string{"ack", "followup: No items were found with that lookup."},
+		},
+		{
+			name:    "settings lookup fails",
+			options: []*discordOption{intOption("item_id", steakID)},
+			errs:    map[string]error{"GetSettings": dbErr},
+			want:    []string{"instant: A database lookup error has occurred."},
+		},
+		{
+			name:    "scope lookup fails",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("world_name", "Gilgamesh")},
+			errs:    map[string]error{"ResolveScope": dbErr},
+			want:    []string{"instant: A database lookup error has occurred."},
+		},
+		{
+			name:    "price lookup fails",
+			options: []*discordOption{intOption("item_id", steakID)},
+			errs:    map[string]error{"GetPriceForItemIDScopedExpensive": dbErr},
+			want:    []string{"ack", "followup: A database lookup error has occurred."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testStore()
+			dc, session := newTestDiscord(store)
+			store.errs = tt.errs
+			dispatch(dc, commandInteraction(COMMAND_LOOKUP, tt.options...))
+			checkSent(t, session.sent(), tt.want)
+		})
+	}
+}
+
+func TestLookupFallsBackToFile(t *testing.T) {
+	store := testStore()
+	dc, session := newTestDiscord(store)
+	session.followupErr = errors.New("embed rejected")
+	dispatch(dc, commandInteraction(COMMAND_LOOKUP, intOption("item_id", steakID)))
+	sent := session.sent()
+	checkSent(t, sent, []string{"ack", "followup: Price data for Rroneek Steak in North-America: | "})
+	if got := sent[1]; !strings.Contains(got, "Gilgamesh") || !strings.Contains(got, "5000") {
+		t.Errorf("expected the text table in the file, got %q", got)
+	}
+}
+
+func TestInteractionFromAnotherApplicationIgnored(t *testing.T) {
+	dc, session := newTestDiscord(testStore())
+	ic := commandInteraction(COMMAND_LOOKUP, intOption("item_id", steakID))
+	ic.AppID = "someone-else"
+	dispatch(dc, ic)
+	checkSent(t, session.sent(), nil)
+}
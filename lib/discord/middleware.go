@@ -0,0 +1,226 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/time/rate"
+)
+
+// Commands that fan out into many price queries, and so share the database
+// concurrency cap.
+var heavyCommands = []string{
+	COMMAND_PRICEDOWN,
+	COMMAND_SHOPPING,
+	COMMAND_VENDOR,
+	COMMAND_CURRENCY,
+	COMMAND_GATHERING,
+	COMMAND_CRAFTS,
+}
+
+// Idle limiters are dropped once there are this many, so one off users don't
+// accumulate forever.
+const maxIdleLimiters = 1000
+
+type CommandLimitsConfig struct {
+	// Each user may run this many commands a minute, in bursts of up to UserBurst.
+	UserPerMinute float64
+	UserBurst     int
+	// Likewise for every user in a guild combined.
+	GuildPerMinute float64
+	GuildBurst     int
+	// At most this many heavy commands query the database at once. Zero is no cap.
+	MaxHeavyCommands int
+	// How long a heavy command waits for a slot before giving up. It has to be
+	// acked within three seconds, so this should be well under that.
+	HeavyCommandWait time.Duration
+	// Command name to the role IDs allowed to run it. Commands not listed are
+	// open to everyone, and administrators can always run everything.
+	CommandRoles map[string][]string
+}
+
+func DefaultCommandLimitsConfig() CommandLimitsConfig {
+	return CommandLimitsConfig{
+		UserPerMinute:    6,
+		UserBurst:        3,
+		GuildPerMinute:   30,
+		GuildBurst:       10,
+		MaxHeavyCommands: 4,
+		HeavyCommandWait: 2 * time.Second,
+	}
+}
+
+// commandLimits is the gate every application command passes through before
+// its handler runs.
+type commandLimits struct {
+	cfg CommandLimitsConfig
+
+	mu     sync.Mutex
+	users  map[string]*rate.Limiter
+	guilds map[string]*rate.Limiter
+
+	heavy chan struct{}
+}
+
+func newCommandLimits(cfg CommandLimitsConfig) *commandLimits {
+	l := &commandLimits{
+		cfg:    cfg,
+		users:  make(map[string]*rate.Limiter),
+		guilds: make(map[string]*rate.Limiter),
+	}
+	if cfg.MaxHeavyCommands > 0 {
+		l.heavy = make(chan struct{}, cfg.MaxHeavyCommands)
+	}
+	return l
+}
+
+func limiterFor(limiters map[string]*rate.Limiter, key string, perMinute float64, burst int, now time.Time) *rate.Limiter {
+	if lim, ok := limiters[key]; ok {
+		return lim
+	}
+	if len(limiters) >= maxIdleLimiters {
+		for k, lim := range limiters {
+			if lim.TokensAt(now) >= float64(lim.Burst()) {
+				delete(limiters, k)
+			}
+		}
+	}
+	lim := rate.NewLimiter(rate.Limit(perMinute/60), burst)
+	limiters[key] = lim
+	return lim
+}
+
+// reserve takes a command from the user's and guild's allowance. When either is
+// used up nothing is taken, and the wait until the command would be allowed is
+// returned.
+func (l *commandLimits) reserve(userID, guildID string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var reservations []*rate.Reservation
+	var wait time.Duration
+	if userID != "" && l.cfg.UserPerMinute > 0 {
+		r := limiterFor(l.users, userID, l.cfg.UserPerMinute, l.cfg.UserBurst, now).ReserveN(now, 1)
+		reservations = append(reservations, r)
+		wait = max(wait, r.DelayFrom(now))
+	}
+	if guildID != "" && l.cfg.GuildPerMinute > 0 {
+		r := limiterFor(l.guilds, guildID, l.cfg.GuildPerMinute, l.cfg.GuildBurst, now).ReserveN(now, 1)
+		reservations = append(reservations, r)
+		wait = max(wait, r.DelayFrom(now))
+	}
+	if wait > 0 {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+	return wait
+}
+
+// permitted reports whether the member may run the command.
+func (l *commandLimits) permitted(command string, member *discordgo.Member) bool {
+	roles := l.cfg.CommandRoles[command]
+	if len(roles) == 0 {
+		return true
+	}
+	// Restricted commands need a guild role, so they can't be run in DMs.
+	if member == nil {
+		return false
+	}
+	if member.Permissions&discordgo.PermissionAdministrator != 0 {
+		return true
+	}
+	for _, role := range member.Roles {
+		if slices.Contains(roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquireHeavy waits for a database slot, returning false if none frees up in
+// time. Callers that get true must call releaseHeavy.
+func (l *commandLimits) acquireHeavy(ctx context.Context) bool {
+	if l.heavy == nil {
+		return true
+	}
+	select {
+	case l.heavy <- struct{}{}:
+		return true
+	default:
+	}
+	timer := time.NewTimer(l.cfg.HeavyCommandWait)
+	defer timer.Stop()
+	select {
+	case l.heavy <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *commandLimits) releaseHeavy() {
+	if l.heavy != nil {
+		<-l.heavy
+	}
+}
+
+// SetCommandLimits replaces the default rate limits and permissions. Must be
+// called before Initialize.
+func (dc *Discord) SetCommandLimits(cfg CommandLimitsConfig) {
+	dc.limits = newCommandLimits(cfg)
+}
+
+// formatCooldown rounds a wait up to whole seconds, so users never retry early.
+func formatCooldown(d time.Duration) string {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds == 1 {
+		return "1 second"
+	}
+	return fmt.Sprintf("%d seconds", seconds)
+}
+
+// withLimits runs handler if the user is allowed to run the command right now,
+// and otherwise tells them why not. Only the user sees the refusal.
+func (dc *Discord) withLimits(ctx context.Context, ic *discordgo.InteractionCreate, handler func(context.Context, *discordgo.InteractionCreate)) {
+	name := ic.ApplicationCommandData().Name
+	userID := interactionUserID(ic)
+
+	if !dc.limits.permitted(name, ic.Member) {
+		dc.logger.Infow(logWithEvent(interactionCreateEventName, "command denied by role"),
+			"command_name", name,
+			"user_id", userID,
+			"guild_id", ic.GuildID)
+		dc.respondEphemeral(ctx, ic, fmt.Sprintf("You don't have a role that's allowed to use `/%s` here.", name))
+		return
+	}
+
+	if wait := dc.limits.reserve(userID, ic.GuildID, time.Now()); wait > 0 {
+		dc.logger.Infow(logWithEvent(interactionCreateEventName, "command rate limited"),
+			"command_name", name,
+			"user_id", userID,
+			"guild_id", ic.GuildID,
+			"wait", wait)
+		dc.respondEphemeral(ctx, ic, fmt.Sprintf("Easy there! You can run another command in %s.", formatCooldown(wait)))
+		return
+	}
+
+	if slices.Contains(heavyCommands, name) {
+		if !dc.limits.acquireHeavy(ctx) {
+			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "no database slot for heavy command"),
+				"command_name", name,
+				"user_id", userID)
+			dc.respondEphemeral(ctx, ic, "I'm busy crunching other requests right now. Try again in a few seconds.")
+			return
+		}
+		defer dc.limits.releaseHeavy()
+	}
+
+	handler(ctx, ic)
+}
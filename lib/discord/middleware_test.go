@@ -0,0 +1,84 @@
+package discord
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestReserveCooldown(t *testing.T) {
+	cfg := DefaultCommandLimitsConfig()
+	cfg.UserPerMinute = 6
+	cfg.UserBurst = 2
+	cfg.GuildPerMinute = 60
+	cfg.GuildBurst = 3
+	l := newCommandLimits(cfg)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if wait := l.reserve("alice", "guild", now); wait != 0 {
+			t.Fatalf("command %d: expected no wait, got %s", i, wait)
+		}
+	}
+	wait := l.reserve("alice", "guild", now)
+	if wait < 9*time.Second || wait > 10*time.Second {
+		t.Fatalf("expected a ten second cooldown, got %s", wait)
+	}
+
+	// The refused command took nothing from the guild, so bob still fits.
+	if wait := l.reserve("bob", "guild", now); wait != 0 {
+		t.Fatalf("expected bob to run, got wait %s", wait)
+	}
+	if wait := l.reserve("carol", "guild", now); wait == 0 {
+		t.Fatalf("expected the guild allowance to be used up")
+	}
+
+	if wait := l.reserve("alice", "guild", now.Add(10*time.Second)); wait != 0 {
+		t.Fatalf("expected alice to run after the cooldown, got wait %s", wait)
+	}
+}
+
+func TestPermitted(t *testing.T) {
+	cfg := DefaultCommandLimitsConfig()
+	cfg.CommandRoles = map[string][]string{COMMAND_PRICEDOWN: {"crafter"}}
+	l := newCommandLimits(cfg)
+
+	tests := []struct {
+		name    string
+		command string
+		member  *discordgo.Member
+		want    bool
+	}{
+		{"unrestricted", COMMAND_LOOKUP, &discordgo.Member{}, true},
+		{"unrestricted in DMs", COMMAND_LOOKUP, nil, true},
+		{"has role", COMMAND_PRICEDOWN, &discordgo.Member{Roles: []string{"other", "crafter"}}, true},
+		{"missing role", COMMAND_PRICEDOWN, &discordgo.Member{Roles: []string{"other"}}, false},
+		{"administrator", COMMAND_PRICEDOWN, &discordgo.Member{Permissions: discordgo.PermissionAdministrator}, true},
+		{"restricted in DMs", COMMAND_PRICEDOWN, nil, false},
+	}
+	for _, tt := range tests {
+		if got := l.permitted(tt.command, tt.member); got != tt.want {
+			t.Errorf("%s: permitted = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAcquireHeavyTimesOut(t *testing.T) {
+	cfg := DefaultCommandLimitsConfig()
+	cfg.MaxHeavyCommands = 1
+	cfg.HeavyCommandWait = 10 * time.Millisecond
+	l := newCommandLimits(cfg)
+
+	if !l.acquireHeavy(context.Background()) {
+		t.Fatal("expected the first slot")
+	}
+	if l.acquireHeavy(context.Background()) {
+		t.Fatal("expected no second slot")
+	}
+	l.releaseHeavy()
+	if !l.acquireHeavy(context.Background()) {
+		t.Fatal("expected the released slot")
+	}
+}
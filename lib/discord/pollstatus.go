@@ -0,0 +1,112 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"profiteeringway/lib/postgres"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+func CommandPollStatus() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Type:        discordgo.ChatApplicationCommand,
+		Name:        COMMAND_POLL_STATUS,
+		Description: "Shows when each hotlist world was last polled and when it's next due. (version 1)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "failing_only",
+				Description: "Only show worlds whose last poll failed.",
+			},
+		},
+	}
+}
+
+// formatAgo renders t relative to now, or "never" for the zero time.
+func formatAgo(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	d := now.Sub(t).Round(time.Second)
+	if d < 0 {
+		return fmt.Sprintf("in %s", -d)
+	}
+	return fmt.Sprintf("%s ago", d)
+}
+
+func pollStatesReport(states []*postgres.PollState, now time.Time) *report {
+	r := &report{
+		title:        "Hotlist poll status",
+		header:       table.Row{"Hotlist", "World", "Last success", "Last attempt", "Failures", "Next due", "Last error"},
+		filterLabel:  "Hotlist",
+		filterColumn: 0,
+	}
+	for _, ps := range states {
+		world := ps.WorldName
+		if world == "" {
+			world = fmt.Sprint(ps.WorldID)
+		}
+		r.rows = append(r.rows, table.Row{
+			ps.HotlistName,
+			world,
+			formatAgo(ps.LastSuccess, now),
+			formatAgo(ps.LastAttempt, now),
+			ps.ConsecutiveFailures,
+			formatAgo(ps.NextDue, now),
+			ps.LastError,
+		})
+	}
+	return r
+}
+
+func (dc *Discord) handlePollStatus(ctx context.Context, ic *discordgo.InteractionCreate) {
+	commandData := ic.ApplicationCommandData()
+	var failingOnly bool
+	for _, option := range commandData.Options {
+		optName := option.Name
+		switch optName {
+		case "failing_only":
+			failingOnly = option.BoolValue()
+		default:
+			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected option name"),
+				"command_name", commandData.Name,
+				"option_name", optName)
+		}
+	}
+
+	dc.respondAck(ctx, ic)
+
+	// Read from Postgres rather than the hub, the poller may be another process.
+	states, err := dc.store.LoadPollStates(ctx)
+	if err != nil {
+		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to load poll state"),
+			"command_name", commandData.Name,
+			"database_error", err)
+		dc.respondFollowup(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+		return
+	}
+
+	if failingOnly {
+		var failing []*postgres.PollState
+		for _, ps := range states {
+			if ps.ConsecutiveFailures > 0 {
+				failing = append(failing, ps)
+			}
+		}
+		states = failing
+	}
+
+	if len(states) == 0 {
+		if failingOnly {
+			dc.respondFollowup(ctx, ic, "Every hotlist world polled successfully last time.")
+		} else {
+			dc.respondFollowup(ctx, ic, "No hotlist has been polled yet.")
+		}
+		return
+	}
+
+	dc.respondFollowupWithReport(ctx, ic, "Hotlist poll status:", pollStatesReport(states, time.Now()), priceModelBoth, reportFormatTable)
+}
@@ -3,36 +3,52 @@ package discord
 import (
 	"context"
 	"fmt"
+	"profiteeringway/lib/costing"
 	"profiteeringway/lib/postgres"
-	"profiteeringway/secrets"
+	"slices"
+	"strings"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/jedib0t/go-pretty/v6/table"
 )
 
+// How many of one recipe's price lookups run at once.
+const maxPricedownLookups = 4
+
 func CommandPricedown() *discordgo.ApplicationCommand {
 	return &discordgo.ApplicationCommand{
-		ApplicationID: secrets.DiscordApplicationID,
-		Type:          discordgo.ChatApplicationCommand,
-		Name:          COMMAND_PRICEDOWN,
-		Description:   "Prices crafted items against their ingredient costs on a world. (version 1)",
+		Type:        discordgo.ChatApplicationCommand,
+		Name:        COMMAND_PRICEDOWN,
+		Description: "Prices crafted items against their ingredient costs on a world. (version 7)",
 		Options: []*discordgo.ApplicationCommandOption{
 			{
-				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "world_name",
-				Description: "The world name corresponding to the world in which to price down the crafted item.",
-				Required:    true,
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "world_name",
+				Description:  "The world, datacenter, or region in which to price down the crafted item, defaults to the server's home region.",
+				Autocomplete: true,
 			},
 			{
 				Type:        discordgo.ApplicationCommandOptionInteger,
 				Name:        "item_id",
 				Description: "The FFXIV internal item ID for the item in question.",
 			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "item_name",
+				Description:  "The name of the item in question (case insensitive).",
+				Autocomplete: true,
+			},
 			{
 				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "item_name",
-				Description: "The name of the item in question (case insensitive).",
+				Name:        "hq_ingredients",
+				Description: "Comma separated ingredients to also price buying HQ, for an HQ result (default none).",
 			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "free_crystals",
+				Description: "Count shards, crystals, and clusters as free, for when you gather your own.",
+			},
+			formatOption(),
 		},
 	}
 }
@@ -40,7 +56,8 @@ func CommandPricedown() *discordgo.ApplicationCommand {
 func (dc *Discord) handlePricedown(ctx context.Context, ic *discordgo.InteractionCreate) {
 	commandData := ic.ApplicationCommandData()
 	var itemID int
-	var itemName, worldName string
+	var itemName, worldName, hqIngredients, format string
+	var freeCrystals bool
 	for _, option := range commandData.Options {
 		optName := option.Name
 		switch optName {
@@ -50,6 +67,12 @@ func (dc *Discord) handlePricedown(ctx context.Context, ic *discordgo.Interactio
 			itemID = int(option.IntValue())
 		case "item_name":
 			itemName = option.StringValue()
+		case "hq_ingredients":
+			hqIngredients = option.StringValue()
+		case "free_crystals":
+			freeCrystals = option.BoolValue()
+		case "format":
+			format = option.StringValue()
 		default:
 			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected option name"),
 				"command_name", commandData.Name,
@@ -63,23 +86,28 @@ func (dc *Discord) handlePricedown(ctx context.Context, ic *discordgo.Interactio
 	}
 
 	if itemName != "" {
-		convItemID, err := dc.pg.ConvertItemNameToItemID(ctx, itemName)
-		itemID = int(convItemID)
-		if err != nil {
-			dc.logger.Errorw("failed to get item ID for item",
-				"item_name", itemName,
-				"error", err)
-			dc.respondInstant(ctx, ic, fmt.Sprintf("Failed to find an item for %s.", itemName))
-			return
+		if entry, ok := dc.resolveItemName(itemName); ok {
+			itemID = int(entry.ItemID)
+		} else {
+			convItemID, err := dc.store.ConvertItemNameToItemID(ctx, itemName)
+			itemID = int(convItemID)
+			if err != nil {
+				dc.logger.Errorw("failed to get item ID for item",
+					"item_name", itemName,
+					"error", err)
+				dc.respondInstant(ctx, ic, fmt.Sprintf("Failed to find an item for %s.%s", itemName, dc.didYouMean(itemName)))
+				return
+			}
 		}
 	}
 
-	worldID, err := dc.pg.WorldIDFromWorldName(ctx, worldName)
-	if err != nil {
-		dc.logger.Errorw("failed to get item ID for item",
-			"item_name", itemName,
-			"error", err)
-		dc.respondInstant(ctx, ic, fmt.Sprintf("Failed to find the world: %s", worldName))
+	settings, ok := dc.settingsFor(ctx, ic)
+	if !ok {
+		return
+	}
+	scope, ok := dc.resolveScopeOrHome(ctx, ic, worldName, settings)
+	if !ok {
+		return
 	}
 
 	// Verified parameters, so ack the message while we compute.
@@ -93,7 +121,7 @@ func (dc *Discord) handlePricedown(ctx context.Context, ic *discordgo.Interactio
 		return
 	}
 
-	recipe, err := dc.pg.RecipesDetailsForItemID(ctx, int32(itemID))
+	recipe, err := dc.store.RecipesDetailsForItemID(ctx, int32(itemID))
 	if err != nil {
 		dc.logger.Errorw("failed to get recipe details for item",
 			"item_id", itemID,
@@ -102,25 +130,53 @@ func (dc *Discord) handlePricedown(ctx context.Context, ic *discordgo.Interactio
 		return
 	}
 
+	// The crystals hotlist keeps crystals fresh, so they aren't recorded as interest.
+	dc.recordInterest(itemID)
+	for _, ing := range recipe.Ingredients {
+		dc.recordInterest(int(ing.ItemID))
+	}
+
+	r, message, err := dc.pricedownReport(ctx, itemID, recipe, scope, settings, hqIngredients, freeCrystals)
+	if err != nil {
+		dc.respondFollowup(ctx, ic, fmt.Sprintf("Can't price `hq_ingredients`: %s.", err))
+		return
+	}
+	dc.respondFollowupWithReport(ctx, ic, message, r, settings.priceModel, format)
+}
+
+// pricedownReport prices the recipe for the item against its materials in
+// scope, returning the report and the message to send with it. It only fails
+// when hqIngredients can't be priced.
+func (dc *Discord) pricedownReport(ctx context.Context, itemID int, recipe *postgres.RecipeDetails, scope *postgres.Scope, settings *effectiveSettings, hqIngredients string, freeCrystals bool) (*report, string, error) {
+	// Crystals are priced like any other material.
+	materials := slices.Concat(recipe.Ingredients, recipe.Crystals)
+
+	// Across a datacenter or region the cheapest HQ and NQ listings can be on
+	// different worlds.
 	type priceForItem struct {
 		name       string
-		worldName  string
+		worldNQ    string
+		worldHQ    string
 		minPriceNQ int
 		minPriceHQ int
 	}
 	// item_name -> price
 	priceMap := make(map[string]*priceForItem)
 
-	waitCount := len(recipe.Ingredients)
+	waitCount := len(materials)
 
 	type lookupResult struct {
 		foundPrices []*postgres.AllWorldsPriceRowExpensive
 		err         error
 	}
 	resChan := make(chan lookupResult)
-	for _, ing := range recipe.Ingredients {
-		go func(itemID int32, worldID int) {
-			prices, err := dc.pg.GetPriceForItemIDWorldSpecificExpensive(ctx, int(itemID), worldName)
+	// One recipe shouldn't take every connection, however many ingredients it has.
+	lookupSlots := make(chan struct{}, maxPricedownLookups)
+	for _, ing := range materials {
+		go func(itemID int32) {
+			lookupSlots <- struct{}{}
+			prices, err := dc.store.GetPriceForItemIDScopedExpensive(ctx, int(itemID), scope)
+			<-lookupSlots
 			resChan <- lookupResult{
 				foundPrices: prices,
 				err:         err,
@@ -128,11 +184,13 @@ func (dc *Discord) handlePricedown(ctx context.Context, ic *discordgo.Interactio
 			dc.logger.Infow("found prices",
 				"prices", prices,
 				"error", err)
-		}(ing.ItemID, worldID)
+		}(ing.ItemID)
 	}
 
-	go func(itemID int32, worldID int) {
-		prices, err := dc.pg.GetPriceForItemIDWorldSpecificExpensive(ctx, int(itemID), worldName)
+	go func(itemID int32) {
+		lookupSlots <- struct{}{}
+		prices, err := dc.store.GetPriceForItemIDScopedExpensive(ctx, int(itemID), scope)
+		<-lookupSlots
 		resChan <- lookupResult{
 			foundPrices: prices,
 			err:         err,
@@ -140,36 +198,31 @@ func (dc *Discord) handlePricedown(ctx context.Context, ic *discordgo.Interactio
 		dc.logger.Infow("found prices",
 			"prices", prices,
 			"error", err)
-	}(int32(itemID), worldID)
+	}(int32(itemID))
 
 	for i := 0; i < waitCount+1; i++ {
 		res := <-resChan
 		if res.err != nil {
-			dc.logger.Warnf("subquery for pricedown price lookup failed",
-				"error", err,
+			dc.logger.Warnw("subquery for pricedown price lookup failed",
+				"error", res.err,
 				"crafted_item", recipe.CraftedItemName)
 			continue
 		}
 
+		// Across a datacenter or region there's a row per world, keep the cheapest.
 		for _, fp := range res.foundPrices {
-			if _, ok := priceMap[fp.Name]; ok {
-				if fp.HighQuality {
-					priceMap[fp.Name].minPriceHQ = fp.MinPrice
-				} else {
-					priceMap[fp.Name].minPriceNQ = fp.MinPrice
-				}
-			} else {
-				pr := &priceForItem{
-					name:      fp.Name,
-					worldName: fp.WorldName,
-				}
-				if fp.HighQuality {
-					pr.minPriceHQ = fp.MinPrice
-				} else {
-					pr.minPriceNQ = fp.MinPrice
-				}
+			pr, ok := priceMap[fp.Name]
+			if !ok {
+				pr = &priceForItem{name: fp.Name}
 				priceMap[fp.Name] = pr
 			}
+			if fp.HighQuality && (pr.minPriceHQ == 0 || fp.MinPrice < pr.minPriceHQ) {
+				pr.minPriceHQ = fp.MinPrice
+				pr.worldHQ = fp.WorldName
+			} else if !fp.HighQuality && (pr.minPriceNQ == 0 || fp.MinPrice < pr.minPriceNQ) {
+				pr.minPriceNQ = fp.MinPrice
+				pr.worldNQ = fp.WorldName
+			}
 		}
 	}
 	dc.logger.Infow("logged priceMap",
@@ -179,112 +232,212 @@ func (dc *Discord) handlePricedown(ctx context.Context, ic *discordgo.Interactio
 		isIngredient bool
 		// either ingredient count in the recipe or produced items
 		quantity    int
-		itemWorld   string
+		worldNQ     string
+		worldHQ     string
 		minPriceNQ  int
 		minPriceHQ  int
 		missingInfo bool
+		// Items without an HQ are only ever bought and sold NQ.
+		highQualityable bool
+		isCrystal       bool
+		// Set for crystals the user gathers, which cost nothing.
+		gathered bool
+		// Set for ingredients an NPC sells for less than the market board.
+		buyFromVendor  bool
+		marketPriceNQ  int
+		vendorGilPrice int
 	}
 	var pricingRows []*pricingRow
 
 	targetItemPricingRow := pricingRow{
-		itemName:     recipe.CraftedItemName,
-		isIngredient: false,
-		quantity:     int(recipe.CraftedItemCount),
+		itemName:        recipe.CraftedItemName,
+		isIngredient:    false,
+		quantity:        int(recipe.CraftedItemCount),
+		highQualityable: recipe.HighQualityable,
 	}
 	targetItemPrice, ok := priceMap[recipe.CraftedItemName]
 	if ok {
 		targetItemPricingRow.minPriceNQ = targetItemPrice.minPriceNQ
 		targetItemPricingRow.minPriceHQ = targetItemPrice.minPriceHQ
-		targetItemPricingRow.itemWorld = targetItemPrice.worldName
+		targetItemPricingRow.worldNQ = targetItemPrice.worldNQ
+		targetItemPricingRow.worldHQ = targetItemPrice.worldHQ
 	} else {
 		targetItemPricingRow.missingInfo = true
 	}
 	pricingRows = append(pricingRows, &targetItemPricingRow)
 
-	for _, ing := range recipe.Ingredients {
+	for _, ing := range materials {
 		ingPriceRow := pricingRow{
-			itemName:     ing.Name,
-			isIngredient: true,
-			quantity:     int(ing.Count),
+			itemName:        ing.Name,
+			isIngredient:    true,
+			quantity:        int(ing.Count),
+			highQualityable: ing.HighQualityable,
+			isCrystal:       slices.Contains(recipe.Crystals, ing),
 		}
 		ingItemPrice, ok := priceMap[ing.Name]
 		if ok {
 			ingPriceRow.minPriceNQ = ingItemPrice.minPriceNQ
 			ingPriceRow.minPriceHQ = ingItemPrice.minPriceHQ
-			ingPriceRow.itemWorld = ingItemPrice.worldName
+			ingPriceRow.worldNQ = ingItemPrice.worldNQ
+			ingPriceRow.worldHQ = ingItemPrice.worldHQ
 		} else {
 			ingPriceRow.missingInfo = true
 		}
+		ingPriceRow.vendorGilPrice = int(ing.GilPrice)
 		pricingRows = append(pricingRows, &ingPriceRow)
 	}
 
-	// If an item has no data for HQ, we'll fallback NQ prices to HQ (it's possible the item has no HQ variant.)
+	// Vendors only sell NQ, but it's what anyone would do for an ingredient the
+	// board has marked up, so cost NQ at the vendor price.
+	var vendorNotes []string
 	for _, pr := range pricingRows {
-		if pr.minPriceHQ == 0 {
-			pr.minPriceHQ = pr.minPriceNQ
+		if !pr.isIngredient || pr.vendorGilPrice == 0 {
+			continue
+		}
+		if !pr.missingInfo && pr.minPriceNQ != 0 && pr.minPriceNQ <= pr.vendorGilPrice {
+			continue
+		}
+		pr.buyFromVendor = true
+		pr.marketPriceNQ = pr.minPriceNQ
+		pr.minPriceNQ = pr.vendorGilPrice
+		pr.worldNQ = "NPC vendor"
+		pr.missingInfo = false
+		if pr.marketPriceNQ == 0 {
+			vendorNotes = append(vendorNotes, fmt.Sprintf("Buy %s from a vendor for %d each, none are listed.", pr.itemName, pr.vendorGilPrice))
+		} else {
+			vendorNotes = append(vendorNotes, fmt.Sprintf("Buy %s from a vendor for %d each instead of %d on the board.", pr.itemName, pr.vendorGilPrice, pr.marketPriceNQ))
+		}
+	}
+
+	if freeCrystals {
+		for _, pr := range pricingRows {
+			if pr.isCrystal {
+				pr.gathered = true
+				pr.worldNQ = "Gathered"
+				pr.minPriceNQ = 0
+				pr.missingInfo = false
+			}
 		}
 	}
 
-	saleTotalHQ := 0
-	costTotalHQ := 0
-	saleTotalNQ := 0
-	costTotalNQ := 0
+	costed := &costing.Recipe{
+		Name:            targetItemPricingRow.itemName,
+		Yield:           targetItemPricingRow.quantity,
+		HighQualityable: targetItemPricingRow.highQualityable,
+		Prices:          costing.Prices{NQ: targetItemPricingRow.minPriceNQ, HQ: targetItemPricingRow.minPriceHQ},
+	}
 	for _, pr := range pricingRows {
 		if pr.isIngredient {
-			costTotalHQ += pr.quantity * pr.minPriceHQ
-			costTotalNQ += pr.quantity * pr.minPriceNQ
-		} else {
-			saleTotalHQ += pr.quantity * pr.minPriceHQ
-			saleTotalNQ += pr.quantity * pr.minPriceNQ
+			costed.Materials = append(costed.Materials, &costing.Material{
+				Name:            pr.itemName,
+				Count:           pr.quantity,
+				HighQualityable: pr.highQualityable,
+				Prices:          costing.Prices{NQ: pr.minPriceNQ, HQ: pr.minPriceHQ},
+				Free:            pr.gathered,
+			})
 		}
 	}
-	t := table.NewWriter()
+	var mixed []string
+	if hqIngredients != "" {
+		mixed = strings.Split(hqIngredients, ",")
+	}
+	strategies, err := costing.Strategies(costed, mixed)
+	if err != nil {
+		return nil, "", err
+	}
 
-	t.AppendHeader(table.Row{"Item", "World", "Price per unit (HQ)", "Price per unit (NQ)", "Quantity", "Total Price (HQ)", "Total Price (NQ)"})
+	r := &report{
+		title:     fmt.Sprintf("%s in %s", recipe.CraftedItemName, scope.Name),
+		header:    table.Row{"Item", "World (HQ)", "Price per unit (HQ)", "World (NQ)", "Price per unit (NQ)", "Quantity", "Total (HQ)", "Total (NQ)"},
+		hqColumns: []int{1, 2, 6},
+		nqColumns: []int{3, 4, 7},
+		format:    settings.formatCell,
+		// The strategies' figures, which the summary shows as one cell.
+		exportColumns: table.Row{"Cost", "Sale", "Profit", "Net"},
+	}
+	// Items without HQ listings, or without an HQ at all, leave HQ cells empty.
+	hqCell := func(price int) interface{} {
+		if price == 0 {
+			return ""
+		}
+		return price
+	}
+	appendPricingRow := func(pr *pricingRow) {
+		if pr.missingInfo {
+			r.rows = append(r.rows, table.Row{pr.itemName})
+			return
+		}
+		r.rows = append(r.rows, table.Row{
+			pr.itemName,
+			pr.worldHQ,
+			hqCell(pr.minPriceHQ),
+			pr.worldNQ,
+			pr.minPriceNQ,
+			pr.quantity,
+			hqCell(pr.quantity * pr.minPriceHQ),
+			pr.quantity * pr.minPriceNQ,
+		})
+	}
 	for _, pr := range pricingRows {
 		if !pr.isIngredient {
-			if !pr.missingInfo {
-				t.AppendRow(table.Row{
-					pr.itemName,
-					pr.itemWorld,
-					pr.minPriceHQ,
-					pr.minPriceNQ,
-					pr.quantity,
-					pr.quantity * pr.minPriceHQ,
-					pr.quantity * pr.minPriceNQ,
-				})
-			} else {
-				t.AppendRow(table.Row{
-					pr.itemName,
-				})
-			}
+			appendPricingRow(pr)
 		}
 	}
-	t.AppendSeparator()
+	r.separatorsAfter = []int{len(r.rows) - 1}
 	for _, pr := range pricingRows {
-		if pr.isIngredient {
-			if !pr.missingInfo {
-				t.AppendRow(table.Row{
-					pr.itemName,
-					pr.itemWorld,
-					pr.minPriceHQ,
-					pr.minPriceNQ,
-					pr.quantity,
-					pr.quantity * pr.minPriceHQ,
-					pr.quantity * pr.minPriceNQ,
-				})
-			} else {
-				t.AppendRow(table.Row{
-					pr.itemName,
-				})
-			}
+		if pr.isIngredient && !pr.isCrystal {
+			appendPricingRow(pr)
+		}
+	}
+	if len(recipe.Crystals) > 0 {
+		r.separatorsAfter = append(r.separatorsAfter, len(r.rows)-1)
+	}
+	for _, pr := range pricingRows {
+		if pr.isCrystal {
+			appendPricingRow(pr)
 		}
 	}
 
-	t.AppendSeparator()
-
-	t.AppendRow(table.Row{
-		"Expected profit", "", "", "", "", saleTotalHQ - costTotalHQ, saleTotalNQ - costTotalNQ,
-	})
-	dc.respondFollowupWithFile(ctx, ic, fmt.Sprintf("Price data for %s:", itemName), t.Render())
+	// A strategy's profit goes under the quality it sells, so the quality menu
+	// shows the strategies for that quality.
+	// Every recipe takes crystals, so none listed means the recipe import
+	// didn't write them, not that they're free. Unless they're counted as
+	// gathered, what the recipe costs isn't known.
+	crystalsUnlisted := len(recipe.Crystals) == 0 && !freeCrystals
+	for _, strategy := range strategies {
+		c := costing.Cost(costed, strategy)
+		net := c.Profit() - settings.fees.Fee(c.Sale)
+		column := 7
+		if strategy.HQResult {
+			column = 6
+		}
+		row := table.Row{strategy.Name, "", "", "", "", "", "", ""}
+		// Spreadsheets get the figures as numbers in columns of their own.
+		exportRow := table.Row{strategy.Name, "", "", "", "", "", "", "", c.Cost, c.Sale, c.Profit(), net}
+		row[column] = fmt.Sprintf("cost %s, profit %s, net %s",
+			settings.formatCell(c.Cost),
+			settings.formatCell(c.Profit()),
+			settings.formatCell(net))
+		if crystalsUnlisted || len(c.Missing) > 0 {
+			row[column] = "cost unknown, the recipe lists no crystals"
+			if len(c.Missing) > 0 {
+				row[column] = fmt.Sprintf("no listings for %s", strings.Join(c.Missing, ", "))
+			}
+			exportRow = table.Row{strategy.Name, "", "", "", "", "", "", "", "", "", "", ""}
+			exportRow[column] = row[column]
+		}
+		r.summary = append(r.summary, row)
+		r.exportSummary = append(r.exportSummary, exportRow)
+	}
+	message := fmt.Sprintf("Price data for %s in %s, net profits after %s:", recipe.CraftedItemName, scope.Name, settings.fees)
+	if freeCrystals {
+		message = fmt.Sprintf("%s\nCrystals are counted as gathered, for free.", message)
+	}
+	if crystalsUnlisted {
+		message = fmt.Sprintf("%s\nThe recipe lists no crystals, so its cost and profit are unknown.", message)
+	}
+	if len(vendorNotes) > 0 {
+		message = fmt.Sprintf("%s\n%s", message, strings.Join(vendorNotes, "\n"))
+	}
+	return r, message, nil
 }
@@ -0,0 +1,235 @@
+package discord
+
+import (
+	"errors"
+	"profiteeringway/lib/fees"
+	"profiteeringway/lib/postgres"
+	"testing"
+)
+
+func TestPricedown(t *testing.T) {
+	dbErr := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		options  []*discordOption
+		errs     map[string]error
+		settings map[string]*postgres.Settings
+		// Changes the store before the command runs.
+		setup func(*fakeStore)
+		want  []string
+	}{
+		{
+			name: "no item",
+			want: []string{"instant: At least one of `item_id` and `item_name` must be provided."},
+		},
+		{
+			name:    "by ID",
+			options: []*discordOption{intOption("item_id", steakID)},
+			want: []string{
+				"ack",
+				"followup: Price data for Rroneek Steak in North-America, net profits after 5% tax:\n" +
+					"Buy Rock Salt from a vendor for 10 each instead of 50 on the board. | Rroneek Steak in North-America" +
+					" | Rroneek Steak: **World (HQ)**: Gilgamesh · **Price per unit (HQ)**: 5000 · **World (NQ)**: Gilgamesh · **Price per unit (NQ)**: 3000 · **Quantity**: 1 · **Total (HQ)**: 5000 · **Total (NQ)**: 3000" +
+					" | Rroneek Chuck: **World (NQ)**: Jenova · **Price per unit (NQ)**: 400 · **Quantity**: 2 · **Total (NQ)**: 800" +
+					" | Rock Salt: **World (NQ)**: NPC vendor · **Price per unit (NQ)**: 10 · **Quantity**: 1 · **Total (NQ)**: 10" +
+					" | Wind Shard: **World (NQ)**: Gilgamesh · **Price per unit (NQ)**: 5 · **Quantity**: 8 · **Total (NQ)**: 40" +
+					" | NQ materials, NQ result: **Total (NQ)**: cost 850, profit 2150, net 2000" +
+					" | NQ materials, HQ result: **Total (HQ)**: cost 850, profit 4150, net 3900",
+			},
+		},
+		{
+			name:    "as CSV",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("format", "csv")},
+			want: []string{
+				"ack",
+				"Item,World (HQ),Price per unit (HQ),World (NQ),Price per unit (NQ),Quantity,Total (HQ),Total (NQ),Cost,Sale,Profit,Net\n" +
+					"Rroneek Steak,Gilgamesh,5000,Gilgamesh,3000,1,5000,3000\n" +
+					"Rroneek Chuck,,,Jenova,400,2,,800\n" +
+					"Rock Salt,,,NPC vendor,10,1,,10\n" +
+					"Wind Shard,,,Gilgamesh,5,8,,40\n" +
+					"\"NQ materials, NQ result\",,,,,,,,850,3000,2150,2000\n" +
+					"\"NQ materials, HQ result\",,,,,,,,850,5000,4150,3900\n",
+			},
+		},
+		{
+			name:    "as CSV without listings",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("format", "csv")},
+			setup:   func(s *fakeStore) { delete(s.prices, shardID) },
+			want:    []string{"ack", "\"NQ materials, NQ result\",,,,,,,no listings for Wind Shard,,,,\n"},
+		},
+		{
+			name:    "by name in a world",
+			options: []*discordOption{stringOption("item_name", "RRONEEK STEAK"), stringOption("world_name", "gilgamesh")},
+			want:    []string{"ack", "followup: Price data for Rroneek Steak in Gilgamesh, net profits after 5% tax:"},
+		},
+		{
+			// Kugane's rate is set to 3% below.
+			name:     "selling in a city",
+			options:  []*discordOption{intOption("item_id", steakID)},
+			settings: map[string]*postgres.Settings{"guild:guild": {TaxCity: "kugane"}},
+			want:     []string{"ack", "cost 850, profit 2150, net 2060 | NQ materials, HQ result: **Total (HQ)**: cost 850, profit 4150, net 4000"},
+		},
+		{
+			name:    "selling directly",
+			options: []*discordOption{intOption("item_id", steakID)},
+			settings: map[string]*postgres.Settings{
+				"guild:guild": {TaxCity: "kugane"},
+				"user:user":   {SellVia: "direct"},
+			},
+			want: []string{"ack", "after no tax selling directly:"},
+		},
+		{
+			name:    "HQ ingredients",
+			options: []*discordOption{intOption("item_id", steakID)},
+			setup:   hqChuck,
+			want: []string{"ack", "Rroneek Chuck: **World (HQ)**: Jenova · **Price per unit (HQ)**: 600 · **World (NQ)**: Jenova · **Price per unit (NQ)**: 400 · **Quantity**: 2 · **Total (HQ)**: 1200 · **Total (NQ)**: 800" +
+				" | Rock Salt: **World (NQ)**: NPC vendor · **Price per unit (NQ)**: 10 · **Quantity**: 1 · **Total (NQ)**: 10" +
+				" | Wind Shard: **World (NQ)**: Gilgamesh · **Price per unit (NQ)**: 5 · **Quantity**: 8 · **Total (NQ)**: 40" +
+				" | NQ materials, NQ result: **Total (NQ)**: cost 850, profit 2150, net 2000" +
+				" | HQ materials, HQ result: **Total (HQ)**: cost 1250, profit 3750, net 3500" +
+				" | NQ materials, HQ result: **Total (HQ)**: cost 850, profit 4150, net 3900"},
+		},
+		{
+			// Naming every HQ-able ingredient is the HQ materials strategy again.
+			name:    "all HQ ingredients named",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("hq_ingredients", "rroneek chuck")},
+			setup:   hqChuck,
+			want:    []string{"ack", "HQ materials, HQ result: **Total (HQ)**: cost 1250, profit 3750, net 3500"},
+		},
+		{
+			name:    "cheapest HQ on another world",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("world_name", "Aether")},
+			setup: func(s *fakeStore) {
+				s.prices[steakID] = append(s.prices[steakID],
+					&postgres.AllWorldsPriceRowExpensive{Name: "Rroneek Steak", WorldName: "Jenova", Datacenter: "Aether", MinPrice: 4500, HighQuality: true},
+					&postgres.AllWorldsPriceRowExpensive{Name: "Rroneek Steak", WorldName: "Jenova", Datacenter: "Aether", MinPrice: 3200})
+			},
+			want: []string{"ack", "Rroneek Steak: **World (HQ)**: Jenova · **Price per unit (HQ)**: 4500 · **World (NQ)**: Gilgamesh · **Price per unit (NQ)**: 3000"},
+		},
+		{
+			name:    "HQ ingredients without HQ listings",
+			options: []*discordOption{intOption("item_id", steakID)},
+			setup: func(s *fakeStore) {
+				hqChuck(s)
+				s.prices[chuckID] = s.prices[chuckID][1:]
+			},
+			want: []string{"ack", "HQ materials, HQ result: **Total (HQ)**: no listings for Rroneek Chuck (HQ)"},
+		},
+		{
+			name:    "free crystals",
+			options: []*discordOption{intOption("item_id", steakID), boolOption("free_crystals", true)},
+			want: []string{"ack", "Wind Shard: **World (NQ)**: Gathered · **Price per unit (NQ)**: 0 · **Quantity**: 8 · **Total (NQ)**: 0" +
+				" | NQ materials, NQ result: **Total (NQ)**: cost 810, profit 2190, net 2040"},
+		},
+		{
+			name:    "free crystals note",
+			options: []*discordOption{intOption("item_id", steakID), boolOption("free_crystals", true)},
+			want:    []string{"ack", "net profits after 5% tax:\nCrystals are counted as gathered, for free.\nBuy Rock Salt"},
+		},
+		{
+			name:    "crystals without listings",
+			options: []*discordOption{intOption("item_id", steakID)},
+			setup:   func(s *fakeStore) { delete(s.prices, shardID) },
+			want:    []string{"ack", "Wind Shard: No price data. | NQ materials, NQ result: **Total (NQ)**: no listings for Wind Shard"},
+		},
+		{
+			name:    "recipe without crystals",
+			options: []*discordOption{intOption("item_id", steakID)},
+			setup:   func(s *fakeStore) { s.recipes[steakID].Crystals = nil },
+			want: []string{"ack", "Rock Salt: **World (NQ)**: NPC vendor · **Price per unit (NQ)**: 10 · **Quantity**: 1 · **Total (NQ)**: 10" +
+				" | NQ materials, NQ result: **Total (NQ)**: cost unknown, the recipe lists no crystals" +
+				" | NQ materials, HQ result: **Total (HQ)**: cost unknown, the recipe lists no crystals"},
+		},
+		{
+			name:    "recipe without crystals note",
+			options: []*discordOption{intOption("item_id", steakID)},
+			setup:   func(s *fakeStore) { s.recipes[steakID].Crystals = nil },
+			want:    []string{"ack", "net profits after 5% tax:\nThe recipe lists no crystals, so its cost and profit are unknown.\nBuy Rock Salt"},
+		},
+		{
+			// Crystals counted as gathered cost nothing whether they're listed or not.
+			name:    "recipe without crystals counted free",
+			options: []*discordOption{intOption("item_id", steakID), boolOption("free_crystals", true)},
+			setup:   func(s *fakeStore) { s.recipes[steakID].Crystals = nil },
+			want:    []string{"ack", "NQ materials, NQ result: **Total (NQ)**: cost 810, profit 2190, net 2040"},
+		},
+		{
+			name:    "ingredient that doesn't come in HQ",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("hq_ingredients", "Rock Salt")},
+			want:    []string{"ack", "followup: Can't price `hq_ingredients`: Rock Salt doesn't come in HQ."},
+		},
+		{
+			name:    "unknown ingredient",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("hq_ingredients", "Rroneek Chuck, Salt")},
+			setup:   hqChuck,
+			want:    []string{"ack", "followup: Can't price `hq_ingredients`: Salt isn't a material of Rroneek Steak."},
+		},
+		{
+			name:    "result that doesn't come in HQ",
+			options: []*discordOption{intOption("item_id", steakID)},
+			setup:   func(s *fakeStore) { s.recipes[steakID].HighQualityable = false },
+			want:    []string{"ack", "NQ materials, NQ result: **Total (NQ)**: cost 850, profit 2150, net 2000"},
+		},
+		{
+			name:    "unknown name",
+			options: []*discordOption{stringOption("item_name", "Rroneek Stek")},
+			want:    []string{"instant: Failed to find an item for Rroneek Stek. Did you mean `Rroneek Steak`"},
+		},
+		{
+			name:    "unknown world",
+			options: []*discordOption{intOption("item_id", steakID), stringOption("world_name", "Atlantis")},
+			want:    []string{"instant: `Atlantis` isn't a world, datacenter, or region I know of."},
+		},
+		{
+			name:    "no recipe",
+			options: []*discordOption{intOption("item_id", chuckID)},
+			want:    []string{"ack", "followup: Failed to find a recipe for item ID: 200."},
+		},
+		{
+			name:    "recipe lookup fails",
+			options: []*discordOption{intOption("item_id", steakID)},
+			errs:    map[string]error{"RecipesDetailsForItemID": dbErr},
+			want:    []string{"ack", "followup: Failed to find a recipe for item ID: 100."},
+		},
+		{
+			name:    "settings lookup fails",
+			options: []*discordOption{intOption("item_id", steakID)},
+			errs:    map[string]error{"GetSettings": dbErr},
+			want:    []string{"instant: A database lookup error has occurred."},
+		},
+		{
+			// Every row is missing its price, except the salt a vendor sells.
+			name:    "price lookups fail",
+			options: []*discordOption{intOption("item_id", steakID)},
+			errs:    map[string]error{"GetPriceForItemIDScopedExpensive": dbErr},
+			want: []string{
+				"ack",
+				"Buy Rock Salt from a vendor for 10 each, none are listed. | Rroneek Steak in North-America" +
+					" | Rroneek Steak: No price data. | Rroneek Chuck: No price data." +
+					" | Rock Salt: **World (NQ)**: NPC vendor",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testStore()
+			dc, session := newTestDiscord(store)
+			dc.SetFeeRates(fees.Rates{fees.Kugane: 0.03})
+			store.errs = tt.errs
+			store.settings = tt.settings
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+			dispatch(dc, commandInteraction(COMMAND_PRICEDOWN, tt.options...))
+			checkSent(t, session.sent(), tt.want)
+		})
+	}
+}
+
+// hqChuck makes the chuck come in HQ, listed at 600.
+func hqChuck(s *fakeStore) {
+	s.recipes[steakID].Ingredients[0].HighQualityable = true
+	s.prices[chuckID] = append([]*postgres.AllWorldsPriceRowExpensive{
+		{Name: "Rroneek Chuck", WorldName: "Jenova", Datacenter: "Aether", MinPrice: 600, HighQuality: true},
+	}, s.prices[chuckID]...)
+}
@@ -0,0 +1,568 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"profiteeringway/lib/export"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+const (
+	reportPageSize = 10
+	// Past this many rows a report goes out as a text file instead.
+	maxEmbedReportRows = 200
+	// Interaction tokens last 15 minutes, after which the message can't be edited.
+	reportLifetime = 15 * time.Minute
+	reportColor    = 0x5865f2
+	// Custom IDs of report components look like report:<id>:<action>.
+	reportComponentPrefix = "report"
+	// Select menus hold at most 25 options, one is used for "All".
+	maxFilterOptions = 24
+	filterAll        = "*"
+	// Embed limits, see https://discord.com/developers/docs/resources/message#embed-object-embed-limits
+	maxEmbedFieldName  = 256
+	maxEmbedFieldValue = 1024
+	// Title, description, field names and values and the footer together.
+	maxEmbedTotal = 6000
+	// Select menu option labels, see https://discord.com/developers/docs/interactions/message-components#select-menu-object-select-option-structure
+	maxSelectOptionLabel = 100
+)
+
+// Report commands send a paginated embed by default, or the report as a file
+// to open in a spreadsheet.
+const reportFormatTable = "table"
+
+func formatOption() *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "format",
+		Description: "Send the results as a table, or as a file for spreadsheets (default table).",
+		Choices: []*discordgo.ApplicationCommandOptionChoice{
+			{Name: "Table", Value: reportFormatTable},
+			{Name: "CSV", Value: string(export.CSV)},
+			{Name: "Excel workbook", Value: string(export.XLSX)},
+		},
+	}
+}
+
+// multiple is a cell that's a ratio, shown like 2.5x.
+type multiple float64
+
+func (m multiple) String() string {
+	return fmt.Sprintf("%.1fx", float64(m))
+}
+
+// report is a table that can be sent as a paginated embed, or rendered as text
+// when it's too big for that.
+type report struct {
+	title  string
+	header table.Row
+	rows   []table.Row
+	// Rows followed by a separator in the text rendering.
+	separatorsAfter []int
+	// Shown below the rows on every page, e.g. totals.
+	summary []table.Row
+	// Exports use exportSummary instead of summary when it's set, for summaries
+	// that combine figures into one cell to read. exportColumns name the cells
+	// its rows have past the header's.
+	exportColumns table.Row
+	exportSummary []table.Row
+	// Columns hidden by the quality select menu. Reports without any don't get one.
+	hqColumns []int
+	nqColumns []int
+	// When filterLabel is set, a select menu narrows the rows to one value of
+	// filterColumn, e.g. one datacenter.
+	filterLabel  string
+	filterColumn int
+	// Formats each cell, fmt.Sprint when nil.
+	format func(interface{}) string
+}
+
+func (r *report) formatCell(val interface{}) string {
+	if r.format != nil {
+		return r.format(val)
+	}
+	return fmt.Sprint(val)
+}
+
+// visibleColumns lists the columns shown for the quality, which is one of the
+// price models.
+func (r *report) visibleColumns(quality string) []int {
+	var columns []int
+	for i := range r.header {
+		if quality == priceModelHQ && slices.Contains(r.nqColumns, i) {
+			continue
+		}
+		if quality == priceModelNQ && slices.Contains(r.hqColumns, i) {
+			continue
+		}
+		columns = append(columns, i)
+	}
+	return columns
+}
+
+func (r *report) filteredRows(filter string) []table.Row {
+	if r.filterLabel == "" || filter == "" || filter == filterAll {
+		return r.rows
+	}
+	var rows []table.Row
+	for _, row := range r.rows {
+		if r.filterColumn < len(row) && r.formatCell(row[r.filterColumn]) == filter {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// filterOption is the select menu value for the filter value at index i of
+// filterValues. Values can be longer than Discord allows an option's, so the
+// menu sends their position instead.
+func filterOption(i int) string {
+	return strconv.Itoa(i)
+}
+
+// filterFromOption is the filter value the select menu option stands for.
+func (r *report) filterFromOption(option string) (string, bool) {
+	if option == filterAll {
+		return filterAll, true
+	}
+	i, err := strconv.Atoi(option)
+	values := r.filterValues()
+	if err != nil || i < 0 || i >= len(values) {
+		return "", false
+	}
+	return values[i], true
+}
+
+// filterValues lists the distinct values of the filter column in row order.
+func (r *report) filterValues() []string {
+	var values []string
+	for _, row := range r.rows {
+		if r.filterColumn >= len(row) {
+			continue
+		}
+		value := r.formatCell(row[r.filterColumn])
+		if value != "" && !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// renderText renders the whole report as a text table with the quality's columns.
+func (r *report) renderText(quality string) string {
+	columns := r.visibleColumns(quality)
+	pick := func(row table.Row) table.Row {
+		var picked table.Row
+		for _, c := range columns {
+			if c < len(row) {
+				picked = append(picked, row[c])
+			}
+		}
+		return picked
+	}
+
+	t := table.NewWriter()
+	var configs []table.ColumnConfig
+	for i := range columns {
+		configs = append(configs, table.ColumnConfig{
+			Number:      i + 1,
+			Transformer: r.formatCell,
+		})
+	}
+	t.SetColumnConfigs(configs)
+
+	t.AppendHeader(pick(r.header))
+	for i, row := range r.rows {
+		t.AppendRow(pick(row))
+		if slices.Contains(r.separatorsAfter, i) {
+			t.AppendSeparator()
+		}
+	}
+	if len(r.summary) > 0 {
+		t.AppendSeparator()
+		for _, row := range r.summary {
+			t.AppendRow(pick(row))
+		}
+	}
+	return t.Render()
+}
+
+// exportTable is every column of the report with its cells unformatted, so
+// spreadsheets get numbers rather than the locale's rendering of them.
+func (r *report) exportTable() *export.Table {
+	t := &export.Table{Name: r.title}
+	for _, cell := range slices.Concat(r.header, r.exportColumns) {
+		t.Header = append(t.Header, fmt.Sprint(cell))
+	}
+	summary := r.summary
+	if r.exportSummary != nil {
+		summary = r.exportSummary
+	}
+	for _, row := range slices.Concat(r.rows, summary) {
+		t.Rows = append(t.Rows, []interface{}(row))
+	}
+	return t
+}
+
+// embedField shows a row as a field named after its first cell, listing the
+// rest as header: value pairs.
+func (r *report) embedField(row table.Row, columns []int) *discordgo.MessageEmbedField {
+	var name string
+	var parts []string
+	for i, c := range columns {
+		if c >= len(row) {
+			continue
+		}
+		value := r.formatCell(row[c])
+		if i == 0 {
+			name = value
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("**%s**: %s", r.formatCell(r.header[c]), value))
+	}
+	// Discord rejects empty field names.
+	if name == "" {
+		name = "\u200b"
+	}
+	value := strings.Join(parts, " · ")
+	if value == "" {
+		value = "No price data."
+	}
+	return &discordgo.MessageEmbedField{
+		Name:  truncate(name, maxEmbedFieldName),
+		Value: truncate(value, maxEmbedFieldValue),
+	}
+}
+
+// truncate cuts s to max characters, counting runes as Discord does so
+// multi-byte characters are never split.
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// reportView is a sent report and how it's currently being shown.
+type reportView struct {
+	id      string
+	report  *report
+	ownerID string
+	page    int
+	quality string
+	filter  string
+	expires time.Time
+}
+
+func (v *reportView) pageCount() int {
+	rows := len(v.report.filteredRows(v.filter))
+	return max(1, (rows+reportPageSize-1)/reportPageSize)
+}
+
+func (v *reportView) embed() *discordgo.MessageEmbed {
+	r := v.report
+	columns := r.visibleColumns(v.quality)
+	rows := r.filteredRows(v.filter)
+	start := min(v.page*reportPageSize, len(rows))
+	end := min(start+reportPageSize, len(rows))
+
+	embed := &discordgo.MessageEmbed{
+		Title: truncate(r.title, maxEmbedFieldName),
+		Color: reportColor,
+	}
+	if r.filterLabel != "" && v.filter != "" && v.filter != filterAll {
+		embed.Description = fmt.Sprintf("%s: %s", r.filterLabel, v.filter)
+	}
+	for _, row := range rows[start:end] {
+		embed.Fields = append(embed.Fields, r.embedField(row, columns))
+	}
+	for _, row := range r.summary {
+		embed.Fields = append(embed.Fields, r.embedField(row, columns))
+	}
+	if pages := v.pageCount(); pages > 1 {
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page %d of %d, %d rows", v.page+1, pages, len(rows)),
+		}
+	}
+	fitEmbed(embed)
+	return embed
+}
+
+// embedLength counts the characters Discord holds against maxEmbedTotal.
+func embedLength(embed *discordgo.MessageEmbed) int {
+	n := utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Description)
+	for _, field := range embed.Fields {
+		n += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+	}
+	if embed.Footer != nil {
+		n += utf8.RuneCountInString(embed.Footer.Text)
+	}
+	return n
+}
+
+// fitEmbed cuts the longest field values down to a shared length until the
+// embed is within Discord's total limit, so a page of long rows still sends
+// rather than being rejected.
+func fitEmbed(embed *discordgo.MessageEmbed) {
+	over := embedLength(embed) - maxEmbedTotal
+	if over <= 0 {
+		return
+	}
+	lengths := make([]int, len(embed.Fields))
+	budget := -over
+	for i, field := range embed.Fields {
+		lengths[i] = utf8.RuneCountInString(field.Value)
+		budget += lengths[i]
+	}
+	slices.Sort(lengths)
+	// Find the longest value every field can keep and still fit the budget.
+	cut := 0
+	for i, length := range lengths {
+		if length*(len(lengths)-i) > budget {
+			cut = budget / (len(lengths) - i)
+			break
+		}
+		budget -= length
+	}
+	for _, field := range embed.Fields {
+		field.Value = truncate(field.Value, max(1, cut))
+	}
+}
+
+func (v *reportView) customID(action string) string {
+	return fmt.Sprintf("%s:%s:%s", reportComponentPrefix, v.id, action)
+}
+
+func (v *reportView) components() []discordgo.MessageComponent {
+	var components []discordgo.MessageComponent
+	r := v.report
+
+	if pages := v.pageCount(); pages > 1 {
+		components = append(components, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Previous",
+					Style:    discordgo.SecondaryButton,
+					CustomID: v.customID("prev"),
+					Disabled: v.page == 0,
+				},
+				discordgo.Button{
+					Label:    "Next",
+					Style:    discordgo.SecondaryButton,
+					CustomID: v.customID("next"),
+					Disabled: v.page >= pages-1,
+				},
+			},
+		})
+	}
+
+	if len(r.hqColumns) > 0 || len(r.nqColumns) > 0 {
+		options := []discordgo.SelectMenuOption{
+			{Label: "HQ and NQ", Value: priceModelBoth},
+			{Label: "HQ only", Value: priceModelHQ},
+			{Label: "NQ only", Value: priceModelNQ},
+		}
+		for i := range options {
+			options[i].Default = options[i].Value == v.quality
+		}
+		components = append(components, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:    v.customID("quality"),
+					Placeholder: "Quality",
+					Options:     options,
+				},
+			},
+		})
+	}
+
+	if r.filterLabel != "" {
+		values := r.filterValues()
+		if len(values) > 1 && len(values) <= maxFilterOptions {
+			options := []discordgo.SelectMenuOption{{
+				Label:   fmt.Sprintf("All %ss", strings.ToLower(r.filterLabel)),
+				Value:   filterAll,
+				Default: v.filter == "" || v.filter == filterAll,
+			}}
+			for i, value := range values {
+				options = append(options, discordgo.SelectMenuOption{
+					Label:   truncate(value, maxSelectOptionLabel),
+					Value:   filterOption(i),
+					Default: value == v.filter,
+				})
+			}
+			components = append(components, discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						CustomID:    v.customID("filter"),
+						Placeholder: r.filterLabel,
+						Options:     options,
+					},
+				},
+			})
+		}
+	}
+	return components
+}
+
+func newReportID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// storeReport keeps a view for its components to act on, dropping expired ones.
+func (dc *Discord) storeReport(v *reportView) {
+	dc.reportsMu.Lock()
+	defer dc.reportsMu.Unlock()
+	now := time.Now()
+	for id, old := range dc.reports {
+		if now.After(old.expires) {
+			delete(dc.reports, id)
+		}
+	}
+	dc.reports[v.id] = v
+}
+
+// respondFollowupWithReport sends the report as a paginated embed, falling back
+// to a text file for large reports or if Discord rejects the embed. quality is
+// the price model to start on. With a format other than a table the report is
+// sent as a file in that format instead.
+func (dc *Discord) respondFollowupWithReport(ctx context.Context, ic *discordgo.InteractionCreate, message string, r *report, quality string, format string) error {
+	if format != "" && format != reportFormatTable {
+		return dc.respondFollowupWithExport(ctx, ic, message, r, format)
+	}
+	if len(r.rows) > maxEmbedReportRows {
+		return dc.respondFollowupWithFile(ctx, ic, message, r.renderText(quality))
+	}
+
+	v := &reportView{
+		id:      newReportID(),
+		report:  r,
+		ownerID: interactionUserID(ic),
+		quality: quality,
+		expires: time.Now().Add(reportLifetime),
+	}
+	dc.storeReport(v)
+
+	icInteraction := interactionFromInteractionCreate(ic)
+	if _, err := dc.client.FollowupMessageCreate(icInteraction, true, &discordgo.WebhookParams{
+		Content:    message,
+		Embeds:     []*discordgo.MessageEmbed{v.embed()},
+		Components: v.components(),
+	}); err != nil {
+		dc.logger.Warnw(logWithEvent(interactionCreateEventName, "failed to send report embed, falling back to a file"),
+			"suberror", err)
+		// The file has no components, nothing will ask for the view again.
+		dc.reportsMu.Lock()
+		delete(dc.reports, v.id)
+		dc.reportsMu.Unlock()
+		return dc.respondFollowupWithFile(ctx, ic, message, r.renderText(quality))
+	}
+	return nil
+}
+
+func (dc *Discord) respondFollowupWithExport(ctx context.Context, ic *discordgo.InteractionCreate, message string, r *report, format string) error {
+	f, err := export.ParseFormat(format)
+	if err != nil {
+		return dc.respondFollowup(ctx, ic, fmt.Sprintf("`%s` isn't a format I can send.", format))
+	}
+	var b bytes.Buffer
+	if err := export.Write(&b, f, r.exportTable()); err != nil {
+		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to export report"),
+			"format", format,
+			"suberror", err)
+		return dc.respondFollowup(ctx, ic, "Failed to export the results. Tell Req to check the logs.")
+	}
+	return dc.respondFollowupWithAttachment(ctx, ic, message, &discordgo.File{
+		Name:        fmt.Sprintf("%s.%s", ic.ApplicationCommandData().Name, f),
+		ContentType: f.ContentType(),
+		Reader:      &b,
+	})
+}
+
+// handleMessageComponent pages through or re-filters a report in place.
+func (dc *Discord) handleMessageComponent(ctx context.Context, ic *discordgo.InteractionCreate) {
+	data := ic.MessageComponentData()
+	parts := strings.Split(data.CustomID, ":")
+	if len(parts) != 3 || parts[0] != reportComponentPrefix {
+		dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected message component"),
+			"custom_id", data.CustomID)
+		return
+	}
+	id, action := parts[1], parts[2]
+
+	dc.reportsMu.Lock()
+	v, ok := dc.reports[id]
+	if ok && time.Now().After(v.expires) {
+		delete(dc.reports, id)
+		ok = false
+	}
+	if !ok {
+		dc.reportsMu.Unlock()
+		dc.respondEphemeral(ctx, ic, "This result has expired, run the command again.")
+		return
+	}
+	if v.ownerID != "" && v.ownerID != interactionUserID(ic) {
+		dc.reportsMu.Unlock()
+		dc.respondEphemeral(ctx, ic, "Only the person who ran the command can change this result.")
+		return
+	}
+
+	switch action {
+	case "prev":
+		v.page = max(0, v.page-1)
+	case "next":
+		v.page = min(v.pageCount()-1, v.page+1)
+	case "quality":
+		if len(data.Values) == 1 {
+			switch quality := data.Values[0]; quality {
+			case priceModelBoth, priceModelHQ, priceModelNQ:
+				v.quality = quality
+			default:
+				dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected report quality"),
+					"quality", quality)
+			}
+		}
+	case "filter":
+		if len(data.Values) == 1 {
+			if filter, ok := v.report.filterFromOption(data.Values[0]); ok {
+				v.filter = filter
+				v.page = 0
+			}
+		}
+	default:
+		dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected report action"),
+			"custom_id", data.CustomID)
+	}
+	embed := v.embed()
+	components := v.components()
+	dc.reportsMu.Unlock()
+
+	icInteraction := interactionFromInteractionCreate(ic)
+	if err := dc.client.InteractionRespond(icInteraction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{embed},
+			Components: components,
+		},
+	}); err != nil {
+		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to update report message"),
+			"suberror", err)
+	}
+}
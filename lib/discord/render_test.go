@@ -0,0 +1,154 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{s: "Rroneek Steak", max: 20, want: "Rroneek Steak"},
+		{s: "Rroneek Steak", max: 13, want: "Rroneek Steak"},
+		{s: "Rroneek Steak", max: 8, want: "Rroneek…"},
+		// Multi-byte characters count once and are never split.
+		{s: "ミコッテのステーキ", max: 5, want: "ミコッテ…"},
+		{s: "Kugane · Ul'dah · Gridania", max: 9, want: "Kugane ·…"},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.s, tt.max)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q isn't valid UTF-8", tt.s, tt.max, got)
+		}
+	}
+}
+
+func componentInteraction(customID string, values ...string) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{
+		Interaction: &discordgo.Interaction{
+			ID:     "component",
+			AppID:  testAppID,
+			Type:   discordgo.InteractionMessageComponent,
+			Member: &discordgo.Member{User: &discordgo.User{ID: "user"}},
+			Data: discordgo.MessageComponentInteractionData{
+				CustomID: customID,
+				Values:   values,
+			},
+		},
+	}
+}
+
+func TestReportFilter(t *testing.T) {
+	// Longer than a select option allows, and multi-byte.
+	long := strings.Repeat("ステーキ", 30)
+	r := &report{
+		title:        "Steaks",
+		header:       table.Row{"Item", "Type"},
+		rows:         []table.Row{{"Rroneek Steak", "Meal"}, {"Miq'abob", long}, {"Chuck", "Meal"}},
+		filterLabel:  "Type",
+		filterColumn: 1,
+	}
+	dc, session := newTestDiscord(testStore())
+	v := &reportView{id: "steaks", report: r, ownerID: "user", expires: time.Now().Add(time.Minute)}
+	dc.storeReport(v)
+
+	menu := v.components()[0].(discordgo.ActionsRow).Components[0].(discordgo.SelectMenu)
+	if len(menu.Options) != 3 {
+		t.Fatalf("got %d filter options, want All and two types", len(menu.Options))
+	}
+	option := menu.Options[2]
+	if utf8.RuneCountInString(option.Label) != maxSelectOptionLabel || !utf8.ValidString(option.Label) {
+		t.Errorf("label %q should be cut to %d characters", option.Label, maxSelectOptionLabel)
+	}
+
+	dispatch(dc, componentInteraction(v.customID("filter"), option.Value))
+	if v.filter != long {
+		t.Fatalf("filtered on %q, want the full type", v.filter)
+	}
+	embed := session.responses[0].Data.Embeds[0]
+	if len(embed.Fields) != 1 || embed.Fields[0].Name != "Miq'abob" {
+		t.Errorf("got fields %v, want only the Miq'abob row", embed.Fields)
+	}
+
+	dispatch(dc, componentInteraction(v.customID("filter"), "7"))
+	if v.filter != long {
+		t.Errorf("an unknown option changed the filter to %q", v.filter)
+	}
+	dispatch(dc, componentInteraction(v.customID("filter"), filterAll))
+	if len(r.filteredRows(v.filter)) != 3 {
+		t.Errorf("expected All to show every row, filter is %q", v.filter)
+	}
+}
+
+func TestReportEmbedFitsTotalLimit(t *testing.T) {
+	long := strings.Repeat("Rroneek ", 100)
+	r := &report{title: "Steaks", header: table.Row{"Item", "Note"}}
+	for i := 0; i < 2*reportPageSize; i++ {
+		r.rows = append(r.rows, table.Row{fmt.Sprintf("Steak %d", i), long})
+	}
+	r.rows[reportPageSize+1][1] = "Short."
+	dc, session := newTestDiscord(testStore())
+	v := &reportView{id: "steaks", report: r, ownerID: "user", expires: time.Now().Add(time.Minute)}
+	dc.storeReport(v)
+	if n := embedLength(v.embed()); n > maxEmbedTotal {
+		t.Errorf("first page is %d characters, over the %d limit", n, maxEmbedTotal)
+	}
+
+	dispatch(dc, componentInteraction(v.customID("next")))
+	embed := session.responses[0].Data.Embeds[0]
+	if n := embedLength(embed); n > maxEmbedTotal {
+		t.Errorf("second page is %d characters, over the %d limit", n, maxEmbedTotal)
+	}
+	if len(embed.Fields) != reportPageSize {
+		t.Errorf("got %d fields, want every row of the page", len(embed.Fields))
+	}
+	// Only the long values are cut.
+	if got := embed.Fields[1].Value; got != "**Note**: Short." {
+		t.Errorf("short value became %q", got)
+	}
+}
+
+func TestReportQualityIsValidated(t *testing.T) {
+	r := &report{title: "Steaks", header: table.Row{"Item", "HQ", "NQ"}, rows: []table.Row{{"Rroneek Steak", 5000, 3000}}, hqColumns: []int{1}, nqColumns: []int{2}}
+	dc, _ := newTestDiscord(testStore())
+	v := &reportView{id: "steaks", report: r, ownerID: "user", quality: priceModelBoth, expires: time.Now().Add(time.Minute)}
+	dc.storeReport(v)
+
+	dispatch(dc, componentInteraction(v.customID("quality"), priceModelHQ))
+	if v.quality != priceModelHQ {
+		t.Fatalf("got quality %q, want %q", v.quality, priceModelHQ)
+	}
+	dispatch(dc, componentInteraction(v.customID("quality"), "glamour"))
+	if v.quality != priceModelHQ {
+		t.Errorf("an unknown quality changed it to %q", v.quality)
+	}
+}
+
+func TestReportFileFallbackDropsView(t *testing.T) {
+	r := &report{title: "Steaks", header: table.Row{"Item"}, rows: []table.Row{{"Rroneek Steak"}}}
+	dc, session := newTestDiscord(testStore())
+	session.followupErr = errors.New("embed rejected")
+	if err := dc.respondFollowupWithReport(context.Background(), commandInteraction(COMMAND_LOOKUP), "Steaks:", r, priceModelBoth, reportFormatTable); err != nil {
+		t.Fatal(err)
+	}
+	if len(session.followups) != 1 || len(session.followups[0].Files) != 1 {
+		t.Fatalf("got %v, want the report as a file", session.sent())
+	}
+	if len(dc.reports) != 0 {
+		t.Errorf("kept %d views for a report sent as a file", len(dc.reports))
+	}
+}
@@ -0,0 +1,513 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"profiteeringway/lib/fees"
+	"profiteeringway/lib/postgres"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	priceModelBoth = "both"
+	priceModelHQ   = "hq"
+	priceModelNQ   = "nq"
+
+	settingsScopeUser   = "user"
+	settingsScopeServer = "server"
+)
+
+// Thousands separators for the supported locales. Without a locale numbers
+// are printed bare.
+var localeSeparators = map[string]string{
+	"en": ",",
+	"de": ".",
+	"fr": " ",
+	"ja": ",",
+}
+
+func CommandSettings() *discordgo.ApplicationCommand {
+	scopeOption := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "scope",
+		Description: "Your own settings, or the server's defaults (needs Manage Server).",
+		Required:    true,
+		Choices: []*discordgo.ApplicationCommandOptionChoice{
+			{Name: "Just me", Value: settingsScopeUser},
+			{Name: "This server", Value: settingsScopeServer},
+		},
+	}
+	var cityChoices []*discordgo.ApplicationCommandOptionChoice
+	for _, city := range fees.Cities {
+		cityChoices = append(cityChoices, &discordgo.ApplicationCommandOptionChoice{Name: city.String(), Value: string(city)})
+	}
+	return &discordgo.ApplicationCommand{
+		Type:        discordgo.ChatApplicationCommand,
+		Name:        COMMAND_SETTINGS,
+		Description: "Shows or changes the defaults commands use when an option is left out. (version 2)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "view",
+				Description: "Shows your settings, the server's, and what commands will use.",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Changes one or more settings.",
+				Options: []*discordgo.ApplicationCommandOption{
+					scopeOption,
+					{
+						Type:         discordgo.ApplicationCommandOptionString,
+						Name:         "home_world",
+						Description:  "World commands look at when none is given.",
+						Autocomplete: true,
+					},
+					{
+						Type:         discordgo.ApplicationCommandOptionString,
+						Name:         "home_datacenter",
+						Description:  "Datacenter commands look at when no world is given.",
+						Autocomplete: true,
+					},
+					{
+						Type:         discordgo.ApplicationCommandOptionString,
+						Name:         "home_region",
+						Description:  "Region commands look at when no world or datacenter is given.",
+						Autocomplete: true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "price_model",
+						Description: "Which qualities to price.",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "HQ and NQ", Value: priceModelBoth},
+							{Name: "HQ only", Value: priceModelHQ},
+							{Name: "NQ only", Value: priceModelNQ},
+						},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "sell_via",
+						Description: "How you sell, which decides the fees taken off net profits.",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "Retainer listings (market tax)", Value: string(fees.ChannelRetainer)},
+							{Name: "Trading directly (no tax)", Value: string(fees.ChannelDirect)},
+						},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "tax_city",
+						Description: "City your retainers sell in, for its tax rate (default 5%).",
+						Choices:     cityChoices,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "locale",
+						Description: "How numbers are written.",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "1,234,567 (en)", Value: "en"},
+							{Name: "1.234.567 (de)", Value: "de"},
+							{Name: "1 234 567 (fr)", Value: "fr"},
+							{Name: "1,234,567 (ja)", Value: "ja"},
+						},
+					},
+					{
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Name:         "alert_channel",
+						Description:  "Channel price alerts are posted to, yours wins over the server's.",
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reset",
+				Description: "Clears settings back to the server's or the built in defaults.",
+				Options: []*discordgo.ApplicationCommandOption{
+					scopeOption,
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "setting",
+						Description: "The setting to clear, leave out to clear all of them.",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "home_world", Value: "home_world"},
+							{Name: "home_datacenter", Value: "home_datacenter"},
+							{Name: "home_region", Value: "home_region"},
+							{Name: "price_model", Value: "price_model"},
+							{Name: "sell_via", Value: "sell_via"},
+							{Name: "tax_city", Value: "tax_city"},
+							{Name: "locale", Value: "locale"},
+							{Name: "alert_channel", Value: "alert_channel"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// effectiveSettings is what a command should use for anything the user left
+// out: their own settings, then the server's, then the built in defaults.
+type effectiveSettings struct {
+	// Most specific home the user set, else the server's, else postgres.DefaultRegion.
+	scope      *postgres.Scope
+	priceModel string
+	// Taken off sales for net profits, reports show gross profits beside them.
+	fees         fees.Model
+	locale       string
+	alertChannel string
+}
+
+func interactionUserID(ic *discordgo.InteractionCreate) string {
+	if ic.Member != nil && ic.Member.User != nil {
+		return ic.Member.User.ID
+	}
+	if ic.User != nil {
+		return ic.User.ID
+	}
+	return ""
+}
+
+// homeScope is the most specific home in s, or nil when none is set.
+func homeScope(s *postgres.Settings) *postgres.Scope {
+	switch {
+	case s.HomeWorld != "":
+		return &postgres.Scope{Kind: postgres.ScopeWorld, Name: s.HomeWorld}
+	case s.HomeDatacenter != "":
+		return &postgres.Scope{Kind: postgres.ScopeDatacenter, Name: s.HomeDatacenter}
+	case s.HomeRegion != "":
+		return &postgres.Scope{Kind: postgres.ScopeRegion, Name: s.HomeRegion}
+	}
+	return nil
+}
+
+func mergeSettings(user *postgres.Settings, guild *postgres.Settings, rates fees.Rates) *effectiveSettings {
+	es := &effectiveSettings{
+		scope:      &postgres.Scope{Kind: postgres.ScopeRegion, Name: postgres.DefaultRegion},
+		priceModel: priceModelBoth,
+	}
+	channel := fees.ChannelRetainer
+	var city fees.City
+	// Apply the server first so the user's settings win.
+	for _, s := range []*postgres.Settings{guild, user} {
+		if s == nil {
+			continue
+		}
+		if scope := homeScope(s); scope != nil {
+			es.scope = scope
+		}
+		if s.PriceModel != "" {
+			es.priceModel = s.PriceModel
+		}
+		if s.SellVia != "" {
+			channel = fees.Channel(s.SellVia)
+		}
+		if s.TaxCity != "" {
+			city = fees.City(s.TaxCity)
+		}
+		if s.Locale != "" {
+			es.locale = s.Locale
+		}
+		// A user's own alerts go to their channel, else the server's.
+		if s.AlertChannel != "" {
+			es.alertChannel = s.AlertChannel
+		}
+	}
+	es.fees = rates.Model(city, channel)
+	return es
+}
+
+func (dc *Discord) loadSettings(ctx context.Context, ic *discordgo.InteractionCreate) (user *postgres.Settings, guild *postgres.Settings, err error) {
+	if userID := interactionUserID(ic); userID != "" {
+		if user, err = dc.store.GetSettings(ctx, postgres.SettingsOwnerUser, userID); err != nil {
+			return nil, nil, err
+		}
+	}
+	if ic.GuildID != "" {
+		if guild, err = dc.store.GetSettings(ctx, postgres.SettingsOwnerGuild, ic.GuildID); err != nil {
+			return nil, nil, err
+		}
+	}
+	return user, guild, nil
+}
+
+// settingsFor returns the defaults for the interaction. On failure it responds
+// to the interaction itself, so callers should return without acking.
+func (dc *Discord) settingsFor(ctx context.Context, ic *discordgo.InteractionCreate) (*effectiveSettings, bool) {
+	user, guild, err := dc.loadSettings(ctx, ic)
+	if err != nil {
+		dc.logger.Errorw("failed to load settings",
+			"guild_id", ic.GuildID,
+			"error", err)
+		dc.respondInstant(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+		return nil, false
+	}
+	return mergeSettings(user, guild, dc.feeRates), true
+}
+
+// resolveScopeOrHome is resolveScope, falling back to the home world,
+// datacenter, or region from settings when no name was given.
+func (dc *Discord) resolveScopeOrHome(ctx context.Context, ic *discordgo.InteractionCreate, name string, settings *effectiveSettings) (*postgres.Scope, bool) {
+	if name != "" {
+		return dc.resolveScope(ctx, ic, name)
+	}
+	return settings.scope, true
+}
+
+func (es *effectiveSettings) showHQ() bool {
+	return es.priceModel != priceModelNQ
+}
+
+func (es *effectiveSettings) showNQ() bool {
+	return es.priceModel != priceModelHQ
+}
+
+// formatNumber writes n with the locale's thousands separator.
+func formatNumber(n int, locale string) string {
+	sep, ok := localeSeparators[locale]
+	if !ok {
+		return strconv.Itoa(n)
+	}
+	digits := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(sep)
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
+
+// formatCell writes integers with the settings' locale, anything else as is.
+func (es *effectiveSettings) formatCell(val interface{}) string {
+	if n, ok := val.(int); ok {
+		return formatNumber(n, es.locale)
+	}
+	return fmt.Sprint(val)
+}
+
+func describeSettings(s *postgres.Settings) string {
+	if s == nil {
+		return "none"
+	}
+	var parts []string
+	add := func(name string, value string) {
+		if value != "" {
+			parts = append(parts, fmt.Sprintf("%s: `%s`", name, value))
+		}
+	}
+	add("home_world", s.HomeWorld)
+	add("home_datacenter", s.HomeDatacenter)
+	add("home_region", s.HomeRegion)
+	add("price_model", s.PriceModel)
+	add("sell_via", s.SellVia)
+	add("tax_city", s.TaxCity)
+	add("locale", s.Locale)
+	if s.AlertChannel != "" {
+		parts = append(parts, fmt.Sprintf("alert_channel: <#%s>", s.AlertChannel))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (dc *Discord) handleSettings(ctx context.Context, ic *discordgo.InteractionCreate) {
+	commandData := ic.ApplicationCommandData()
+	if len(commandData.Options) != 1 {
+		dc.logger.Warnw(logWithEvent(interactionCreateEventName, "expected exactly one subcommand"),
+			"command_name", commandData.Name)
+		return
+	}
+	sub := commandData.Options[0]
+	switch sub.Name {
+	case "view":
+		dc.handleSettingsView(ctx, ic)
+	case "set", "reset":
+		dc.handleSettingsChange(ctx, ic, sub)
+	default:
+		dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected subcommand"),
+			"command_name", commandData.Name,
+			"subcommand", sub.Name)
+	}
+}
+
+func (dc *Discord) handleSettingsView(ctx context.Context, ic *discordgo.InteractionCreate) {
+	user, guild, err := dc.loadSettings(ctx, ic)
+	if err != nil {
+		dc.logger.Errorw("failed to load settings",
+			"guild_id", ic.GuildID,
+			"error", err)
+		dc.respondEphemeral(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+		return
+	}
+	es := mergeSettings(user, guild, dc.feeRates)
+
+	lines := []string{
+		fmt.Sprintf("Your settings: %s", describeSettings(user)),
+	}
+	if ic.GuildID != "" {
+		lines = append(lines, fmt.Sprintf("Server settings: %s", describeSettings(guild)))
+	}
+	lines = append(lines, fmt.Sprintf("Commands will use %s %s, price %s, net profits after %s.",
+		es.scope.Kind, es.scope.Name, es.priceModel, es.fees))
+	dc.respondEphemeral(ctx, ic, strings.Join(lines, "\n"))
+}
+
+// handleSettingsChange applies a set or reset subcommand.
+func (dc *Discord) handleSettingsChange(ctx context.Context, ic *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	var scope string
+	var reset string
+	var worldName, datacenterName, regionName string
+	var update postgres.Settings
+	for _, option := range sub.Options {
+		optName := option.Name
+		switch optName {
+		case "scope":
+			scope = option.StringValue()
+		case "setting":
+			reset = option.StringValue()
+		case "home_world":
+			worldName = option.StringValue()
+		case "home_datacenter":
+			datacenterName = option.StringValue()
+		case "home_region":
+			regionName = option.StringValue()
+		case "price_model":
+			update.PriceModel = option.StringValue()
+		case "sell_via":
+			update.SellVia = option.StringValue()
+		case "tax_city":
+			update.TaxCity = option.StringValue()
+		case "locale":
+			update.Locale = option.StringValue()
+		case "alert_channel":
+			update.AlertChannel = option.ChannelValue(nil).ID
+		default:
+			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected option name"),
+				"command_name", COMMAND_SETTINGS,
+				"option_name", optName)
+		}
+	}
+
+	kind := postgres.SettingsOwnerUser
+	ownerID := interactionUserID(ic)
+	if scope == settingsScopeServer {
+		if ic.GuildID == "" || ic.Member == nil {
+			dc.respondEphemeral(ctx, ic, "Server settings can only be changed in a server.")
+			return
+		}
+		if ic.Member.Permissions&discordgo.PermissionManageServer == 0 {
+			dc.respondEphemeral(ctx, ic, "Changing server settings needs the Manage Server permission.")
+			return
+		}
+		kind = postgres.SettingsOwnerGuild
+		ownerID = ic.GuildID
+	}
+
+	// Homes must name a real world, datacenter, or region of the right kind.
+	homes := []struct {
+		name   string
+		kind   postgres.ScopeKind
+		target *string
+	}{
+		{worldName, postgres.ScopeWorld, &update.HomeWorld},
+		{datacenterName, postgres.ScopeDatacenter, &update.HomeDatacenter},
+		{regionName, postgres.ScopeRegion, &update.HomeRegion},
+	}
+	for _, home := range homes {
+		if home.name == "" {
+			continue
+		}
+		resolved, ok := dc.resolveScope(ctx, ic, home.name)
+		if !ok {
+			return
+		}
+		if resolved.Kind != home.kind {
+			dc.respondEphemeral(ctx, ic, fmt.Sprintf("`%s` is a %s, not a %s.", resolved.Name, resolved.Kind, home.kind))
+			return
+		}
+		*home.target = resolved.Name
+	}
+
+	current, err := dc.store.GetSettings(ctx, kind, ownerID)
+	if err != nil {
+		dc.logger.Errorw("failed to load settings",
+			"owner_kind", kind,
+			"error", err)
+		dc.respondEphemeral(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+		return
+	}
+
+	if sub.Name == "reset" {
+		clearSetting(current, reset)
+	} else {
+		applySettings(current, &update)
+	}
+
+	if err := dc.store.SaveSettings(ctx, kind, ownerID, current); err != nil {
+		dc.logger.Errorw("failed to save settings",
+			"owner_kind", kind,
+			"error", err)
+		dc.respondEphemeral(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+		return
+	}
+	dc.respondEphemeral(ctx, ic, fmt.Sprintf("Saved. %s settings: %s", strings.ToUpper(scope[:1])+scope[1:], describeSettings(current)))
+}
+
+// applySettings copies every setting present in update onto current. Setting a
+// home replaces the other homes, so the newest one is what's used.
+func applySettings(current *postgres.Settings, update *postgres.Settings) {
+	if update.HomeWorld != "" || update.HomeDatacenter != "" || update.HomeRegion != "" {
+		current.HomeWorld = update.HomeWorld
+		current.HomeDatacenter = update.HomeDatacenter
+		current.HomeRegion = update.HomeRegion
+	}
+	if update.PriceModel != "" {
+		current.PriceModel = update.PriceModel
+	}
+	if update.SellVia != "" {
+		current.SellVia = update.SellVia
+	}
+	if update.TaxCity != "" {
+		current.TaxCity = update.TaxCity
+	}
+	if update.Locale != "" {
+		current.Locale = update.Locale
+	}
+	if update.AlertChannel != "" {
+		current.AlertChannel = update.AlertChannel
+	}
+}
+
+// clearSetting unsets one setting by option name, or all of them for "".
+func clearSetting(s *postgres.Settings, name string) {
+	switch name {
+	case "":
+		*s = postgres.Settings{}
+	case "home_world":
+		s.HomeWorld = ""
+	case "home_datacenter":
+		s.HomeDatacenter = ""
+	case "home_region":
+		s.HomeRegion = ""
+	case "price_model":
+		s.PriceModel = ""
+	case "sell_via":
+		s.SellVia = ""
+	case "tax_city":
+		s.TaxCity = ""
+	case "locale":
+		s.Locale = ""
+	case "alert_channel":
+		s.AlertChannel = ""
+	}
+}
@@ -0,0 +1,44 @@
+package discord
+
+import (
+	"context"
+	"profiteeringway/lib/fees"
+	"profiteeringway/lib/postgres"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func channelOption(name, channelID string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:  name,
+		Type:  discordgo.ApplicationCommandOptionChannel,
+		Value: channelID,
+	}
+}
+
+func TestUserAlertChannel(t *testing.T) {
+	store := testStore()
+	store.settings = map[string]*postgres.Settings{
+		"guild:guild": {AlertChannel: "server-alerts"},
+	}
+	dc, session := newTestDiscord(store)
+
+	dispatch(dc, commandInteraction(COMMAND_SETTINGS, &discordOption{
+		Name:    "set",
+		Type:    discordgo.ApplicationCommandOptionSubCommand,
+		Options: []*discordOption{stringOption("scope", settingsScopeUser), channelOption("alert_channel", "my-alerts")},
+	}))
+	checkSent(t, session.sent(), []string{"instant: Saved. User settings: alert_channel: <#my-alerts>"})
+
+	user, guild, err := dc.loadSettings(context.Background(), commandInteraction(COMMAND_SETTINGS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mergeSettings(user, guild, fees.Rates{}).alertChannel; got != "my-alerts" {
+		t.Errorf("alerts go to %q, want the user's channel", got)
+	}
+	if got := mergeSettings(nil, guild, fees.Rates{}).alertChannel; got != "server-alerts" {
+		t.Errorf("alerts go to %q without a channel of their own, want the server's", got)
+	}
+}
@@ -0,0 +1,383 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/shopping"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+const (
+	defaultTravelPenalty = 5000
+	// Anything bigger than this isn't a shopping list.
+	maxShoppingListBytes = 64 * 1024
+	// Deep enough for any crafted intermediate chain in the game.
+	maxRecipeDepth = 5
+)
+
+func CommandShopping() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Type:        discordgo.ChatApplicationCommand,
+		Name:        COMMAND_SHOPPING,
+		Description: "Plans the cheapest way to buy a list of materials across a datacenter. (version 3)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "datacenter",
+				Description:  "The datacenter whose worlds to shop on, defaults to your home datacenter.",
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "items",
+				Description: "Items to buy separated by semicolons, e.g. `Cotton Boll x12; 3x Iron Ore`.",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionAttachment,
+				Name:        "file",
+				Description: "A text file with one item per line, e.g. `Cotton Boll x12`.",
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "recipe_item",
+				Description:  "Shop for the ingredients of this crafted item instead.",
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "crafts",
+				Description: "How many times to craft recipe_item (default 1).",
+				MinValue:    &[]float64{1}[0],
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "expand_intermediates",
+				Description: "Buy the ingredients of craftable ingredients rather than the ingredients themselves.",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "travel_penalty",
+				Description: fmt.Sprintf("Gil you'd pay to avoid visiting one more world (default %d).", defaultTravelPenalty),
+				MinValue:    &[]float64{0}[0],
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "nq_only",
+				Description: "Skip HQ listings, defaults to on when your price model is NQ only.",
+			},
+			formatOption(),
+		},
+	}
+}
+
+func (dc *Discord) handleShopping(ctx context.Context, ic *discordgo.InteractionCreate) {
+	commandData := ic.ApplicationCommandData()
+	var datacenter, itemsText, attachmentID, recipeItem, format string
+	var expand bool
+	var nqOnly *bool
+	crafts := 1
+	travelPenalty := defaultTravelPenalty
+	for _, option := range commandData.Options {
+		optName := option.Name
+		switch optName {
+		case "datacenter":
+			datacenter = option.StringValue()
+		case "items":
+			itemsText = option.StringValue()
+		case "file":
+			attachmentID, _ = option.Value.(string)
+		case "recipe_item":
+			recipeItem = option.StringValue()
+		case "crafts":
+			crafts = int(option.IntValue())
+		case "expand_intermediates":
+			expand = option.BoolValue()
+		case "travel_penalty":
+			travelPenalty = int(option.IntValue())
+		case "nq_only":
+			value := option.BoolValue()
+			nqOnly = &value
+		case "format":
+			format = option.StringValue()
+		default:
+			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected option name"),
+				"command_name", commandData.Name,
+				"option_name", optName)
+		}
+	}
+
+	provided := 0
+	for _, s := range []string{itemsText, attachmentID, recipeItem} {
+		if s != "" {
+			provided += 1
+		}
+	}
+	if provided != 1 {
+		dc.respondInstant(ctx, ic, "Exactly one of `items`, `file`, and `recipe_item` must be provided.")
+		return
+	}
+
+	settings, ok := dc.settingsFor(ctx, ic)
+	if !ok {
+		return
+	}
+	if datacenter == "" && settings.scope.Kind != postgres.ScopeDatacenter {
+		dc.respondInstant(ctx, ic, "Give a `datacenter`, or set a home datacenter with `/settings set`.")
+		return
+	}
+	if nqOnly == nil {
+		nqOnly = &[]bool{settings.priceModel == priceModelNQ}[0]
+	}
+
+	scope, ok := dc.resolveScopeOrHome(ctx, ic, datacenter, settings)
+	if !ok {
+		return
+	}
+	if scope.Kind != postgres.ScopeDatacenter {
+		dc.respondInstant(ctx, ic, fmt.Sprintf("`%s` is a %s, shopping lists are planned across a datacenter.", scope.Name, scope.Kind))
+		return
+	}
+
+	var recipeItemID int32
+	if recipeItem != "" {
+		entry, ok := dc.resolveItemName(recipeItem)
+		if !ok {
+			dc.respondInstant(ctx, ic, fmt.Sprintf("Failed to find an item for %s.%s", recipeItem, dc.didYouMean(recipeItem)))
+			return
+		}
+		recipeItemID = entry.ItemID
+	}
+
+	var attachmentURL string
+	if attachmentID != "" {
+		var attachment *discordgo.MessageAttachment
+		if commandData.Resolved != nil {
+			attachment = commandData.Resolved.Attachments[attachmentID]
+		}
+		if attachment == nil {
+			dc.respondInstant(ctx, ic, "Couldn't read the attached file, try pasting the list into `items` instead.")
+			return
+		}
+		if attachment.Size > maxShoppingListBytes {
+			dc.respondInstant(ctx, ic, "That file is too big to be a shopping list.")
+			return
+		}
+		attachmentURL = attachment.URL
+	}
+
+	// Verified parameters, so ack the message while we compute.
+	dc.respondAck(ctx, ic)
+
+	var wants []shopping.Want
+	var err error
+	if recipeItemID != 0 {
+		wants, err = dc.shoppingListFromRecipe(ctx, recipeItemID, crafts, expand)
+		if err != nil {
+			dc.logger.Errorw("failed to build shopping list from recipe",
+				"item_id", recipeItemID,
+				"error", err)
+			dc.respondFollowup(ctx, ic, fmt.Sprintf("Failed to find a recipe for %s.", recipeItem))
+			return
+		}
+	} else {
+		if attachmentURL != "" {
+			itemsText, err = downloadAttachment(ctx, attachmentURL)
+			if err != nil {
+				dc.logger.Errorw("failed to download shopping list attachment",
+					"url", attachmentURL,
+					"error", err)
+				dc.respondFollowup(ctx, ic, "Couldn't download the attached file, try pasting the list into `items` instead.")
+				return
+			}
+		}
+		var message string
+		wants, message = dc.shoppingListFromText(itemsText)
+		if message != "" {
+			dc.respondFollowup(ctx, ic, message)
+			return
+		}
+	}
+
+	plan, err := dc.planShopping(ctx, wants, scope, shopping.Options{
+		TravelPenalty: travelPenalty,
+		NQOnly:        *nqOnly,
+	})
+	if err != nil {
+		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to get listings"),
+			"command_name", commandData.Name,
+			"database_error", err)
+		dc.respondFollowup(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+		return
+	}
+	r := shoppingPlanReport(plan, settings)
+	r.title = fmt.Sprintf("Shopping on %s", scope.Name)
+	dc.respondFollowupWithReport(ctx, ic, fmt.Sprintf("Shopping plan on %s, visiting %d world(s):", scope.Name, len(plan.Worlds)), r, settings.priceModel, format)
+}
+
+// planShopping finds the cheapest way to buy wants from the listings across
+// the datacenter.
+func (dc *Discord) planShopping(ctx context.Context, wants []shopping.Want, datacenter *postgres.Scope, opts shopping.Options) (*shopping.Plan, error) {
+	var itemIDs []int32
+	for _, want := range wants {
+		itemIDs = append(itemIDs, want.ItemID)
+	}
+	listingRows, err := dc.store.CurrentListingsInDatacenter(ctx, itemIDs, datacenter.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var listings []shopping.Listing
+	for _, row := range listingRows {
+		listings = append(listings, shopping.Listing{
+			ItemID:       row.ItemID,
+			WorldName:    row.WorldName,
+			PricePerUnit: row.PricePerUnit,
+			Quantity:     row.Quantity,
+			HighQuality:  row.HighQuality,
+		})
+	}
+	return shopping.Optimize(wants, listings, opts), nil
+}
+
+// shoppingListFromText resolves a pasted list. The returned message is meant for
+// the user and is non-empty when the list can't be used.
+func (dc *Discord) shoppingListFromText(text string) ([]shopping.Want, string) {
+	entries, err := shopping.ParseList(text)
+	if err != nil {
+		return nil, fmt.Sprintf("Couldn't read the shopping list: %s.", err)
+	}
+
+	var wants []shopping.Want
+	var unknown []string
+	for _, entry := range entries {
+		item, ok := dc.resolveItemName(entry.Name)
+		if !ok {
+			unknown = append(unknown, fmt.Sprintf("`%s`.%s", entry.Name, dc.didYouMean(entry.Name)))
+			continue
+		}
+		wants = append(wants, shopping.Want{
+			ItemID:   item.ItemID,
+			Name:     item.Name,
+			Quantity: entry.Quantity,
+		})
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Sprintf("Couldn't find these items:\n%s", strings.Join(unknown, "\n"))
+	}
+	return wants, ""
+}
+
+// shoppingListFromRecipe lists the ingredients for crafting the item the given
+// number of times, optionally walking down into craftable ingredients.
+func (dc *Discord) shoppingListFromRecipe(ctx context.Context, itemID int32, crafts int, expand bool) ([]shopping.Want, error) {
+	recipe, err := dc.store.RecipesDetailsForItemID(ctx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if len(recipe.Ingredients) == 0 {
+		return nil, fmt.Errorf("item %v has no recipe", itemID)
+	}
+
+	quantities := make(map[int32]int)
+	names := make(map[int32]string)
+	var order []int32
+	var walk func(recipe *postgres.RecipeDetails, crafts int, depth int) error
+	walk = func(recipe *postgres.RecipeDetails, crafts int, depth int) error {
+		// Crystals have to be bought too, though nothing crafts them.
+		for _, ing := range slices.Concat(recipe.Ingredients, recipe.Crystals) {
+			need := int(ing.Count) * crafts
+			if expand && depth < maxRecipeDepth {
+				sub, err := dc.store.RecipesDetailsForItemID(ctx, ing.ItemID)
+				if err != nil {
+					return err
+				}
+				if len(sub.Ingredients) > 0 && sub.CraftedItemCount > 0 {
+					// Round up, a craft yielding 3 still needs a whole craft for the 4th.
+					subCrafts := (need + int(sub.CraftedItemCount) - 1) / int(sub.CraftedItemCount)
+					if err := walk(sub, subCrafts, depth+1); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if _, ok := quantities[ing.ItemID]; !ok {
+				order = append(order, ing.ItemID)
+				names[ing.ItemID] = ing.Name
+			}
+			quantities[ing.ItemID] += need
+		}
+		return nil
+	}
+	if err := walk(recipe, crafts, 0); err != nil {
+		return nil, err
+	}
+
+	var wants []shopping.Want
+	for _, id := range order {
+		wants = append(wants, shopping.Want{
+			ItemID:   id,
+			Name:     names[id],
+			Quantity: quantities[id],
+		})
+	}
+	return wants, nil
+}
+
+func downloadAttachment(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build attachment request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download attachment: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status downloading attachment: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxShoppingListBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to read attachment: %w", err)
+	}
+	return string(body), nil
+}
+
+func shoppingPlanReport(plan *shopping.Plan, settings *effectiveSettings) *report {
+	r := &report{
+		header:       table.Row{"World", "Item", "HQ", "Price per unit", "Quantity", "Total Price"},
+		filterLabel:  "World",
+		filterColumn: 0,
+		format:       settings.formatCell,
+	}
+	for _, p := range plan.Purchases {
+		hq := ""
+		if p.HighQuality {
+			hq = "HQ"
+		}
+		r.rows = append(r.rows, table.Row{
+			p.WorldName,
+			p.Name,
+			hq,
+			p.PricePerUnit,
+			p.Quantity,
+			p.PricePerUnit * p.Quantity,
+		})
+	}
+	r.summary = append(r.summary,
+		table.Row{"Materials", "", "", "", "", plan.ItemCost},
+		table.Row{"Travel penalty", fmt.Sprintf("%d world(s)", len(plan.Worlds)), "", "", "", plan.TravelCost},
+		table.Row{"Total", "", "", "", "", plan.TotalCost()},
+	)
+	for _, s := range plan.Shortfalls {
+		r.summary = append(r.summary, table.Row{"Not enough listed", s.Name, "", "", s.Quantity, ""})
+	}
+	return r
+}
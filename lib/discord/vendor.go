@@ -0,0 +1,122 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"profiteeringway/lib/postgres"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+const (
+	defaultVendorMinMarkup = 2.0
+	defaultVendorLimit     = 25
+	maxVendorLimit         = 100
+)
+
+func CommandVendor() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Type:        discordgo.ChatApplicationCommand,
+		Name:        COMMAND_VENDOR,
+		Description: "Finds items NPC vendors sell for well under the market board price. (version 3)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "world_name",
+				Description:  "The world, datacenter, or region to sell on, defaults to the server's home region.",
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionNumber,
+				Name:        "min_markup",
+				Description: fmt.Sprintf("Only show items listed at this many times the vendor price or more (default %v).", defaultVendorMinMarkup),
+				MinValue:    &[]float64{1}[0],
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "limit",
+				Description: fmt.Sprintf("How many items to show (default %d).", defaultVendorLimit),
+				MinValue:    &[]float64{1}[0],
+				MaxValue:    maxVendorLimit,
+			},
+			formatOption(),
+		},
+	}
+}
+
+func vendorArbitrageReport(rows []*postgres.VendorArbitrageRow, settings *effectiveSettings) *report {
+	r := &report{
+		header:       table.Row{"Item", "World", "Vendor price", "Market price", "Markup", "Gross profit per unit", "Net profit per unit", "Sales per day"},
+		filterLabel:  "World",
+		filterColumn: 1,
+		format:       settings.formatCell,
+	}
+	for _, row := range rows {
+		r.rows = append(r.rows, table.Row{
+			row.Name,
+			row.WorldName,
+			row.GilPrice,
+			row.MinPrice,
+			multiple(row.Markup()),
+			row.ProfitPerUnit(),
+			settings.fees.Net(row.MinPrice) - row.GilPrice,
+			row.SaleVelocity,
+		})
+	}
+	return r
+}
+
+func (dc *Discord) handleVendor(ctx context.Context, ic *discordgo.InteractionCreate) {
+	commandData := ic.ApplicationCommandData()
+	var worldName, format string
+	minMarkup := defaultVendorMinMarkup
+	limit := defaultVendorLimit
+	for _, option := range commandData.Options {
+		optName := option.Name
+		switch optName {
+		case "world_name":
+			worldName = option.StringValue()
+		case "min_markup":
+			minMarkup = option.FloatValue()
+		case "limit":
+			limit = int(option.IntValue())
+		case "format":
+			format = option.StringValue()
+		default:
+			dc.logger.Warnw(logWithEvent(interactionCreateEventName, "unexpected option name"),
+				"command_name", commandData.Name,
+				"option_name", optName)
+		}
+	}
+
+	settings, ok := dc.settingsFor(ctx, ic)
+	if !ok {
+		return
+	}
+	scope, ok := dc.resolveScopeOrHome(ctx, ic, worldName, settings)
+	if !ok {
+		return
+	}
+
+	// Verified parameters, so ack the message while we compute.
+	dc.respondAck(ctx, ic)
+
+	rows, err := dc.store.VendorArbitrage(ctx, scope, minMarkup, limit)
+	if err != nil {
+		dc.logger.Errorw(logWithEvent(interactionCreateEventName, "failed to get vendor arbitrage"),
+			"command_name", commandData.Name,
+			"database_error", err)
+		dc.respondFollowup(ctx, ic, "A database lookup error has occurred. Tell Req to check the logs.")
+		return
+	}
+
+	if len(rows) == 0 {
+		dc.respondFollowup(ctx, ic, fmt.Sprintf("No vendor items are listed at %vx their vendor price or more in %s.", minMarkup, scope.Name))
+		return
+	}
+
+	r := vendorArbitrageReport(rows, settings)
+	r.title = fmt.Sprintf("Vendor arbitrage in %s", scope.Name)
+	dc.respondFollowupWithReport(ctx, ic, fmt.Sprintf("Vendor items worth reselling in %s, net profits after %s:", scope.Name, settings.fees), r, settings.priceModel, format)
+}
@@ -0,0 +1,231 @@
+// Package export writes tables as CSV, or as XLSX workbooks with numeric cells
+// typed as numbers so spreadsheets can sort and sum them.
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type Format string
+
+const (
+	CSV  Format = "csv"
+	XLSX Format = "xlsx"
+)
+
+var Formats = []Format{CSV, XLSX}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if strings.EqualFold(strings.TrimSpace(s), string(f)) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown export format %q, pick one of csv or xlsx", s)
+}
+
+// FormatForPath picks the format from the file extension, e.g. report.xlsx.
+func FormatForPath(path string) (Format, error) {
+	return ParseFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+func (f Format) ContentType() string {
+	if f == XLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv"
+}
+
+// Table is the cells of a report. Integer and float cells, including named
+// types like a markup, are numbers; anything else is written as fmt.Sprint
+// writes it, and nil cells are left empty.
+type Table struct {
+	// Name of the worksheet.
+	Name   string
+	Header []string
+	Rows   [][]interface{}
+}
+
+func Write(w io.Writer, f Format, t *Table) error {
+	switch f {
+	case CSV:
+		return writeCSV(w, t)
+	case XLSX:
+		return writeXLSX(w, t)
+	default:
+		return fmt.Errorf("unknown export format %q", f)
+	}
+}
+
+// number is the cell as a number, and false when it isn't one.
+func number(cell interface{}) (string, bool) {
+	if cell == nil {
+		return "", false
+	}
+	v := reflect.ValueOf(cell)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), true
+	}
+	return "", false
+}
+
+func text(cell interface{}) string {
+	if cell == nil {
+		return ""
+	}
+	if n, ok := number(cell); ok {
+		return n
+	}
+	return fmt.Sprint(cell)
+}
+
+func writeCSV(w io.Writer, t *Table) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.Header); err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = text(cell)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// The fixed parts of a workbook with a single worksheet.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+)
+
+// Excel limits sheet names to 31 characters, none of them these.
+const (
+	maxSheetName      = 31
+	invalidSheetChars = `[]:*?/\`
+)
+
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(invalidSheetChars, r) {
+			return '-'
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > maxSheetName {
+		name = string(runes[:maxSheetName])
+	}
+	if strings.TrimSpace(name) == "" {
+		return "Report"
+	}
+	return name
+}
+
+// columnName is the spreadsheet name of the zero based column, A to Z then AA.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func writeSheet(w io.Writer, t *Table) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	writeRow := func(r int, cells []interface{}) {
+		fmt.Fprintf(&b, `<row r="%d">`, r)
+		for c, cell := range cells {
+			ref := fmt.Sprintf("%s%d", columnName(c), r)
+			if n, ok := number(cell); ok {
+				fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, n)
+			} else if s := text(cell); s != "" {
+				fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, escape(s))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	header := make([]interface{}, len(t.Header))
+	for i, h := range t.Header {
+		header[i] = h
+	}
+	writeRow(1, header)
+	for i, row := range t.Rows {
+		writeRow(i+2, row)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeXLSX(w io.Writer, t *Table) error {
+	zw := zip.NewWriter(w)
+	parts := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"[Content_Types].xml", constant(xlsxContentTypes)},
+		{"_rels/.rels", constant(xlsxRels)},
+		{"xl/workbook.xml", constant(fmt.Sprintf(xlsxWorkbook, escape(sheetName(t.Name))))},
+		{"xl/_rels/workbook.xml.rels", constant(xlsxWorkbookRels)},
+		{"xl/worksheets/sheet1.xml", func(w io.Writer) error { return writeSheet(w, t) }},
+	}
+	for _, part := range parts {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to workbook: %w", part.name, err)
+		}
+		if err := part.write(pw); err != nil {
+			return fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+func constant(s string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+}
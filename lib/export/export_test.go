@@ -0,0 +1,108 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+type markup float64
+
+func (m markup) String() string { return fmt.Sprintf("%.1fx", float64(m)) }
+
+func testTable() *Table {
+	return &Table{
+		Name:   "Crafts in North-America",
+		Header: []string{"Item", "Cost", "Markup", "Note"},
+		Rows: [][]interface{}{
+			{"Rroneek Steak", 274, markup(2.5), nil},
+			{`Salted "Miq'abob", large`, -10, 0.25, "a < b & c"},
+		},
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, CSV, testTable()); err != nil {
+		t.Fatal(err)
+	}
+	want := "Item,Cost,Markup,Note\nRroneek Steak,274,2.5,\n\"Salted \"\"Miq'abob\"\", large\",-10,0.25,a < b & c\n"
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, XLSX, testTable()); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("workbook is missing %s", name)
+		}
+	}
+	if workbook := files["xl/workbook.xml"]; !strings.Contains(workbook, `<sheet name="Crafts in North-America"`) {
+		t.Errorf("expected the sheet named after the table, got %s", workbook)
+	}
+	sheet := files["xl/worksheets/sheet1.xml"]
+	for _, want := range []string{
+		`<c r="A1" t="inlineStr"><is><t xml:space="preserve">Item</t></is></c>`,
+		`<c r="B2"><v>274</v></c>`,
+		`<c r="C2"><v>2.5</v></c>`,
+		`<c r="B3"><v>-10</v></c>`,
+		`<t xml:space="preserve">Salted &#34;Miq&#39;abob&#34;, large</t>`,
+		`<t xml:space="preserve">a &lt; b &amp; c</t>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("sheet doesn't contain %s:\n%s", want, sheet)
+		}
+	}
+	if strings.Contains(sheet, `r="D2"`) {
+		t.Error("expected the nil cell to be left out")
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestSheetName(t *testing.T) {
+	if got := sheetName("Gil/day in [Aether]: a very long report title"); got != "Gil-day in -Aether-- a very lon" {
+		t.Errorf("got %q", got)
+	}
+	if got := sheetName(""); got != "Report" {
+		t.Errorf("got %q for no name", got)
+	}
+}
+
+func TestFormatForPath(t *testing.T) {
+	if f, err := FormatForPath("out/crafts.XLSX"); err != nil || f != XLSX {
+		t.Errorf("got %v, %v", f, err)
+	}
+	if _, err := FormatForPath("crafts.txt"); err == nil {
+		t.Error("expected an error for a .txt file")
+	}
+}
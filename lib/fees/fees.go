@@ -0,0 +1,158 @@
+// Package fees models what selling on the market board costs, so profits can
+// be reported net of it as well as gross.
+package fees
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultTaxRate is the market tax of a city whose rate isn't configured. It's
+// also the highest rate a city can set, so it never overstates a profit.
+const DefaultTaxRate = 0.05
+
+// City is a city with a market board. Each taxes the sales of retainers listed
+// there at its own rate.
+type City string
+
+const (
+	LimsaLominsa City = "limsa_lominsa"
+	Gridania     City = "gridania"
+	Uldah        City = "uldah"
+	Ishgard      City = "ishgard"
+	Kugane       City = "kugane"
+	Crystarium   City = "crystarium"
+	OldSharlayan City = "old_sharlayan"
+	Tuliyollal   City = "tuliyollal"
+)
+
+// Cities lists every city in the order the game does.
+var Cities = []City{LimsaLominsa, Gridania, Uldah, Ishgard, Kugane, Crystarium, OldSharlayan, Tuliyollal}
+
+var cityNames = map[City]string{
+	LimsaLominsa: "Limsa Lominsa",
+	Gridania:     "Gridania",
+	Uldah:        "Ul'dah",
+	Ishgard:      "Ishgard",
+	Kugane:       "Kugane",
+	Crystarium:   "The Crystarium",
+	OldSharlayan: "Old Sharlayan",
+	Tuliyollal:   "Tuliyollal",
+}
+
+func (c City) String() string {
+	if name, ok := cityNames[c]; ok {
+		return name
+	}
+	return string(c)
+}
+
+// ParseCity reads a city by its ID or name, case insensitively.
+func ParseCity(s string) (City, error) {
+	s = strings.TrimSpace(s)
+	for _, city := range Cities {
+		if strings.EqualFold(s, string(city)) || strings.EqualFold(s, city.String()) {
+			return city, nil
+		}
+	}
+	return "", fmt.Errorf("unknown city %q", s)
+}
+
+// Channel is how an item is sold.
+type Channel string
+
+const (
+	// ChannelRetainer is listing on the market board through a retainer, taxed
+	// by the city the retainer is listed in.
+	ChannelRetainer Channel = "retainer"
+	// ChannelDirect is selling to other players by trade, which isn't taxed.
+	ChannelDirect Channel = "direct"
+)
+
+// Rates are market tax rates by city, as fractions of a sale.
+type Rates map[City]float64
+
+// Rate is the city's tax rate, DefaultTaxRate when it isn't configured.
+func (r Rates) Rate(city City) float64 {
+	if rate, ok := r[city]; ok {
+		return rate
+	}
+	return DefaultTaxRate
+}
+
+// ParseRates reads city=percent pairs separated by commas, e.g.
+// kugane=3,gridania=0. Cities left out keep DefaultTaxRate.
+func ParseRates(s string) (Rates, error) {
+	rates := make(Rates)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, percent, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected city=percent, got %q", pair)
+		}
+		city, err := ParseCity(name)
+		if err != nil {
+			return nil, err
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || rate < 0 || rate > 100 {
+			return nil, fmt.Errorf("expected a percentage between 0 and 100 for %s, got %q", city, percent)
+		}
+		rates[city] = rate / 100
+	}
+	return rates, nil
+}
+
+// Model returns the fees of selling through the channel in the city. Without a
+// city the rate is DefaultTaxRate.
+func (r Rates) Model(city City, channel Channel) Model {
+	m := Model{City: city, Channel: channel, TaxRate: DefaultTaxRate}
+	if city != "" {
+		m.TaxRate = r.Rate(city)
+	}
+	if channel == ChannelDirect {
+		m.TaxRate = 0
+	}
+	return m
+}
+
+// Model is what selling costs one seller. The zero Model charges nothing.
+type Model struct {
+	City    City
+	Channel Channel
+	TaxRate float64
+}
+
+// Fee is what a sale at gross costs, rounded down as the market board does.
+// The epsilon keeps rates like 0.03, which floats hold as a hair under, from
+// rounding a whole fee down by one.
+func (m Model) Fee(gross int) int {
+	return int(math.Floor(float64(gross)*m.TaxRate + 1e-9))
+}
+
+// Net is what a sale at gross brings in.
+func (m Model) Net(gross int) int {
+	return gross - m.Fee(gross)
+}
+
+// NetRate is what a sale worth rate, e.g. gil per currency, brings in.
+func (m Model) NetRate(rate float64) float64 {
+	return rate * (1 - m.TaxRate)
+}
+
+// String describes the model for report labels, e.g. "5% tax in Kugane".
+func (m Model) String() string {
+	if m.Channel == ChannelDirect {
+		return "no tax selling directly"
+	}
+	percent := strconv.FormatFloat(m.TaxRate*100, 'f', -1, 64)
+	if m.City == "" {
+		return fmt.Sprintf("%s%% tax", percent)
+	}
+	return fmt.Sprintf("%s%% tax in %s", percent, m.City)
+}
@@ -0,0 +1,50 @@
+package fees
+
+import "testing"
+
+func TestModel(t *testing.T) {
+	rates := Rates{Kugane: 0.03, Gridania: 0}
+	tests := []struct {
+		name    string
+		city    City
+		channel Channel
+		gross   int
+		wantNet int
+		want    string
+	}{
+		{name: "no city", channel: ChannelRetainer, gross: 5000, wantNet: 4750, want: "5% tax"},
+		{name: "unset channel is a retainer", gross: 5000, wantNet: 4750, want: "5% tax"},
+		{name: "configured city", city: Kugane, channel: ChannelRetainer, gross: 100, wantNet: 97, want: "3% tax in Kugane"},
+		{name: "untaxed city", city: Gridania, channel: ChannelRetainer, gross: 100, wantNet: 100, want: "0% tax in Gridania"},
+		{name: "unconfigured city", city: Uldah, channel: ChannelRetainer, gross: 100, wantNet: 95, want: "5% tax in Ul'dah"},
+		{name: "fees round down", channel: ChannelRetainer, gross: 39, wantNet: 38, want: "5% tax"},
+		{name: "direct", city: Kugane, channel: ChannelDirect, gross: 5000, wantNet: 5000, want: "no tax selling directly"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := rates.Model(tt.city, tt.channel)
+			if got := m.Net(tt.gross); got != tt.wantNet {
+				t.Errorf("Net(%d) = %d, want %d", tt.gross, got, tt.wantNet)
+			}
+			if got := m.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRates(t *testing.T) {
+	rates, err := ParseRates(" kugane=3, Old Sharlayan=0 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 || rates[Kugane] != 0.03 || rates[OldSharlayan] != 0 {
+		t.Errorf("ParseRates() = %v, want Kugane at 3%% and Old Sharlayan at 0%%", rates)
+	}
+
+	for _, bad := range []string{"kugane", "kugane=six", "kugane=101", "atlantis=3"} {
+		if _, err := ParseRates(bad); err == nil {
+			t.Errorf("ParseRates(%q) succeeded, want an error", bad)
+		}
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"profiteeringway/lib/postgres"
 	"profiteeringway/lib/universalis"
-	"strings"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/time/rate"
 )
 
@@ -19,146 +22,593 @@ type Hotlist struct {
 	WorldIDs      []int
 }
 
+// AdaptiveHotlistName is what adaptive polling shows up as in stats and metrics.
+const AdaptiveHotlistName = "Adaptive"
+
+const (
+	// Delay before the first poll, so a restart doesn't hit Universalis instantly.
+	initialPollDelay = 5 * time.Second
+	// How long CleanUp lets in-flight writes finish before cancelling them.
+	defaultDrainTimeout = 30 * time.Second
+	// Longest a failing world is left before it's retried.
+	maxPollBackoff = 4 * time.Hour
+)
+
+// FetchFunc retrieves market data for items on a world.
+type FetchFunc func(ctx context.Context, worldID int, itemIDs []int) (*universalis.UniversalisPriceData, error)
+
+// PriceWriter persists market data, implemented by *postgres.Postgres.
+type PriceWriter interface {
+	WriteUniversalisPriceData(ctx context.Context, upd *universalis.UniversalisPriceData) (int, error)
+}
+
+// PollStateStore persists where polling left off, implemented by *postgres.Postgres.
+type PollStateStore interface {
+	LoadPollStates(ctx context.Context) ([]*postgres.PollState, error)
+	SavePollState(ctx context.Context, ps *postgres.PollState) error
+}
+
+type pollStateKey struct {
+	hotlist string
+	worldID int
+}
+
+// HotlistHub polls every configured hotlist on its own goroutine. All of them
+// hang off one root context, so CleanUp can stop everything at once while
+// StopPolling stops a single hotlist.
 type HotlistHub struct {
 	ConfiguredHotlists map[string]*Hotlist
-	enabledHotlists    map[string]struct{}
-	resultChan         chan *timerResult
-	stopChans          map[string]chan struct{}
-	cleanupChan        chan struct{}
+
+	mu      sync.Mutex
+	running map[string]*runningHotlist
+	stats   map[string]*HotlistStats
+	// Per hotlist and world, loaded from states the first time polling begins.
+	pollStates       map[pollStateKey]*postgres.PollState
+	pollStatesLoaded bool
+
+	// Polling stops when ctx is cancelled. Writes use writeCtx, which is only
+	// cancelled once the drain deadline passes, so a write that already has its
+	// data isn't thrown away on shutdown.
+	ctx         context.Context
+	cancel      context.CancelFunc
+	writeCtx    context.Context
+	writeCancel context.CancelFunc
+	group       *errgroup.Group
+
 	universalisLimiter *rate.Limiter
 	postgresLimiter    *rate.Limiter
-	pg                 *postgres.Postgres
+	fetch              FetchFunc
+	pg                 PriceWriter
+	states             PollStateStore
+	clock              Clock
+	scheduler          *Scheduler
+	drainTimeout       time.Duration
 	logger             *zap.SugaredLogger
 }
 
-type timerResult struct {
-	success bool
-	message string
-	err     error
+type runningHotlist struct {
+	hotlist *Hotlist
+	cancel  context.CancelFunc
+	done    chan struct{}
 }
 
-func NewHotlistHub(db *postgres.Postgres, logger *zap.SugaredLogger) *HotlistHub {
-	cleanupChan := make(chan struct{})
-	resultChan := make(chan *timerResult)
+// HotlistStats is a point in time summary of a hotlist's polling.
+type HotlistStats struct {
+	Name            string
+	Running         bool
+	Polling         bool
+	Polls           int
+	WorldsSucceeded int
+	WorldsFailed    int
+	RowsWritten     int
+	LastPollStart   time.Time
+	LastPollEnd     time.Time
+	LastError       string
+}
+
+// Store is where the hub writes prices and poll state, implemented by
+// *postgres.Postgres and *store.Memory.
+type Store interface {
+	PriceWriter
+	PollStateStore
+}
+
+// NewHotlistHub polls Universalis with fetch, normally
+// universalis.GetItemDataContext or the method of a replaying Client.
+func NewHotlistHub(db Store, fetch FetchFunc, logger *zap.SugaredLogger) *HotlistHub {
+	return newHotlistHub(db, db, fetch, realClock{}, logger)
+}
 
-	// Set up HTTP rate limiting to 5 qps.
-	var five_qps rate.Limit = 5.0
+// states may be nil, in which case every start begins from scratch.
+func newHotlistHub(pg PriceWriter, states PollStateStore, fetch FetchFunc, clock Clock, logger *zap.SugaredLogger) *HotlistHub {
 	// Postgres write limit to 20 qps.
 	var twenty_qps rate.Limit = 20.0
-	l := rate.NewLimiter(five_qps, 2)
+	l := rate.NewLimiter(universalis.RequestsPerSecond, universalis.RequestBurst)
 	pgl := rate.NewLimiter(twenty_qps, 10)
 
-	go func(resultChan chan *timerResult, cleanupChan chan struct{}) {
-		done := false
-		for {
-			if done {
-				break
-			}
-			var result *timerResult
-			select {
-			case result = <-resultChan:
-				if result.err != nil {
-					logger.Warnf("failed to poll with error %s: %s", result.message, result.err)
-				} else if !result.success {
-					logger.Warnf("failed to poll with details %s", result.message)
-				} else {
-					logger.Infof("successfully polled %s", result.message)
-				}
-			case <-cleanupChan:
-				for {
-					// Ensure resultChan is closed before cleaning up this goroutine.
-					if _, ok := <-resultChan; ok {
-						done = true
-						logger.Infow("received shutdown signal for hub")
-					}
-				}
-			}
-		}
-	}(resultChan, cleanupChan)
+	rootCtx, cancel := context.WithCancel(context.Background())
+	group, ctx := errgroup.WithContext(rootCtx)
+	writeCtx, writeCancel := context.WithCancel(context.Background())
 
 	return &HotlistHub{
 		ConfiguredHotlists: map[string]*Hotlist{},
-		enabledHotlists:    map[string]struct{}{},
-		resultChan:         resultChan,
-		stopChans:          map[string]chan struct{}{},
-		cleanupChan:        cleanupChan,
+		running:            map[string]*runningHotlist{},
+		stats:              map[string]*HotlistStats{},
+		pollStates:         map[pollStateKey]*postgres.PollState{},
+		ctx:                ctx,
+		cancel:             cancel,
+		writeCtx:           writeCtx,
+		writeCancel:        writeCancel,
+		group:              group,
 		universalisLimiter: l,
 		postgresLimiter:    pgl,
-		pg:                 db,
+		fetch:              fetch,
+		pg:                 pg,
+		states:             states,
+		clock:              clock,
+		scheduler:          NewScheduler(DefaultSchedulerConfig(), clock),
+		drainTimeout:       defaultDrainTimeout,
 		logger:             logger,
 	}
 }
 
+// BeginPollingAll starts every configured hotlist that isn't already polling.
 func (h *HotlistHub) BeginPollingAll() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.ctx.Err(); err != nil {
+		return fmt.Errorf("hub is shut down: %w", err)
+	}
+	h.loadPollStates()
+
 	for name, hl := range h.ConfiguredHotlists {
-		if _, ok := h.enabledHotlists[name]; ok {
+		if _, ok := h.running[name]; ok {
 			continue
 		}
 
-		stopChan := make(chan struct{})
+		ctx, cancel := context.WithCancel(h.ctx)
+		rh := &runningHotlist{
+			hotlist: hl,
+			cancel:  cancel,
+			done:    make(chan struct{}),
+		}
+		h.running[name] = rh
+		if _, ok := h.stats[name]; !ok {
+			h.stats[name] = &HotlistStats{Name: name}
+		}
+		h.stats[name].Running = true
 
-		go func(hotlist *Hotlist, result chan *timerResult, stopSignal chan struct{}) {
-			ctx := context.Background()
-			duration, err := time.ParseDuration("5s")
-			if err != nil {
-				panic("failed to parse duration when initializing timer")
-			}
-			fetchSignal := time.After(duration)
-			done := false
-			for {
-				if done {
-					break
-				}
-				select {
-				case <-fetchSignal:
-					successCount := 0
-					var sb strings.Builder
-					for _, worldID := range hotlist.WorldIDs {
-						if err := h.universalisLimiter.Wait(ctx); err != nil {
-							sb.WriteString(fmt.Sprintf("error universalis rate limiting %s", err))
-							continue
-						}
-						marketData, err := universalis.GetItemData(worldID, hotlist.ItemIDs)
-						successCount += 1
-						if err != nil {
-							sb.WriteString(fmt.Sprintf("error getting data %s", err))
-							continue
-						}
-						if err := h.postgresLimiter.Wait(ctx); err != nil {
-							sb.WriteString(fmt.Sprintf("error postgres rate limiting %s", err))
-							continue
-						}
-						if err := h.pg.WriteUniversalisPriceData(ctx, marketData); err != nil {
-							sb.WriteString(fmt.Sprintf("error writing to postgres %s", err))
-							continue
-						}
-						successCount += 1
-						sb.WriteString(fmt.Sprintf("successfully wrote for hotlist %s", hotlist.Name))
-					}
-
-					// Reset the timer.
-					fetchSignal = time.After(hotlist.PollFrequency)
-
-					h.logger.Infow("fetch for hotlist resulted in",
-						"hotlist", hotlist.Name,
-						"message", sb.String())
-				case <-stopSignal:
-					done = true
-				}
+		h.group.Go(func() error {
+			return h.run(ctx, rh)
+		})
+	}
+	return nil
+}
+
+// BeginAdaptivePolling tracks the items of every configured hotlist in the
+// scheduler and polls them from a single loop under the name AdaptiveHotlistName,
+// each item on its own interval. Run it instead of BeginPollingAll.
+func (h *HotlistHub) BeginAdaptivePolling(cfg SchedulerConfig) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.ctx.Err(); err != nil {
+		return fmt.Errorf("hub is shut down: %w", err)
+	}
+	if _, ok := h.running[AdaptiveHotlistName]; ok {
+		return fmt.Errorf("adaptive polling already running")
+	}
+
+	h.loadPollStates()
+	h.scheduler.configure(cfg)
+	for _, hl := range h.ConfiguredHotlists {
+		h.scheduler.Track(hl.WorldIDs, hl.ItemIDs)
+	}
+	// Don't refetch what was fetched just before a restart.
+	for key, ps := range h.pollStates {
+		if key.hotlist == AdaptiveHotlistName && !ps.LastSuccess.IsZero() {
+			h.scheduler.Resume(key.worldID, ps.LastSuccess)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	rh := &runningHotlist{
+		hotlist: &Hotlist{Name: AdaptiveHotlistName},
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	h.running[AdaptiveHotlistName] = rh
+	if _, ok := h.stats[AdaptiveHotlistName]; !ok {
+		h.stats[AdaptiveHotlistName] = &HotlistStats{Name: AdaptiveHotlistName}
+	}
+	h.stats[AdaptiveHotlistName].Running = true
+
+	h.group.Go(func() error {
+		return h.runAdaptive(ctx, rh, cfg)
+	})
+	return nil
+}
+
+// RecordInterest tells the scheduler someone looked up the item, so it's
+// refreshed sooner.
+func (h *HotlistHub) RecordInterest(itemID int) {
+	h.scheduler.RecordInterest(itemID)
+}
+
+func (h *HotlistHub) runAdaptive(ctx context.Context, rh *runningHotlist, cfg SchedulerConfig) error {
+	defer close(rh.done)
+	defer h.updateStats(rh.hotlist.Name, func(s *HotlistStats) {
+		s.Running = false
+	})
+
+	// Spend the per-minute budget evenly across ticks.
+	budget := max(1, int(float64(cfg.RequestsPerMinute)*cfg.Tick.Seconds()/time.Minute.Seconds()))
+	wait := initialPollDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-h.clock.After(wait):
+		}
+
+		var batches []Batch
+		now := h.clock.Now()
+		for _, batch := range h.scheduler.Due(budget) {
+			if due, ok := h.backingOff(rh.hotlist.Name, batch.WorldID, now); ok {
+				h.logger.Debugw("skipping world in backoff",
+					"hotlist", rh.hotlist.Name,
+					"world_id", batch.WorldID,
+					"next_due", due)
+				continue
 			}
-		}(hl, h.resultChan, stopChan)
+			batches = append(batches, batch)
+		}
+		if len(batches) > 0 {
+			h.pollBatches(ctx, rh.hotlist.Name, batches, cfg.MinInterval)
+		}
+		wait = cfg.Tick
+	}
+}
 
-		h.stopChans[name] = stopChan
-		h.enabledHotlists[name] = struct{}{}
+// StopPolling stops one hotlist and waits for its goroutine to exit. An
+// in-flight write is allowed to finish.
+func (h *HotlistHub) StopPolling(name string) error {
+	h.mu.Lock()
+	rh, ok := h.running[name]
+	if ok {
+		delete(h.running, name)
+	}
+	h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("hotlist %s is not polling", name)
 	}
+
+	rh.cancel()
+	<-rh.done
 	return nil
 }
 
+func (h *HotlistHub) run(ctx context.Context, rh *runningHotlist) error {
+	defer close(rh.done)
+	defer h.updateStats(rh.hotlist.Name, func(s *HotlistStats) {
+		s.Running = false
+	})
+
+	// Worlds pick up where the last run left off, but nothing is fetched
+	// before the initial delay.
+	hl := rh.hotlist
+	// With no worlds nothing is ever due, so there's nothing to wait for.
+	if len(hl.WorldIDs) == 0 {
+		h.logger.Warnw("hotlist has no worlds to poll, not starting it",
+			"hotlist", hl.Name)
+		return nil
+	}
+	earliest := h.clock.Now().Add(initialPollDelay)
+	due := make(map[int]time.Time, len(hl.WorldIDs))
+	for _, worldID := range hl.WorldIDs {
+		due[worldID] = earliest
+		if ps, ok := h.PollState(hl.Name, worldID); ok && ps.NextDue.After(earliest) {
+			due[worldID] = ps.NextDue
+		}
+	}
+
+	for {
+		var next time.Time
+		for _, t := range due {
+			if next.IsZero() || t.Before(next) {
+				next = t
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-h.clock.After(next.Sub(h.clock.Now())):
+		}
+
+		now := h.clock.Now()
+		var batches []Batch
+		for _, worldID := range hl.WorldIDs {
+			if due[worldID].After(now) {
+				continue
+			}
+			batches = append(batches, Batch{
+				WorldID: worldID,
+				ItemIDs: hl.ItemIDs,
+			})
+		}
+		// Woken before any world was due, don't count it as a poll.
+		if len(batches) == 0 {
+			continue
+		}
+		h.pollBatches(ctx, hl.Name, batches, hl.PollFrequency)
+
+		for _, batch := range batches {
+			if ps, ok := h.PollState(hl.Name, batch.WorldID); ok {
+				due[batch.WorldID] = ps.NextDue
+			} else {
+				// Cancelled before the world was reached.
+				due[batch.WorldID] = now.Add(hl.PollFrequency)
+			}
+		}
+	}
+}
+
+func (h *HotlistHub) updateStats(name string, update func(*HotlistStats)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.stats[name]
+	if !ok {
+		s = &HotlistStats{Name: name}
+		h.stats[name] = s
+	}
+	update(s)
+}
+
+// Stats returns a snapshot of every hotlist the hub has polled, sorted by name.
+func (h *HotlistHub) Stats() []HotlistStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := make([]HotlistStats, 0, len(h.stats))
+	for _, s := range h.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// pollBatches makes each request in turn, logging a summary under the hotlist
+// name and recording each world's poll state. It stops between requests once
+// ctx is cancelled. Worlds are next due frequency from now, or later when
+// they're failing.
+func (h *HotlistHub) pollBatches(ctx context.Context, name string, batches []Batch, frequency time.Duration) {
+	start := h.clock.Now()
+	h.updateStats(name, func(s *HotlistStats) {
+		s.Polling = true
+		s.LastPollStart = start
+	})
+
+	succeeded := 0
+	totalRows := 0
+	var failures []string
+	for _, batch := range batches {
+		if ctx.Err() != nil {
+			break
+		}
+		rows, err := h.pollWorld(ctx, name, batch)
+		h.recordAttempt(name, batch.WorldID, frequency, err)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("world %d: %s", batch.WorldID, err))
+			continue
+		}
+		succeeded += 1
+		totalRows += rows
+	}
+
+	end := h.clock.Now()
+	h.updateStats(name, func(s *HotlistStats) {
+		s.Polling = false
+		s.Polls += 1
+		s.WorldsSucceeded += succeeded
+		s.WorldsFailed += len(failures)
+		s.RowsWritten += totalRows
+		s.LastPollEnd = end
+		if len(failures) > 0 {
+			s.LastError = failures[len(failures)-1]
+		}
+	})
+
+	h.logger.Infow("fetch for hotlist resulted in",
+		"hotlist", name,
+		"requests", len(batches),
+		"requests_succeeded", succeeded,
+		"rows_written", totalRows,
+		"duration", end.Sub(start),
+		"failures", failures)
+}
+
+// PollState returns a copy of the state of one world of a hotlist.
+func (h *HotlistHub) PollState(name string, worldID int) (postgres.PollState, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ps, ok := h.pollStates[pollStateKey{hotlist: name, worldID: worldID}]
+	if !ok {
+		return postgres.PollState{}, false
+	}
+	return *ps, true
+}
+
+// PollStates returns a snapshot of every world's poll state, sorted by
+// hotlist then world.
+func (h *HotlistHub) PollStates() []postgres.PollState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ret := make([]postgres.PollState, 0, len(h.pollStates))
+	for _, ps := range h.pollStates {
+		ret = append(ret, *ps)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].HotlistName != ret[j].HotlistName {
+			return ret[i].HotlistName < ret[j].HotlistName
+		}
+		return ret[i].WorldID < ret[j].WorldID
+	})
+	return ret
+}
+
+// loadPollStates reads saved poll state the first time it's called. h.mu must
+// be held. Polling still starts if it fails, it just starts from scratch.
+func (h *HotlistHub) loadPollStates() {
+	if h.pollStatesLoaded || h.states == nil {
+		return
+	}
+	h.pollStatesLoaded = true
+
+	states, err := h.states.LoadPollStates(h.ctx)
+	if err != nil {
+		h.logger.Errorw("failed to load poll state, polling everything from scratch",
+			"suberror", err)
+		return
+	}
+	for _, ps := range states {
+		h.pollStates[pollStateKey{hotlist: ps.HotlistName, worldID: ps.WorldID}] = ps
+	}
+	h.logger.Infow("loaded poll state",
+		"worlds", len(states))
+}
+
+// backingOff reports whether a world is failing and not yet due for a retry.
+func (h *HotlistHub) backingOff(name string, worldID int, now time.Time) (time.Time, bool) {
+	ps, ok := h.PollState(name, worldID)
+	if !ok || ps.ConsecutiveFailures == 0 || !ps.NextDue.After(now) {
+		return time.Time{}, false
+	}
+	return ps.NextDue, true
+}
+
+// pollBackoff doubles the wait for each failure after the first, up to maxPollBackoff.
+func pollBackoff(frequency time.Duration, failures int) time.Duration {
+	wait := frequency
+	for i := 1; i < failures && wait < maxPollBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxPollBackoff)
+}
+
+// recordAttempt updates and saves a world's poll state after a poll.
+func (h *HotlistHub) recordAttempt(name string, worldID int, frequency time.Duration, pollErr error) {
+	now := h.clock.Now()
+	key := pollStateKey{hotlist: name, worldID: worldID}
+
+	h.mu.Lock()
+	ps, ok := h.pollStates[key]
+	if !ok {
+		ps = &postgres.PollState{
+			HotlistName: name,
+			WorldID:     worldID,
+		}
+		h.pollStates[key] = ps
+	}
+	ps.LastAttempt = now
+	if pollErr != nil {
+		ps.ConsecutiveFailures += 1
+		ps.LastError = pollErr.Error()
+		ps.NextDue = now.Add(pollBackoff(frequency, ps.ConsecutiveFailures))
+	} else {
+		ps.ConsecutiveFailures = 0
+		ps.LastError = ""
+		ps.LastSuccess = now
+		ps.NextDue = now.Add(frequency)
+	}
+	saved := *ps
+	h.mu.Unlock()
+
+	consecutiveFailures.With(name, strconv.Itoa(worldID)).Set(float64(saved.ConsecutiveFailures))
+	if h.states == nil {
+		return
+	}
+	if err := h.states.SavePollState(h.writeCtx, &saved); err != nil {
+		h.logger.Warnw("failed to save poll state",
+			"hotlist", name,
+			"world_id", worldID,
+			"suberror", err)
+	}
+}
+
+// pollWorld fetches and writes one batch of items on a world, returning the
+// number of rows written.
+func (h *HotlistHub) pollWorld(ctx context.Context, name string, batch Batch) (int, error) {
+	worldLabel := strconv.Itoa(batch.WorldID)
+
+	waitStart := time.Now()
+	if err := h.universalisLimiter.Wait(ctx); err != nil {
+		return 0, fmt.Errorf("universalis rate limiting: %w", err)
+	}
+	limiterWait.With("universalis").Observe(time.Since(waitStart).Seconds())
+
+	universalisRequests.With(name, worldLabel).Inc()
+	requestStart := time.Now()
+	marketData, err := h.fetch(ctx, batch.WorldID, batch.ItemIDs)
+	universalisLatency.With(name).Observe(time.Since(requestStart).Seconds())
+	if err != nil {
+		universalisErrors.With(name, universalis.ErrorType(err)).Inc()
+		return 0, fmt.Errorf("getting data: %w", err)
+	}
+	h.scheduler.Observe(marketData)
+
+	var oldestUpload int64
+	for _, item := range marketData.Items {
+		if item.LastUploadTime > 0 && (oldestUpload == 0 || item.LastUploadTime < oldestUpload) {
+			oldestUpload = item.LastUploadTime
+		}
+	}
+	if oldestUpload > 0 {
+		dataAge.With(name, worldLabel).Set(h.clock.Now().Sub(time.UnixMilli(oldestUpload)).Seconds())
+	}
+
+	// From here on the data is in hand, so only the drain deadline stops the write.
+	waitStart = time.Now()
+	if err := h.postgresLimiter.Wait(h.writeCtx); err != nil {
+		return 0, fmt.Errorf("postgres rate limiting: %w", err)
+	}
+	limiterWait.With("postgres").Observe(time.Since(waitStart).Seconds())
+
+	rows, err := h.pg.WriteUniversalisPriceData(h.writeCtx, marketData)
+	if err != nil {
+		writeErrors.With(name).Inc()
+		return 0, fmt.Errorf("writing to postgres: %w", err)
+	}
+	rowsWritten.With(name).Add(float64(rows))
+	lastSuccess.With(name, worldLabel).Set(float64(h.clock.Now().Unix()))
+	return rows, nil
+}
+
+// CleanUp stops all polling and waits for in-flight writes to finish, cancelling
+// them if they take longer than the drain timeout.
 func (h *HotlistHub) CleanUp() error {
-	h.cleanupChan <- struct{}{}
-	for _, stopChan := range h.stopChans {
-		stopChan <- struct{}{}
+	h.mu.Lock()
+	h.cancel()
+	h.running = map[string]*runningHotlist{}
+	h.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.group.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-h.clock.After(h.drainTimeout):
+		h.logger.Warnw("hotlist hub drain timed out, cancelling in-flight writes",
+			"drain_timeout", h.drainTimeout)
+		h.writeCancel()
+		err = <-done
 	}
-	close(h.resultChan)
-	return nil
+	h.writeCancel()
+	return err
 }
@@ -0,0 +1,583 @@
+package hotlist
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/universalis"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const testTimeout = 5 * time.Second
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// fakeClock only moves when Advance is called.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 7, 2, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, &fakeWaiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	var pending []*fakeWaiter
+	for _, w := range c.waiters {
+		if !w.deadline.After(c.now) {
+			w.ch <- c.now
+			continue
+		}
+		pending = append(pending, w)
+	}
+	c.waiters = pending
+}
+
+// BlockUntil waits for n goroutines to be waiting on the clock.
+func (c *fakeClock) BlockUntil(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		count := len(c.waiters)
+		c.mu.Unlock()
+		if count >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d clock waiters", n)
+}
+
+type fakeWriter struct {
+	mu     sync.Mutex
+	writes int
+	// When set, writes signal started and then wait for release or ctx.
+	started chan struct{}
+	release chan struct{}
+	ctxErr  error
+}
+
+func (w *fakeWriter) WriteUniversalisPriceData(ctx context.Context, upd *universalis.UniversalisPriceData) (int, error) {
+	if w.started != nil {
+		w.started <- struct{}{}
+		select {
+		case <-w.release:
+		case <-ctx.Done():
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ctxErr = ctx.Err()
+	if w.ctxErr != nil {
+		return 0, w.ctxErr
+	}
+	w.writes += 1
+	return len(upd.Items), nil
+}
+
+func (w *fakeWriter) Writes() (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writes, w.ctxErr
+}
+
+type fakeFetcher struct {
+	mu     sync.Mutex
+	calls  []int
+	failOn map[int]error
+}
+
+func (f *fakeFetcher) fetch(ctx context.Context, worldID int, itemIDs []int) (*universalis.UniversalisPriceData, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, worldID)
+	if err := f.failOn[worldID]; err != nil {
+		return nil, err
+	}
+	upd := &universalis.UniversalisPriceData{
+		Items: map[string]universalis.ItemPriceData{},
+	}
+	for _, id := range itemIDs {
+		upd.Items[strconv.Itoa(id)] = universalis.ItemPriceData{
+			ItemID:  id,
+			WorldID: worldID,
+		}
+	}
+	return upd, nil
+}
+
+func (f *fakeFetcher) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.calls)
+}
+
+func newTestHub(w PriceWriter, f *fakeFetcher, clock Clock) *HotlistHub {
+	h := newHotlistHub(w, nil, f.fetch, clock, zap.NewNop().Sugar())
+	h.universalisLimiter = rate.NewLimiter(rate.Inf, 1)
+	h.postgresLimiter = rate.NewLimiter(rate.Inf, 1)
+	return h
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func statsFor(t *testing.T, h *HotlistHub, name string) HotlistStats {
+	t.Helper()
+	for _, s := range h.Stats() {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no stats for hotlist %s", name)
+	return HotlistStats{}
+}
+
+func TestHubPollsOnSchedule(t *testing.T) {
+	clock := newFakeClock()
+	writer := &fakeWriter{}
+	fetcher := &fakeFetcher{failOn: map[int]error{
+		3: universalis.ErrRateLimited,
+	}}
+	h := newTestHub(writer, fetcher, clock)
+	h.ConfiguredHotlists["Materia"] = &Hotlist{
+		Name:          "Materia",
+		ItemIDs:       []int{1, 2},
+		PollFrequency: 15 * time.Minute,
+		WorldIDs:      []int{1, 2, 3},
+	}
+	if err := h.BeginPollingAll(); err != nil {
+		t.Fatalf("BeginPollingAll() = %v", err)
+	}
+
+	clock.BlockUntil(t, 1)
+	if got := fetcher.Calls(); got != 0 {
+		t.Fatalf("fetched %d times before the initial delay", got)
+	}
+	clock.Advance(initialPollDelay)
+	waitFor(t, "first poll", func() bool { return statsFor(t, h, "Materia").Polls == 1 })
+
+	clock.BlockUntil(t, 1)
+	clock.Advance(15 * time.Minute)
+	waitFor(t, "second poll", func() bool { return statsFor(t, h, "Materia").Polls == 2 })
+
+	if err := h.CleanUp(); err != nil {
+		t.Fatalf("CleanUp() = %v", err)
+	}
+
+	got := statsFor(t, h, "Materia")
+	if got.Running || got.Polling {
+		t.Errorf("stats after CleanUp = %+v, want not running", got)
+	}
+	if got.WorldsSucceeded != 4 || got.WorldsFailed != 2 || got.RowsWritten != 8 {
+		t.Errorf("stats = %+v, want 4 worlds succeeded, 2 failed, 8 rows written", got)
+	}
+	if !strings.Contains(got.LastError, "world 3") {
+		t.Errorf("LastError = %q, want it to mention world 3", got.LastError)
+	}
+	if writes, _ := writer.Writes(); writes != 4 {
+		t.Errorf("writes = %d, want 4", writes)
+	}
+}
+
+func TestStopPollingOneHotlist(t *testing.T) {
+	clock := newFakeClock()
+	h := newTestHub(&fakeWriter{}, &fakeFetcher{}, clock)
+	for _, name := range []string{"Crystals", "Materia"} {
+		h.ConfiguredHotlists[name] = &Hotlist{
+			Name:          name,
+			ItemIDs:       []int{1},
+			PollFrequency: time.Minute,
+			WorldIDs:      []int{1},
+		}
+	}
+	if err := h.BeginPollingAll(); err != nil {
+		t.Fatalf("BeginPollingAll() = %v", err)
+	}
+	clock.BlockUntil(t, 2)
+
+	if err := h.StopPolling("Crystals"); err != nil {
+		t.Fatalf("StopPolling() = %v", err)
+	}
+	if err := h.StopPolling("Crystals"); err == nil {
+		t.Errorf("StopPolling() of a stopped hotlist succeeded, want an error")
+	}
+	if statsFor(t, h, "Crystals").Running {
+		t.Errorf("Crystals still running after StopPolling")
+	}
+	if !statsFor(t, h, "Materia").Running {
+		t.Errorf("Materia stopped along with Crystals")
+	}
+
+	// Restarting picks the stopped hotlist back up.
+	if err := h.BeginPollingAll(); err != nil {
+		t.Fatalf("BeginPollingAll() = %v", err)
+	}
+	if !statsFor(t, h, "Crystals").Running {
+		t.Errorf("Crystals not running after BeginPollingAll")
+	}
+
+	if err := h.CleanUp(); err != nil {
+		t.Fatalf("CleanUp() = %v", err)
+	}
+	if err := h.BeginPollingAll(); err == nil {
+		t.Errorf("BeginPollingAll() after CleanUp succeeded, want an error")
+	}
+}
+
+func TestHotlistWithoutWorldsDoesNotRun(t *testing.T) {
+	clock := newFakeClock()
+	fetcher := &fakeFetcher{}
+	h := newTestHub(&fakeWriter{}, fetcher, clock)
+	h.ConfiguredHotlists["Empty"] = &Hotlist{
+		Name:          "Empty",
+		ItemIDs:       []int{1},
+		PollFrequency: time.Minute,
+	}
+	if err := h.BeginPollingAll(); err != nil {
+		t.Fatalf("BeginPollingAll() = %v", err)
+	}
+	waitFor(t, "hotlist to stop", func() bool { return !statsFor(t, h, "Empty").Running })
+
+	clock.Advance(initialPollDelay + time.Hour)
+	if got := statsFor(t, h, "Empty"); got.Polls != 0 {
+		t.Errorf("polls = %d, want 0", got.Polls)
+	}
+	if err := h.CleanUp(); err != nil {
+		t.Fatalf("CleanUp() = %v", err)
+	}
+}
+
+// earlyClock wakes the first few waiters straight away, before anything is due.
+type earlyClock struct {
+	*fakeClock
+	mu    sync.Mutex
+	early int
+}
+
+func (c *earlyClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.early > 0 {
+		c.early -= 1
+		return c.fakeClock.After(0)
+	}
+	return c.fakeClock.After(d)
+}
+
+func TestEarlyWakeupIsNotAPoll(t *testing.T) {
+	clock := &earlyClock{fakeClock: newFakeClock(), early: 3}
+	fetcher := &fakeFetcher{}
+	h := newTestHub(&fakeWriter{}, fetcher, clock)
+	h.ConfiguredHotlists["Materia"] = &Hotlist{
+		Name:          "Materia",
+		ItemIDs:       []int{1},
+		PollFrequency: time.Minute,
+		WorldIDs:      []int{1},
+	}
+	if err := h.BeginPollingAll(); err != nil {
+		t.Fatalf("BeginPollingAll() = %v", err)
+	}
+
+	// Past the early wakeups, the loop waits on the clock for the initial delay.
+	clock.BlockUntil(t, 1)
+	if got := statsFor(t, h, "Materia"); got.Polls != 0 || fetcher.Calls() != 0 {
+		t.Errorf("polls = %d, fetches = %d before anything was due, want 0", got.Polls, fetcher.Calls())
+	}
+	clock.Advance(initialPollDelay)
+	waitFor(t, "first poll", func() bool { return statsFor(t, h, "Materia").Polls == 1 })
+
+	if err := h.CleanUp(); err != nil {
+		t.Fatalf("CleanUp() = %v", err)
+	}
+}
+
+func TestCleanUpWithoutPolling(t *testing.T) {
+	h := newTestHub(&fakeWriter{}, &fakeFetcher{}, newFakeClock())
+	done := make(chan error)
+	go func() {
+		done <- h.CleanUp()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("CleanUp() = %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("CleanUp() deadlocked")
+	}
+}
+
+func TestCleanUpDrainsInFlightWrite(t *testing.T) {
+	clock := newFakeClock()
+	writer := &fakeWriter{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	h := newTestHub(writer, &fakeFetcher{}, clock)
+	h.ConfiguredHotlists["Materia"] = &Hotlist{
+		Name:          "Materia",
+		ItemIDs:       []int{1},
+		PollFrequency: time.Minute,
+		WorldIDs:      []int{1, 2},
+	}
+	if err := h.BeginPollingAll(); err != nil {
+		t.Fatalf("BeginPollingAll() = %v", err)
+	}
+	clock.BlockUntil(t, 1)
+	clock.Advance(initialPollDelay)
+	<-writer.started
+
+	done := make(chan error)
+	go func() {
+		done <- h.CleanUp()
+	}()
+	// CleanUp is now waiting on the drain timer.
+	clock.BlockUntil(t, 1)
+	select {
+	case <-done:
+		t.Fatalf("CleanUp() returned with a write in flight")
+	default:
+	}
+
+	close(writer.release)
+	if err := <-done; err != nil {
+		t.Fatalf("CleanUp() = %v", err)
+	}
+	writes, ctxErr := writer.Writes()
+	if writes != 1 || ctxErr != nil {
+		t.Errorf("writes = %d with context error %v, want the in-flight write to finish", writes, ctxErr)
+	}
+	// The second world was never started once shutdown began.
+	if got := statsFor(t, h, "Materia").WorldsSucceeded; got != 1 {
+		t.Errorf("WorldsSucceeded = %d, want 1", got)
+	}
+}
+
+func TestCleanUpCancelsWritesAfterDeadline(t *testing.T) {
+	clock := newFakeClock()
+	writer := &fakeWriter{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	h := newTestHub(writer, &fakeFetcher{}, clock)
+	h.ConfiguredHotlists["Materia"] = &Hotlist{
+		Name:          "Materia",
+		ItemIDs:       []int{1},
+		PollFrequency: time.Minute,
+		WorldIDs:      []int{1},
+	}
+	if err := h.BeginPollingAll(); err != nil {
+		t.Fatalf("BeginPollingAll() = %v", err)
+	}
+	clock.BlockUntil(t, 1)
+	clock.Advance(initialPollDelay)
+	<-writer.started
+
+	done := make(chan error)
+	go func() {
+		done <- h.CleanUp()
+	}()
+	clock.BlockUntil(t, 1)
+	clock.Advance(h.drainTimeout)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("CleanUp() = %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("CleanUp() didn't return after the drain deadline")
+	}
+	if _, ctxErr := writer.Writes(); !errors.Is(ctxErr, context.Canceled) {
+		t.Errorf("write context error = %v, want context.Canceled", ctxErr)
+	}
+}
+
+type fakeStateStore struct {
+	mu     sync.Mutex
+	loaded []*postgres.PollState
+	saved  map[pollStateKey]postgres.PollState
+}
+
+func (s *fakeStateStore) LoadPollStates(ctx context.Context) ([]*postgres.PollState, error) {
+	return s.loaded, nil
+}
+
+func (s *fakeStateStore) SavePollState(ctx context.Context, ps *postgres.PollState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saved == nil {
+		s.saved = make(map[pollStateKey]postgres.PollState)
+	}
+	s.saved[pollStateKey{hotlist: ps.HotlistName, worldID: ps.WorldID}] = *ps
+	return nil
+}
+
+func (s *fakeStateStore) Saved(name string, worldID int) (postgres.PollState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps, ok := s.saved[pollStateKey{hotlist: name, worldID: worldID}]
+	return ps, ok
+}
+
+func (f *fakeFetcher) CallsFor(worldID int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, id := range f.calls {
+		if id == worldID {
+			n += 1
+		}
+	}
+	return n
+}
+
+func TestHubResumesSavedSchedule(t *testing.T) {
+	clock := newFakeClock()
+	fetcher := &fakeFetcher{}
+	store := &fakeStateStore{loaded: []*postgres.PollState{{
+		HotlistName: "Materia",
+		WorldID:     1,
+		LastSuccess: clock.Now().Add(-5 * time.Minute),
+		NextDue:     clock.Now().Add(10 * time.Minute),
+	}}}
+	h := newTestHub(&fakeWriter{}, fetcher, clock)
+	h.states = store
+	h.ConfiguredHotlists["Materia"] = &Hotlist{
+		Name:          "Materia",
+		ItemIDs:       []int{1},
+		PollFrequency: 15 * time.Minute,
+		WorldIDs:      []int{1, 2},
+	}
+	if err := h.BeginPollingAll(); err != nil {
+		t.Fatalf("BeginPollingAll() = %v", err)
+	}
+
+	// Only the world with no saved state is fetched after the initial delay.
+	clock.BlockUntil(t, 1)
+	clock.Advance(initialPollDelay)
+	waitFor(t, "first poll", func() bool { return statsFor(t, h, "Materia").Polls == 1 })
+	if fetcher.CallsFor(1) != 0 || fetcher.CallsFor(2) != 1 {
+		t.Fatalf("calls = %v, want only world 2", fetcher.calls)
+	}
+
+	clock.BlockUntil(t, 1)
+	clock.Advance(10*time.Minute - initialPollDelay)
+	waitFor(t, "second poll", func() bool { return statsFor(t, h, "Materia").Polls == 2 })
+	if fetcher.CallsFor(1) != 1 || fetcher.CallsFor(2) != 1 {
+		t.Errorf("calls = %v, want world 1 once its saved time came due", fetcher.calls)
+	}
+
+	if err := h.CleanUp(); err != nil {
+		t.Fatalf("CleanUp() = %v", err)
+	}
+	ps, ok := store.Saved("Materia", 1)
+	if !ok || !ps.LastSuccess.Equal(clock.Now()) || !ps.NextDue.Equal(clock.Now().Add(15*time.Minute)) {
+		t.Errorf("saved state = %+v, want success now and due in 15 minutes", ps)
+	}
+}
+
+func TestFailingWorldBacksOff(t *testing.T) {
+	clock := newFakeClock()
+	fetcher := &fakeFetcher{failOn: map[int]error{
+		2: universalis.ErrRequest,
+	}}
+	store := &fakeStateStore{}
+	h := newTestHub(&fakeWriter{}, fetcher, clock)
+	h.states = store
+	h.ConfiguredHotlists["Materia"] = &Hotlist{
+		Name:          "Materia",
+		ItemIDs:       []int{1},
+		PollFrequency: time.Minute,
+		WorldIDs:      []int{1, 2},
+	}
+	if err := h.BeginPollingAll(); err != nil {
+		t.Fatalf("BeginPollingAll() = %v", err)
+	}
+
+	clock.BlockUntil(t, 1)
+	clock.Advance(initialPollDelay)
+	for polls := 1; polls <= 4; polls++ {
+		waitFor(t, "poll", func() bool { return statsFor(t, h, "Materia").Polls == polls })
+		clock.BlockUntil(t, 1)
+		clock.Advance(time.Minute)
+	}
+	waitFor(t, "fifth poll", func() bool { return statsFor(t, h, "Materia").Polls == 5 })
+	if err := h.CleanUp(); err != nil {
+		t.Fatalf("CleanUp() = %v", err)
+	}
+
+	// World 2 is retried after 1, 2, then 4 minutes.
+	if got := fetcher.CallsFor(1); got != 5 {
+		t.Errorf("world 1 fetched %d times, want 5", got)
+	}
+	if got := fetcher.CallsFor(2); got != 3 {
+		t.Errorf("world 2 fetched %d times, want 3", got)
+	}
+	ps, ok := store.Saved("Materia", 2)
+	if !ok || ps.ConsecutiveFailures != 3 || ps.LastError == "" || !ps.LastSuccess.IsZero() {
+		t.Errorf("saved state = %+v, want 3 failures and no success", ps)
+	}
+}
+
+func TestPollBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 15 * time.Minute},
+		{2, 30 * time.Minute},
+		{3, time.Hour},
+		{10, maxPollBackoff},
+	}
+	for _, tt := range tests {
+		if got := pollBackoff(15*time.Minute, tt.failures); got != tt.want {
+			t.Errorf("pollBackoff(15m, %d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
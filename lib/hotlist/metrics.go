@@ -0,0 +1,46 @@
+package hotlist
+
+import (
+	"profiteeringway/lib/metrics"
+)
+
+var (
+	universalisRequests = metrics.Default.NewCounterVec(
+		"profiteeringway_universalis_requests_total",
+		"Requests made to Universalis, per hotlist and world.",
+		"hotlist", "world_id")
+	universalisErrors = metrics.Default.NewCounterVec(
+		"profiteeringway_universalis_errors_total",
+		"Failed Universalis requests by error type.",
+		"hotlist", "type")
+	universalisLatency = metrics.Default.NewHistogramVec(
+		"profiteeringway_universalis_request_duration_seconds",
+		"Time taken by Universalis requests.",
+		[]float64{.1, .25, .5, 1, 2, 5, 10, 30},
+		"hotlist")
+	rowsWritten = metrics.Default.NewCounterVec(
+		"profiteeringway_postgres_rows_written_total",
+		"Price and listing rows written to Postgres.",
+		"hotlist")
+	writeErrors = metrics.Default.NewCounterVec(
+		"profiteeringway_postgres_write_errors_total",
+		"Universalis responses that failed to be written to Postgres.",
+		"hotlist")
+	limiterWait = metrics.Default.NewHistogramVec(
+		"profiteeringway_limiter_wait_seconds",
+		"Time spent waiting on a rate limiter before making a request.",
+		[]float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		"limiter")
+	dataAge = metrics.Default.NewGaugeVec(
+		"profiteeringway_universalis_data_age_seconds",
+		"Age of the oldest item upload in the latest Universalis response.",
+		"hotlist", "world_id")
+	lastSuccess = metrics.Default.NewGaugeVec(
+		"profiteeringway_hotlist_last_success_timestamp_seconds",
+		"Unix time a world of the hotlist was last polled and written successfully.",
+		"hotlist", "world_id")
+	consecutiveFailures = metrics.Default.NewGaugeVec(
+		"profiteeringway_hotlist_consecutive_failures",
+		"Polls of a world of the hotlist that have failed in a row.",
+		"hotlist", "world_id")
+)
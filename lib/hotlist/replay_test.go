@@ -0,0 +1,71 @@
+package hotlist
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/store"
+	"profiteeringway/lib/universalis"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// TestHubReplaysRecordedPoll runs a polling cycle against recorded Universalis
+// responses, writing into the in-memory store.
+func TestHubReplaysRecordedPoll(t *testing.T) {
+	replayer, err := universalis.NewReplayer("testdata/universalis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, err := store.LoadFixtures("../store/testdata", zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock()
+	client := universalis.NewClient(&http.Client{Transport: replayer})
+	h := newHotlistHub(mem, nil, client.GetItemData, clock, zap.NewNop().Sugar())
+	h.universalisLimiter = rate.NewLimiter(rate.Inf, 1)
+	h.postgresLimiter = rate.NewLimiter(rate.Inf, 1)
+	h.ConfiguredHotlists["Steak"] = &Hotlist{
+		Name:          "Steak",
+		ItemIDs:       []int{44000, 43976},
+		PollFrequency: 15 * time.Minute,
+		WorldIDs:      []int{63, 40},
+	}
+	if err := h.BeginPollingAll(); err != nil {
+		t.Fatalf("BeginPollingAll() = %v", err)
+	}
+	clock.BlockUntil(t, 1)
+	clock.Advance(initialPollDelay)
+	waitFor(t, "first poll", func() bool { return statsFor(t, h, "Steak").Polls == 1 })
+	if err := h.CleanUp(); err != nil {
+		t.Fatalf("CleanUp() = %v", err)
+	}
+
+	got := statsFor(t, h, "Steak")
+	if got.WorldsSucceeded != 2 || got.WorldsFailed != 0 || got.RowsWritten != 11 {
+		t.Errorf("stats = %+v, want 2 worlds succeeded and 11 rows written", got)
+	}
+	if history := mem.History(); len(history) != 3 {
+		t.Errorf("moved %d snapshots to history, want the 3 the fixtures had", len(history))
+	}
+
+	rows, err := mem.GetPriceForItemIDScopedExpensive(context.Background(), 44000, &postgres.Scope{Kind: postgres.ScopeDatacenter, Name: "Aether"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prices []string
+	for _, r := range rows {
+		prices = append(prices, fmt.Sprintf("%s %v %d", r.WorldName, r.HighQuality, r.MinPrice))
+	}
+	want := "[Jenova true 1390 Gilgamesh true 1450 Jenova false 840 Gilgamesh false 880]"
+	if fmt.Sprint(prices) != want {
+		t.Errorf("prices after replay = %v, want %s", prices, want)
+	}
+}
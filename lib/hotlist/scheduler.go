@@ -0,0 +1,311 @@
+package hotlist
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"profiteeringway/lib/universalis"
+)
+
+// Universalis won't return more than 100 items per request.
+const maxItemsPerRequest = 100
+
+type SchedulerConfig struct {
+	// Refresh intervals are clamped between these.
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	// RequestsPerMinute is the share of the Universalis rate budget the
+	// scheduler may spend.
+	RequestsPerMinute int
+	// Tick is how often due items are checked.
+	Tick time.Duration
+	// InterestHalfLife is how quickly a lookup stops counting towards refreshes.
+	InterestHalfLife time.Duration
+}
+
+func DefaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		MinInterval:       3 * time.Minute,
+		MaxInterval:       time.Hour,
+		RequestsPerMinute: 60,
+		Tick:              30 * time.Second,
+		InterestHalfLife:  time.Hour,
+	}
+}
+
+type pollKey struct {
+	itemID  int
+	worldID int
+}
+
+type pollState struct {
+	nextDue  time.Time
+	interval time.Duration
+	// Sales per day, NQ and HQ combined.
+	velocity float64
+	// Moving average of the relative change in minimum price between polls.
+	volatility float64
+	lastPrice  int
+	// Decayed count of lookups, as of interestAt.
+	interest   float64
+	interestAt time.Time
+}
+
+// Batch is a single Universalis request, up to 100 items on one world.
+type Batch struct {
+	WorldID int
+	ItemIDs []int
+}
+
+// Scheduler gives each tracked (item, world) its own refresh interval. Items
+// that sell quickly, swing in price, or that people are looking up refresh
+// every few minutes, dead items refresh hourly.
+type Scheduler struct {
+	mu     sync.Mutex
+	cfg    SchedulerConfig
+	clock  Clock
+	states map[pollKey]*pollState
+}
+
+func NewScheduler(cfg SchedulerConfig, clock Clock) *Scheduler {
+	return &Scheduler{
+		cfg:    cfg,
+		clock:  clock,
+		states: make(map[pollKey]*pollState),
+	}
+}
+
+func (s *Scheduler) configure(cfg SchedulerConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
+
+// Track starts scheduling every item on every world, due immediately. Items
+// that are already tracked keep their schedule.
+func (s *Scheduler) Track(worldIDs []int, itemIDs []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for _, worldID := range worldIDs {
+		for _, itemID := range itemIDs {
+			key := pollKey{itemID: itemID, worldID: worldID}
+			if _, ok := s.states[key]; ok {
+				continue
+			}
+			s.states[key] = &pollState{
+				nextDue:  now,
+				interval: s.cfg.MaxInterval,
+			}
+		}
+	}
+}
+
+// Resume pushes back items on a world that were fetched at lastSuccess, so a
+// restart doesn't refetch them straight away.
+func (s *Scheduler) Resume(worldID int, lastSuccess time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := lastSuccess.Add(s.cfg.MinInterval)
+	for key, state := range s.states {
+		if key.worldID == worldID && state.nextDue.Before(due) {
+			state.nextDue = due
+		}
+	}
+}
+
+// Len returns how many (item, world) pairs are tracked.
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.states)
+}
+
+// Observe updates the schedule from freshly fetched market data.
+func (s *Scheduler) Observe(upd *universalis.UniversalisPriceData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for _, item := range upd.Items {
+		state, ok := s.states[pollKey{itemID: item.ItemID, worldID: item.WorldID}]
+		if !ok {
+			continue
+		}
+		state.velocity = item.NqSaleVelocity + item.HqSaleVelocity
+
+		price := item.MinPriceNQ
+		if price == 0 {
+			price = item.MinPriceHQ
+		}
+		if state.lastPrice > 0 && price > 0 {
+			change := math.Abs(float64(price-state.lastPrice)) / float64(state.lastPrice)
+			state.volatility = 0.7*state.volatility + 0.3*change
+		}
+		if price > 0 {
+			state.lastPrice = price
+		}
+
+		state.interval = s.interval(state, now)
+		state.nextDue = now.Add(state.interval)
+	}
+}
+
+// RecordInterest marks an item as wanted on every world it's tracked on, which
+// shortens its interval and pulls its next refresh forward.
+func (s *Scheduler) RecordInterest(itemID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for key, state := range s.states {
+		if key.itemID != itemID {
+			continue
+		}
+		state.interest = s.decayedInterest(state, now) + 1
+		state.interestAt = now
+
+		interval := s.interval(state, now)
+		if due := now.Add(interval); due.Before(state.nextDue) {
+			state.nextDue = due
+		}
+		state.interval = interval
+	}
+}
+
+// Interval returns the current refresh interval for an item on a world.
+func (s *Scheduler) Interval(itemID int, worldID int) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[pollKey{itemID: itemID, worldID: worldID}]
+	if !ok {
+		return 0, false
+	}
+	return state.interval, true
+}
+
+func (s *Scheduler) decayedInterest(state *pollState, now time.Time) float64 {
+	if state.interest == 0 || s.cfg.InterestHalfLife <= 0 {
+		return state.interest
+	}
+	halfLives := now.Sub(state.interestAt).Seconds() / s.cfg.InterestHalfLife.Seconds()
+	return state.interest * math.Pow(0.5, halfLives)
+}
+
+// interval divides the maximum interval by how hot the item is. Velocity counts
+// logarithmically, a few hundred sales a day brings it down around ten-fold.
+func (s *Scheduler) interval(state *pollState, now time.Time) time.Duration {
+	heat := (1 + math.Log2(1+state.velocity)) *
+		(1 + 4*state.volatility) *
+		(1 + s.decayedInterest(state, now))
+	interval := time.Duration(float64(s.cfg.MaxInterval) / heat)
+	if interval < s.cfg.MinInterval {
+		return s.cfg.MinInterval
+	}
+	if interval > s.cfg.MaxInterval {
+		return s.cfg.MaxInterval
+	}
+	return interval
+}
+
+// Due packs the items due now into at most maxRequests batches, most overdue
+// first. Batches with room left are topped up with items coming due within
+// the minimum interval, since the request is being made anyway. Returned items
+// are pushed back one interval so they aren't handed out again before their
+// results are observed.
+func (s *Scheduler) Due(maxRequests int) []Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	lookahead := now.Add(s.cfg.MinInterval)
+
+	type candidate struct {
+		key   pollKey
+		state *pollState
+	}
+	due := make(map[int][]candidate)
+	soon := make(map[int][]candidate)
+	for key, state := range s.states {
+		c := candidate{key: key, state: state}
+		if !state.nextDue.After(now) {
+			due[key.worldID] = append(due[key.worldID], c)
+		} else if !state.nextDue.After(lookahead) {
+			soon[key.worldID] = append(soon[key.worldID], c)
+		}
+	}
+
+	byDue := func(cs []candidate) {
+		sort.Slice(cs, func(i, j int) bool {
+			if !cs[i].state.nextDue.Equal(cs[j].state.nextDue) {
+				return cs[i].state.nextDue.Before(cs[j].state.nextDue)
+			}
+			return cs[i].key.itemID < cs[j].key.itemID
+		})
+	}
+
+	type pending struct {
+		batch  Batch
+		oldest time.Time
+		states []*pollState
+	}
+	var batches []*pending
+	for worldID, cs := range due {
+		byDue(cs)
+		for start := 0; start < len(cs); start += maxItemsPerRequest {
+			end := min(start+maxItemsPerRequest, len(cs))
+			p := &pending{
+				batch:  Batch{WorldID: worldID},
+				oldest: cs[start].state.nextDue,
+			}
+			for _, c := range cs[start:end] {
+				p.batch.ItemIDs = append(p.batch.ItemIDs, c.key.itemID)
+				p.states = append(p.states, c.state)
+			}
+			batches = append(batches, p)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		if !batches[i].oldest.Equal(batches[j].oldest) {
+			return batches[i].oldest.Before(batches[j].oldest)
+		}
+		return batches[i].batch.WorldID < batches[j].batch.WorldID
+	})
+	if len(batches) > maxRequests {
+		batches = batches[:maxRequests]
+	}
+
+	var ret []Batch
+	for _, p := range batches {
+		if room := maxItemsPerRequest - len(p.batch.ItemIDs); room > 0 {
+			extra := soon[p.batch.WorldID]
+			byDue(extra)
+			if len(extra) > room {
+				extra = extra[:room]
+			}
+			for _, c := range extra {
+				p.batch.ItemIDs = append(p.batch.ItemIDs, c.key.itemID)
+				p.states = append(p.states, c.state)
+			}
+			soon[p.batch.WorldID] = soon[p.batch.WorldID][len(extra):]
+		}
+		for _, state := range p.states {
+			state.nextDue = now.Add(state.interval)
+		}
+		sort.Ints(p.batch.ItemIDs)
+		ret = append(ret, p.batch)
+	}
+	return ret
+}
+
+// NextDue returns when the next item comes due, or the zero time when nothing is tracked.
+func (s *Scheduler) NextDue() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next time.Time
+	for _, state := range s.states {
+		if next.IsZero() || state.nextDue.Before(next) {
+			next = state.nextDue
+		}
+	}
+	return next
+}
@@ -0,0 +1,89 @@
+package hotlist
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"profiteeringway/lib/universalis"
+)
+
+func observe(s *Scheduler, worldID int, items ...universalis.ItemPriceData) {
+	upd := &universalis.UniversalisPriceData{Items: make(map[string]universalis.ItemPriceData)}
+	for _, item := range items {
+		item.WorldID = worldID
+		upd.Items[strconv.Itoa(item.ItemID)] = item
+	}
+	s.Observe(upd)
+}
+
+func TestSchedulerIntervalFollowsVelocity(t *testing.T) {
+	clock := newFakeClock()
+	cfg := DefaultSchedulerConfig()
+	s := NewScheduler(cfg, clock)
+	s.Track([]int{1}, []int{10, 20})
+
+	observe(s, 1,
+		universalis.ItemPriceData{ItemID: 10, NqSaleVelocity: 500, MinPriceNQ: 100},
+		universalis.ItemPriceData{ItemID: 20, MinPriceNQ: 100},
+	)
+
+	hot, _ := s.Interval(10, 1)
+	dead, _ := s.Interval(20, 1)
+	if dead != cfg.MaxInterval {
+		t.Errorf("dead item interval = %v, want %v", dead, cfg.MaxInterval)
+	}
+	if hot >= dead/5 {
+		t.Errorf("hot item interval = %v, want well under %v", hot, dead)
+	}
+}
+
+func TestSchedulerInterestPullsForward(t *testing.T) {
+	clock := newFakeClock()
+	cfg := DefaultSchedulerConfig()
+	s := NewScheduler(cfg, clock)
+	s.Track([]int{1}, []int{10})
+	observe(s, 1, universalis.ItemPriceData{ItemID: 10, MinPriceNQ: 100})
+
+	s.RecordInterest(10)
+	got, _ := s.Interval(10, 1)
+	if got >= cfg.MaxInterval {
+		t.Errorf("interval after lookup = %v, want under %v", got, cfg.MaxInterval)
+	}
+	if next := s.NextDue(); !next.Equal(clock.Now().Add(got)) {
+		t.Errorf("next due = %v, want %v", next, clock.Now().Add(got))
+	}
+}
+
+func TestSchedulerDuePacksAndBudgets(t *testing.T) {
+	clock := newFakeClock()
+	s := NewScheduler(DefaultSchedulerConfig(), clock)
+	var itemIDs []int
+	for i := 1; i <= 250; i++ {
+		itemIDs = append(itemIDs, i)
+	}
+	s.Track([]int{1, 2}, itemIDs)
+
+	batches := s.Due(3)
+	if len(batches) != 3 {
+		t.Fatalf("got %d batches, want 3", len(batches))
+	}
+	for _, b := range batches {
+		if len(b.ItemIDs) > maxItemsPerRequest {
+			t.Errorf("batch for world %d has %d items", b.WorldID, len(b.ItemIDs))
+		}
+	}
+
+	// The remaining three requests are handed out next, then nothing until
+	// an interval has passed.
+	if got := len(s.Due(10)); got != 3 {
+		t.Errorf("second call got %d batches, want 3", got)
+	}
+	if got := len(s.Due(10)); got != 0 {
+		t.Errorf("third call got %d batches, want 0", got)
+	}
+	clock.Advance(time.Hour)
+	if got := len(s.Due(10)); got != 6 {
+		t.Errorf("after an hour got %d batches, want 6", got)
+	}
+}
@@ -0,0 +1,169 @@
+package itemsearch
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Entry is a single searchable item name.
+type Entry struct {
+	ItemID int32
+	Name   string
+}
+
+// Match is an Entry along with how closely it matched the query, in [0, 1].
+type Match struct {
+	Entry
+	Score float64
+}
+
+// Index is an in-memory trigram index over item names, built once from the
+// items table. Scoring follows the same idea as pg_trgm's similarity (shared
+// trigrams over total trigrams) with a bonus for prefix and substring hits,
+// which is what people usually mean when typing into autocomplete.
+type Index struct {
+	entries    []Entry
+	normalized []string
+	// Number of distinct trigrams per entry, for computing similarity.
+	sizes    []int
+	exact    map[string]int
+	trigrams map[string][]int
+}
+
+func NewIndex(entries []Entry) *Index {
+	idx := &Index{
+		entries:    make([]Entry, 0, len(entries)),
+		normalized: make([]string, 0, len(entries)),
+		sizes:      make([]int, 0, len(entries)),
+		exact:      make(map[string]int, len(entries)),
+		trigrams:   make(map[string][]int),
+	}
+	for _, e := range entries {
+		norm := normalize(e.Name)
+		if norm == "" {
+			continue
+		}
+		// Names aren't unique in the items table (some are blank or reused), keep
+		// the first one so exact lookups are stable.
+		if _, ok := idx.exact[norm]; ok {
+			continue
+		}
+		pos := len(idx.entries)
+		idx.entries = append(idx.entries, e)
+		idx.normalized = append(idx.normalized, norm)
+		idx.exact[norm] = pos
+		set := trigramSet(norm)
+		idx.sizes = append(idx.sizes, len(set))
+		for tg := range set {
+			idx.trigrams[tg] = append(idx.trigrams[tg], pos)
+		}
+	}
+	return idx
+}
+
+// Len returns the number of distinct names in the index.
+func (idx *Index) Len() int {
+	return len(idx.entries)
+}
+
+// Exact looks up a name case insensitively.
+func (idx *Index) Exact(name string) (Entry, bool) {
+	pos, ok := idx.exact[normalize(name)]
+	if !ok {
+		return Entry{}, false
+	}
+	return idx.entries[pos], true
+}
+
+// Search returns up to limit entries ordered from best to worst match. Matches
+// scoring under minScore are dropped so typos don't surface unrelated items.
+func (idx *Index) Search(query string, limit int, minScore float64) []Match {
+	norm := normalize(query)
+	if norm == "" || limit <= 0 {
+		return nil
+	}
+
+	queryTrigrams := trigramSet(norm)
+	shared := make(map[int]int)
+	for tg := range queryTrigrams {
+		for _, pos := range idx.trigrams[tg] {
+			shared[pos] += 1
+		}
+	}
+
+	var matches []Match
+	for pos, count := range shared {
+		candidate := idx.normalized[pos]
+		// Jaccard similarity over the trigram sets, as pg_trgm does.
+		total := len(queryTrigrams) + idx.sizes[pos] - count
+		score := float64(count) / float64(total)
+		if strings.HasPrefix(candidate, norm) {
+			score += 0.5
+		} else if strings.Contains(candidate, norm) {
+			score += 0.25
+		}
+		if score > 1 {
+			score = 1
+		}
+		if score < minScore {
+			continue
+		}
+		matches = append(matches, Match{
+			Entry: idx.entries[pos],
+			Score: score,
+		})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		// Prefer shorter names on ties, they're closer to what was typed.
+		if len(matches[i].Name) != len(matches[j].Name) {
+			return len(matches[i].Name) < len(matches[j].Name)
+		}
+		return matches[i].Name < matches[j].Name
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// normalize lowercases and collapses anything that isn't a letter or digit into
+// single spaces, so "Archeo-kingdom  Ring" and "archeo kingdom ring" compare equal.
+// Apostrophes are kept, with the curly one phones type read as a straight one.
+func normalize(s string) string {
+	var sb strings.Builder
+	space := true
+	for _, r := range strings.ToLower(s) {
+		if r == '\u2019' {
+			r = '\''
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'' {
+			sb.WriteRune(r)
+			space = false
+			continue
+		}
+		if !space {
+			sb.WriteRune(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// trigramSet splits each word of a normalized string into trigrams, padding
+// the word with two leading spaces and one trailing one like pg_trgm does.
+func trigramSet(s string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, word := range strings.Fields(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = struct{}{}
+		}
+	}
+	return set
+}
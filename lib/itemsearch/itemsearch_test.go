@@ -0,0 +1,151 @@
+package itemsearch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testIndex() *Index {
+	return NewIndex([]Entry{
+		{ItemID: 1, Name: "Rroneek Steak"},
+		{ItemID: 2, Name: "Rroneek Chuck"},
+		{ItemID: 3, Name: "Salted Miq'abob"},
+		{ItemID: 4, Name: "Archeo Kingdom Ring of Healing"},
+		{ItemID: 5, Name: "Archeo Kingdom Ring of Aiming"},
+		{ItemID: 6, Name: "Rock Salt"},
+		{ItemID: 7, Name: ""},
+		{ItemID: 8, Name: "rock salt"},
+	})
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"Rroneek Steak":           "rroneek steak",
+		"  Archeo-kingdom  Ring ": "archeo kingdom ring",
+		"Salted Miq'abob":         "salted miq'abob",
+		"Salted Miq’abob":         "salted miq'abob",
+		"Grade 2 Gemdraught (HQ)": "grade 2 gemdraught hq",
+		"---":                     "",
+	}
+	for in, want := range tests {
+		if got := normalize(in); got != want {
+			t.Errorf("normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestExact(t *testing.T) {
+	idx := testIndex()
+	tests := []struct {
+		name   string
+		wantID int32
+	}{
+		{name: "Rroneek Steak", wantID: 1},
+		{name: "RRONEEK STEAK", wantID: 1},
+		{name: " rroneek   steak ", wantID: 1},
+		{name: "archeo-kingdom ring of healing", wantID: 4},
+		{name: "salted miq'abob", wantID: 3},
+		{name: "Salted Miq’abob", wantID: 3},
+		// The apostrophe is part of the name.
+		{name: "Salted Miqabob"},
+		// Duplicate names keep the first entry.
+		{name: "ROCK SALT", wantID: 6},
+		{name: "Rroneek"},
+		{name: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := idx.Exact(tt.name)
+			if ok != (tt.wantID != 0) || got.ItemID != tt.wantID {
+				t.Errorf("Exact(%q) = %v, %t, want item %d", tt.name, got, ok, tt.wantID)
+			}
+		})
+	}
+	if idx.Len() != 6 {
+		t.Errorf("Len() = %d, want blank and duplicate names left out", idx.Len())
+	}
+}
+
+func TestSearch(t *testing.T) {
+	idx := testIndex()
+	tests := []struct {
+		name     string
+		query    string
+		limit    int
+		minScore float64
+		want     []string
+	}{
+		{
+			name:     "exact name first",
+			query:    "rroneek steak",
+			limit:    5,
+			minScore: 0.2,
+			want:     []string{"Rroneek Steak 1.00", "Rroneek Chuck 0.40"},
+		},
+		{
+			name:     "prefix ties prefer shorter names",
+			query:    "rron",
+			limit:    5,
+			minScore: 0.2,
+			want:     []string{"Rroneek Chuck 0.77", "Rroneek Steak 0.77"},
+		},
+		{
+			name:     "substring",
+			query:    "ring of",
+			limit:    5,
+			minScore: 0.2,
+			want:     []string{"Archeo Kingdom Ring of Aiming 0.56", "Archeo Kingdom Ring of Healing 0.54"},
+		},
+		{
+			name:     "typo",
+			query:    "rroneek stek",
+			limit:    5,
+			minScore: 0.2,
+			want:     []string{"Rroneek Steak 0.73", "Rroneek Chuck 0.44"},
+		},
+		{
+			name:     "min score drops weak matches",
+			query:    "rroneek stek",
+			limit:    5,
+			minScore: 0.5,
+			want:     []string{"Rroneek Steak 0.73"},
+		},
+		{
+			name:  "limit",
+			query: "archeo kingdom ring",
+			limit: 1,
+			want:  []string{"Archeo Kingdom Ring of Aiming 1.00"},
+		},
+		{
+			name:  "nothing shared",
+			query: "xyz",
+			limit: 5,
+		},
+		{
+			name:  "punctuation only",
+			query: "!!",
+			limit: 5,
+		},
+		{
+			name:  "unrelated names share word boundaries",
+			query: "rron",
+			limit: 3,
+			want:  []string{"Rroneek Chuck 0.77", "Rroneek Steak 0.77", "Rock Salt 0.07"},
+		},
+		{
+			name:  "no limit",
+			query: "rroneek",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range idx.Search(tt.query, tt.limit, tt.minScore) {
+				got = append(got, fmt.Sprintf("%s %.2f", m.Name, m.Score))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Search(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets match the Prometheus client defaults, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry the process serves on /metrics.
+var Default = NewRegistry()
+
+// Registry holds metrics and renders them in the Prometheus text exposition
+// format. It's deliberately small, counters, gauges, and histograms with
+// labels are all we need.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]collector
+}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]collector),
+	}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	r.metrics[name] = c
+}
+
+// WriteText writes every metric, sorted by name, in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	collectors := make([]collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// key joins label values so a series can be found in a map. The separator
+// can't appear in valid UTF-8 label values.
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	var pairs []string
+	for i, label := range d.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, escapeLabelValue(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabelValue(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// atomicFloat is a float64 that can be updated from many goroutines.
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&f.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// series keeps one value per distinct set of label values, in creation order.
+type series[T any] struct {
+	mu     sync.Mutex
+	values map[string]*T
+	labels map[string][]string
+	order  []string
+}
+
+func (s *series[T]) get(key string, values []string, create func() *T) *T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.values[key]; ok {
+		return v
+	}
+	if s.values == nil {
+		s.values = make(map[string]*T)
+		s.labels = make(map[string][]string)
+	}
+	v := create()
+	s.values[key] = v
+	s.labels[key] = append([]string(nil), values...)
+	s.order = append(s.order, key)
+	return v
+}
+
+func (s *series[T]) each(fn func(values []string, v *T)) {
+	s.mu.Lock()
+	keys := append([]string(nil), s.order...)
+	sort.Strings(keys)
+	type entry struct {
+		values []string
+		v      *T
+	}
+	entries := make([]entry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, entry{s.labels[key], s.values[key]})
+	}
+	s.mu.Unlock()
+
+	for _, e := range entries {
+		fn(e.values, e.v)
+	}
+}
+
+// Counter only goes up.
+type Counter struct {
+	v atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add panics on negative deltas, use a Gauge for values that go down.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.v.add(delta)
+}
+
+type CounterVec struct {
+	desc
+	series series[Counter]
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, kind: "counter", labels: labels}}
+	r.register(name, c)
+	return c
+}
+
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.series.get(c.key(values), values, func() *Counter { return &Counter{} })
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.header(w)
+	c.series.each(func(values []string, v *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(values), formatFloat(v.v.load()))
+	})
+}
+
+// Gauge can go up and down.
+type Gauge struct {
+	v atomicFloat
+}
+
+func (g *Gauge) Set(v float64) {
+	g.v.set(v)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+type GaugeVec struct {
+	desc
+	series series[Gauge]
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{desc: desc{name: name, help: help, kind: "gauge", labels: labels}}
+	r.register(name, g)
+	return g
+}
+
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.series.get(g.key(values), values, func() *Gauge { return &Gauge{} })
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.header(w)
+	g.series.each(func(values []string, v *Gauge) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(values), formatFloat(v.v.load()))
+	})
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i] += 1
+		}
+	}
+	h.sum += v
+	h.count += 1
+}
+
+type HistogramVec struct {
+	desc
+	buckets []float64
+	series  series[Histogram]
+}
+
+// NewHistogramVec uses DefaultBuckets when buckets is nil.
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+	}
+	r.register(name, h)
+	return h
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.series.get(h.key(values), values, func() *Histogram {
+		return &Histogram{
+			buckets: h.buckets,
+			counts:  make([]uint64, len(h.buckets)),
+		}
+	})
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.header(w)
+	h.series.each(func(values []string, v *Histogram) {
+		v.mu.Lock()
+		counts := append([]uint64(nil), v.counts...)
+		sum, count := v.sum, v.count
+		v.mu.Unlock()
+
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(values, "le", formatFloat(upper)), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(values), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(values), count)
+	})
+}
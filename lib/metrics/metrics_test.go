@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(r *Registry)
+		want   string
+	}{
+		{
+			name: "counter",
+			record: func(r *Registry) {
+				c := r.NewCounterVec("polls_total", "Polls by outcome.", "world", "outcome")
+				c.With("Jenova", "ok").Inc()
+				c.With("Gilgamesh", "ok").Add(2.5)
+				c.With("Gilgamesh", "error").Inc()
+				c.With("Jenova", "ok").Inc()
+			},
+			want: `# HELP polls_total Polls by outcome.
+# TYPE polls_total counter
+polls_total{world="Gilgamesh",outcome="error"} 1
+polls_total{world="Gilgamesh",outcome="ok"} 2.5
+polls_total{world="Jenova",outcome="ok"} 2
+`,
+		},
+		{
+			name: "gauge without labels",
+			record: func(r *Registry) {
+				g := r.NewGaugeVec("hotlists", "Hotlists polling.")
+				g.With().Set(6)
+				g.With().Add(-2)
+			},
+			want: `# HELP hotlists Hotlists polling.
+# TYPE hotlists gauge
+hotlists 4
+`,
+		},
+		{
+			name:   "metric without series",
+			record: func(r *Registry) { r.NewGaugeVec("idle", "Nothing yet.", "world") },
+			want: `# HELP idle Nothing yet.
+# TYPE idle gauge
+`,
+		},
+		{
+			name: "histogram buckets are cumulative",
+			record: func(r *Registry) {
+				h := r.NewHistogramVec("poll_seconds", "Poll latency.", []float64{1, 0.1, 0.5}, "world")
+				for _, v := range []float64{0.05, 0.3, 0.3, 2} {
+					h.With("Jenova").Observe(v)
+				}
+			},
+			want: `# HELP poll_seconds Poll latency.
+# TYPE poll_seconds histogram
+poll_seconds_bucket{world="Jenova",le="0.1"} 1
+poll_seconds_bucket{world="Jenova",le="0.5"} 3
+poll_seconds_bucket{world="Jenova",le="1"} 3
+poll_seconds_bucket{world="Jenova",le="+Inf"} 4
+poll_seconds_sum{world="Jenova"} 2.65
+poll_seconds_count{world="Jenova"} 4
+`,
+		},
+		{
+			name: "escaping",
+			record: func(r *Registry) {
+				c := r.NewCounterVec("errors_total", "Errors by message,\nwith a \\ in the help.", "message")
+				c.With("bad \"quote\"\nand C:\\path").Inc()
+			},
+			want: `# HELP errors_total Errors by message,\nwith a \\ in the help.
+# TYPE errors_total counter
+errors_total{message="bad \"quote\"\nand C:\\path"} 1
+`,
+		},
+		{
+			name: "sorted by name",
+			record: func(r *Registry) {
+				r.NewGaugeVec("zeta", "Last.").With().Set(1)
+				r.NewCounterVec("alpha", "First.").With().Inc()
+				r.NewGaugeVec("mid", "Middle.").With().Set(0.5)
+			},
+			want: `# HELP alpha First.
+# TYPE alpha counter
+alpha 1
+# HELP mid Middle.
+# TYPE mid gauge
+mid 0.5
+# HELP zeta Last.
+# TYPE zeta gauge
+zeta 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.record(r)
+			var b bytes.Buffer
+			if err := r.WriteText(&b); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultBuckets(t *testing.T) {
+	r := NewRegistry()
+	r.NewHistogramVec("latency_seconds", "Latency.", nil).With().Observe(0.2)
+	var b bytes.Buffer
+	r.WriteText(&b)
+	if got := strings.Count(b.String(), "latency_seconds_bucket"); got != len(DefaultBuckets)+1 {
+		t.Errorf("got %d buckets, want the %d defaults and +Inf:\n%s", got, len(DefaultBuckets), b.String())
+	}
+	if !strings.Contains(b.String(), `latency_seconds_bucket{le="0.25"} 1`) {
+		t.Errorf("expected the observation in the 0.25 bucket:\n%s", b.String())
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("polls_total", "Polls.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering polls_total twice to panic")
+		}
+	}()
+	r.NewGaugeVec("polls_total", "Polls again.")
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	c := NewRegistry().NewCounterVec("polls_total", "Polls.", "world")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a missing label value to panic")
+		}
+	}()
+	c.With()
+}
+
+func TestCounterCannotDecrease(t *testing.T) {
+	c := NewRegistry().NewCounterVec("polls_total", "Polls.").With()
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a negative Add to panic")
+		}
+	}()
+	c.Add(-1)
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("polls_total", "Polls.").With().Inc()
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "polls_total 1\n") {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+}
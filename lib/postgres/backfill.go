@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Sales are only ever written by backfills, the poller sees listings. A sale
+// is identified by all of its columns, Universalis gives sales no ID.
+const initializeBackfillTables = `CREATE TABLE IF NOT EXISTS sales_history (
+	item_id integer REFERENCES items ON DELETE CASCADE NOT NULL,
+	world_id integer REFERENCES worlds ON DELETE RESTRICT NOT NULL,
+	sale_time timestamp without time zone NOT NULL,
+	price_per_unit integer NOT NULL,
+	quantity integer NOT NULL,
+	high_quality boolean NOT NULL,
+	PRIMARY KEY (item_id, world_id, sale_time, price_per_unit, quantity, high_quality)
+);
+
+CREATE TABLE IF NOT EXISTS backfill_progress (
+	item_id integer REFERENCES items ON DELETE CASCADE,
+	world_id integer REFERENCES worlds ON DELETE RESTRICT,
+	backfilled_since timestamp without time zone NOT NULL,
+	completed_at timestamp without time zone NOT NULL,
+	PRIMARY KEY (item_id, world_id)
+);`
+
+func (p *Postgres) InitializeBackfillTables() error {
+	_, err := p.Db.Exec(initializeBackfillTables)
+	if err != nil {
+		return fmt.Errorf("failed to initialize backfill tables: %w", err)
+	}
+	return nil
+}
+
+type Sale struct {
+	ItemID       int
+	WorldID      int
+	Time         time.Time
+	PricePerUnit int
+	Quantity     int
+	HighQuality  bool
+}
+
+// HistorySnapshot is a row of prices_history made up from a day of sales.
+type HistorySnapshot struct {
+	PriceID        int64
+	ItemID         int
+	WorldID        int
+	UpdateTime     time.Time
+	NQSaleVelocity int
+	HQSaleVelocity int
+	MinPriceNQ     int
+	MinPriceHQ     int
+}
+
+// BackfillPriceID is the prices_history ID of the snapshot backfilled for an
+// item on a world for the day starting at day. It's negative, so it never
+// meets the prices sequence, and the same every run, so reruns don't
+// duplicate snapshots. Item IDs get 20 bits and world IDs 13.
+func BackfillPriceID(itemID int, worldID int, day time.Time) int64 {
+	days := day.Unix() / int64(24*time.Hour/time.Second)
+	return -(days<<33 | int64(worldID)<<20 | int64(itemID))
+}
+
+// BackfillBatch is everything a backfill learned from one request: the sales
+// and snapshots to write, and the items on the world to mark backfilled since.
+type BackfillBatch struct {
+	WorldID   int
+	ItemIDs   []int
+	Since     time.Time
+	Sales     []Sale
+	Snapshots []HistorySnapshot
+}
+
+// BackfilledItems returns which of the items on the world have already been
+// backfilled at least as far back as since.
+func (p *Postgres) BackfilledItems(ctx context.Context, worldID int, itemIDs []int, since time.Time) (map[int]bool, error) {
+	rows, err := p.Db.QueryContext(ctx, `SELECT item_id FROM backfill_progress
+WHERE world_id = ($1) AND item_id = ANY($2) AND backfilled_since <= ($3)`, worldID, pq.Array(itemIDs), since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backfill progress for world %d: %w", worldID, err)
+	}
+	defer rows.Close()
+
+	done := make(map[int]bool)
+	for rows.Next() {
+		var itemID int
+		if err := rows.Scan(&itemID); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		done[itemID] = true
+	}
+	return done, nil
+}
+
+// WriteBackfill writes a batch in one transaction, so an interrupted backfill
+// never marks items done without their history. Rows already written by an
+// earlier run are left alone, the count is of new rows only.
+func (p *Postgres) WriteBackfill(ctx context.Context, b *BackfillBatch) (int, error) {
+	tx, err := p.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin backfill of world %d: %w", b.WorldID, err)
+	}
+	defer tx.Rollback()
+
+	var itemIDs, worldIDs, prices, quantities []int
+	var times []int64
+	var hq []bool
+	for _, s := range b.Sales {
+		itemIDs = append(itemIDs, s.ItemID)
+		worldIDs = append(worldIDs, s.WorldID)
+		times = append(times, s.Time.Unix())
+		prices = append(prices, s.PricePerUnit)
+		quantities = append(quantities, s.Quantity)
+		hq = append(hq, s.HighQuality)
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO sales_history
+SELECT item_id, world_id, to_timestamp(sale_time) AT TIME ZONE 'UTC', price_per_unit, quantity, high_quality
+FROM unnest($1::integer[], $2::integer[], $3::bigint[], $4::integer[], $5::integer[], $6::boolean[])
+	AS s(item_id, world_id, sale_time, price_per_unit, quantity, high_quality)
+ON CONFLICT DO NOTHING`, pq.Array(itemIDs), pq.Array(worldIDs), pq.Array(times), pq.Array(prices), pq.Array(quantities), pq.Array(hq))
+	if err != nil {
+		return 0, fmt.Errorf("failed to write sales for world %d: %w", b.WorldID, err)
+	}
+	written, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sales written for world %d: %w", b.WorldID, err)
+	}
+
+	for _, s := range b.Snapshots {
+		res, err := tx.ExecContext(ctx, `INSERT INTO prices_history
+(price_id, item_id, world_id, update_time, nq_sale_velocity, hq_sale_velocity, min_price_nq, min_price_hq)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (price_id) DO NOTHING`, s.PriceID, s.ItemID, s.WorldID, s.UpdateTime.UTC(), s.NQSaleVelocity, s.HQSaleVelocity, s.MinPriceNQ, s.MinPriceHQ)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write snapshot of %d on world %d: %w", s.ItemID, s.WorldID, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to count snapshots written for world %d: %w", b.WorldID, err)
+		}
+		written += n
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO backfill_progress (item_id, world_id, backfilled_since, completed_at)
+SELECT item_id, ($2), ($3), ($4) FROM unnest($1::integer[]) AS item_id
+ON CONFLICT (item_id, world_id) DO UPDATE SET
+	backfilled_since = LEAST(backfill_progress.backfilled_since, EXCLUDED.backfilled_since),
+	completed_at = EXCLUDED.completed_at`, pq.Array(b.ItemIDs), b.WorldID, b.Since.UTC(), time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to record backfill progress for world %d: %w", b.WorldID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit backfill of world %d: %w", b.WorldID, err)
+	}
+	return int(written), nil
+}
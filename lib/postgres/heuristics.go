@@ -3,8 +3,11 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"profiteeringway/lib/fees"
 	"strconv"
 	"strings"
+
+	"github.com/lib/pq"
 )
 
 func (p *Postgres) GetPricesForItemIDs(ctx context.Context, itemIDs []int) ([]*HQPriceRow, error) {
@@ -237,14 +240,18 @@ ORDER BY
 func recipeDetailsForItemID(itemID string) string {
 	return fmt.Sprintf(`SELECT
 	ing.*,
-	items.name AS crafted_item_name
+	items.name AS crafted_item_name,
+	items.high_qualityable AS crafted_high_qualityable
 FROM (
 SELECT	
 	items.name AS ingredient_name,
 	ingredients.ingredient_id,
 	ingredients.ingredient_count,
 	ingredients.crafted_item_id,
-	ingredients.crafted_item_count AS crafted_quantity
+	ingredients.crafted_item_count AS crafted_quantity,
+	COALESCE(items.gil_price, 0) AS ingredient_gil_price,
+	items.high_qualityable AS ingredient_high_qualityable,
+	items.type = 'Crystal' AS ingredient_is_crystal
 FROM
 	(SELECT
 		r.crafted_item_id,
@@ -272,13 +279,21 @@ type RecipeDetails struct {
 	CraftedItemName  string
 	CraftedItemCount int32
 	CraftedItemID    int32
-	Ingredients      []*Ingredient
+	// Whether the crafted item comes in HQ at all.
+	HighQualityable bool
+	Ingredients     []*Ingredient
+	// The shards, crystals, and clusters the recipe takes, which aren't in
+	// Ingredients.
+	Crystals []*Ingredient
 }
 
 type Ingredient struct {
 	ItemID int32
 	Name   string
 	Count  int32
+	// What an NPC vendor sells the ingredient for, zero if no vendor does.
+	GilPrice        int32
+	HighQualityable bool
 }
 
 func (pg *Postgres) RecipesDetailsForItemID(ctx context.Context, itemID int32) (*RecipeDetails, error) {
@@ -292,9 +307,10 @@ func (pg *Postgres) RecipesDetailsForItemID(ctx context.Context, itemID int32) (
 	initialized := false
 	for rows.Next() {
 		var craftedItemName, ingredientName string
-		var craftedItemCount, craftedItemID, ingredientItemID, ingredientCount int32
+		var craftedItemCount, craftedItemID, ingredientItemID, ingredientCount, ingredientGilPrice int32
+		var craftedHighQualityable, ingredientHighQualityable, ingredientIsCrystal bool
 
-		if err := rows.Scan(&ingredientName, &ingredientItemID, &ingredientCount, &craftedItemID, &craftedItemCount, &craftedItemName); err != nil {
+		if err := rows.Scan(&ingredientName, &ingredientItemID, &ingredientCount, &craftedItemID, &craftedItemCount, &ingredientGilPrice, &ingredientHighQualityable, &ingredientIsCrystal, &craftedItemName, &craftedHighQualityable); err != nil {
 			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
 		}
 
@@ -302,22 +318,29 @@ func (pg *Postgres) RecipesDetailsForItemID(ctx context.Context, itemID int32) (
 			details.CraftedItemName = craftedItemName
 			details.CraftedItemCount = craftedItemCount
 			details.CraftedItemID = craftedItemID
+			details.HighQualityable = craftedHighQualityable
 			initialized = true
 		}
 
 		ingredient := &Ingredient{
-			ItemID: ingredientItemID,
-			Name:   ingredientName,
-			Count:  ingredientCount,
+			ItemID:          ingredientItemID,
+			Name:            ingredientName,
+			Count:           ingredientCount,
+			GilPrice:        ingredientGilPrice,
+			HighQualityable: ingredientHighQualityable,
 		}
 
-		details.Ingredients = append(details.Ingredients, ingredient)
+		if ingredientIsCrystal {
+			details.Crystals = append(details.Crystals, ingredient)
+		} else {
+			details.Ingredients = append(details.Ingredients, ingredient)
+		}
 	}
 	return details, nil
 }
 
 func (pg *Postgres) ConvertItemNameToItemID(ctx context.Context, itemName string) (int32, error) {
-	row := pg.Db.QueryRowContext(ctx, `SELECT items.item_id FROM items WHERE items.name = ($1)`, itemName)
+	row := pg.Db.QueryRowContext(ctx, `SELECT items.item_id FROM items WHERE UPPER(items.name) = UPPER(($1)) ORDER BY items.item_id LIMIT 1`, itemName)
 	var itemID int32
 	if err := row.Scan(&itemID); err != nil {
 		return 0, fmt.Errorf("failed to scan row value for item name lookup: %w", err)
@@ -326,6 +349,34 @@ func (pg *Postgres) ConvertItemNameToItemID(ctx context.Context, itemName string
 	return itemID, nil
 }
 
+type ItemName struct {
+	ItemID int32
+	Name   string
+}
+
+// AllItemNames returns every named item, used to build the in-memory search index.
+func (pg *Postgres) AllItemNames(ctx context.Context) ([]*ItemName, error) {
+	rows, err := pg.Db.QueryContext(ctx, `SELECT item_id, name FROM items WHERE name <> '' ORDER BY item_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item names: %w", err)
+	}
+	defer rows.Close()
+
+	var names []*ItemName
+	for rows.Next() {
+		var itemID int32
+		var name string
+		if err := rows.Scan(&itemID, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		names = append(names, &ItemName{
+			ItemID: itemID,
+			Name:   name,
+		})
+	}
+	return names, nil
+}
+
 func (pg *Postgres) WorldIDFromWorldName(ctx context.Context, worldName string) (int, error) {
 	query := `SELECT
 		world_id
@@ -341,3 +392,584 @@ func (pg *Postgres) WorldIDFromWorldName(ctx context.Context, worldName string)
 	}
 	return worldID, nil
 }
+
+type ListingRow struct {
+	ItemID       int32
+	ItemName     string
+	WorldName    string
+	PricePerUnit int
+	Quantity     int
+	HighQuality  bool
+}
+
+// CurrentListingsInDatacenter returns every current listing for the items on
+// public worlds of the datacenter. The prices table only holds the latest
+// snapshot per item and world, older ones are moved to history by trigger.
+func (pg *Postgres) CurrentListingsInDatacenter(ctx context.Context, itemIDs []int32, datacenter string) ([]*ListingRow, error) {
+	rows, err := pg.Db.QueryContext(ctx, `SELECT
+	prices.item_id,
+	items.name,
+	worlds.name AS world_name,
+	listings.price_per_unit,
+	listings.quantity,
+	listings.high_quality
+FROM
+	prices
+		INNER JOIN listings USING (price_id)
+		INNER JOIN worlds USING (world_id)
+		INNER JOIN items USING (item_id)
+WHERE
+	prices.item_id = ANY($1)
+	AND worlds.datacenter = ($2)
+	AND worlds.is_public
+ORDER BY
+	prices.item_id,
+	listings.price_per_unit;`, pq.Array(itemIDs), datacenter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get listings in datacenter %s: %w", datacenter, err)
+	}
+	defer rows.Close()
+
+	var listings []*ListingRow
+	for rows.Next() {
+		l := &ListingRow{}
+		if err := rows.Scan(&l.ItemID, &l.ItemName, &l.WorldName, &l.PricePerUnit, &l.Quantity, &l.HighQuality); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		listings = append(listings, l)
+	}
+	return listings, nil
+}
+
+type VendorArbitrageRow struct {
+	ItemID     int32
+	Name       string
+	WorldName  string
+	Datacenter string
+	GilPrice   int
+	MinPrice   int
+	// Combined NQ and HQ sales per day as reported by Universalis.
+	SaleVelocity int
+}
+
+func (r *VendorArbitrageRow) ProfitPerUnit() int {
+	return r.MinPrice - r.GilPrice
+}
+
+func (r *VendorArbitrageRow) Markup() float64 {
+	return float64(r.MinPrice) / float64(r.GilPrice)
+}
+
+// VendorArbitrage finds items an NPC vendor sells that are listed on the market
+// board for at least minMarkup times the vendor price, ordered by expected daily
+// profit. The cheapest current listing is used since that's what we'd undercut.
+func (pg *Postgres) VendorArbitrage(ctx context.Context, scope *Scope, minMarkup float64, limit int) ([]*VendorArbitrageRow, error) {
+	condition, arg := scope.conditionOn("worlds.name", "worlds.datacenter", 3)
+	rows, err := pg.Db.QueryContext(ctx, fmt.Sprintf(`SELECT
+	items.item_id,
+	items.name,
+	worlds.name AS world_name,
+	worlds.datacenter,
+	items.gil_price,
+	MIN(listings.price_per_unit) AS min_price,
+	prices.nq_sale_velocity + prices.hq_sale_velocity AS sale_velocity
+FROM
+	items
+		INNER JOIN prices USING (item_id)
+		INNER JOIN worlds USING (world_id)
+		INNER JOIN listings USING (price_id)
+WHERE
+	items.gil_price > 0
+	AND items.marketable
+	AND worlds.is_public
+	AND %s
+GROUP BY
+	items.item_id,
+	items.name,
+	worlds.name,
+	worlds.datacenter,
+	items.gil_price,
+	prices.nq_sale_velocity,
+	prices.hq_sale_velocity
+HAVING
+	MIN(listings.price_per_unit) >= items.gil_price * ($1)::double precision
+ORDER BY
+	(MIN(listings.price_per_unit) - items.gil_price) * (prices.nq_sale_velocity + prices.hq_sale_velocity) DESC,
+	MIN(listings.price_per_unit) - items.gil_price DESC
+LIMIT ($2);`, condition), minMarkup, limit, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vendor arbitrage in %s %s: %w", scope.Kind, scope.Name, err)
+	}
+	defer rows.Close()
+
+	var arbitrage []*VendorArbitrageRow
+	for rows.Next() {
+		r := &VendorArbitrageRow{}
+		if err := rows.Scan(&r.ItemID, &r.Name, &r.WorldName, &r.Datacenter, &r.GilPrice, &r.MinPrice, &r.SaleVelocity); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		arbitrage = append(arbitrage, r)
+	}
+	return arbitrage, nil
+}
+
+// SpecialCurrencies lists the items used as currency in special shops, tomestones and scrips.
+func (pg *Postgres) SpecialCurrencies(ctx context.Context) ([]*ItemName, error) {
+	rows, err := pg.Db.QueryContext(ctx, `SELECT DISTINCT
+	currency.item_id,
+	currency.name
+FROM
+	items INNER JOIN items AS currency ON items.special_currency_item_id = currency.item_id
+WHERE
+	items.special_currency_count > 0
+ORDER BY
+	currency.name;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get special currencies: %w", err)
+	}
+	defer rows.Close()
+
+	var currencies []*ItemName
+	for rows.Next() {
+		c := &ItemName{}
+		if err := rows.Scan(&c.ItemID, &c.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		currencies = append(currencies, c)
+	}
+	return currencies, nil
+}
+
+type CurrencyValueRow struct {
+	ItemID        int32
+	Name          string
+	WorldName     string
+	CurrencyCount int
+	MinPrice      int
+	SaleVelocity  int
+}
+
+func (r *CurrencyValueRow) GilPerCurrency() float64 {
+	return float64(r.MinPrice) / float64(r.CurrencyCount)
+}
+
+// CurrencyValue ranks what the special currency buys by current market price per
+// unit of currency spent. Items selling fewer than minVelocity a day are left out,
+// a high price nobody pays isn't worth the currency.
+func (pg *Postgres) CurrencyValue(ctx context.Context, currencyItemID int32, scope *Scope, minVelocity int, limit int) ([]*CurrencyValueRow, error) {
+	condition, arg := scope.conditionOn("worlds.name", "worlds.datacenter", 4)
+	rows, err := pg.Db.QueryContext(ctx, fmt.Sprintf(`SELECT
+	items.item_id,
+	items.name,
+	worlds.name AS world_name,
+	items.special_currency_count,
+	MIN(listings.price_per_unit) AS min_price,
+	prices.nq_sale_velocity + prices.hq_sale_velocity AS sale_velocity
+FROM
+	items
+		INNER JOIN prices USING (item_id)
+		INNER JOIN worlds USING (world_id)
+		INNER JOIN listings USING (price_id)
+WHERE
+	items.special_currency_item_id = ($1)
+	AND items.special_currency_count > 0
+	AND items.marketable
+	AND worlds.is_public
+	AND prices.nq_sale_velocity + prices.hq_sale_velocity >= ($2)
+	AND %s
+GROUP BY
+	items.item_id,
+	items.name,
+	worlds.name,
+	items.special_currency_count,
+	prices.nq_sale_velocity,
+	prices.hq_sale_velocity
+ORDER BY
+	MIN(listings.price_per_unit)::double precision / items.special_currency_count DESC
+LIMIT ($3);`, condition), currencyItemID, minVelocity, limit, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get currency value for %v in %s %s: %w", currencyItemID, scope.Kind, scope.Name, err)
+	}
+	defer rows.Close()
+
+	var values []*CurrencyValueRow
+	for rows.Next() {
+		r := &CurrencyValueRow{}
+		if err := rows.Scan(&r.ItemID, &r.Name, &r.WorldName, &r.CurrencyCount, &r.MinPrice, &r.SaleVelocity); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		values = append(values, r)
+	}
+	return values, nil
+}
+
+// Values of item_origins.origin, named after ItemOrigin in proto/item.proto.
+const (
+	OriginGathering = "GATHERING"
+	OriginFishing   = "FISHING"
+)
+
+type GatheringClass string
+
+const (
+	GatheringMiner    GatheringClass = "MIN"
+	GatheringBotanist GatheringClass = "BTN"
+	GatheringFisher   GatheringClass = "FSH"
+)
+
+// item_origins doesn't tell miners and botanists apart, both are GATHERING,
+// so they're split by item type. Crystals and reagents come from both.
+var gatheringClassTypes = map[GatheringClass][]string{
+	GatheringMiner:    {"Stone", "Metal", "Crystal", "Reagent"},
+	GatheringBotanist: {"Lumber", "Ingredient", "Cloth", "Crystal", "Reagent"},
+}
+
+// ItemTypes lists the item types gathered by miners or botanists, nil for
+// fishers, whose items are told apart by origin instead.
+func (c GatheringClass) ItemTypes() []string {
+	return gatheringClassTypes[c]
+}
+
+type GatheringFilter struct {
+	// Class limits the report to one gathering class, empty for all of them.
+	Class        GatheringClass
+	MinItemLevel int
+	// MaxItemLevel of zero means no upper bound.
+	MaxItemLevel int
+}
+
+type GatheringRow struct {
+	ItemID       int32
+	Name         string
+	Type         string
+	ItemLevel    int
+	Origin       string
+	WorldName    string
+	MinPrice     int
+	SaleVelocity int
+}
+
+// GilPerDay estimates what the market absorbs each day at the current price.
+func (r *GatheringRow) GilPerDay() int {
+	return r.MinPrice * r.SaleVelocity
+}
+
+// GatheringProfitability ranks gatherable items by current price times sale
+// velocity on each world in scope.
+func (pg *Postgres) GatheringProfitability(ctx context.Context, scope *Scope, filter GatheringFilter, limit int) ([]*GatheringRow, error) {
+	args := []interface{}{limit, filter.MinItemLevel}
+	conditions := []string{
+		"items.marketable",
+		"worlds.is_public",
+		"COALESCE(items.item_level, 0) >= ($2)",
+	}
+
+	if filter.MaxItemLevel > 0 {
+		args = append(args, filter.MaxItemLevel)
+		conditions = append(conditions, fmt.Sprintf("COALESCE(items.item_level, 0) <= ($%d)", len(args)))
+	}
+
+	switch filter.Class {
+	case "":
+		args = append(args, pq.Array([]string{OriginGathering, OriginFishing}))
+		conditions = append(conditions, fmt.Sprintf("item_origins.origin = ANY($%d)", len(args)))
+	case GatheringFisher:
+		args = append(args, OriginFishing)
+		conditions = append(conditions, fmt.Sprintf("item_origins.origin = ($%d)", len(args)))
+	case GatheringMiner, GatheringBotanist:
+		args = append(args, OriginGathering)
+		conditions = append(conditions, fmt.Sprintf("item_origins.origin = ($%d)", len(args)))
+		args = append(args, pq.Array(gatheringClassTypes[filter.Class]))
+		conditions = append(conditions, fmt.Sprintf("items.type = ANY($%d)", len(args)))
+	default:
+		return nil, fmt.Errorf("unknown gathering class %s", filter.Class)
+	}
+
+	condition, arg := scope.conditionOn("worlds.name", "worlds.datacenter", len(args)+1)
+	args = append(args, arg)
+	conditions = append(conditions, condition)
+
+	rows, err := pg.Db.QueryContext(ctx, fmt.Sprintf(`SELECT
+	items.item_id,
+	items.name,
+	items.type,
+	COALESCE(items.item_level, 0),
+	item_origins.origin,
+	worlds.name AS world_name,
+	MIN(listings.price_per_unit) AS min_price,
+	prices.nq_sale_velocity + prices.hq_sale_velocity AS sale_velocity
+FROM
+	items
+		INNER JOIN item_origins USING (item_id)
+		INNER JOIN prices USING (item_id)
+		INNER JOIN worlds USING (world_id)
+		INNER JOIN listings USING (price_id)
+WHERE
+	%s
+GROUP BY
+	items.item_id,
+	items.name,
+	items.type,
+	items.item_level,
+	item_origins.origin,
+	worlds.name,
+	prices.nq_sale_velocity,
+	prices.hq_sale_velocity
+ORDER BY
+	MIN(listings.price_per_unit) * (prices.nq_sale_velocity + prices.hq_sale_velocity) DESC
+LIMIT ($1);`, strings.Join(conditions, "\n\tAND ")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gathering profitability in %s %s: %w", scope.Kind, scope.Name, err)
+	}
+	defer rows.Close()
+
+	var gathering []*GatheringRow
+	for rows.Next() {
+		r := &GatheringRow{}
+		if err := rows.Scan(&r.ItemID, &r.Name, &r.Type, &r.ItemLevel, &r.Origin, &r.WorldName, &r.MinPrice, &r.SaleVelocity); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		gathering = append(gathering, r)
+	}
+	return gathering, nil
+}
+
+// CraftProfitSort is what a craft profit ranking is ordered by, best first.
+type CraftProfitSort string
+
+const (
+	// Profit of one craft.
+	CraftProfitSortProfit CraftProfitSort = "profit"
+	// Profit per unit times how many sell a day.
+	CraftProfitSortDaily CraftProfitSort = "daily"
+	// Profit over the cost of the materials.
+	CraftProfitSortMargin CraftProfitSort = "margin"
+)
+
+// Each sort's ORDER BY expression over the netted columns.
+var craftProfitOrders = map[CraftProfitSort]string{
+	CraftProfitSortProfit: "net_profit",
+	CraftProfitSortDaily:  "net_profit * sale_velocity / crafted_item_count",
+	CraftProfitSortMargin: "profit::double precision / NULLIF(CASE WHEN best_high_quality THEN cost_hq ELSE cost_nq END, 0)",
+}
+
+type CraftProfitFilter struct {
+	Items ItemSelector
+	// FreeCrystals counts shards, crystals, and clusters as gathered, costing nothing.
+	FreeCrystals bool
+	// SortBy defaults to CraftProfitSortProfit.
+	SortBy CraftProfitSort
+	// Fees are taken off each sale before ranking by profit. The zero Model
+	// takes nothing, ranking by gross profit.
+	Fees fees.Model
+}
+
+// CraftProfitRow is one recipe costed two ways: every material bought NQ for
+// an NQ result, and every HQ-able material bought HQ for an HQ result. Like
+// pricedown, materials are priced at their cheapest listing in scope, or at
+// the vendor when that's cheaper for NQ.
+type CraftProfitRow struct {
+	ItemID    int32
+	Name      string
+	Type      string
+	ItemLevel int
+	// How many of the item one craft makes.
+	Yield           int
+	HighQualityable bool
+	// Cheapest listings of the item in scope, zero where there are none.
+	MinPriceNQ int
+	MinPriceHQ int
+	// Sales per day across every world in scope.
+	SaleVelocity int
+	// What one craft's materials cost, leaving out the missing ones, and how
+	// many materials have no price. The HQ columns are zero for items without HQ.
+	CostNQ    int
+	CostHQ    int
+	MissingNQ int
+	MissingHQ int
+	// Profit of one craft by the better strategy with every price known.
+	Profit int
+	// Whether that's the HQ strategy.
+	BestHighQuality bool
+}
+
+// Cost is what the better strategy's materials cost.
+func (r *CraftProfitRow) Cost() int {
+	if r.BestHighQuality {
+		return r.CostHQ
+	}
+	return r.CostNQ
+}
+
+// Sale is what one craft sells for by the better strategy, before fees.
+func (r *CraftProfitRow) Sale() int {
+	return r.Cost() + r.Profit
+}
+
+// ProfitPerDay estimates what selling as many as sell a day would make.
+func (r *CraftProfitRow) ProfitPerDay() int {
+	return r.Profit * r.SaleVelocity / r.Yield
+}
+
+// Margin is the profit over the cost, zero when the materials are free.
+func (r *CraftProfitRow) Margin() float64 {
+	if r.Cost() == 0 {
+		return 0
+	}
+	return float64(r.Profit) / float64(r.Cost())
+}
+
+// CraftProfitRanking costs every recipe for the selected items against prices
+// in scope in one pass, ranking them by the filter's sort with profits net of
+// its fees. Recipes that can't be fully priced either way are left out, as are
+// recipes listing no crystals unless crystals are free, since their cost isn't
+// known.
+func (pg *Postgres) CraftProfitRanking(ctx context.Context, scope *Scope, filter CraftProfitFilter, limit int) ([]*CraftProfitRow, error) {
+	sortBy := filter.SortBy
+	if sortBy == "" {
+		sortBy = CraftProfitSortProfit
+	}
+	order, ok := craftProfitOrders[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown craft profit sort %s", sortBy)
+	}
+
+	condition, arg := scope.conditionOn("worlds.name", "worlds.datacenter", 3)
+	conditions, args := filter.Items.conditions([]interface{}{limit, filter.FreeCrystals, arg, filter.Fees.TaxRate})
+
+	rows, err := pg.Db.QueryContext(ctx, fmt.Sprintf(`WITH scoped_listings AS (
+	SELECT
+		prices.item_id,
+		MIN(listings.price_per_unit) FILTER (WHERE NOT listings.high_quality) AS min_price_nq,
+		MIN(listings.price_per_unit) FILTER (WHERE listings.high_quality) AS min_price_hq
+	FROM
+		prices
+			INNER JOIN worlds USING (world_id)
+			INNER JOIN listings USING (price_id)
+	WHERE
+		worlds.is_public
+		AND %[1]s
+	GROUP BY
+		prices.item_id
+), scoped_velocity AS (
+	SELECT
+		prices.item_id,
+		SUM(prices.nq_sale_velocity + prices.hq_sale_velocity) AS sale_velocity
+	FROM
+		prices INNER JOIN worlds USING (world_id)
+	WHERE
+		worlds.is_public
+		AND %[1]s
+	GROUP BY
+		prices.item_id
+), material_prices AS (
+	SELECT
+		items.item_id,
+		items.type,
+		CASE
+			WHEN ($2) AND items.type = 'Crystal' THEN 0
+			ELSE LEAST(scoped_listings.min_price_nq, NULLIF(items.gil_price, 0))
+		END AS price_nq,
+		CASE
+			WHEN ($2) AND items.type = 'Crystal' THEN 0
+			WHEN items.high_qualityable THEN scoped_listings.min_price_hq
+			ELSE LEAST(scoped_listings.min_price_nq, NULLIF(items.gil_price, 0))
+		END AS price_hq
+	FROM
+		items LEFT JOIN scoped_listings USING (item_id)
+), material_costs AS (
+	SELECT
+		recipe_ingredients.recipe_id,
+		COALESCE(SUM(recipe_ingredients.quantity * material_prices.price_nq), 0) AS cost_nq,
+		COUNT(*) FILTER (WHERE material_prices.price_nq IS NULL) AS missing_nq,
+		COALESCE(SUM(recipe_ingredients.quantity * material_prices.price_hq), 0) AS cost_hq,
+		COUNT(*) FILTER (WHERE material_prices.price_hq IS NULL) AS missing_hq,
+		COUNT(*) FILTER (WHERE material_prices.type = 'Crystal') AS crystals
+	FROM
+		recipe_ingredients INNER JOIN material_prices ON recipe_ingredients.ingredient_id = material_prices.item_id
+	GROUP BY
+		recipe_ingredients.recipe_id
+), costed AS (
+	SELECT
+		items.item_id,
+		items.name,
+		items.type,
+		COALESCE(items.item_level, 0) AS item_level,
+		recipes.crafted_item_count,
+		items.high_qualityable,
+		COALESCE(scoped_listings.min_price_nq, 0) AS min_price_nq,
+		COALESCE(scoped_listings.min_price_hq, 0) AS min_price_hq,
+		COALESCE(scoped_velocity.sale_velocity, 0) AS sale_velocity,
+		material_costs.cost_nq,
+		CASE WHEN items.high_qualityable THEN material_costs.cost_hq ELSE 0 END AS cost_hq,
+		material_costs.missing_nq,
+		CASE WHEN items.high_qualityable THEN material_costs.missing_hq ELSE 0 END AS missing_hq,
+		CASE
+			WHEN material_costs.missing_nq = 0 THEN recipes.crafted_item_count * scoped_listings.min_price_nq - material_costs.cost_nq
+		END AS profit_nq,
+		CASE
+			WHEN items.high_qualityable AND material_costs.missing_hq = 0 THEN recipes.crafted_item_count * scoped_listings.min_price_hq - material_costs.cost_hq
+		END AS profit_hq
+	FROM
+		recipes
+			INNER JOIN items ON recipes.crafted_item_id = items.item_id
+			INNER JOIN material_costs USING (recipe_id)
+			LEFT JOIN scoped_listings ON recipes.crafted_item_id = scoped_listings.item_id
+			LEFT JOIN scoped_velocity ON recipes.crafted_item_id = scoped_velocity.item_id
+	WHERE
+		(($2) OR material_costs.crystals > 0)
+		AND %[2]s
+), ranked AS (
+	SELECT
+		costed.*,
+		GREATEST(profit_nq, profit_hq) AS profit,
+		profit_hq IS NOT NULL AND (profit_nq IS NULL OR profit_hq > profit_nq) AS best_high_quality
+	FROM
+		costed
+), netted AS (
+	SELECT
+		ranked.*,
+		profit - FLOOR((profit + CASE WHEN best_high_quality THEN cost_hq ELSE cost_nq END) * ($4::double precision) + 1e-9)::bigint AS net_profit
+	FROM
+		ranked
+)
+SELECT
+	item_id,
+	name,
+	type,
+	item_level,
+	crafted_item_count,
+	high_qualityable,
+	min_price_nq,
+	min_price_hq,
+	sale_velocity,
+	cost_nq,
+	cost_hq,
+	missing_nq,
+	missing_hq,
+	profit,
+	best_high_quality
+FROM
+	netted
+WHERE
+	profit IS NOT NULL
+ORDER BY
+	%[3]s DESC NULLS LAST,
+	name,
+	item_id
+LIMIT ($1);`, condition, strings.Join(conditions, "\n\t\tAND "), order), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get craft profit ranking in %s %s: %w", scope.Kind, scope.Name, err)
+	}
+	defer rows.Close()
+
+	var ranking []*CraftProfitRow
+	for rows.Next() {
+		r := &CraftProfitRow{}
+		if err := rows.Scan(&r.ItemID, &r.Name, &r.Type, &r.ItemLevel, &r.Yield, &r.HighQualityable, &r.MinPriceNQ, &r.MinPriceHQ, &r.SaleVelocity, &r.CostNQ, &r.CostHQ, &r.MissingNQ, &r.MissingHQ, &r.Profit, &r.BestHighQuality); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		ranking = append(ranking, r)
+	}
+	return ranking, nil
+}
@@ -1,15 +1,23 @@
 package postgres
 
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
 func (p *Postgres) DawntrailMateriaIDs() ([]int, error) {
 	return p.GetItemIDsForStaticQuery(`SELECT item_id FROM items WHERE type IN ('Materia') AND item_level > 650;`)
 }
 
 func (p *Postgres) DawntrailConsumables() ([]int, error) {
-	return p.GetItemIDsForStaticQuery(`SELECT item_id FROM items WHERE type IN ('Meal', 'Medicine') AND item_level > 700;`)
+	return p.ItemIDsForSelector(context.Background(), ItemSelectors[SelectorDawntrailConsumables])
 }
 
 func (p *Postgres) DawntrailTierOneCraftedEquipment() ([]int, error) {
-	return p.GetItemIDsForStaticQuery(`SELECT item_id FROM items WHERE type IN ('Marauder''s Arm','Two–handed Thaumaturge''s Arm','Weaver''s Primary Tool','Goldsmith''s Secondary Tool','Botanist''s Secondary Tool','Astrologian''s Arm','Fisher''s Primary Tool','Alchemist''s Primary Tool','Archer''s Arm','One–handed Conjurer''s Arm','Blacksmith''s Primary Tool','Arcanist''s Grimoire','Goldsmith''s Primary Tool','Alchemist''s Secondary Tool','Gladiator''s Arm','Red Mage''s Arm','Leatherworker''s Primary Tool','Scholar''s Arm','Earrings','Sage''s Arm','Blue Mage''s Arm','Rogue''s Arm','Blacksmith''s Secondary Tool','Culinarian''s Primary Tool','Reaper''s Arm','Miner''s Secondary Tool','Botanist''s Primary Tool','Culinarian''s Secondary Tool','Weaver''s Secondary Tool','Dancer''s Arm','Carpenter''s Secondary Tool','Armorer''s Primary Tool','Carpenter''s Primary Tool','Two–handed Conjurer''s Arm','Armorer''s Secondary Tool','One–handed Thaumaturge''s Arm','Dark Knight''s Arm','Miner''s Primary Tool','Samurai''s Arm','Shield','Fisher''s Secondary Tool','Machinist''s Arm','Hands','Body','Head','Necklace','Ring','Legs','Feet','Bracelets','Leatherworker''s Secondary Tool','Pugilist''s Arm','Lancer''s Arm','Pictomancer''s Arm','Viper''s Arm','Gunbreaker''s Arm') AND item_level > 709 AND marketable`)
+	return p.ItemIDsForSelector(context.Background(), ItemSelectors[SelectorDawntrailCraftedEquipment])
 }
 
 func (p *Postgres) DawntrailMaterialsSetOne() ([]int, error) {
@@ -25,3 +33,122 @@ func (p *Postgres) AllCrystals() ([]int, error) {
 	return p.GetItemIDsForStaticQuery(`SELECT item_id FROM items WHERE type = 'Crystal';`)
 }
 
+// ItemSelector picks a category of items by type and item level, for reports
+// covering a whole category at once. Only marketable items are ever selected.
+type ItemSelector struct {
+	// Types of item to select, empty for every type.
+	Types        []string
+	MinItemLevel int
+	// MaxItemLevel of zero means no upper bound.
+	MaxItemLevel int
+}
+
+// Names of the selectors in ItemSelectors.
+const (
+	SelectorDawntrailCraftedEquipment = "dawntrail_crafted_equipment"
+	SelectorDawntrailConsumables      = "dawntrail_consumables"
+)
+
+var dawntrailEquipmentTypes = []string{
+	"Marauder's Arm",
+	"Two–handed Thaumaturge's Arm",
+	"Weaver's Primary Tool",
+	"Goldsmith's Secondary Tool",
+	"Botanist's Secondary Tool",
+	"Astrologian's Arm",
+	"Fisher's Primary Tool",
+	"Alchemist's Primary Tool",
+	"Archer's Arm",
+	"One–handed Conjurer's Arm",
+	"Blacksmith's Primary Tool",
+	"Arcanist's Grimoire",
+	"Goldsmith's Primary Tool",
+	"Alchemist's Secondary Tool",
+	"Gladiator's Arm",
+	"Red Mage's Arm",
+	"Leatherworker's Primary Tool",
+	"Scholar's Arm",
+	"Earrings",
+	"Sage's Arm",
+	"Blue Mage's Arm",
+	"Rogue's Arm",
+	"Blacksmith's Secondary Tool",
+	"Culinarian's Primary Tool",
+	"Reaper's Arm",
+	"Miner's Secondary Tool",
+	"Botanist's Primary Tool",
+	"Culinarian's Secondary Tool",
+	"Weaver's Secondary Tool",
+	"Dancer's Arm",
+	"Carpenter's Secondary Tool",
+	"Armorer's Primary Tool",
+	"Carpenter's Primary Tool",
+	"Two–handed Conjurer's Arm",
+	"Armorer's Secondary Tool",
+	"One–handed Thaumaturge's Arm",
+	"Dark Knight's Arm",
+	"Miner's Primary Tool",
+	"Samurai's Arm",
+	"Shield",
+	"Fisher's Secondary Tool",
+	"Machinist's Arm",
+	"Hands",
+	"Body",
+	"Head",
+	"Necklace",
+	"Ring",
+	"Legs",
+	"Feet",
+	"Bracelets",
+	"Leatherworker's Secondary Tool",
+	"Pugilist's Arm",
+	"Lancer's Arm",
+	"Pictomancer's Arm",
+	"Viper's Arm",
+	"Gunbreaker's Arm",
+}
+
+// ItemSelectors are the categories the hotlists poll, by name.
+var ItemSelectors = map[string]ItemSelector{
+	SelectorDawntrailCraftedEquipment: {Types: dawntrailEquipmentTypes, MinItemLevel: 710},
+	SelectorDawntrailConsumables:      {Types: []string{"Meal", "Medicine"}, MinItemLevel: 701},
+}
+
+// conditions renders the selector as SQL conditions on items, numbering its
+// placeholders after the args already given.
+func (s ItemSelector) conditions(args []interface{}) ([]string, []interface{}) {
+	conditions := []string{"items.marketable"}
+	if len(s.Types) > 0 {
+		args = append(args, pq.Array(s.Types))
+		conditions = append(conditions, fmt.Sprintf("items.type = ANY($%d)", len(args)))
+	}
+	if s.MinItemLevel > 0 {
+		args = append(args, s.MinItemLevel)
+		conditions = append(conditions, fmt.Sprintf("COALESCE(items.item_level, 0) >= ($%d)", len(args)))
+	}
+	if s.MaxItemLevel > 0 {
+		args = append(args, s.MaxItemLevel)
+		conditions = append(conditions, fmt.Sprintf("COALESCE(items.item_level, 0) <= ($%d)", len(args)))
+	}
+	return conditions, args
+}
+
+// ItemIDsForSelector lists the items the selector picks.
+func (p *Postgres) ItemIDsForSelector(ctx context.Context, s ItemSelector) ([]int, error) {
+	conditions, args := s.conditions(nil)
+	rows, err := p.Db.QueryContext(ctx, `SELECT item_id FROM items WHERE `+strings.Join(conditions, " AND ")+` ORDER BY item_id;`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get items for selector: %w", err)
+	}
+	defer rows.Close()
+
+	var itemIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		itemIDs = append(itemIDs, id)
+	}
+	return itemIDs, nil
+}
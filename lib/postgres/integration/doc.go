@@ -0,0 +1,15 @@
+// Package integration tests the Postgres queries against a real database.
+//
+// The tests connect to the server in PROFITEERINGWAY_TEST_POSTGRES, a lib/pq
+// connection string. Without it they start a throwaway server using the
+// initdb and pg_ctl binaries from PROFITEERINGWAY_TEST_POSTGRES_BIN or the
+// PATH. If neither works, the Postgres tests are skipped. Each test gets its
+// own schema, so a shared database is left the way it was found.
+//
+// The schema comes from sql/schema and sql/functions, and the fixtures from
+// lib/store/testdata. Query results are compared against the golden files in
+// testdata, and the in-memory store is held to the same files. To rewrite
+// them from Postgres after a deliberate change, run
+//
+//	go test ./lib/postgres/integration -update
+package integration
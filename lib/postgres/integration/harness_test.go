@@ -0,0 +1,251 @@
+package integration
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/store"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const (
+	connEnv = "PROFITEERINGWAY_TEST_POSTGRES"
+	binEnv  = "PROFITEERINGWAY_TEST_POSTGRES_BIN"
+
+	sqlDir     = "../../../sql"
+	fixtureDir = "../../store/testdata"
+)
+
+// schemaFiles are applied in this order so foreign keys have their tables.
+var schemaFiles = []string{
+	"schema/items.sql",
+	"schema/item_origins.sql",
+	"schema/item_equipment.sql",
+	"schema/recipes.sql",
+	"schema/recipe_ingredients.sql",
+	"schema/worlds.sql",
+	"schema/datacenters.sql",
+	"schema/price.sql",
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files from Postgres")
+
+// connStr is the server the tests run against, empty if there isn't one.
+var connStr string
+
+var schemaCount atomic.Int64
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	var stop func()
+	connStr = os.Getenv(connEnv)
+	if connStr == "" {
+		var err error
+		connStr, stop, err = startServer()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping Postgres tests: %v\n", err)
+		}
+	}
+
+	code := m.Run()
+	if stop != nil {
+		stop()
+	}
+	os.Exit(code)
+}
+
+func findBinary(name string) (string, error) {
+	if dir := os.Getenv(binEnv); dir != "" {
+		return filepath.Join(dir, name), nil
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s isn't on the PATH, set %s or %s", name, connEnv, binEnv)
+	}
+	return path, nil
+}
+
+// startServer initializes a cluster in a temporary directory and starts it,
+// listening only on a socket in that directory.
+func startServer() (string, func(), error) {
+	initdb, err := findBinary("initdb")
+	if err != nil {
+		return "", nil, err
+	}
+	pgCtl, err := findBinary("pg_ctl")
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "profiteeringway-pg")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create a directory for Postgres: %w", err)
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "--auth=trust", "--no-sync").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("initdb failed: %w: %s", err, out)
+	}
+
+	opts := fmt.Sprintf("-c listen_addresses='' -c unix_socket_directories=%s -c fsync=off", dir)
+	if out, err := exec.Command(pgCtl, "-D", data, "-o", opts, "-l", filepath.Join(dir, "postgres.log"), "-w", "start").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("pg_ctl start failed: %w: %s", err, out)
+	}
+
+	stop := func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "stop").Run()
+		os.RemoveAll(dir)
+	}
+	return fmt.Sprintf("host=%s user=postgres dbname=postgres sslmode=disable", dir), stop, nil
+}
+
+// withSearchPath points every connection in the pool at schema. lib/pq passes
+// settings it doesn't recognise on to the server.
+func withSearchPath(connStr string, schema string) (string, error) {
+	if !strings.HasPrefix(connStr, "postgres://") && !strings.HasPrefix(connStr, "postgresql://") {
+		return connStr + " search_path=" + schema, nil
+	}
+	u, err := url.Parse(connStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", connEnv, err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// newDatabase creates a schema for the test holding the tables, the price
+// history trigger, and the fixtures. It's dropped when the test ends.
+func newDatabase(t *testing.T) *postgres.Postgres {
+	t.Helper()
+	if connStr == "" {
+		t.Skipf("no Postgres to test against, set %s or %s", connEnv, binEnv)
+	}
+
+	admin, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("failed to connect to Postgres: %v", err)
+	}
+	schema := fmt.Sprintf("profiteeringway_test_%d_%d", os.Getpid(), schemaCount.Add(1))
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatalf("failed to create schema %s: %v", schema, err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("failed to drop schema %s: %v", schema, err)
+		}
+		admin.Close()
+	})
+
+	conn, err := withSearchPath(connStr, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg, err := postgres.NewPostgres(conn, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pg.CleanUp() })
+
+	applySchema(t, pg)
+	loadFixtures(t, pg)
+	return pg
+}
+
+// applySchema sets the database up the way a deployment is: the schema files,
+// the tables the bot creates at startup, then the history trigger.
+func applySchema(t *testing.T, pg *postgres.Postgres) {
+	t.Helper()
+	for _, name := range schemaFiles {
+		execFile(t, pg, name)
+	}
+	for _, initialize := range []func() error{
+		pg.InitializePriceTables,
+		pg.InitializeRegionTables,
+		pg.InitializeSettingsTable,
+		pg.InitializePollStateTable,
+		pg.InitializeBackfillTables,
+	} {
+		if err := initialize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	execFile(t, pg, "functions/transfer_old_prices.sql")
+}
+
+func execFile(t *testing.T, pg *postgres.Postgres, name string) {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(sqlDir, name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+	if _, err := pg.Db.Exec(string(b)); err != nil {
+		t.Fatalf("failed to apply %s: %v", name, err)
+	}
+}
+
+func mustExec(t *testing.T, pg *postgres.Postgres, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := pg.Db.Exec(query, args...); err != nil {
+		t.Fatalf("failed to load fixture: %v", err)
+	}
+}
+
+// loadFixtures inserts the same fixtures store.LoadFixtures reads, with zeroes
+// stored as NULL where the real data leaves them out.
+func loadFixtures(t *testing.T, pg *postgres.Postgres) {
+	t.Helper()
+	f, err := store.ReadFixtures(fixtureDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range f.Items {
+		mustExec(t, pg, `INSERT INTO items
+(item_id, type, name, item_level, special_currency_item_id, special_currency_count, high_qualityable, marketable, gil_price)
+VALUES ($1, $2, $3, NULLIF($4::integer, 0), NULLIF($5::integer, 0), NULLIF($6::integer, 0), $7, $8, NULLIF($9::integer, 0))`,
+			item.ItemID, item.Type, item.Name, item.ItemLevel, item.SpecialCurrencyItemID, item.SpecialCurrencyCount, item.HighQualityable, item.Marketable, item.GilPrice)
+		for _, origin := range item.Origins {
+			mustExec(t, pg, `INSERT INTO item_origins (item_id, origin) VALUES ($1, $2)`, item.ItemID, origin)
+		}
+	}
+
+	for _, w := range f.Worlds {
+		mustExec(t, pg, `INSERT INTO datacenters (name, region) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING`, w.Datacenter, w.Region)
+		mustExec(t, pg, `INSERT INTO worlds (world_id, name, datacenter, is_public) VALUES ($1, $2, $3, $4)`, w.WorldID, w.Name, w.Datacenter, w.IsPublic)
+	}
+
+	for _, r := range f.Recipes {
+		mustExec(t, pg, `INSERT INTO recipes (recipe_id, crafted_item_id, crafted_item_count) VALUES ($1, $2, $3)`, r.RecipeID, r.CraftedItemID, r.CraftedItemCount)
+		for _, ing := range r.Ingredients {
+			mustExec(t, pg, `INSERT INTO recipe_ingredients (recipe_id, ingredient_id, quantity) VALUES ($1, $2, $3)`, r.RecipeID, ing.ItemID, ing.Quantity)
+		}
+	}
+
+	for _, ps := range f.Prices {
+		var priceID int64
+		row := pg.Db.QueryRow(`INSERT INTO prices
+(item_id, world_id, update_time, nq_sale_velocity, hq_sale_velocity, min_price_nq, min_price_hq)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING price_id`, ps.ItemID, ps.WorldID, ps.UpdateTime, ps.NQSaleVelocity, ps.HQSaleVelocity, ps.MinPriceNQ, ps.MinPriceHQ)
+		if err := row.Scan(&priceID); err != nil {
+			t.Fatalf("failed to load fixture: %v", err)
+		}
+		for _, l := range ps.Listings {
+			mustExec(t, pg, `INSERT INTO listings (price_id, price_per_unit, quantity, high_quality) VALUES ($1, $2, $3, $4)`, priceID, l.PricePerUnit, l.Quantity, l.HighQuality)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/store"
+	"profiteeringway/lib/universalis"
+	"sort"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// golden compares got, rendered as JSON, against testdata/name.json. With
+// write set it rewrites the file instead.
+func golden(t *testing.T, name string, got interface{}, write bool) {
+	t.Helper()
+	b, err := json.MarshalIndent(got, "", "\t")
+	if err != nil {
+		t.Fatalf("failed to render %s: %v", name, err)
+	}
+	b = append(b, '\n')
+
+	path := filepath.Join("testdata", name+".json")
+	if write {
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run with -update to create it: %v", err)
+	}
+	if string(b) != string(want) {
+		t.Errorf("%s doesn't match %s\ngot:\n%s\nwant:\n%s", name, path, b, want)
+	}
+}
+
+func describeScope(s *postgres.Scope) string {
+	return fmt.Sprintf("%s %s", s.Kind, s.Name)
+}
+
+var (
+	aether       = &postgres.Scope{Kind: postgres.ScopeDatacenter, Name: "Aether"}
+	lich         = &postgres.Scope{Kind: postgres.ScopeWorld, Name: "Lich"}
+	northAmerica = &postgres.Scope{Kind: postgres.ScopeRegion, Name: postgres.DefaultRegion}
+)
+
+var meals = postgres.ItemSelector{Types: []string{"Meal", "Medicine"}, MinItemLevel: 700}
+
+const (
+	poeticsID = 28
+	saltID    = 5518
+	chuckID   = 43976
+	steakID   = 44000
+)
+
+// storeCases run against both Postgres and the in-memory store, which must
+// agree on every golden file.
+var storeCases = []struct {
+	name string
+	run  func(ctx context.Context, s store.Store) (interface{}, error)
+}{
+	{"all_item_names", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.AllItemNames(ctx)
+	}},
+	{"convert_item_name", func(ctx context.Context, s store.Store) (interface{}, error) {
+		ids := make(map[string]interface{})
+		for _, name := range []string{"Rock Salt", "ROCK SALT", "Rock Sugar"} {
+			id, err := s.ConvertItemNameToItemID(ctx, name)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				ids[name] = "no rows"
+			case err != nil:
+				return nil, err
+			default:
+				ids[name] = id
+			}
+		}
+		return ids, nil
+	}},
+	{"special_currencies", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.SpecialCurrencies(ctx)
+	}},
+	{"recipe_details", func(ctx context.Context, s store.Store) (interface{}, error) {
+		details, err := s.RecipesDetailsForItemID(ctx, steakID)
+		if err != nil {
+			return nil, err
+		}
+		// The query leaves ingredients unordered.
+		sort.Slice(details.Ingredients, func(i, j int) bool { return details.Ingredients[i].ItemID < details.Ingredients[j].ItemID })
+		return details, nil
+	}},
+	{"recipe_details_uncrafted", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.RecipesDetailsForItemID(ctx, saltID)
+	}},
+	{"all_scopes", func(ctx context.Context, s store.Store) (interface{}, error) {
+		scopes, err := s.AllScopes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var described []string
+		for _, scope := range scopes {
+			described = append(described, describeScope(scope))
+		}
+		return described, nil
+	}},
+	{"resolve_scope", func(ctx context.Context, s store.Store) (interface{}, error) {
+		resolved := make(map[string]string)
+		for _, name := range []string{"gilgamesh", "AETHER", "north-america", "Cloudtest01", "Atlantis"} {
+			scope, err := s.ResolveScope(ctx, name)
+			switch {
+			case errors.Is(err, postgres.ErrUnknownScope):
+				resolved[name] = "unknown"
+			case err != nil:
+				return nil, err
+			default:
+				resolved[name] = describeScope(scope)
+			}
+		}
+		return resolved, nil
+	}},
+	{"world_ids_in_scope", func(ctx context.Context, s store.Store) (interface{}, error) {
+		worldIDs := make(map[string][]int)
+		for _, scope := range []*postgres.Scope{aether, lich, northAmerica} {
+			ids, err := s.WorldIDsInScope(ctx, scope)
+			if err != nil {
+				return nil, err
+			}
+			worldIDs[describeScope(scope)] = ids
+		}
+		return worldIDs, nil
+	}},
+	{"world_id_from_world_name", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.WorldIDFromWorldName(ctx, "Lich")
+	}},
+	{"prices_by_id_in_datacenter", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.GetPriceForItemIDScopedExpensive(ctx, steakID, aether)
+	}},
+	{"prices_by_id_on_world", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.GetPriceForItemIDScopedExpensive(ctx, steakID, lich)
+	}},
+	{"prices_by_id_everywhere", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.GetPriceForItemIDScopedExpensive(ctx, steakID, nil)
+	}},
+	{"prices_by_name_in_region", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.GetPriceForItemNameScopedExpensive(ctx, "rroneek steak", northAmerica)
+	}},
+	{"prices_unmarketable", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.GetPriceForItemIDScopedExpensive(ctx, poeticsID, nil)
+	}},
+	{"current_listings_in_datacenter", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.CurrentListingsInDatacenter(ctx, []int32{steakID, chuckID, saltID}, "Aether")
+	}},
+	{"vendor_arbitrage", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.VendorArbitrage(ctx, northAmerica, 2, 10)
+	}},
+	{"vendor_arbitrage_high_markup", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.VendorArbitrage(ctx, northAmerica, 3, 10)
+	}},
+	{"vendor_arbitrage_fractional_markup", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.VendorArbitrage(ctx, northAmerica, 2.6, 10)
+	}},
+	{"currency_value", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.CurrencyValue(ctx, poeticsID, northAmerica, 10, 10)
+	}},
+	{"currency_value_slow_sellers", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.CurrencyValue(ctx, poeticsID, northAmerica, 20, 10)
+	}},
+	{"gathering", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.GatheringProfitability(ctx, northAmerica, postgres.GatheringFilter{}, 10)
+	}},
+	{"gathering_limited", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.GatheringProfitability(ctx, northAmerica, postgres.GatheringFilter{}, 2)
+	}},
+	{"gathering_by_class", func(ctx context.Context, s store.Store) (interface{}, error) {
+		byClass := make(map[postgres.GatheringClass][]*postgres.GatheringRow)
+		for _, class := range []postgres.GatheringClass{postgres.GatheringMiner, postgres.GatheringBotanist, postgres.GatheringFisher} {
+			rows, err := s.GatheringProfitability(ctx, northAmerica, postgres.GatheringFilter{Class: class}, 10)
+			if err != nil {
+				return nil, err
+			}
+			byClass[class] = rows
+		}
+		return byClass, nil
+	}},
+	{"gathering_by_item_level", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.GatheringProfitability(ctx, northAmerica, postgres.GatheringFilter{MinItemLevel: 695, MaxItemLevel: 700}, 10)
+	}},
+	{"craft_profit", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.CraftProfitRanking(ctx, northAmerica, postgres.CraftProfitFilter{Items: meals}, 10)
+	}},
+	{"craft_profit_free_crystals", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.CraftProfitRanking(ctx, northAmerica, postgres.CraftProfitFilter{Items: meals, FreeCrystals: true, SortBy: postgres.CraftProfitSortMargin}, 10)
+	}},
+	{"craft_profit_unpriced", func(ctx context.Context, s store.Store) (interface{}, error) {
+		// Lich has the steak's prices but none of its materials'.
+		return s.CraftProfitRanking(ctx, lich, postgres.CraftProfitFilter{Items: meals}, 10)
+	}},
+	{"craft_profit_unselected", func(ctx context.Context, s store.Store) (interface{}, error) {
+		return s.CraftProfitRanking(ctx, northAmerica, postgres.CraftProfitFilter{Items: postgres.ItemSelector{Types: []string{"Meal"}, MaxItemLevel: 700}}, 10)
+	}},
+}
+
+// postgresCases cover queries only Postgres implements.
+var postgresCases = []struct {
+	name string
+	run  func(ctx context.Context, pg *postgres.Postgres) (interface{}, error)
+}{
+	{"prices_for_item_ids", func(ctx context.Context, pg *postgres.Postgres) (interface{}, error) {
+		return pg.GetPricesForItemIDs(ctx, []int{steakID})
+	}},
+	{"price_for_item_id_expensive", func(ctx context.Context, pg *postgres.Postgres) (interface{}, error) {
+		return pg.GetPriceForItemIDExpensive(ctx, steakID)
+	}},
+	{"price_for_item_id_world_specific_expensive", func(ctx context.Context, pg *postgres.Postgres) (interface{}, error) {
+		return pg.GetPriceForItemIDWorldSpecificExpensive(ctx, steakID, "Jenova")
+	}},
+	{"price_for_item_name_expensive", func(ctx context.Context, pg *postgres.Postgres) (interface{}, error) {
+		return pg.GetPriceForItemNameExpensive(ctx, "rroneek steak")
+	}},
+}
+
+func TestPostgresQueries(t *testing.T) {
+	pg := newDatabase(t)
+	ctx := context.Background()
+	for _, c := range storeCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.run(ctx, pg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, c.name, got, *update)
+		})
+	}
+	for _, c := range postgresCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.run(ctx, pg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, c.name, got, *update)
+		})
+	}
+}
+
+func TestMemoryMatchesPostgres(t *testing.T) {
+	m, err := store.LoadFixtures(fixtureDir, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, c := range storeCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.run(ctx, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, c.name, got, false)
+		})
+	}
+}
+
+type historyPrice struct {
+	ItemID         int
+	WorldID        int
+	NQSaleVelocity int
+	HQSaleVelocity int
+	MinPriceNQ     int
+	MinPriceHQ     int
+}
+
+type historyListing struct {
+	PricePerUnit int
+	Quantity     int
+	HighQuality  bool
+}
+
+func TestWriteMovesOldPricesToHistory(t *testing.T) {
+	pg := newDatabase(t)
+	ctx := context.Background()
+
+	written, err := pg.WriteUniversalisPriceData(ctx, &universalis.UniversalisPriceData{Items: map[string]universalis.ItemPriceData{
+		"44000": {
+			ItemID:         steakID,
+			WorldID:        63,
+			LastUploadTime: 1722603600000,
+			NqSaleVelocity: 24.6,
+			HqSaleVelocity: 75.2,
+			MinPriceNQ:     880,
+			MinPriceHQ:     1100,
+			Listings: []universalis.Listing{
+				{PricePerUnit: 1100, Quantity: 1, Hq: true},
+				{PricePerUnit: 880, Quantity: 2},
+			},
+		},
+	}})
+	if err != nil || written != 3 {
+		t.Fatalf("expected a price and two listings written, got %d, %v", written, err)
+	}
+
+	var result struct {
+		PricesHistory   []historyPrice
+		ListingsHistory []historyListing
+		Current         []historyPrice
+		CurrentPrices   []*postgres.AllWorldsPriceRowExpensive
+	}
+
+	result.PricesHistory = queryPrices(t, pg, "prices_history")
+	result.Current = queryPrices(t, pg, "prices WHERE item_id = 44000")
+
+	rows, err := pg.Db.Query(`SELECT price_per_unit, quantity, high_quality FROM listings_history ORDER BY listing_id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l historyListing
+		if err := rows.Scan(&l.PricePerUnit, &l.Quantity, &l.HighQuality); err != nil {
+			t.Fatal(err)
+		}
+		result.ListingsHistory = append(result.ListingsHistory, l)
+	}
+
+	result.CurrentPrices, err = pg.GetPriceForItemIDScopedExpensive(ctx, steakID, aether)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "write_history", result, *update)
+}
+
+func queryPrices(t *testing.T, pg *postgres.Postgres, from string) []historyPrice {
+	t.Helper()
+	rows, err := pg.Db.Query(`SELECT item_id, world_id, nq_sale_velocity, hq_sale_velocity, min_price_nq, min_price_hq FROM ` + from + ` ORDER BY world_id, price_id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var prices []historyPrice
+	for rows.Next() {
+		var p historyPrice
+		if err := rows.Scan(&p.ItemID, &p.WorldID, &p.NQSaleVelocity, &p.HQSaleVelocity, &p.MinPriceNQ, &p.MinPriceHQ); err != nil {
+			t.Fatal(err)
+		}
+		prices = append(prices, p)
+	}
+	return prices
+}
+
+func TestWriteBackfillIsIdempotent(t *testing.T) {
+	pg := newDatabase(t)
+	ctx := context.Background()
+
+	since := time.Date(2024, 7, 2, 6, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 7, 3, 0, 0, 0, 0, time.UTC)
+	batch := &postgres.BackfillBatch{
+		WorldID: 63,
+		ItemIDs: []int{steakID},
+		Since:   since,
+		Sales: []postgres.Sale{
+			{ItemID: steakID, WorldID: 63, Time: day.Add(9 * time.Hour), PricePerUnit: 100, Quantity: 1},
+			{ItemID: steakID, WorldID: 63, Time: day.Add(15 * time.Hour), PricePerUnit: 90, Quantity: 1},
+		},
+		Snapshots: []postgres.HistorySnapshot{
+			{PriceID: postgres.BackfillPriceID(steakID, 63, day), ItemID: steakID, WorldID: 63, UpdateTime: day.Add(24*time.Hour - time.Second), NQSaleVelocity: 2, MinPriceNQ: 90},
+		},
+	}
+
+	written, err := pg.WriteBackfill(ctx, batch)
+	if err != nil || written != 3 {
+		t.Fatalf("first WriteBackfill() = %d, %v, want 2 sales and a snapshot", written, err)
+	}
+	written, err = pg.WriteBackfill(ctx, batch)
+	if err != nil || written != 0 {
+		t.Fatalf("second WriteBackfill() = %d, %v, want nothing new", written, err)
+	}
+
+	var sales int
+	if err := pg.Db.QueryRow(`SELECT count(*) FROM sales_history`).Scan(&sales); err != nil {
+		t.Fatal(err)
+	}
+	if sales != 2 {
+		t.Errorf("got %d sales, want 2", sales)
+	}
+
+	for _, c := range []struct {
+		since time.Time
+		done  bool
+	}{
+		{since, true},
+		{since.Add(time.Hour), true},
+		{since.Add(-time.Hour), false},
+	} {
+		done, err := pg.BackfilledItems(ctx, 63, []int{steakID, 43976}, c.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done[steakID] != c.done || done[43976] {
+			t.Errorf("BackfilledItems(since %v) = %v, want %d done: %v", c.since, done, steakID, c.done)
+		}
+	}
+}
+
+func TestSettingsTableUpgradesOldLayout(t *testing.T) {
+	pg := newDatabase(t)
+	ctx := context.Background()
+
+	// The layout before sell_via and tax_city, with a user who turned tax off.
+	mustExec(t, pg, `DROP TABLE bot_settings`)
+	mustExec(t, pg, `CREATE TABLE bot_settings (
+	owner_kind text NOT NULL,
+	owner_id text NOT NULL,
+	home_region text,
+	include_tax boolean,
+	PRIMARY KEY (owner_kind, owner_id)
+)`)
+	mustExec(t, pg, `INSERT INTO bot_settings (owner_kind, owner_id, home_region, include_tax) VALUES ('user', 'user', 'Europe', false)`)
+
+	if err := pg.InitializeSettingsTable(); err != nil {
+		t.Fatalf("InitializeSettingsTable() = %v", err)
+	}
+	got, err := pg.GetSettings(ctx, postgres.SettingsOwnerUser, "user")
+	if err != nil {
+		t.Fatalf("GetSettings() = %v", err)
+	}
+	if got.HomeRegion != "Europe" || got.SellVia != "direct" {
+		t.Errorf("GetSettings() = %+v, want Europe kept and include_tax off as selling directly", got)
+	}
+	if err := pg.SaveSettings(ctx, postgres.SettingsOwnerGuild, "guild", &postgres.Settings{TaxCity: "kugane"}); err != nil {
+		t.Errorf("SaveSettings() = %v", err)
+	}
+}
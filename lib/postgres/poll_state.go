@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const initializePollStateTable = `CREATE TABLE IF NOT EXISTS poll_state (
+	hotlist_name text NOT NULL,
+	world_id integer REFERENCES worlds ON DELETE CASCADE NOT NULL,
+	last_attempt timestamp with time zone,
+	last_success timestamp with time zone,
+	consecutive_failures integer NOT NULL DEFAULT 0,
+	next_due timestamp with time zone NOT NULL,
+	last_error text NOT NULL DEFAULT '',
+	PRIMARY KEY (hotlist_name, world_id)
+);`
+
+// PollState is where polling of one world of a hotlist left off. Zero times
+// mean it has never happened.
+type PollState struct {
+	HotlistName         string
+	WorldID             int
+	WorldName           string
+	LastAttempt         time.Time
+	LastSuccess         time.Time
+	ConsecutiveFailures int
+	NextDue             time.Time
+	LastError           string
+}
+
+func (p *Postgres) InitializePollStateTable() error {
+	_, err := p.Db.Exec(initializePollStateTable)
+	if err != nil {
+		return fmt.Errorf("failed to initialize poll state table: %w", err)
+	}
+	return nil
+}
+
+// LoadPollStates returns every saved poll state, ordered by hotlist then world.
+func (p *Postgres) LoadPollStates(ctx context.Context) ([]*PollState, error) {
+	query := `SELECT
+	poll_state.hotlist_name,
+	poll_state.world_id,
+	COALESCE(worlds.name, ''),
+	poll_state.last_attempt,
+	poll_state.last_success,
+	poll_state.consecutive_failures,
+	poll_state.next_due,
+	poll_state.last_error
+FROM poll_state
+LEFT JOIN worlds ON worlds.world_id = poll_state.world_id
+ORDER BY poll_state.hotlist_name, worlds.name`
+	rows, err := p.Db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load poll state: %w", err)
+	}
+	defer rows.Close()
+
+	var ret []*PollState
+	for rows.Next() {
+		var ps PollState
+		var lastAttempt, lastSuccess sql.NullTime
+		if err := rows.Scan(&ps.HotlistName, &ps.WorldID, &ps.WorldName, &lastAttempt, &lastSuccess, &ps.ConsecutiveFailures, &ps.NextDue, &ps.LastError); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		ps.LastAttempt = lastAttempt.Time
+		ps.LastSuccess = lastSuccess.Time
+		ret = append(ret, &ps)
+	}
+	return ret, nil
+}
+
+// SavePollState inserts or replaces the state for the hotlist and world.
+func (p *Postgres) SavePollState(ctx context.Context, ps *PollState) error {
+	query := `INSERT INTO poll_state (hotlist_name, world_id, last_attempt, last_success, consecutive_failures, next_due, last_error)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (hotlist_name, world_id) DO UPDATE SET
+	last_attempt = EXCLUDED.last_attempt,
+	last_success = EXCLUDED.last_success,
+	consecutive_failures = EXCLUDED.consecutive_failures,
+	next_due = EXCLUDED.next_due,
+	last_error = EXCLUDED.last_error`
+	_, err := p.Db.ExecContext(ctx, query,
+		ps.HotlistName,
+		ps.WorldID,
+		nullTime(ps.LastAttempt),
+		nullTime(ps.LastSuccess),
+		ps.ConsecutiveFailures,
+		ps.NextDue,
+		ps.LastError)
+	if err != nil {
+		return fmt.Errorf("failed to save poll state for hotlist %s world %d: %w", ps.HotlistName, ps.WorldID, err)
+	}
+	return nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
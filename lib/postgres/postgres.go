@@ -141,7 +141,10 @@ func checkPositive(nums []int) bool {
 	}
 	return true
 }
-func (p *Postgres) WriteUniversalisPriceData(ctx context.Context, upd *universalis.UniversalisPriceData) error {
+
+// WriteUniversalisPriceData writes a snapshot per item along with its listings,
+// returning how many rows were written.
+func (p *Postgres) WriteUniversalisPriceData(ctx context.Context, upd *universalis.UniversalisPriceData) (int, error) {
 	successCount := 0
 	for _, priceData := range upd.Items {
 		// Check in case it's garbage
@@ -183,26 +186,9 @@ RETURNING price_id;`, priceData.ItemID, priceData.WorldID, priceData.LastUploadT
 	}
 
 	if successCount == 0 {
-		return fmt.Errorf("all writes failed, see logs")
-	}
-	return nil
-}
-
-func (p *Postgres) NorthAmericanWorlds() ([]int, error) {
-	rows, err := p.Db.Query(`SELECT world_id FROM worlds WHERE datacenter IN ('Aether', 'Primal', 'Crystal', 'Dynamis') AND is_public;`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get NA worlds: %w", err)
-	}
-
-	var worldIDs []int
-	for rows.Next() {
-		var id int
-		if err := rows.Scan(&id); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal data for world id: %w", err)
-		}
-		worldIDs = append(worldIDs, id)
+		return 0, fmt.Errorf("all writes failed, see logs")
 	}
-	return worldIDs, nil
+	return successCount, nil
 }
 
 func (p *Postgres) GetItemIDsForStaticQuery(query string) ([]int, error) {
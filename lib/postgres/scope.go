@@ -0,0 +1,230 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownScope is returned when a name matches no world, datacenter, or region.
+var ErrUnknownScope = errors.New("no world, datacenter, or region with that name")
+
+type ScopeKind int
+
+const (
+	ScopeWorld ScopeKind = iota
+	ScopeDatacenter
+	ScopeRegion
+)
+
+func (k ScopeKind) String() string {
+	switch k {
+	case ScopeWorld:
+		return "world"
+	case ScopeDatacenter:
+		return "datacenter"
+	case ScopeRegion:
+		return "region"
+	}
+	return "unknown"
+}
+
+// Scope narrows a price lookup to a single world, every world in a datacenter,
+// or every world in a region.
+type Scope struct {
+	Kind ScopeKind
+	Name string
+}
+
+// DefaultRegion is the region the bot served before it knew about any others.
+const DefaultRegion = "North-America"
+
+// The worlds table only names datacenters, the datacenters table maps them onto
+// regions using the names Universalis uses. It's seeded with the public
+// datacenters, new ones can be added with a row.
+const initializeRegionTables = `CREATE TABLE IF NOT EXISTS datacenters (
+	name text PRIMARY KEY,
+	region text NOT NULL
+);
+
+INSERT INTO datacenters (name, region) VALUES
+	('Aether', 'North-America'),
+	('Primal', 'North-America'),
+	('Crystal', 'North-America'),
+	('Dynamis', 'North-America'),
+	('Chaos', 'Europe'),
+	('Light', 'Europe'),
+	('Shadow', 'Europe'),
+	('Materia', 'Oceania'),
+	('Elemental', 'Japan'),
+	('Gaia', 'Japan'),
+	('Mana', 'Japan'),
+	('Meteor', 'Japan')
+ON CONFLICT (name) DO NOTHING;`
+
+func (p *Postgres) InitializeRegionTables() error {
+	_, err := p.Db.Exec(initializeRegionTables)
+	if err != nil {
+		return fmt.Errorf("failed to initialize region tables: %w", err)
+	}
+	return nil
+}
+
+// condition returns a WHERE fragment restricting the price_world subquery of
+// recentAllWorldsPriceQueryExpensive to the scope, bound to placeholder $n.
+func (s *Scope) condition(n int) (string, interface{}) {
+	return s.conditionOn("price_world.world_name", "price_world.datacenter", n)
+}
+
+// conditionOn is condition for queries naming the world and datacenter columns differently.
+func (s *Scope) conditionOn(worldColumn string, datacenterColumn string, n int) (string, interface{}) {
+	switch s.Kind {
+	case ScopeDatacenter:
+		return fmt.Sprintf("%s = ($%d)", datacenterColumn, n), s.Name
+	case ScopeRegion:
+		return fmt.Sprintf("%s IN (SELECT name FROM datacenters WHERE region = ($%d))", datacenterColumn, n), s.Name
+	default:
+		return fmt.Sprintf("%s = ($%d)", worldColumn, n), s.Name
+	}
+}
+
+// ResolveScope finds the world, datacenter, or region with the given name, case
+// insensitively, preferring the narrowest match.
+func (pg *Postgres) ResolveScope(ctx context.Context, name string) (*Scope, error) {
+	var canonical string
+	row := pg.Db.QueryRowContext(ctx, `SELECT name FROM worlds WHERE UPPER(name) = UPPER(($1)) AND is_public`, name)
+	err := row.Scan(&canonical)
+	if err == nil {
+		return &Scope{Kind: ScopeWorld, Name: canonical}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to look up world %s: %w", name, err)
+	}
+
+	row = pg.Db.QueryRowContext(ctx, `SELECT DISTINCT datacenter FROM worlds WHERE UPPER(datacenter) = UPPER(($1)) AND is_public`, name)
+	err = row.Scan(&canonical)
+	if err == nil {
+		return &Scope{Kind: ScopeDatacenter, Name: canonical}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to look up datacenter %s: %w", name, err)
+	}
+
+	row = pg.Db.QueryRowContext(ctx, `SELECT DISTINCT region FROM datacenters WHERE UPPER(region) = UPPER(($1))`, name)
+	err = row.Scan(&canonical)
+	if err == nil {
+		return &Scope{Kind: ScopeRegion, Name: canonical}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to look up region %s: %w", name, err)
+	}
+	return nil, fmt.Errorf("%s: %w", name, ErrUnknownScope)
+}
+
+// AllScopes lists every public world and datacenter, and the regions they belong to.
+func (pg *Postgres) AllScopes(ctx context.Context) ([]*Scope, error) {
+	rows, err := pg.Db.QueryContext(ctx, `SELECT worlds.name, worlds.datacenter, COALESCE(datacenters.region, '')
+FROM worlds
+LEFT JOIN datacenters ON datacenters.name = worlds.datacenter
+WHERE worlds.is_public
+ORDER BY worlds.datacenter, worlds.name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worlds: %w", err)
+	}
+	defer rows.Close()
+
+	var scopes []*Scope
+	seenDatacenters := make(map[string]struct{})
+	seenRegions := make(map[string]struct{})
+	for rows.Next() {
+		var worldName, datacenter, region string
+		if err := rows.Scan(&worldName, &datacenter, &region); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		scopes = append(scopes, &Scope{Kind: ScopeWorld, Name: worldName})
+		if _, ok := seenDatacenters[datacenter]; !ok {
+			seenDatacenters[datacenter] = struct{}{}
+			scopes = append(scopes, &Scope{Kind: ScopeDatacenter, Name: datacenter})
+		}
+		if region != "" {
+			if _, seen := seenRegions[region]; !seen {
+				seenRegions[region] = struct{}{}
+				scopes = append(scopes, &Scope{Kind: ScopeRegion, Name: region})
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// WorldIDsInScope returns the IDs of every public world in scope.
+func (pg *Postgres) WorldIDsInScope(ctx context.Context, scope *Scope) ([]int, error) {
+	condition, arg := scope.conditionOn("worlds.name", "worlds.datacenter", 1)
+	rows, err := pg.Db.QueryContext(ctx, `SELECT worlds.world_id FROM worlds WHERE worlds.is_public AND `+condition+` ORDER BY worlds.world_id`, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worlds in %s %s: %w", scope.Kind, scope.Name, err)
+	}
+	defer rows.Close()
+
+	var worldIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		worldIDs = append(worldIDs, id)
+	}
+	return worldIDs, nil
+}
+
+// GetPriceForItemIDScopedExpensive is GetPriceForItemIDExpensive restricted to
+// the worlds in scope. A nil scope covers every world.
+func (p *Postgres) GetPriceForItemIDScopedExpensive(ctx context.Context, itemID int, scope *Scope) ([]*AllWorldsPriceRowExpensive, error) {
+	if scope == nil {
+		return p.GetPriceForItemIDExpensive(ctx, itemID)
+	}
+	condition, arg := scope.condition(2)
+	query := recentAllWorldsPriceQueryExpensive("items.item_id = ($1) AND " + condition)
+	rows, err := p.Db.QueryContext(ctx, query, itemID, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prices for item in %s %s (expensive query): %w", scope.Kind, scope.Name, err)
+	}
+	return scanAllWorldsPriceRowsExpensive(rows)
+}
+
+// GetPriceForItemNameScopedExpensive is GetPriceForItemNameExpensive restricted
+// to the worlds in scope. A nil scope covers every world.
+func (p *Postgres) GetPriceForItemNameScopedExpensive(ctx context.Context, itemName string, scope *Scope) ([]*AllWorldsPriceRowExpensive, error) {
+	if scope == nil {
+		return p.GetPriceForItemNameExpensive(ctx, itemName)
+	}
+	condition, arg := scope.condition(2)
+	query := recentAllWorldsPriceQueryExpensive("UPPER(items.name) = UPPER(($1)) AND " + condition)
+	rows, err := p.Db.QueryContext(ctx, query, itemName, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prices for item in %s %s (expensive query): %w", scope.Kind, scope.Name, err)
+	}
+	return scanAllWorldsPriceRowsExpensive(rows)
+}
+
+func scanAllWorldsPriceRowsExpensive(rows *sql.Rows) ([]*AllWorldsPriceRowExpensive, error) {
+	defer rows.Close()
+
+	var prices []*AllWorldsPriceRowExpensive
+	for rows.Next() {
+		var name, worldName, datacenter string
+		var minPrice int
+		var highQuality bool
+		if err := rows.Scan(&name, &worldName, &datacenter, &minPrice, &highQuality); err != nil {
+			return nil, fmt.Errorf("failed to scan out values into row: %w", err)
+		}
+		prices = append(prices, &AllWorldsPriceRowExpensive{
+			Name:        name,
+			WorldName:   worldName,
+			Datacenter:  datacenter,
+			MinPrice:    minPrice,
+			HighQuality: highQuality,
+		})
+	}
+	return prices, nil
+}
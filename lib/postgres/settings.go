@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const initializeSettingsTable = `CREATE TABLE IF NOT EXISTS bot_settings (
+	owner_kind text NOT NULL,
+	owner_id text NOT NULL,
+	home_world text,
+	home_datacenter text,
+	home_region text,
+	price_model text,
+	sell_via text,
+	tax_city text,
+	locale text,
+	alert_channel text,
+	PRIMARY KEY (owner_kind, owner_id)
+);
+
+-- Tables made before every setting existed only get the columns they're
+-- missing. include_tax off meant no sales tax, which is selling directly.
+ALTER TABLE bot_settings
+	ADD COLUMN IF NOT EXISTS home_world text,
+	ADD COLUMN IF NOT EXISTS home_datacenter text,
+	ADD COLUMN IF NOT EXISTS home_region text,
+	ADD COLUMN IF NOT EXISTS price_model text,
+	ADD COLUMN IF NOT EXISTS sell_via text,
+	ADD COLUMN IF NOT EXISTS tax_city text,
+	ADD COLUMN IF NOT EXISTS locale text,
+	ADD COLUMN IF NOT EXISTS alert_channel text;
+
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'bot_settings' AND column_name = 'include_tax') THEN
+		UPDATE bot_settings SET sell_via = 'direct' WHERE include_tax = false AND sell_via IS NULL;
+		ALTER TABLE bot_settings DROP COLUMN include_tax;
+	END IF;
+END $$;`
+
+// SettingsOwner is who a row of settings belongs to.
+type SettingsOwner string
+
+const (
+	SettingsOwnerGuild SettingsOwner = "guild"
+	SettingsOwnerUser  SettingsOwner = "user"
+)
+
+// Settings are one owner's bot defaults. Empty strings are unset, so another
+// owner's value or the built in default applies.
+type Settings struct {
+	HomeWorld      string
+	HomeDatacenter string
+	HomeRegion     string
+	PriceModel     string
+	// A fees.Channel and fees.City.
+	SellVia      string
+	TaxCity      string
+	Locale       string
+	AlertChannel string
+}
+
+func (p *Postgres) InitializeSettingsTable() error {
+	_, err := p.Db.Exec(initializeSettingsTable)
+	if err != nil {
+		return fmt.Errorf("failed to initialize settings table: %w", err)
+	}
+	return nil
+}
+
+// GetSettings returns the owner's settings, all unset when they've never saved any.
+func (p *Postgres) GetSettings(ctx context.Context, kind SettingsOwner, ownerID string) (*Settings, error) {
+	query := `SELECT home_world, home_datacenter, home_region, price_model, sell_via, tax_city, locale, alert_channel
+FROM bot_settings
+WHERE owner_kind = ($1) AND owner_id = ($2)`
+	var homeWorld, homeDatacenter, homeRegion, priceModel, sellVia, taxCity, locale, alertChannel sql.NullString
+	row := p.Db.QueryRowContext(ctx, query, string(kind), ownerID)
+	err := row.Scan(&homeWorld, &homeDatacenter, &homeRegion, &priceModel, &sellVia, &taxCity, &locale, &alertChannel)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Settings{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings for %s %s: %w", kind, ownerID, err)
+	}
+
+	return &Settings{
+		HomeWorld:      homeWorld.String,
+		HomeDatacenter: homeDatacenter.String,
+		HomeRegion:     homeRegion.String,
+		PriceModel:     priceModel.String,
+		SellVia:        sellVia.String,
+		TaxCity:        taxCity.String,
+		Locale:         locale.String,
+		AlertChannel:   alertChannel.String,
+	}, nil
+}
+
+// SaveSettings replaces every setting of the owner, writing unset values as NULL.
+func (p *Postgres) SaveSettings(ctx context.Context, kind SettingsOwner, ownerID string, s *Settings) error {
+	query := `INSERT INTO bot_settings (owner_kind, owner_id, home_world, home_datacenter, home_region, price_model, sell_via, tax_city, locale, alert_channel)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (owner_kind, owner_id) DO UPDATE SET
+	home_world = EXCLUDED.home_world,
+	home_datacenter = EXCLUDED.home_datacenter,
+	home_region = EXCLUDED.home_region,
+	price_model = EXCLUDED.price_model,
+	sell_via = EXCLUDED.sell_via,
+	tax_city = EXCLUDED.tax_city,
+	locale = EXCLUDED.locale,
+	alert_channel = EXCLUDED.alert_channel`
+	_, err := p.Db.ExecContext(ctx, query,
+		string(kind),
+		ownerID,
+		nullString(s.HomeWorld),
+		nullString(s.HomeDatacenter),
+		nullString(s.HomeRegion),
+		nullString(s.PriceModel),
+		nullString(s.SellVia),
+		nullString(s.TaxCity),
+		nullString(s.Locale),
+		nullString(s.AlertChannel))
+	if err != nil {
+		return fmt.Errorf("failed to save settings for %s %s: %w", kind, ownerID, err)
+	}
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,68 @@
+package shopping
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Entry is an unresolved line of a pasted shopping list.
+type Entry struct {
+	Name     string
+	Quantity int
+}
+
+var (
+	// "3x Item", "3 x Item", "3 Item"
+	leadingQuantity = regexp.MustCompile(`^(\d+)\s*[xX]?\s+(.+)$`)
+	// "Item x3", "Item x 3", "Item, 3", "Item: 3", "Item	3"
+	trailingQuantity = regexp.MustCompile(`^(.+?)\s*(?:[xX]\s*|[,:\t]\s*|\s+)(\d+)$`)
+)
+
+// ParseList reads a shopping list with one item per line or separated by
+// semicolons. Lines without a quantity count as one. Repeated items are merged.
+func ParseList(text string) ([]Entry, error) {
+	var entries []Entry
+	index := make(map[string]int)
+
+	lines := strings.FieldsFunc(text, func(r rune) bool {
+		return r == '\n' || r == ';'
+	})
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name := line
+		quantity := 1
+		if m := leadingQuantity.FindStringSubmatch(line); m != nil {
+			name = m[2]
+			quantity, _ = strconv.Atoi(m[1])
+		} else if m := trailingQuantity.FindStringSubmatch(line); m != nil {
+			name = m[1]
+			quantity, _ = strconv.Atoi(m[2])
+		}
+		name = strings.TrimSpace(name)
+		if quantity <= 0 {
+			return nil, fmt.Errorf("quantity for %s must be positive", name)
+		}
+
+		key := strings.ToLower(name)
+		if i, ok := index[key]; ok {
+			entries[i].Quantity += quantity
+			continue
+		}
+		index[key] = len(entries)
+		entries = append(entries, Entry{
+			Name:     name,
+			Quantity: quantity,
+		})
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("shopping list is empty")
+	}
+	return entries, nil
+}
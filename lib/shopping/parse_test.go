@@ -0,0 +1,41 @@
+package shopping
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{name: "leading quantity", text: "3x Rock Salt\n3 x Rroneek Chuck\n3 Wind Shard", want: "[{Rock Salt 3} {Rroneek Chuck 3} {Wind Shard 3}]"},
+		{name: "trailing quantity", text: "Rock Salt x3\nRroneek Chuck, 4\nWind Shard: 5\nFire Shard\t6\nIce Shard 7", want: "[{Rock Salt 3} {Rroneek Chuck 4} {Wind Shard 5} {Fire Shard 6} {Ice Shard 7}]"},
+		{name: "no quantity is one", text: "Rock Salt", want: "[{Rock Salt 1}]"},
+		{name: "semicolons", text: "Rock Salt x2; Wind Shard x8", want: "[{Rock Salt 2} {Wind Shard 8}]"},
+		{name: "comments and blank lines", text: "# for the steak\n\n  Rock Salt x2  \n", want: "[{Rock Salt 2}]"},
+		{name: "repeats are merged ignoring case", text: "Rock Salt x2\nrock salt 3", want: "[{Rock Salt 5}]"},
+		{name: "zero quantity", text: "Rock Salt x0", wantErr: true},
+		{name: "empty", text: "# nothing\n;", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseList(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseList() = %v, want an error", entries)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(entries); got != tt.want {
+				t.Errorf("ParseList() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package shopping
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Worlds beyond this many are planned with a greedy search instead of trying
+// every combination of worlds, 2^12 combinations is still quick.
+const maxExactWorlds = 12
+
+// Want is a single line of the shopping list.
+type Want struct {
+	ItemID   int32
+	Name     string
+	Quantity int
+}
+
+// Listing is a market board listing. Listings are bought whole.
+type Listing struct {
+	ItemID       int32
+	WorldName    string
+	PricePerUnit int
+	Quantity     int
+	HighQuality  bool
+}
+
+type Options struct {
+	// TravelPenalty is the gil cost charged for every world visited, trading
+	// a few gil saved against the time spent world hopping.
+	TravelPenalty int
+	// NQOnly skips HQ listings, for when HQ materials would be wasted.
+	NQOnly bool
+}
+
+type Purchase struct {
+	ItemID       int32
+	Name         string
+	WorldName    string
+	PricePerUnit int
+	Quantity     int
+	HighQuality  bool
+}
+
+// Shortfall records how much of an item couldn't be bought anywhere.
+type Shortfall struct {
+	ItemID   int32
+	Name     string
+	Quantity int
+}
+
+type Plan struct {
+	Worlds     []string
+	Purchases  []*Purchase
+	Shortfalls []*Shortfall
+	ItemCost   int
+	TravelCost int
+}
+
+func (p *Plan) TotalCost() int {
+	return p.ItemCost + p.TravelCost
+}
+
+type planner struct {
+	wants  []Want
+	worlds []string
+	// Listings per want, cheapest unit price first, with the world as an index into worlds.
+	listings [][]indexedListing
+}
+
+type indexedListing struct {
+	Listing
+	world int
+}
+
+type evaluation struct {
+	cost      int
+	shortfall int
+	picks     [][]indexedListing
+	missing   []int
+}
+
+// Optimize picks the set of worlds and listings that fill the shopping list for
+// the least gil plus travel penalty. Filling as much of the list as possible
+// always wins over saving gil.
+func Optimize(wants []Want, listings []Listing, opts Options) *Plan {
+	p := newPlanner(wants, listings, opts)
+
+	all := uint64(1)<<len(p.worlds) - 1
+	best := all
+	bestEval := p.evaluate(all)
+	bestScore := score(bestEval, all, opts)
+
+	if len(p.worlds) <= maxExactWorlds {
+		for mask := uint64(1); mask < all; mask++ {
+			ev := p.evaluate(mask)
+			if ev.shortfall > bestEval.shortfall {
+				continue
+			}
+			if s := score(ev, mask, opts); ev.shortfall < bestEval.shortfall || s < bestScore {
+				best, bestEval, bestScore = mask, ev, s
+			}
+		}
+	} else {
+		// Drop whichever world saves the most until dropping any world costs more.
+		for improved := true; improved; {
+			improved = false
+			for w := range p.worlds {
+				if best&(1<<w) == 0 {
+					continue
+				}
+				mask := best &^ (1 << w)
+				ev := p.evaluate(mask)
+				if ev.shortfall > bestEval.shortfall {
+					continue
+				}
+				if s := score(ev, mask, opts); s < bestScore {
+					best, bestEval, bestScore = mask, ev, s
+					improved = true
+				}
+			}
+		}
+	}
+
+	return p.plan(best, bestEval, opts)
+}
+
+func newPlanner(wants []Want, listings []Listing, opts Options) *planner {
+	p := &planner{}
+	wantIndex := make(map[int32]int)
+	for _, want := range wants {
+		// Names typed differently can resolve to the same item, buy it as one.
+		if i, ok := wantIndex[want.ItemID]; ok {
+			p.wants[i].Quantity += want.Quantity
+			continue
+		}
+		wantIndex[want.ItemID] = len(p.wants)
+		p.wants = append(p.wants, want)
+	}
+	p.listings = make([][]indexedListing, len(p.wants))
+
+	worldIndex := make(map[string]int)
+	for _, l := range listings {
+		i, ok := wantIndex[l.ItemID]
+		if !ok || l.Quantity <= 0 || (opts.NQOnly && l.HighQuality) {
+			continue
+		}
+		w, ok := worldIndex[l.WorldName]
+		if !ok {
+			w = len(p.worlds)
+			worldIndex[l.WorldName] = w
+			p.worlds = append(p.worlds, l.WorldName)
+		}
+		p.listings[i] = append(p.listings[i], indexedListing{Listing: l, world: w})
+	}
+	for _, ls := range p.listings {
+		sort.SliceStable(ls, func(a, b int) bool {
+			return ls[a].PricePerUnit < ls[b].PricePerUnit
+		})
+	}
+	return p
+}
+
+func score(ev *evaluation, mask uint64, opts Options) int {
+	return ev.cost + bits.OnesCount64(mask)*opts.TravelPenalty
+}
+
+// evaluate fills every want using only the worlds in mask.
+func (p *planner) evaluate(mask uint64) *evaluation {
+	ev := &evaluation{
+		picks:   make([][]indexedListing, len(p.wants)),
+		missing: make([]int, len(p.wants)),
+	}
+	for i, want := range p.wants {
+		picks, cost, missing := fill(p.listings[i], mask, want.Quantity)
+		ev.picks[i] = picks
+		ev.cost += cost
+		ev.missing[i] = missing
+		ev.shortfall += missing
+	}
+	return ev
+}
+
+// fill buys listings cheapest unit price first. Once the next listing would
+// overshoot, it also considers the single cheapest listing that covers the rest,
+// which avoids buying a 99 stack at a good unit price to cover the last 2.
+func fill(listings []indexedListing, mask uint64, quantity int) ([]indexedListing, int, int) {
+	var picks []indexedListing
+	cost := 0
+	used := make([]bool, len(listings))
+	remaining := quantity
+	for remaining > 0 {
+		next := -1
+		cover := -1
+		for i, l := range listings {
+			if used[i] || mask&(1<<l.world) == 0 {
+				continue
+			}
+			if next == -1 {
+				next = i
+			}
+			if l.Quantity >= remaining && (cover == -1 || l.PricePerUnit*l.Quantity < listings[cover].PricePerUnit*listings[cover].Quantity) {
+				cover = i
+			}
+		}
+		if next == -1 {
+			break
+		}
+
+		pick := next
+		if listings[next].Quantity >= remaining || (cover != -1 && coverIsCheaper(listings, used, mask, next, cover, remaining)) {
+			pick = cover
+		}
+		used[pick] = true
+		picks = append(picks, listings[pick])
+		cost += listings[pick].PricePerUnit * listings[pick].Quantity
+		remaining -= listings[pick].Quantity
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return picks, cost, remaining
+}
+
+// coverIsCheaper compares buying cover outright against continuing to buy by
+// unit price, finishing with the cheapest listing that covers what's left
+// rather than whatever stack comes next.
+func coverIsCheaper(listings []indexedListing, used []bool, mask uint64, next int, cover int, remaining int) bool {
+	greedy := 0
+	left := remaining
+	for i := next; i < len(listings); i++ {
+		l := listings[i]
+		if used[i] || mask&(1<<l.world) == 0 {
+			continue
+		}
+		if l.Quantity >= left {
+			return listings[cover].PricePerUnit*listings[cover].Quantity <= greedy+cheapestCover(listings, used, mask, i, left)
+		}
+		greedy += l.PricePerUnit * l.Quantity
+		left -= l.Quantity
+	}
+	return true
+}
+
+// cheapestCover is the lowest total of a single listing from start on with at
+// least quantity units. Listings before start are bought or out of reach.
+func cheapestCover(listings []indexedListing, used []bool, mask uint64, start int, quantity int) int {
+	cheapest := -1
+	for i := start; i < len(listings); i++ {
+		l := listings[i]
+		if used[i] || l.Quantity < quantity || mask&(1<<l.world) == 0 {
+			continue
+		}
+		if total := l.PricePerUnit * l.Quantity; cheapest == -1 || total < cheapest {
+			cheapest = total
+		}
+	}
+	return cheapest
+}
+
+func (p *planner) plan(mask uint64, ev *evaluation, opts Options) *Plan {
+	plan := &Plan{
+		ItemCost: ev.cost,
+	}
+	visited := make(map[int]struct{})
+	for i, want := range p.wants {
+		for _, l := range ev.picks[i] {
+			visited[l.world] = struct{}{}
+			plan.Purchases = append(plan.Purchases, &Purchase{
+				ItemID:       want.ItemID,
+				Name:         want.Name,
+				WorldName:    l.WorldName,
+				PricePerUnit: l.PricePerUnit,
+				Quantity:     l.Quantity,
+				HighQuality:  l.HighQuality,
+			})
+		}
+		if ev.missing[i] > 0 {
+			plan.Shortfalls = append(plan.Shortfalls, &Shortfall{
+				ItemID:   want.ItemID,
+				Name:     want.Name,
+				Quantity: ev.missing[i],
+			})
+		}
+	}
+	// Only count worlds we actually buy from, the mask can include worlds left unused.
+	for w := range visited {
+		plan.Worlds = append(plan.Worlds, p.worlds[w])
+	}
+	sort.Strings(plan.Worlds)
+	plan.TravelCost = len(plan.Worlds) * opts.TravelPenalty
+
+	sort.SliceStable(plan.Purchases, func(a, b int) bool {
+		if plan.Purchases[a].WorldName != plan.Purchases[b].WorldName {
+			return plan.Purchases[a].WorldName < plan.Purchases[b].WorldName
+		}
+		return plan.Purchases[a].Name < plan.Purchases[b].Name
+	})
+	return plan
+}
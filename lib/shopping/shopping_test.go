@@ -0,0 +1,246 @@
+package shopping
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	saltID  = 1
+	chuckID = 2
+)
+
+var (
+	salt  = Want{ItemID: saltID, Name: "Rock Salt"}
+	chuck = Want{ItemID: chuckID, Name: "Rroneek Chuck"}
+)
+
+func want(w Want, quantity int) Want {
+	w.Quantity = quantity
+	return w
+}
+
+func listing(itemID int32, world string, quantity int, price int) Listing {
+	return Listing{ItemID: itemID, WorldName: world, PricePerUnit: price, Quantity: quantity}
+}
+
+// describe writes a plan on one line, e.g.
+// "Jenova: 3 Rock Salt at 9; short 2 Rroneek Chuck; items 27, travel 0".
+func describe(p *Plan) string {
+	var parts []string
+	for _, pu := range p.Purchases {
+		hq := ""
+		if pu.HighQuality {
+			hq = " HQ"
+		}
+		parts = append(parts, fmt.Sprintf("%s: %d %s%s at %d", pu.WorldName, pu.Quantity, pu.Name, hq, pu.PricePerUnit))
+	}
+	for _, s := range p.Shortfalls {
+		parts = append(parts, fmt.Sprintf("short %d %s", s.Quantity, s.Name))
+	}
+	parts = append(parts, fmt.Sprintf("items %d, travel %d", p.ItemCost, p.TravelCost))
+	return strings.Join(parts, "; ")
+}
+
+func TestOptimize(t *testing.T) {
+	// Thirteen worlds is past maxExactWorlds, so it's planned greedily. Each
+	// world has one salt, the later ones a little cheaper.
+	var manyWorlds []Listing
+	for i := 0; i <= maxExactWorlds; i++ {
+		manyWorlds = append(manyWorlds, listing(saltID, fmt.Sprintf("World %02d", i), 1, 100-i))
+	}
+
+	tests := []struct {
+		name     string
+		wants    []Want
+		listings []Listing
+		opts     Options
+		want     string
+	}{
+		{
+			name:     "cheapest world",
+			wants:    []Want{want(salt, 3)},
+			listings: []Listing{listing(saltID, "Gilgamesh", 3, 10), listing(saltID, "Jenova", 3, 9)},
+			want:     "Jenova: 3 Rock Salt at 9; items 27, travel 0",
+		},
+		{
+			name:     "splits across worlds without a travel penalty",
+			wants:    []Want{want(salt, 1), want(chuck, 1)},
+			listings: []Listing{listing(saltID, "Gilgamesh", 1, 100), listing(chuckID, "Gilgamesh", 1, 100), listing(saltID, "Jenova", 1, 90)},
+			want:     "Gilgamesh: 1 Rroneek Chuck at 100; Jenova: 1 Rock Salt at 90; items 190, travel 0",
+		},
+		{
+			name:     "travel penalty keeps to one world",
+			wants:    []Want{want(salt, 1), want(chuck, 1)},
+			listings: []Listing{listing(saltID, "Gilgamesh", 1, 100), listing(chuckID, "Gilgamesh", 1, 100), listing(saltID, "Jenova", 1, 90)},
+			opts:     Options{TravelPenalty: 50},
+			want:     "Gilgamesh: 1 Rock Salt at 100; Gilgamesh: 1 Rroneek Chuck at 100; items 200, travel 50",
+		},
+		{
+			name:     "filling the list beats saving gil",
+			wants:    []Want{want(salt, 2)},
+			listings: []Listing{listing(saltID, "Gilgamesh", 1, 10), listing(saltID, "Jenova", 1, 1000)},
+			opts:     Options{TravelPenalty: 500},
+			want:     "Gilgamesh: 1 Rock Salt at 10; Jenova: 1 Rock Salt at 1000; items 1010, travel 1000",
+		},
+		{
+			name:     "shortfall",
+			wants:    []Want{want(salt, 5), want(chuck, 1)},
+			listings: []Listing{listing(saltID, "Gilgamesh", 3, 10)},
+			want:     "Gilgamesh: 3 Rock Salt at 10; short 2 Rock Salt; short 1 Rroneek Chuck; items 30, travel 0",
+		},
+		{
+			name:  "nothing listed",
+			wants: []Want{want(salt, 5)},
+			want:  "short 5 Rock Salt; items 0, travel 0",
+		},
+		{
+			name:     "duplicate items are bought together",
+			wants:    []Want{want(salt, 3), want(salt, 2)},
+			listings: []Listing{listing(saltID, "Gilgamesh", 99, 1)},
+			want:     "Gilgamesh: 99 Rock Salt at 1; items 99, travel 0",
+		},
+		{
+			name:  "NQ only",
+			wants: []Want{want(chuck, 1)},
+			listings: []Listing{
+				{ItemID: chuckID, WorldName: "Gilgamesh", PricePerUnit: 50, Quantity: 1, HighQuality: true},
+				listing(chuckID, "Jenova", 1, 80),
+			},
+			opts: Options{NQOnly: true},
+			want: "Jenova: 1 Rroneek Chuck at 80; items 80, travel 0",
+		},
+		{
+			name:  "HQ when cheaper",
+			wants: []Want{want(chuck, 1)},
+			listings: []Listing{
+				{ItemID: chuckID, WorldName: "Gilgamesh", PricePerUnit: 50, Quantity: 1, HighQuality: true},
+				listing(chuckID, "Jenova", 1, 80),
+			},
+			want: "Gilgamesh: 1 Rroneek Chuck HQ at 50; items 50, travel 0",
+		},
+		{
+			name:     "unwanted and empty listings are ignored",
+			wants:    []Want{want(salt, 1)},
+			listings: []Listing{listing(chuckID, "Gilgamesh", 1, 1), listing(saltID, "Gilgamesh", 0, 1), listing(saltID, "Jenova", 1, 5)},
+			want:     "Jenova: 1 Rock Salt at 5; items 5, travel 0",
+		},
+		{
+			name:     "greedy search over many worlds",
+			wants:    []Want{want(salt, 2)},
+			listings: manyWorlds,
+			opts:     Options{TravelPenalty: 10},
+			want:     "World 11: 1 Rock Salt at 89; World 12: 1 Rock Salt at 88; items 177, travel 20",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describe(Optimize(tt.wants, tt.listings, tt.opts)); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFill(t *testing.T) {
+	indexed := func(ls ...Listing) []indexedListing {
+		var out []indexedListing
+		for i, l := range ls {
+			out = append(out, indexedListing{Listing: l, world: i})
+		}
+		return out
+	}
+	tests := []struct {
+		name     string
+		listings []indexedListing
+		mask     uint64
+		quantity int
+		want     string
+	}{
+		{
+			name:     "cheapest unit price first",
+			listings: indexed(listing(saltID, "A", 2, 10), listing(saltID, "B", 2, 11), listing(saltID, "C", 10, 12)),
+			mask:     0b111,
+			quantity: 4,
+			want:     "[2 at 10, 2 at 11] cost 42 missing 0",
+		},
+		{
+			name:     "one listing covering the rest",
+			listings: indexed(listing(saltID, "A", 2, 10), listing(saltID, "B", 5, 12)),
+			mask:     0b11,
+			quantity: 4,
+			want:     "[5 at 12] cost 60 missing 0",
+		},
+		{
+			name:     "cheaper cover for the last few",
+			listings: indexed(listing(saltID, "A", 3, 10), listing(saltID, "B", 99, 11), listing(saltID, "C", 2, 15)),
+			mask:     0b111,
+			quantity: 5,
+			want:     "[3 at 10, 2 at 15] cost 60 missing 0",
+		},
+		{
+			name:     "worlds outside the mask",
+			listings: indexed(listing(saltID, "A", 5, 1), listing(saltID, "B", 5, 10)),
+			mask:     0b10,
+			quantity: 4,
+			want:     "[5 at 10] cost 50 missing 0",
+		},
+		{
+			name:     "not enough listed",
+			listings: indexed(listing(saltID, "A", 1, 10)),
+			mask:     0b1,
+			quantity: 3,
+			want:     "[1 at 10] cost 10 missing 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			picks, cost, missing := fill(tt.listings, tt.mask, tt.quantity)
+			var bought []string
+			for _, p := range picks {
+				bought = append(bought, fmt.Sprintf("%d at %d", p.Quantity, p.PricePerUnit))
+			}
+			got := fmt.Sprintf("[%s] cost %d missing %d", strings.Join(bought, ", "), cost, missing)
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoverIsCheaper(t *testing.T) {
+	listings := []indexedListing{
+		{Listing: listing(saltID, "A", 2, 10), world: 0},
+		{Listing: listing(saltID, "A", 2, 11), world: 0},
+		{Listing: listing(saltID, "B", 5, 12), world: 1},
+		{Listing: listing(saltID, "B", 99, 13), world: 1},
+	}
+	tests := []struct {
+		name      string
+		used      []bool
+		mask      uint64
+		remaining int
+		want      bool
+	}{
+		// 2 at 10, 2 at 11, then the 5 at 12 for the last one is 102 against 60.
+		{name: "stack is cheaper", mask: 0b11, remaining: 5, want: true},
+		// 2 at 10 then 2 at 11 is 42 against 60.
+		{name: "unit price is cheaper", mask: 0b11, remaining: 4, want: false},
+		// 2 at 10 then 2 at 11 covers the last one for 42, not the 99 stack.
+		{name: "finishes with a small listing", mask: 0b11, remaining: 3, want: false},
+		// With the 2 at 11 already bought, 2 at 10 then the 5 at 12 is 80 against 60.
+		{name: "skips bought listings", used: []bool{false, true, false, false}, mask: 0b11, remaining: 4, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			used := tt.used
+			if used == nil {
+				used = make([]bool, len(listings))
+			}
+			if got := coverIsCheaper(listings, used, tt.mask, 0, 2, tt.remaining); got != tt.want {
+				t.Errorf("coverIsCheaper() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
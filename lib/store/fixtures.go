@@ -0,0 +1,74 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+// Fixture files LoadFixtures reads from its directory, each a JSON array of
+// the matching type. A missing file is treated as empty.
+const (
+	ItemsFixture   = "items.json"
+	WorldsFixture  = "worlds.json"
+	RecipesFixture = "recipes.json"
+	PricesFixture  = "prices.json"
+)
+
+// Fixtures is the contents of a fixture directory.
+type Fixtures struct {
+	Items   []*Item
+	Worlds  []*World
+	Recipes []*Recipe
+	Prices  []*PriceSnapshot
+}
+
+// ReadFixtures decodes the fixture files in dir, for loading into a store.
+func ReadFixtures(dir string) (*Fixtures, error) {
+	f := &Fixtures{}
+	for name, dst := range map[string]interface{}{
+		ItemsFixture:   &f.Items,
+		WorldsFixture:  &f.Worlds,
+		RecipesFixture: &f.Recipes,
+		PricesFixture:  &f.Prices,
+	} {
+		if err := readFixture(filepath.Join(dir, name), dst); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// LoadFixtures builds a Memory store from the fixture files in dir.
+func LoadFixtures(dir string, logger *zap.SugaredLogger) (*Memory, error) {
+	f, err := ReadFixtures(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMemory(logger)
+	m.AddItems(f.Items...)
+	m.AddWorlds(f.Worlds...)
+	m.AddRecipes(f.Recipes...)
+	m.AddPrices(f.Prices...)
+	return m, nil
+}
+
+func readFixture(path string, dst interface{}) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read fixture %s: %w", path, err)
+	}
+	if err := json.Unmarshal(b, dst); err != nil {
+		return fmt.Errorf("failed to decode fixture %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,839 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/universalis"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Item is a row of the items table, along with its item_origins.
+type Item struct {
+	ItemID                int32    `json:"item_id"`
+	Type                  string   `json:"type"`
+	Name                  string   `json:"name"`
+	ItemLevel             int      `json:"item_level"`
+	SpecialCurrencyItemID int32    `json:"special_currency_item_id"`
+	SpecialCurrencyCount  int      `json:"special_currency_count"`
+	HighQualityable       bool     `json:"high_qualityable"`
+	Marketable            bool     `json:"marketable"`
+	GilPrice              int      `json:"gil_price"`
+	Origins               []string `json:"origins"`
+}
+
+// World is a row of the worlds table, along with its datacenter's region.
+type World struct {
+	WorldID    int    `json:"world_id"`
+	Name       string `json:"name"`
+	Datacenter string `json:"datacenter"`
+	Region     string `json:"region"`
+	IsPublic   bool   `json:"is_public"`
+}
+
+type Recipe struct {
+	RecipeID         int32              `json:"recipe_id"`
+	CraftedItemID    int32              `json:"crafted_item_id"`
+	CraftedItemCount int32              `json:"crafted_item_count"`
+	Ingredients      []RecipeIngredient `json:"ingredients"`
+}
+
+type RecipeIngredient struct {
+	ItemID   int32 `json:"item_id"`
+	Quantity int32 `json:"quantity"`
+}
+
+// PriceSnapshot is a row of the prices table and its listings.
+type PriceSnapshot struct {
+	ItemID         int               `json:"item_id"`
+	WorldID        int               `json:"world_id"`
+	UpdateTime     time.Time         `json:"update_time"`
+	NQSaleVelocity int               `json:"nq_sale_velocity"`
+	HQSaleVelocity int               `json:"hq_sale_velocity"`
+	MinPriceNQ     int               `json:"min_price_nq"`
+	MinPriceHQ     int               `json:"min_price_hq"`
+	Listings       []SnapshotListing `json:"listings"`
+}
+
+type SnapshotListing struct {
+	PricePerUnit int  `json:"price_per_unit"`
+	Quantity     int  `json:"quantity"`
+	HighQuality  bool `json:"high_quality"`
+}
+
+func (ps *PriceSnapshot) saleVelocity() int {
+	return ps.NQSaleVelocity + ps.HQSaleVelocity
+}
+
+// minListing is the cheapest listing, optionally of one quality, and false when
+// there are none.
+func (ps *PriceSnapshot) minListing(quality func(hq bool) bool) (int, bool) {
+	minPrice, found := 0, false
+	for _, l := range ps.Listings {
+		if !quality(l.HighQuality) {
+			continue
+		}
+		if !found || l.PricePerUnit < minPrice {
+			minPrice, found = l.PricePerUnit, true
+		}
+	}
+	return minPrice, found
+}
+
+func anyQuality(bool) bool { return true }
+
+type priceKey struct {
+	itemID  int
+	worldID int
+}
+
+type settingsKey struct {
+	kind    postgres.SettingsOwner
+	ownerID string
+}
+
+type pollStateKey struct {
+	hotlist string
+	worldID int
+}
+
+// Memory is a Store held entirely in memory. Like the prices table it keeps
+// only the latest snapshot per item and world, moving older ones to history.
+type Memory struct {
+	mu         sync.RWMutex
+	items      map[int32]*Item
+	worlds     map[int]*World
+	recipes    []*Recipe
+	prices     map[priceKey]*PriceSnapshot
+	history    []*PriceSnapshot
+	settings   map[settingsKey]postgres.Settings
+	pollStates map[pollStateKey]postgres.PollState
+	logger     *zap.SugaredLogger
+}
+
+func NewMemory(logger *zap.SugaredLogger) *Memory {
+	return &Memory{
+		items:      make(map[int32]*Item),
+		worlds:     make(map[int]*World),
+		prices:     make(map[priceKey]*PriceSnapshot),
+		settings:   make(map[settingsKey]postgres.Settings),
+		pollStates: make(map[pollStateKey]postgres.PollState),
+		logger:     logger,
+	}
+}
+
+func (m *Memory) AddItems(items ...*Item) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, item := range items {
+		m.items[item.ItemID] = item
+	}
+}
+
+func (m *Memory) AddWorlds(worlds ...*World) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, world := range worlds {
+		m.worlds[world.WorldID] = world
+	}
+}
+
+func (m *Memory) AddRecipes(recipes ...*Recipe) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recipes = append(m.recipes, recipes...)
+}
+
+// AddPrices stores snapshots as if they'd just been written.
+func (m *Memory) AddPrices(snapshots ...*PriceSnapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ps := range snapshots {
+		m.addPrice(ps)
+	}
+}
+
+func (m *Memory) addPrice(ps *PriceSnapshot) {
+	key := priceKey{itemID: ps.ItemID, worldID: ps.WorldID}
+	if old, ok := m.prices[key]; ok {
+		m.history = append(m.history, old)
+	}
+	m.prices[key] = ps
+}
+
+// History returns every snapshot replaced by a newer one, oldest first.
+func (m *Memory) History() []*PriceSnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.history)
+}
+
+func (m *Memory) AllItemNames(ctx context.Context) ([]*postgres.ItemName, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var names []*postgres.ItemName
+	for _, item := range m.items {
+		if item.Name == "" {
+			continue
+		}
+		names = append(names, &postgres.ItemName{ItemID: item.ItemID, Name: item.Name})
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].ItemID < names[j].ItemID })
+	return names, nil
+}
+
+func (m *Memory) ConvertItemNameToItemID(ctx context.Context, itemName string) (int32, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var itemID int32
+	found := false
+	for _, item := range m.items {
+		if strings.EqualFold(item.Name, itemName) && (!found || item.ItemID < itemID) {
+			itemID, found = item.ItemID, true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("failed to scan row value for item name lookup: %w", sql.ErrNoRows)
+	}
+	return itemID, nil
+}
+
+func (m *Memory) SpecialCurrencies(ctx context.Context) ([]*postgres.ItemName, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	seen := make(map[int32]bool)
+	var currencies []*postgres.ItemName
+	for _, item := range m.items {
+		if item.SpecialCurrencyCount <= 0 || seen[item.SpecialCurrencyItemID] {
+			continue
+		}
+		currency, ok := m.items[item.SpecialCurrencyItemID]
+		if !ok {
+			continue
+		}
+		seen[currency.ItemID] = true
+		currencies = append(currencies, &postgres.ItemName{ItemID: currency.ItemID, Name: currency.Name})
+	}
+	sort.Slice(currencies, func(i, j int) bool { return currencies[i].Name < currencies[j].Name })
+	return currencies, nil
+}
+
+// RecipesDetailsForItemID matches Postgres in returning empty details, not an
+// error, for an item nothing crafts.
+func (m *Memory) RecipesDetailsForItemID(ctx context.Context, itemID int32) (*postgres.RecipeDetails, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	details := &postgres.RecipeDetails{}
+	for _, recipe := range m.recipes {
+		if recipe.CraftedItemID != itemID {
+			continue
+		}
+		crafted, ok := m.items[recipe.CraftedItemID]
+		if !ok {
+			continue
+		}
+		for _, ing := range recipe.Ingredients {
+			item, ok := m.items[ing.ItemID]
+			if !ok {
+				continue
+			}
+			if details.CraftedItemID == 0 {
+				details.CraftedItemName = crafted.Name
+				details.CraftedItemCount = recipe.CraftedItemCount
+				details.CraftedItemID = crafted.ItemID
+				details.HighQualityable = crafted.HighQualityable
+			}
+			ingredient := &postgres.Ingredient{
+				ItemID:          item.ItemID,
+				Name:            item.Name,
+				Count:           ing.Quantity,
+				GilPrice:        int32(item.GilPrice),
+				HighQualityable: item.HighQualityable,
+			}
+			if item.Type == "Crystal" {
+				details.Crystals = append(details.Crystals, ingredient)
+			} else {
+				details.Ingredients = append(details.Ingredients, ingredient)
+			}
+		}
+	}
+	return details, nil
+}
+
+// publicWorlds returns the public worlds ordered by datacenter then name.
+func (m *Memory) publicWorlds() []*World {
+	var worlds []*World
+	for _, w := range m.worlds {
+		if w.IsPublic {
+			worlds = append(worlds, w)
+		}
+	}
+	sort.Slice(worlds, func(i, j int) bool {
+		if worlds[i].Datacenter != worlds[j].Datacenter {
+			return worlds[i].Datacenter < worlds[j].Datacenter
+		}
+		return worlds[i].Name < worlds[j].Name
+	})
+	return worlds
+}
+
+func inScope(w *World, scope *postgres.Scope) bool {
+	if scope == nil {
+		return true
+	}
+	switch scope.Kind {
+	case postgres.ScopeDatacenter:
+		return w.Datacenter == scope.Name
+	case postgres.ScopeRegion:
+		return w.Region == scope.Name
+	default:
+		return w.Name == scope.Name
+	}
+}
+
+func (m *Memory) AllScopes(ctx context.Context) ([]*postgres.Scope, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var scopes []*postgres.Scope
+	seenDatacenters := make(map[string]bool)
+	seenRegions := make(map[string]bool)
+	for _, w := range m.publicWorlds() {
+		scopes = append(scopes, &postgres.Scope{Kind: postgres.ScopeWorld, Name: w.Name})
+		if !seenDatacenters[w.Datacenter] {
+			seenDatacenters[w.Datacenter] = true
+			scopes = append(scopes, &postgres.Scope{Kind: postgres.ScopeDatacenter, Name: w.Datacenter})
+		}
+		if w.Region != "" && !seenRegions[w.Region] {
+			seenRegions[w.Region] = true
+			scopes = append(scopes, &postgres.Scope{Kind: postgres.ScopeRegion, Name: w.Region})
+		}
+	}
+	return scopes, nil
+}
+
+func (m *Memory) ResolveScope(ctx context.Context, name string) (*postgres.Scope, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	worlds := m.publicWorlds()
+	for _, w := range worlds {
+		if strings.EqualFold(w.Name, name) {
+			return &postgres.Scope{Kind: postgres.ScopeWorld, Name: w.Name}, nil
+		}
+	}
+	for _, w := range worlds {
+		if strings.EqualFold(w.Datacenter, name) {
+			return &postgres.Scope{Kind: postgres.ScopeDatacenter, Name: w.Datacenter}, nil
+		}
+	}
+	for _, w := range m.worlds {
+		if w.Region != "" && strings.EqualFold(w.Region, name) {
+			return &postgres.Scope{Kind: postgres.ScopeRegion, Name: w.Region}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", name, postgres.ErrUnknownScope)
+}
+
+func (m *Memory) WorldIDsInScope(ctx context.Context, scope *postgres.Scope) ([]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var worldIDs []int
+	for _, w := range m.publicWorlds() {
+		if inScope(w, scope) {
+			worldIDs = append(worldIDs, w.WorldID)
+		}
+	}
+	slices.Sort(worldIDs)
+	return worldIDs, nil
+}
+
+func (m *Memory) WorldIDFromWorldName(ctx context.Context, worldName string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, w := range m.worlds {
+		if w.Name == worldName {
+			return w.WorldID, nil
+		}
+	}
+	return 0, fmt.Errorf("failed to get world ID for world: %s: %w", worldName, sql.ErrNoRows)
+}
+
+// WriteUniversalisPriceData mirrors the Postgres writer, including skipping
+// items or worlds it doesn't know about as the foreign keys would.
+func (m *Memory) WriteUniversalisPriceData(ctx context.Context, upd *universalis.UniversalisPriceData) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	successCount := 0
+	for _, priceData := range upd.Items {
+		if priceData.ItemID <= 0 || priceData.WorldID <= 0 {
+			m.logger.Warnf("unexpected negative values found: %+v", priceData)
+			continue
+		}
+		if _, ok := m.items[int32(priceData.ItemID)]; !ok {
+			m.logger.Errorf("failed to write price: unknown item %d", priceData.ItemID)
+			continue
+		}
+		if _, ok := m.worlds[priceData.WorldID]; !ok {
+			m.logger.Errorf("failed to write price: unknown world %d", priceData.WorldID)
+			continue
+		}
+
+		ps := &PriceSnapshot{
+			ItemID:         priceData.ItemID,
+			WorldID:        priceData.WorldID,
+			UpdateTime:     time.UnixMilli(priceData.LastUploadTime).UTC(),
+			NQSaleVelocity: int(math.Round(priceData.NqSaleVelocity)),
+			HQSaleVelocity: int(math.Round(priceData.HqSaleVelocity)),
+			MinPriceNQ:     priceData.MinPriceNQ,
+			MinPriceHQ:     priceData.MinPriceHQ,
+		}
+		for _, l := range priceData.Listings {
+			ps.Listings = append(ps.Listings, SnapshotListing{
+				PricePerUnit: l.PricePerUnit,
+				Quantity:     l.Quantity,
+				HighQuality:  l.Hq,
+			})
+		}
+		m.addPrice(ps)
+		successCount += 1 + len(ps.Listings)
+	}
+
+	if successCount == 0 {
+		return 0, fmt.Errorf("all writes failed, see logs")
+	}
+	return successCount, nil
+}
+
+// snapshotsInScope calls fn for the latest snapshot of every item matching the
+// predicate on every world in scope.
+func (m *Memory) snapshotsInScope(scope *postgres.Scope, publicOnly bool, match func(*Item) bool, fn func(*Item, *World, *PriceSnapshot)) {
+	for key, ps := range m.prices {
+		item, ok := m.items[int32(key.itemID)]
+		if !ok || !match(item) {
+			continue
+		}
+		w, ok := m.worlds[key.worldID]
+		if !ok || (publicOnly && !w.IsPublic) || !inScope(w, scope) {
+			continue
+		}
+		fn(item, w, ps)
+	}
+}
+
+// worldMinimums is the expensive price query: the cheapest HQ and NQ listing
+// of each matching item on each world.
+func (m *Memory) worldMinimums(scope *postgres.Scope, match func(*Item) bool) []*postgres.AllWorldsPriceRowExpensive {
+	var rows []*postgres.AllWorldsPriceRowExpensive
+	m.snapshotsInScope(scope, false, match, func(item *Item, w *World, ps *PriceSnapshot) {
+		for _, hq := range []bool{true, false} {
+			minPrice, ok := ps.minListing(func(listingHQ bool) bool { return listingHQ == hq })
+			if !ok {
+				continue
+			}
+			rows = append(rows, &postgres.AllWorldsPriceRowExpensive{
+				Name:        item.Name,
+				WorldName:   w.Name,
+				Datacenter:  w.Datacenter,
+				MinPrice:    minPrice,
+				HighQuality: hq,
+			})
+		}
+	})
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.HighQuality != b.HighQuality {
+			return a.HighQuality
+		}
+		if a.Datacenter != b.Datacenter {
+			return a.Datacenter < b.Datacenter
+		}
+		if a.MinPrice != b.MinPrice {
+			return a.MinPrice < b.MinPrice
+		}
+		return a.WorldName < b.WorldName
+	})
+	return rows
+}
+
+func (m *Memory) GetPriceForItemIDScopedExpensive(ctx context.Context, itemID int, scope *postgres.Scope) ([]*postgres.AllWorldsPriceRowExpensive, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.worldMinimums(scope, func(item *Item) bool {
+		return item.Marketable && int(item.ItemID) == itemID
+	}), nil
+}
+
+func (m *Memory) GetPriceForItemNameScopedExpensive(ctx context.Context, itemName string, scope *postgres.Scope) ([]*postgres.AllWorldsPriceRowExpensive, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.worldMinimums(scope, func(item *Item) bool {
+		return item.Marketable && strings.EqualFold(item.Name, itemName)
+	}), nil
+}
+
+func (m *Memory) CurrentListingsInDatacenter(ctx context.Context, itemIDs []int32, datacenter string) ([]*postgres.ListingRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var listings []*postgres.ListingRow
+	scope := &postgres.Scope{Kind: postgres.ScopeDatacenter, Name: datacenter}
+	match := func(item *Item) bool { return slices.Contains(itemIDs, item.ItemID) }
+	m.snapshotsInScope(scope, true, match, func(item *Item, w *World, ps *PriceSnapshot) {
+		for _, l := range ps.Listings {
+			listings = append(listings, &postgres.ListingRow{
+				ItemID:       item.ItemID,
+				ItemName:     item.Name,
+				WorldName:    w.Name,
+				PricePerUnit: l.PricePerUnit,
+				Quantity:     l.Quantity,
+				HighQuality:  l.HighQuality,
+			})
+		}
+	})
+	sort.SliceStable(listings, func(i, j int) bool {
+		if listings[i].ItemID != listings[j].ItemID {
+			return listings[i].ItemID < listings[j].ItemID
+		}
+		return listings[i].PricePerUnit < listings[j].PricePerUnit
+	})
+	return listings, nil
+}
+
+func (m *Memory) VendorArbitrage(ctx context.Context, scope *postgres.Scope, minMarkup float64, limit int) ([]*postgres.VendorArbitrageRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var arbitrage []*postgres.VendorArbitrageRow
+	match := func(item *Item) bool { return item.GilPrice > 0 && item.Marketable }
+	m.snapshotsInScope(scope, true, match, func(item *Item, w *World, ps *PriceSnapshot) {
+		minPrice, ok := ps.minListing(anyQuality)
+		if !ok || float64(minPrice) < float64(item.GilPrice)*minMarkup {
+			return
+		}
+		arbitrage = append(arbitrage, &postgres.VendorArbitrageRow{
+			ItemID:       item.ItemID,
+			Name:         item.Name,
+			WorldName:    w.Name,
+			Datacenter:   w.Datacenter,
+			GilPrice:     item.GilPrice,
+			MinPrice:     minPrice,
+			SaleVelocity: ps.saleVelocity(),
+		})
+	})
+	sort.SliceStable(arbitrage, func(i, j int) bool {
+		a, b := arbitrage[i], arbitrage[j]
+		if a.ProfitPerUnit()*a.SaleVelocity != b.ProfitPerUnit()*b.SaleVelocity {
+			return a.ProfitPerUnit()*a.SaleVelocity > b.ProfitPerUnit()*b.SaleVelocity
+		}
+		if a.ProfitPerUnit() != b.ProfitPerUnit() {
+			return a.ProfitPerUnit() > b.ProfitPerUnit()
+		}
+		return a.WorldName < b.WorldName
+	})
+	return truncate(arbitrage, limit), nil
+}
+
+func (m *Memory) CurrencyValue(ctx context.Context, currencyItemID int32, scope *postgres.Scope, minVelocity int, limit int) ([]*postgres.CurrencyValueRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var values []*postgres.CurrencyValueRow
+	match := func(item *Item) bool {
+		return item.SpecialCurrencyItemID == currencyItemID && item.SpecialCurrencyCount > 0 && item.Marketable
+	}
+	m.snapshotsInScope(scope, true, match, func(item *Item, w *World, ps *PriceSnapshot) {
+		minPrice, ok := ps.minListing(anyQuality)
+		if !ok || ps.saleVelocity() < minVelocity {
+			return
+		}
+		values = append(values, &postgres.CurrencyValueRow{
+			ItemID:        item.ItemID,
+			Name:          item.Name,
+			WorldName:     w.Name,
+			CurrencyCount: item.SpecialCurrencyCount,
+			MinPrice:      minPrice,
+			SaleVelocity:  ps.saleVelocity(),
+		})
+	})
+	sort.SliceStable(values, func(i, j int) bool {
+		if values[i].GilPerCurrency() != values[j].GilPerCurrency() {
+			return values[i].GilPerCurrency() > values[j].GilPerCurrency()
+		}
+		return values[i].WorldName < values[j].WorldName
+	})
+	return truncate(values, limit), nil
+}
+
+func (m *Memory) GatheringProfitability(ctx context.Context, scope *postgres.Scope, filter postgres.GatheringFilter, limit int) ([]*postgres.GatheringRow, error) {
+	var origins, types []string
+	switch filter.Class {
+	case "":
+		origins = []string{postgres.OriginGathering, postgres.OriginFishing}
+	case postgres.GatheringFisher:
+		origins = []string{postgres.OriginFishing}
+	case postgres.GatheringMiner, postgres.GatheringBotanist:
+		origins = []string{postgres.OriginGathering}
+		types = filter.Class.ItemTypes()
+	default:
+		return nil, fmt.Errorf("unknown gathering class %s", filter.Class)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var gathering []*postgres.GatheringRow
+	match := func(item *Item) bool {
+		if !item.Marketable || item.ItemLevel < filter.MinItemLevel {
+			return false
+		}
+		if filter.MaxItemLevel > 0 && item.ItemLevel > filter.MaxItemLevel {
+			return false
+		}
+		return types == nil || slices.Contains(types, item.Type)
+	}
+	m.snapshotsInScope(scope, true, match, func(item *Item, w *World, ps *PriceSnapshot) {
+		minPrice, ok := ps.minListing(anyQuality)
+		if !ok {
+			return
+		}
+		// Like the join on item_origins, an item gets a row per matching origin.
+		for _, origin := range item.Origins {
+			if !slices.Contains(origins, origin) {
+				continue
+			}
+			gathering = append(gathering, &postgres.GatheringRow{
+				ItemID:       item.ItemID,
+				Name:         item.Name,
+				Type:         item.Type,
+				ItemLevel:    item.ItemLevel,
+				Origin:       origin,
+				WorldName:    w.Name,
+				MinPrice:     minPrice,
+				SaleVelocity: ps.saleVelocity(),
+			})
+		}
+	})
+	sort.SliceStable(gathering, func(i, j int) bool {
+		if gathering[i].GilPerDay() != gathering[j].GilPerDay() {
+			return gathering[i].GilPerDay() > gathering[j].GilPerDay()
+		}
+		return gathering[i].WorldName < gathering[j].WorldName
+	})
+	return truncate(gathering, limit), nil
+}
+
+func matchesSelector(item *Item, s postgres.ItemSelector) bool {
+	if !item.Marketable || item.ItemLevel < s.MinItemLevel {
+		return false
+	}
+	if s.MaxItemLevel > 0 && item.ItemLevel > s.MaxItemLevel {
+		return false
+	}
+	return len(s.Types) == 0 || slices.Contains(s.Types, item.Type)
+}
+
+// scopedPrices are an item's cheapest listings across the public worlds in
+// scope, zero where there are none, and its sales per day across them.
+type scopedPrices struct {
+	nq, hq   int
+	velocity int
+}
+
+func (m *Memory) pricesInScope(scope *postgres.Scope) map[int32]*scopedPrices {
+	prices := make(map[int32]*scopedPrices)
+	m.snapshotsInScope(scope, true, func(*Item) bool { return true }, func(item *Item, w *World, ps *PriceSnapshot) {
+		p, ok := prices[item.ItemID]
+		if !ok {
+			p = &scopedPrices{}
+			prices[item.ItemID] = p
+		}
+		p.velocity += ps.saleVelocity()
+		for _, l := range ps.Listings {
+			cheapest := &p.nq
+			if l.HighQuality {
+				cheapest = &p.hq
+			}
+			if *cheapest == 0 || l.PricePerUnit < *cheapest {
+				*cheapest = l.PricePerUnit
+			}
+		}
+	})
+	return prices
+}
+
+func (m *Memory) CraftProfitRanking(ctx context.Context, scope *postgres.Scope, filter postgres.CraftProfitFilter, limit int) ([]*postgres.CraftProfitRow, error) {
+	// Like the netted CTE, profits are ranked after the filter's fees.
+	netProfit := func(r *postgres.CraftProfitRow) int { return r.Profit - filter.Fees.Fee(r.Sale()) }
+	sortKey := map[postgres.CraftProfitSort]func(r *postgres.CraftProfitRow) (float64, bool){
+		postgres.CraftProfitSortProfit: func(r *postgres.CraftProfitRow) (float64, bool) { return float64(netProfit(r)), true },
+		postgres.CraftProfitSortDaily: func(r *postgres.CraftProfitRow) (float64, bool) {
+			return float64(netProfit(r) * r.SaleVelocity / r.Yield), true
+		},
+		// Like NULLIF, materials costing nothing have no margin and sort last.
+		postgres.CraftProfitSortMargin: func(r *postgres.CraftProfitRow) (float64, bool) { return r.Margin(), r.Cost() != 0 },
+	}
+	sortBy := filter.SortBy
+	if sortBy == "" {
+		sortBy = postgres.CraftProfitSortProfit
+	}
+	key, ok := sortKey[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown craft profit sort %s", sortBy)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	prices := m.pricesInScope(scope)
+	priceOf := func(item *Item) scopedPrices {
+		if p, ok := prices[item.ItemID]; ok {
+			return *p
+		}
+		return scopedPrices{}
+	}
+
+	var ranking []*postgres.CraftProfitRow
+	for _, recipe := range m.recipes {
+		crafted, ok := m.items[recipe.CraftedItemID]
+		if !ok || !matchesSelector(crafted, filter.Items) {
+			continue
+		}
+		sale := priceOf(crafted)
+		r := &postgres.CraftProfitRow{
+			ItemID:          crafted.ItemID,
+			Name:            crafted.Name,
+			Type:            crafted.Type,
+			ItemLevel:       crafted.ItemLevel,
+			Yield:           int(recipe.CraftedItemCount),
+			HighQualityable: crafted.HighQualityable,
+			MinPriceNQ:      sale.nq,
+			MinPriceHQ:      sale.hq,
+			SaleVelocity:    sale.velocity,
+		}
+		materials, crystals := 0, 0
+		for _, ing := range recipe.Ingredients {
+			item, ok := m.items[ing.ItemID]
+			if !ok {
+				continue
+			}
+			materials++
+			if item.Type == "Crystal" {
+				crystals++
+			}
+			// Vendors only sell NQ, and are used when they're cheaper.
+			p := priceOf(item)
+			nq := p.nq
+			if item.GilPrice > 0 && (nq == 0 || item.GilPrice < nq) {
+				nq = item.GilPrice
+			}
+			hq := nq
+			if item.HighQualityable {
+				hq = p.hq
+			}
+			if filter.FreeCrystals && item.Type == "Crystal" {
+				continue
+			}
+			if nq == 0 {
+				r.MissingNQ++
+			}
+			if hq == 0 {
+				r.MissingHQ++
+			}
+			r.CostNQ += int(ing.Quantity) * nq
+			r.CostHQ += int(ing.Quantity) * hq
+		}
+		// Like the join on recipe_ingredients, recipes without materials are skipped.
+		if materials == 0 {
+			continue
+		}
+		// Every recipe takes crystals, so one listing none can't be costed.
+		if crystals == 0 && !filter.FreeCrystals {
+			continue
+		}
+		if !crafted.HighQualityable {
+			r.CostHQ, r.MissingHQ = 0, 0
+		}
+
+		nqOK := r.MissingNQ == 0 && sale.nq > 0
+		hqOK := crafted.HighQualityable && r.MissingHQ == 0 && sale.hq > 0
+		profitNQ := r.Yield*sale.nq - r.CostNQ
+		profitHQ := r.Yield*sale.hq - r.CostHQ
+		switch {
+		case hqOK && (!nqOK || profitHQ > profitNQ):
+			r.Profit, r.BestHighQuality = profitHQ, true
+		case nqOK:
+			r.Profit = profitNQ
+		default:
+			continue
+		}
+		ranking = append(ranking, r)
+	}
+
+	sort.SliceStable(ranking, func(i, j int) bool {
+		a, b := ranking[i], ranking[j]
+		ka, aOK := key(a)
+		kb, bOK := key(b)
+		if aOK != bOK {
+			return aOK
+		}
+		if ka != kb {
+			return ka > kb
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ItemID < b.ItemID
+	})
+	return truncate(ranking, limit), nil
+}
+
+func truncate[T any](rows []T, limit int) []T {
+	if len(rows) > limit {
+		return rows[:limit]
+	}
+	return rows
+}
+
+func (m *Memory) GetSettings(ctx context.Context, kind postgres.SettingsOwner, ownerID string) (*postgres.Settings, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s := m.settings[settingsKey{kind: kind, ownerID: ownerID}]
+	return &s, nil
+}
+
+func (m *Memory) SaveSettings(ctx context.Context, kind postgres.SettingsOwner, ownerID string, s *postgres.Settings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settings[settingsKey{kind: kind, ownerID: ownerID}] = *s
+	return nil
+}
+
+func (m *Memory) LoadPollStates(ctx context.Context) ([]*postgres.PollState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var states []*postgres.PollState
+	for _, ps := range m.pollStates {
+		ps := ps
+		ps.WorldName = ""
+		if w, ok := m.worlds[ps.WorldID]; ok {
+			ps.WorldName = w.Name
+		}
+		states = append(states, &ps)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].HotlistName != states[j].HotlistName {
+			return states[i].HotlistName < states[j].HotlistName
+		}
+		return states[i].WorldName < states[j].WorldName
+	})
+	return states, nil
+}
+
+func (m *Memory) SavePollState(ctx context.Context, ps *postgres.PollState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pollStates[pollStateKey{hotlist: ps.HotlistName, worldID: ps.WorldID}] = *ps
+	return nil
+}
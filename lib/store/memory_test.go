@@ -0,0 +1,304 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"profiteeringway/lib/fees"
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/universalis"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func loadTestdata(t *testing.T) *Memory {
+	t.Helper()
+	m, err := LoadFixtures("testdata", zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("failed to load fixtures: %v", err)
+	}
+	return m
+}
+
+func TestResolveScope(t *testing.T) {
+	m := loadTestdata(t)
+	tests := []struct {
+		name string
+		want *postgres.Scope
+	}{
+		{"gilgamesh", &postgres.Scope{Kind: postgres.ScopeWorld, Name: "Gilgamesh"}},
+		{"AETHER", &postgres.Scope{Kind: postgres.ScopeDatacenter, Name: "Aether"}},
+		{"europe", &postgres.Scope{Kind: postgres.ScopeRegion, Name: "Europe"}},
+		{"Cloudtest01", nil},
+		{"Atlantis", nil},
+	}
+	for _, tt := range tests {
+		got, err := m.ResolveScope(context.Background(), tt.name)
+		if tt.want == nil {
+			if !errors.Is(err, postgres.ErrUnknownScope) {
+				t.Errorf("%s: expected ErrUnknownScope, got %v, %v", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil || *got != *tt.want {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func formatPriceRows(rows []*postgres.AllWorldsPriceRowExpensive) []string {
+	var out []string
+	for _, r := range rows {
+		out = append(out, fmt.Sprintf("%s/%s hq=%v %d", r.Datacenter, r.WorldName, r.HighQuality, r.MinPrice))
+	}
+	return out
+}
+
+func TestPricesInScope(t *testing.T) {
+	m := loadTestdata(t)
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		scope *postgres.Scope
+		want  []string
+	}{
+		{
+			name:  "datacenter",
+			scope: &postgres.Scope{Kind: postgres.ScopeDatacenter, Name: "Aether"},
+			want:  []string{"Aether/Jenova hq=true 1400", "Aether/Gilgamesh hq=true 1500", "Aether/Jenova hq=false 850", "Aether/Gilgamesh hq=false 900"},
+		},
+		{
+			name:  "world",
+			scope: &postgres.Scope{Kind: postgres.ScopeWorld, Name: "Lich"},
+			want:  []string{"Light/Lich hq=true 1200", "Light/Lich hq=false 700"},
+		},
+		{
+			name: "everywhere",
+			want: []string{
+				"Aether/Jenova hq=true 1400", "Aether/Gilgamesh hq=true 1500", "Light/Lich hq=true 1200",
+				"Aether/Jenova hq=false 850", "Aether/Gilgamesh hq=false 900", "Light/Lich hq=false 700",
+			},
+		},
+	}
+	for _, tt := range tests {
+		byID, err := m.GetPriceForItemIDScopedExpensive(ctx, 44000, tt.scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byName, err := m.GetPriceForItemNameScopedExpensive(ctx, "rroneek steak", tt.scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, got := range [][]string{formatPriceRows(byID), formatPriceRows(byName)} {
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRecipeAndItems(t *testing.T) {
+	m := loadTestdata(t)
+	ctx := context.Background()
+
+	recipe, err := m.RecipesDetailsForItemID(ctx, 44000)
+	if err != nil || recipe.CraftedItemName != "Rroneek Steak" || recipe.CraftedItemCount != 3 || len(recipe.Ingredients) != 2 {
+		t.Fatalf("got %+v, %v", recipe, err)
+	}
+	if len(recipe.Crystals) != 1 || recipe.Crystals[0].Name != "Wind Shard" || recipe.Crystals[0].Count != 8 {
+		t.Errorf("expected 8 wind shards apart from the ingredients, got %+v", recipe.Crystals)
+	}
+	if salt := recipe.Ingredients[1]; salt.Name != "Rock Salt" || salt.GilPrice != 10 {
+		t.Errorf("expected rock salt at 10 gil, got %+v", salt)
+	}
+	if none, err := m.RecipesDetailsForItemID(ctx, 5518); err != nil || len(none.Ingredients) != 0 {
+		t.Errorf("expected empty details for an uncraftable item, got %+v, %v", none, err)
+	}
+
+	if id, err := m.ConvertItemNameToItemID(ctx, "ROCK SALT"); err != nil || id != 5518 {
+		t.Errorf("got %d, %v", id, err)
+	}
+	if _, err := m.ConvertItemNameToItemID(ctx, "Rock Sugar"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+
+	currencies, _ := m.SpecialCurrencies(ctx)
+	if len(currencies) != 1 || currencies[0].ItemID != 28 {
+		t.Errorf("expected poetics, got %v", currencies)
+	}
+}
+
+func TestHeuristics(t *testing.T) {
+	m := loadTestdata(t)
+	ctx := context.Background()
+	na := &postgres.Scope{Kind: postgres.ScopeRegion, Name: "North-America"}
+
+	arbitrage, _ := m.VendorArbitrage(ctx, na, 2, 10)
+	if len(arbitrage) != 2 || arbitrage[0].WorldName != "Gilgamesh" || arbitrage[1].WorldName != "Jenova" {
+		t.Errorf("expected salt on Gilgamesh then Jenova, got %+v", arbitrage)
+	}
+	if arbitrage, _ := m.VendorArbitrage(ctx, na, 3, 10); len(arbitrage) != 1 {
+		t.Errorf("expected Jenova's markup to fall short, got %+v", arbitrage)
+	}
+
+	values, _ := m.CurrencyValue(ctx, 28, na, 10, 10)
+	if len(values) != 1 || values[0].GilPerCurrency() != 80 {
+		t.Errorf("expected 80 gil per poetic, got %+v", values)
+	}
+
+	tests := []struct {
+		filter postgres.GatheringFilter
+		want   []string
+	}{
+		{postgres.GatheringFilter{}, []string{"Dawntrail Trout", "Ra'Kaznar Ore", "Rroneek Chuck", "Wind Shard"}},
+		{postgres.GatheringFilter{Class: postgres.GatheringMiner}, []string{"Ra'Kaznar Ore", "Wind Shard"}},
+		{postgres.GatheringFilter{Class: postgres.GatheringFisher}, []string{"Dawntrail Trout"}},
+		{postgres.GatheringFilter{MinItemLevel: 695}, []string{"Dawntrail Trout", "Ra'Kaznar Ore"}},
+	}
+	for _, tt := range tests {
+		rows, err := m.GatheringProfitability(ctx, na, tt.filter, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range rows {
+			got = append(got, r.Name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%+v: got %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestCraftProfitRankingSorts(t *testing.T) {
+	m := loadTestdata(t)
+	ctx := context.Background()
+	// A cheap, fast selling meal to rank against the steak.
+	m.AddItems(&Item{ItemID: 44001, Type: "Meal", Name: "Salted Miq'abob", ItemLevel: 710, Marketable: true})
+	m.AddRecipes(&Recipe{RecipeID: 35001, CraftedItemID: 44001, CraftedItemCount: 1, Ingredients: []RecipeIngredient{{ItemID: 5518, Quantity: 1}, {ItemID: 5, Quantity: 1}}})
+	m.AddPrices(&PriceSnapshot{ItemID: 44001, WorldID: 63, NQSaleVelocity: 1000, Listings: []SnapshotListing{{PricePerUnit: 500, Quantity: 1}}})
+
+	na := &postgres.Scope{Kind: postgres.ScopeRegion, Name: postgres.DefaultRegion}
+	tests := []struct {
+		sortBy postgres.CraftProfitSort
+		want   string
+	}{
+		{"", "[Rroneek Steak 3926 Salted Miq'abob 487]"},
+		{postgres.CraftProfitSortDaily, "[Salted Miq'abob 487 Rroneek Steak 3926]"},
+		{postgres.CraftProfitSortMargin, "[Salted Miq'abob 487 Rroneek Steak 3926]"},
+	}
+	for _, tt := range tests {
+		rows, err := m.CraftProfitRanking(ctx, na, postgres.CraftProfitFilter{SortBy: tt.sortBy}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []interface{}
+		for _, r := range rows {
+			got = append(got, r.Name, r.Profit)
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("sorted by %q: got %v, want %s", tt.sortBy, got, tt.want)
+		}
+	}
+
+	if _, err := m.CraftProfitRanking(ctx, na, postgres.CraftProfitFilter{SortBy: "fame"}, 10); err == nil {
+		t.Error("expected an error sorting by an unknown column")
+	}
+}
+
+func TestCraftProfitRankingIsNetOfFees(t *testing.T) {
+	m := loadTestdata(t)
+	ctx := context.Background()
+	// Grosses more than the steak, but its sale is big enough that the tax
+	// takes all of that.
+	m.AddItems(
+		&Item{ItemID: 44002, Type: "Meal", Name: "Dragon Steak", ItemLevel: 710, Marketable: true},
+		&Item{ItemID: 44003, Type: "Ingredient", Name: "Dragon Loin", ItemLevel: 700, Marketable: true},
+	)
+	m.AddRecipes(&Recipe{RecipeID: 35002, CraftedItemID: 44002, CraftedItemCount: 1, Ingredients: []RecipeIngredient{{ItemID: 44003, Quantity: 1}, {ItemID: 5, Quantity: 1}}})
+	m.AddPrices(
+		&PriceSnapshot{ItemID: 44002, WorldID: 63, Listings: []SnapshotListing{{PricePerUnit: 100000, Quantity: 1}}},
+		&PriceSnapshot{ItemID: 44003, WorldID: 63, Listings: []SnapshotListing{{PricePerUnit: 95000, Quantity: 1}}},
+	)
+
+	na := &postgres.Scope{Kind: postgres.ScopeRegion, Name: postgres.DefaultRegion}
+	tests := []struct {
+		fees fees.Model
+		want string
+	}{
+		{fees.Model{}, "[Dragon Steak Rroneek Steak]"},
+		{fees.Model{TaxRate: 0.05}, "[Rroneek Steak Dragon Steak]"},
+	}
+	for _, tt := range tests {
+		rows, err := m.CraftProfitRanking(ctx, na, postgres.CraftProfitFilter{Fees: tt.fees}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range rows {
+			got = append(got, r.Name)
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("with %s: got %v, want %s", tt.fees, got, tt.want)
+		}
+	}
+}
+
+func TestCraftProfitRankingSkipsRecipesWithoutCrystals(t *testing.T) {
+	m := loadTestdata(t)
+	ctx := context.Background()
+	m.AddItems(&Item{ItemID: 44001, Type: "Meal", Name: "Salted Miq'abob", ItemLevel: 710, Marketable: true})
+	m.AddRecipes(&Recipe{RecipeID: 35001, CraftedItemID: 44001, CraftedItemCount: 1, Ingredients: []RecipeIngredient{{ItemID: 5518, Quantity: 1}}})
+	m.AddPrices(&PriceSnapshot{ItemID: 44001, WorldID: 63, NQSaleVelocity: 1000, Listings: []SnapshotListing{{PricePerUnit: 500, Quantity: 1}}})
+
+	na := &postgres.Scope{Kind: postgres.ScopeRegion, Name: postgres.DefaultRegion}
+	tests := []struct {
+		freeCrystals bool
+		want         string
+	}{
+		{false, "[Rroneek Steak]"},
+		{true, "[Rroneek Steak Salted Miq'abob]"},
+	}
+	for _, tt := range tests {
+		rows, err := m.CraftProfitRanking(ctx, na, postgres.CraftProfitFilter{FreeCrystals: tt.freeCrystals}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range rows {
+			got = append(got, r.Name)
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("free crystals %v: got %v, want %s", tt.freeCrystals, got, tt.want)
+		}
+	}
+}
+
+func TestWriteMovesOldPricesToHistory(t *testing.T) {
+	m := loadTestdata(t)
+	ctx := context.Background()
+
+	written, err := m.WriteUniversalisPriceData(ctx, &universalis.UniversalisPriceData{Items: map[string]universalis.ItemPriceData{
+		"44000": {ItemID: 44000, WorldID: 63, LastUploadTime: 1722600000000, Listings: []universalis.Listing{{PricePerUnit: 1100, Quantity: 1, Hq: true}}},
+		"1":     {ItemID: 1, WorldID: 63},
+	}})
+	if err != nil || written != 2 {
+		t.Fatalf("expected a price and a listing written, got %d, %v", written, err)
+	}
+	if history := m.History(); len(history) != 1 || history[0].Listings[0].PricePerUnit != 1500 {
+		t.Errorf("expected the old snapshot in history, got %+v", history)
+	}
+
+	rows, _ := m.GetPriceForItemIDScopedExpensive(ctx, 44000, &postgres.Scope{Kind: postgres.ScopeWorld, Name: "Gilgamesh"})
+	if got := formatPriceRows(rows); fmt.Sprint(got) != "[Aether/Gilgamesh hq=true 1100]" {
+		t.Errorf("expected only the new listing, got %v", got)
+	}
+
+	if _, err := m.WriteUniversalisPriceData(ctx, &universalis.UniversalisPriceData{Items: map[string]universalis.ItemPriceData{
+		"44000": {ItemID: 44000, WorldID: 12345},
+	}}); err == nil {
+		t.Error("expected an error when every write fails")
+	}
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/universalis"
+)
+
+// Store is everything the bot and the poller read from or write to the
+// database. It's implemented by *postgres.Postgres, and by *Memory for tests
+// and for developing without a database.
+type Store interface {
+	// Items.
+	AllItemNames(ctx context.Context) ([]*postgres.ItemName, error)
+	ConvertItemNameToItemID(ctx context.Context, itemName string) (int32, error)
+	SpecialCurrencies(ctx context.Context) ([]*postgres.ItemName, error)
+
+	// Recipes.
+	RecipesDetailsForItemID(ctx context.Context, itemID int32) (*postgres.RecipeDetails, error)
+
+	// Worlds, datacenters, and regions.
+	AllScopes(ctx context.Context) ([]*postgres.Scope, error)
+	ResolveScope(ctx context.Context, name string) (*postgres.Scope, error)
+	WorldIDsInScope(ctx context.Context, scope *postgres.Scope) ([]int, error)
+	WorldIDFromWorldName(ctx context.Context, worldName string) (int, error)
+
+	// Price writes.
+	WriteUniversalisPriceData(ctx context.Context, upd *universalis.UniversalisPriceData) (int, error)
+
+	// Price reads.
+	GetPriceForItemIDScopedExpensive(ctx context.Context, itemID int, scope *postgres.Scope) ([]*postgres.AllWorldsPriceRowExpensive, error)
+	GetPriceForItemNameScopedExpensive(ctx context.Context, itemName string, scope *postgres.Scope) ([]*postgres.AllWorldsPriceRowExpensive, error)
+	CurrentListingsInDatacenter(ctx context.Context, itemIDs []int32, datacenter string) ([]*postgres.ListingRow, error)
+	VendorArbitrage(ctx context.Context, scope *postgres.Scope, minMarkup float64, limit int) ([]*postgres.VendorArbitrageRow, error)
+	CurrencyValue(ctx context.Context, currencyItemID int32, scope *postgres.Scope, minVelocity int, limit int) ([]*postgres.CurrencyValueRow, error)
+	GatheringProfitability(ctx context.Context, scope *postgres.Scope, filter postgres.GatheringFilter, limit int) ([]*postgres.GatheringRow, error)
+	CraftProfitRanking(ctx context.Context, scope *postgres.Scope, filter postgres.CraftProfitFilter, limit int) ([]*postgres.CraftProfitRow, error)
+
+	// Bot settings and poller state.
+	GetSettings(ctx context.Context, kind postgres.SettingsOwner, ownerID string) (*postgres.Settings, error)
+	SaveSettings(ctx context.Context, kind postgres.SettingsOwner, ownerID string, s *postgres.Settings) error
+	LoadPollStates(ctx context.Context) ([]*postgres.PollState, error)
+	SavePollState(ctx context.Context, ps *postgres.PollState) error
+}
+
+var (
+	_ Store = (*postgres.Postgres)(nil)
+	_ Store = (*Memory)(nil)
+)
@@ -0,0 +1,66 @@
+package universalis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Universalis keeps a limited number of sales per item and world, this asks
+// for all of them within the window.
+const maxHistoryEntries = 99999
+
+type HistoryData struct {
+	Items map[string]ItemHistory `json:"items"`
+}
+
+type ItemHistory struct {
+	ItemID  int    `json:"itemID"`
+	WorldID int    `json:"worldID"`
+	Entries []Sale `json:"entries"`
+}
+
+type Sale struct {
+	Hq           bool `json:"hq"`
+	PricePerUnit int  `json:"pricePerUnit"`
+	Quantity     int  `json:"quantity"`
+	// Seconds since the epoch, unlike the milliseconds of lastUploadTime.
+	Timestamp int64 `json:"timestamp"`
+}
+
+func (s Sale) Time() time.Time {
+	return time.Unix(s.Timestamp, 0).UTC()
+}
+
+// GetHistory fetches the sales of the items on a world within the window.
+func (c *Client) GetHistory(ctx context.Context, worldID int, itemIDs []int, within time.Duration) (*HistoryData, error) {
+	q := url.Values{}
+	q.Set("entriesWithin", strconv.Itoa(int(within.Seconds())))
+	q.Set("entriesToReturn", strconv.Itoa(maxHistoryEntries))
+	endpointUrl, err := buildUrl(q, "history", strconv.Itoa(worldID), joinItemIDs(itemIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.get(ctx, endpointUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single item comes back on its own rather than in items.
+	history := &HistoryData{}
+	if len(itemIDs) == 1 {
+		item := ItemHistory{}
+		err = json.Unmarshal(body, &item)
+		history.Items = map[string]ItemHistory{strconv.Itoa(item.ItemID): item}
+	} else {
+		err = json.Unmarshal(body, history)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to unmarshal json response to %s from Universalis: %w", ErrDecode, endpointUrl, err)
+	}
+	return history, nil
+}
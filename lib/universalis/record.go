@@ -0,0 +1,236 @@
+package universalis
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ErrNotRecorded is returned by Replayer for a request it has no recording of.
+var ErrNotRecorded = errors.New("no recorded response for request")
+
+// recording is one saved response. JSON bodies are kept as JSON so recordings
+// can be read and edited by hand, anything else as text.
+type recording struct {
+	URL    string          `json:"url"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Text   string          `json:"text,omitempty"`
+}
+
+// recordingName names the file a URL's response is saved in.
+func recordingName(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:8]) + ".json"
+}
+
+// Recorder is an http.RoundTripper that passes requests on and saves every
+// response to a directory, scrubbed of player names, for a Replayer to serve.
+// A later response for the same URL replaces the earlier one.
+type Recorder struct {
+	dir      string
+	next     http.RoundTripper
+	scrubber *Scrubber
+}
+
+// NewRecorder records responses from next, or http.DefaultTransport if nil.
+func NewRecorder(dir string, next http.RoundTripper) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{
+		dir:      dir,
+		next:     next,
+		scrubber: NewScrubber(),
+	}, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response to record: %w", err)
+	}
+	// The caller gets what was actually sent, only the recording is scrubbed.
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	rec := &recording{
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+	}
+	if json.Valid(body) {
+		if rec.Body, err = r.scrubber.Scrub(body); err != nil {
+			return nil, err
+		}
+	} else {
+		rec.Text = string(body)
+	}
+
+	// Left unescaped, the ampersands in URLs stay readable.
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", "\t")
+	if err := e.Encode(rec); err != nil {
+		return nil, fmt.Errorf("failed to encode recording of %s: %w", rec.URL, err)
+	}
+	if err := os.WriteFile(filepath.Join(r.dir, recordingName(rec.URL)), b.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save recording of %s: %w", rec.URL, err)
+	}
+	return resp, nil
+}
+
+// Replayer is an http.RoundTripper serving the responses a Recorder saved,
+// matched by URL, without touching the network.
+type Replayer struct {
+	recordings map[string]*recording
+}
+
+// NewReplayer loads every recording in dir. Recordings are matched by the URL
+// inside them, so files can be renamed or written by hand.
+func NewReplayer(dir string) (*Replayer, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recordings: %w", err)
+	}
+	r := &Replayer{
+		recordings: make(map[string]*recording, len(paths)),
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recording: %w", err)
+		}
+		rec := &recording{}
+		if err := json.Unmarshal(b, rec); err != nil {
+			return nil, fmt.Errorf("failed to decode recording %s: %w", path, err)
+		}
+		r.recordings[rec.URL] = rec
+	}
+	return r, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec, ok := r.recordings[req.URL.String()]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotRecorded, req.URL)
+	}
+	body := []byte(rec.Body)
+	if rec.Text != "" {
+		body = []byte(rec.Text)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+		StatusCode:    rec.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// scrubbedFields are the response fields identifying players: retainers, the
+// characters behind them, crafters, and buyers.
+var scrubbedFields = map[string]bool{
+	"retainerName": true,
+	"retainerID":   true,
+	"sellerID":     true,
+	"creatorName":  true,
+	"creatorID":    true,
+	"buyerName":    true,
+}
+
+// Scrubber replaces player names and IDs in Universalis responses with
+// placeholders. The same value always gets the same placeholder, so listings
+// by one retainer can still be told apart from another's.
+type Scrubber struct {
+	mu      sync.Mutex
+	aliases map[string]map[string]string
+}
+
+func NewScrubber() *Scrubber {
+	return &Scrubber{
+		aliases: make(map[string]map[string]string),
+	}
+}
+
+// Scrub returns the JSON body with every player name and ID replaced.
+func (s *Scrubber) Scrub(body []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to decode response to scrub: %w", err)
+	}
+
+	s.mu.Lock()
+	v = s.scrub(v)
+	s.mu.Unlock()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode scrubbed response: %w", err)
+	}
+	return b, nil
+}
+
+func (s *Scrubber) scrub(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		// In key order, so a recording gets the same aliases every time.
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if scrubbedFields[key] {
+				v[key] = s.alias(key, v[key])
+				continue
+			}
+			v[key] = s.scrub(v[key])
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = s.scrub(v[i])
+		}
+	}
+	return v
+}
+
+func (s *Scrubber) alias(field string, value interface{}) interface{} {
+	original, ok := value.(string)
+	if !ok || original == "" {
+		return value
+	}
+	aliases, ok := s.aliases[field]
+	if !ok {
+		aliases = make(map[string]string)
+		s.aliases[field] = aliases
+	}
+	alias, ok := aliases[original]
+	if !ok {
+		alias = fmt.Sprintf("%s-%d", field, len(aliases)+1)
+		aliases[original] = alias
+	}
+	return alias
+}
@@ -0,0 +1,115 @@
+package universalis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// fakeUniversalis answers world 63 with a listing, rate limits world 40, and
+// counts requests.
+func fakeUniversalis(requests *int) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		*requests += 1
+		status, body := http.StatusOK, `{"items": {"44000": {"itemID": 44000, "worldID": 63, "minPriceHQ": 1500,
+"listings": [
+	{"pricePerUnit": 1500, "quantity": 3, "hq": true, "retainerName": "Cookie Jar", "sellerID": "abc123"},
+	{"pricePerUnit": 1800, "quantity": 1, "hq": true, "retainerName": "Cookie Jar", "sellerID": "abc123"},
+	{"pricePerUnit": 1900, "quantity": 1, "hq": true, "retainerName": "Pantry", "creatorName": "Req Uest"}
+],
+"recentHistory": [{"pricePerUnit": 1450, "buyerName": "Someone Else"}]}}}`
+		if strings.Contains(req.URL.Path, "/40/") {
+			status, body = http.StatusTooManyRequests, "slow down"
+		}
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	requests := 0
+	recorder, err := NewRecorder(dir, fakeUniversalis(&requests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := NewClient(&http.Client{Transport: recorder})
+	recorded, err := live.GetItemData(ctx, 63, []int{44000})
+	if err != nil {
+		t.Fatalf("GetItemData() = %v", err)
+	}
+	if _, err := live.GetItemData(ctx, 40, []int{44000}); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("GetItemData() = %v, want ErrRateLimited", err)
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(paths) != 2 {
+		t.Fatalf("recorded %d responses, want 2", len(paths))
+	}
+	for _, path := range paths {
+		b, _ := os.ReadFile(path)
+		for _, name := range []string{"Cookie Jar", "Pantry", "abc123", "Req Uest", "Someone Else"} {
+			if strings.Contains(string(b), name) {
+				t.Errorf("%s still contains %q", path, name)
+			}
+		}
+	}
+
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := NewClient(&http.Client{Transport: replayer})
+	replayed, err := replay.GetItemData(ctx, 63, []int{44000})
+	if err != nil {
+		t.Fatalf("replayed GetItemData() = %v", err)
+	}
+	got, want := replayed.Items["44000"], recorded.Items["44000"]
+	if got.MinPriceHQ != want.MinPriceHQ || len(got.Listings) != len(want.Listings) || got.Listings[2] != want.Listings[2] {
+		t.Errorf("replayed %+v, want %+v", got, want)
+	}
+	if _, err := replay.GetItemData(ctx, 40, []int{44000}); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("replayed GetItemData() = %v, want ErrRateLimited", err)
+	}
+	if _, err := replay.GetItemData(ctx, 63, []int{44001}); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("GetItemData() for an unrecorded URL = %v, want ErrNotRecorded", err)
+	}
+	if requests != 2 {
+		t.Errorf("made %d requests, want only the 2 recorded", requests)
+	}
+}
+
+func TestScrubberAliasesConsistently(t *testing.T) {
+	s := NewScrubber()
+	got, err := s.Scrub([]byte(`{"listings": [{"retainerName": "A", "pricePerUnit": 12345678901}, {"retainerName": "B"}, {"retainerName": "A", "sellerID": "A"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"listings":[{"pricePerUnit":12345678901,"retainerName":"retainerName-1"},{"retainerName":"retainerName-2"},{"retainerName":"retainerName-1","sellerID":"sellerID-1"}]}`
+	if string(got) != want {
+		t.Errorf("Scrub() = %s, want %s", got, want)
+	}
+
+	// Aliases carry over between responses.
+	got, _ = s.Scrub([]byte(`{"retainerName": "B"}`))
+	if string(got) != `{"retainerName":"retainerName-2"}` {
+		t.Errorf("Scrub() = %s, want B's earlier alias", got)
+	}
+}
@@ -1,7 +1,9 @@
 package universalis
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +14,21 @@ import (
 
 const universalisBaseAPIUrl = "https://universalis.app/api/v2"
 
+// How fast everything fetching from Universalis, the hub and backfills alike,
+// lets itself make requests.
+const (
+	RequestsPerSecond = 5
+	RequestBurst      = 2
+)
+
+// Errors from GetItemData wrap one of these, so callers can tell failures apart.
+var (
+	ErrRequest          = errors.New("universalis request failed")
+	ErrRateLimited      = errors.New("universalis rate limited the request")
+	ErrUnexpectedStatus = errors.New("universalis returned an unexpected status")
+	ErrDecode           = errors.New("universalis response could not be decoded")
+)
+
 func fieldFilters() []string {
 	return []string{
 		"items.minPriceNQ",
@@ -28,63 +45,135 @@ func fieldFilters() []string {
 }
 
 type UniversalisPriceData struct {
-	Items map[string]struct {
-		ItemID         int   `json:"itemID"`
-		WorldID        int   `json:"worldID"`
-		LastUploadTime int64 `json:"lastUploadTime"`
-		Listings       []struct {
-			PricePerUnit int  `json:"pricePerUnit"`
-			Quantity     int  `json:"quantity"`
-			Hq           bool `json:"hq"`
-		} `json:"listings"`
-		NqSaleVelocity float64 `json:"nqSaleVelocity"`
-		HqSaleVelocity float64 `json:"hqSaleVelocity"`
-		MinPriceNQ     int     `json:"minPriceNQ"`
-		MinPriceHQ     int     `json:"minPriceHQ"`
-	} `json:"items"`
+	Items map[string]ItemPriceData `json:"items"`
+}
+
+type ItemPriceData struct {
+	ItemID         int       `json:"itemID"`
+	WorldID        int       `json:"worldID"`
+	LastUploadTime int64     `json:"lastUploadTime"`
+	Listings       []Listing `json:"listings"`
+	NqSaleVelocity float64   `json:"nqSaleVelocity"`
+	HqSaleVelocity float64   `json:"hqSaleVelocity"`
+	MinPriceNQ     int       `json:"minPriceNQ"`
+	MinPriceHQ     int       `json:"minPriceHQ"`
+}
+
+type Listing struct {
+	PricePerUnit int  `json:"pricePerUnit"`
+	Quantity     int  `json:"quantity"`
+	Hq           bool `json:"hq"`
+}
+
+// ErrorType names the kind of failure for metrics and logs.
+func ErrorType(err error) string {
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrUnexpectedStatus):
+		return "status"
+	case errors.Is(err, ErrDecode):
+		return "decode"
+	case errors.Is(err, ErrRequest):
+		return "request"
+	}
+	return "other"
+}
+
+// Client fetches from Universalis through an HTTP client, whose transport can
+// be a Recorder or Replayer to work from saved responses.
+type Client struct {
+	httpClient *http.Client
 }
 
+func NewClient(httpClient *http.Client) *Client {
+	return &Client{
+		httpClient: httpClient,
+	}
+}
+
+var defaultClient = NewClient(http.DefaultClient)
+
 func GetItemData(worldID int, itemIDs []int) (*UniversalisPriceData, error) {
-	endpointUrl, err := url.Parse(universalisBaseAPIUrl)
+	return GetItemDataContext(context.Background(), worldID, itemIDs)
+}
+
+// GetItemDataContext is GetItemData that gives up when ctx is done.
+func GetItemDataContext(ctx context.Context, worldID int, itemIDs []int) (*UniversalisPriceData, error) {
+	return defaultClient.GetItemData(ctx, worldID, itemIDs)
+}
+
+// GetItemData fetches current prices for the items on a world.
+func (c *Client) GetItemData(ctx context.Context, worldID int, itemIDs []int) (*UniversalisPriceData, error) {
+	q := url.Values{}
+	q.Set("entriesWithin", "36000")
+	q.Set("statsWithin", "36000000")
+	q.Set("fields", strings.Join(fieldFilters(), ","))
+	endpointUrl, err := buildUrl(q, strconv.Itoa(worldID), joinItemIDs(itemIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.get(ctx, endpointUrl)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build Universalis URL: %w", err)
+		return nil, err
 	}
+	priceData := &UniversalisPriceData{}
+	err = json.Unmarshal(body, priceData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to unmarshal json response to %s from Universalis: %w", ErrDecode, endpointUrl, err)
+	}
+	return priceData, nil
+}
 
+func joinItemIDs(itemIDs []int) string {
 	var stringItemIDs []string
 
 	for _, id := range itemIDs {
 		stringItemIDs = append(stringItemIDs, strconv.Itoa(id))
 	}
 
-	itemIDsJoined := strings.Join(stringItemIDs, ",")
-
-	endpointUrl = endpointUrl.JoinPath(strconv.Itoa(worldID), itemIDsJoined)
-	q := endpointUrl.Query()
-	q.Set("entriesWithin", "36000")
-	q.Set("statsWithin", "36000000")
-	q.Set("fields", strings.Join(fieldFilters(), ","))
+	return strings.Join(stringItemIDs, ",")
+}
 
+// buildUrl joins path onto the API URL, leaving the commas between item IDs
+// and fields unescaped.
+func buildUrl(q url.Values, path ...string) (string, error) {
+	endpointUrl, err := url.Parse(universalisBaseAPIUrl)
+	if err != nil {
+		return "", fmt.Errorf("failed to build Universalis URL: %w", err)
+	}
+	endpointUrl = endpointUrl.JoinPath(path...)
 	endpointUrl.RawQuery = q.Encode()
 
 	finalizedUrl, err := url.PathUnescape(endpointUrl.String())
 	if err != nil {
-		return nil, fmt.Errorf("failed to unescape constructed url: %w", err)
+		return "", fmt.Errorf("failed to unescape constructed url: %w", err)
 	}
+	return finalizedUrl, nil
+}
 
-	resp, err := http.Get(finalizedUrl)
+// get returns the body of a successful response.
+func (c *Client) get(ctx context.Context, endpointUrl string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpointUrl, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get item from Universalis: %w", err)
+		return nil, fmt.Errorf("failed to build Universalis request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get item from Universalis: %w", ErrRequest, err)
 	}
 
-	priceData := &UniversalisPriceData{}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response from Universalis: %w", err)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%w: %s", ErrRateLimited, resp.Status)
 	}
-	err = json.Unmarshal(body, priceData)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s from %s", ErrUnexpectedStatus, resp.Status, endpointUrl)
+	}
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal json response to %s from Universalis: %w", endpointUrl.String(), err)
+		return nil, fmt.Errorf("%w: failed to read response from Universalis: %w", ErrRequest, err)
 	}
-	return priceData, nil
+	return body, nil
 }
@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"profiteeringway/lib/backfill"
 	"profiteeringway/lib/discord"
+	"profiteeringway/lib/export"
+	"profiteeringway/lib/fees"
 	"profiteeringway/lib/hotlist"
+	"profiteeringway/lib/metrics"
 	"profiteeringway/lib/postgres"
+	"profiteeringway/lib/store"
+	"profiteeringway/lib/universalis"
 	"profiteeringway/secrets"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -82,6 +93,10 @@ func makeHotlist(worldIDs []int, itemIDs []int, name string) *hotlist.Hotlist {
 	}
 }
 
+func scopedHotlistName(name string, scope *postgres.Scope) string {
+	return fmt.Sprintf("%s (%s)", name, scope.Name)
+}
+
 func copyIntSlice(s []int) []int {
 	c := make([]int, len(s))
 
@@ -90,14 +105,11 @@ func copyIntSlice(s []int) []int {
 	return c
 }
 
-func dawntrailTierOneHotlists(p *postgres.Postgres) ([]*hotlist.Hotlist, error) {
+// dawntrailTierOneHotlists builds each hotlist once per scope, so every region
+// or datacenter polled gets its own schedule and stats.
+func dawntrailTierOneHotlists(p *postgres.Postgres, scopeNames []string) ([]*hotlist.Hotlist, error) {
 	var ret []*hotlist.Hotlist
 
-	worldIDs, err := p.NorthAmericanWorlds()
-	if err != nil {
-		return nil, fmt.Errorf("%w", err)
-	}
-
 	materiaIDs, err := p.DawntrailMateriaIDs()
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
@@ -128,20 +140,270 @@ func dawntrailTierOneHotlists(p *postgres.Postgres) ([]*hotlist.Hotlist, error)
 		return nil, fmt.Errorf("%w", err)
 	}
 
-	ret = append(ret, makeHotlist(copyIntSlice(worldIDs), materiaIDs, HotlistDawntrailMateria))
-	ret = append(ret, makeHotlist(copyIntSlice(worldIDs), consumableIDs, HotlistDawntrailConsumables))
-	ret = append(ret, makeHotlist(copyIntSlice(worldIDs), craftedIDs, HotlistDawntrailTierOneCraftedEquipment))
-	ret = append(ret, makeHotlist(copyIntSlice(worldIDs), materialsOneID, HotlistDawntrailMaterialsSetOne))
-	ret = append(ret, makeHotlist(copyIntSlice(worldIDs), materialsTwoID, HotlistDawntrailMaterialsSetTwo))
-	ret = append(ret, makeHotlist(copyIntSlice(worldIDs), crystalIDs, HotlistCrystals))
+	for _, scopeName := range scopeNames {
+		scope, err := p.ResolveScope(context.Background(), strings.TrimSpace(scopeName))
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		worldIDs, err := p.WorldIDsInScope(context.Background(), scope)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		ret = append(ret, makeHotlist(copyIntSlice(worldIDs), materiaIDs, scopedHotlistName(HotlistDawntrailMateria, scope)))
+		ret = append(ret, makeHotlist(copyIntSlice(worldIDs), consumableIDs, scopedHotlistName(HotlistDawntrailConsumables, scope)))
+		ret = append(ret, makeHotlist(copyIntSlice(worldIDs), craftedIDs, scopedHotlistName(HotlistDawntrailTierOneCraftedEquipment, scope)))
+		ret = append(ret, makeHotlist(copyIntSlice(worldIDs), materialsOneID, scopedHotlistName(HotlistDawntrailMaterialsSetOne, scope)))
+		ret = append(ret, makeHotlist(copyIntSlice(worldIDs), materialsTwoID, scopedHotlistName(HotlistDawntrailMaterialsSetTwo, scope)))
+		ret = append(ret, makeHotlist(copyIntSlice(worldIDs), crystalIDs, scopedHotlistName(HotlistCrystals, scope)))
+	}
 
 	return ret, nil
 }
 
+// parseCommandRoles reads the -command_roles flag, command=role|role pairs
+// separated by commas.
+func parseCommandRoles(flagValue string) (map[string][]string, error) {
+	roles := make(map[string][]string)
+	for _, pair := range strings.Split(flagValue, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		command, roleIDs, ok := strings.Cut(pair, "=")
+		if !ok || command == "" || roleIDs == "" {
+			return nil, fmt.Errorf("malformed command roles %q, expected command=role|role", pair)
+		}
+		for _, roleID := range strings.Split(roleIDs, "|") {
+			roles[command] = append(roles[command], strings.TrimSpace(roleID))
+		}
+	}
+	return roles, nil
+}
+
+// universalisFetch picks how the hub reaches Universalis: live, live while
+// recording to recordDir, or replaying from replayDir.
+func universalisFetch(recordDir string, replayDir string) (hotlist.FetchFunc, error) {
+	switch {
+	case recordDir != "" && replayDir != "":
+		return nil, errors.New("-record_universalis and -replay_universalis can't be used together")
+	case recordDir != "":
+		recorder, err := universalis.NewRecorder(recordDir, nil)
+		if err != nil {
+			return nil, err
+		}
+		return universalis.NewClient(&http.Client{Transport: recorder}).GetItemData, nil
+	case replayDir != "":
+		replayer, err := universalis.NewReplayer(replayDir)
+		if err != nil {
+			return nil, err
+		}
+		return universalis.NewClient(&http.Client{Transport: replayer}).GetItemData, nil
+	}
+	return universalis.GetItemDataContext, nil
+}
+
+// runBackfill is the backfill subcommand, seeding the history tables with
+// Universalis sales for a hotlist or a list of items. It's safe to rerun, and
+// an interrupted run picks up where it stopped.
+func runBackfill(logger *zap.SugaredLogger, args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	hotlistName := fs.String("hotlist", "", fmt.Sprintf("hotlist whose items to backfill, e.g. %q", HotlistDawntrailMateria))
+	items := fs.String("items", "", "comma separated item IDs to backfill, instead of a hotlist")
+	scopes := fs.String("scopes", postgres.DefaultRegion, "comma separated worlds, datacenters, or regions to backfill")
+	days := fs.Int("days", 7, "days of history to backfill")
+	batchSize := fs.Int("batch_size", backfill.DefaultBatchSize, "items per Universalis request")
+	fs.Parse(args)
+	if (*hotlistName == "") == (*items == "") {
+		return errors.New("backfill needs one of -hotlist or -items")
+	}
+
+	pg, err := postgres.NewPostgres(secrets.PostgresConnectionString, logger)
+	if err != nil {
+		return err
+	}
+	defer pg.CleanUp()
+	if err := pg.InitializePriceTables(); err != nil {
+		return err
+	}
+	if err := pg.InitializeBackfillTables(); err != nil {
+		return err
+	}
+
+	scopeNames := strings.Split(*scopes, ",")
+	var itemIDs []int
+	if *items != "" {
+		for _, id := range strings.Split(*items, ",") {
+			itemID, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil {
+				return fmt.Errorf("malformed item ID %q: %w", id, err)
+			}
+			itemIDs = append(itemIDs, itemID)
+		}
+	} else {
+		// Hotlists have the same items whatever their scope.
+		hotlists, err := dawntrailTierOneHotlists(pg, scopeNames[:1])
+		if err != nil {
+			return err
+		}
+		for _, h := range hotlists {
+			if strings.HasPrefix(strings.ToLower(h.Name), strings.ToLower(*hotlistName)+" (") {
+				itemIDs = h.ItemIDs
+			}
+		}
+		if itemIDs == nil {
+			return fmt.Errorf("no hotlist named %q, pick one of %s", *hotlistName, strings.Join([]string{
+				HotlistDawntrailMateria,
+				HotlistDawntrailConsumables,
+				HotlistDawntrailTierOneCraftedEquipment,
+				HotlistDawntrailMaterialsSetOne,
+				HotlistDawntrailMaterialsSetTwo,
+				HotlistCrystals,
+			}, ", "))
+		}
+	}
+
+	var worldIDs []int
+	seen := make(map[int]bool)
+	for _, scopeName := range scopeNames {
+		scope, err := pg.ResolveScope(context.Background(), strings.TrimSpace(scopeName))
+		if err != nil {
+			return err
+		}
+		ids, err := pg.WorldIDsInScope(context.Background(), scope)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				worldIDs = append(worldIDs, id)
+			}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	b := backfill.NewBackfiller(pg, universalis.NewClient(http.DefaultClient).GetHistory, logger)
+	result, err := b.Run(ctx, backfill.Config{
+		WorldIDs:  worldIDs,
+		ItemIDs:   itemIDs,
+		Window:    time.Duration(*days) * 24 * time.Hour,
+		BatchSize: *batchSize,
+	})
+	fmt.Printf("%d requests: %d item and world pairs backfilled, %d already done, %d failed, %d rows written\n",
+		result.Requests, result.Backfilled, result.Skipped, result.Failed, result.Rows)
+	return err
+}
+
+// runExport is the export subcommand, building a report command's results as
+// the bot would for a server with no settings and writing them to a file, e.g.
+// export -out crafts.xlsx crafts -category dawntrail_consumables.
+func runExport(logger *zap.SugaredLogger, args []string, fixtures string, taxRates string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("out", "", "file to write, as CSV or an XLSX workbook by its extension, e.g. crafts.xlsx")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: export -out <file> <%s> [-option value ...]\n", strings.Join(discord.ExportableCommands(), "|"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *out == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("export needs -out and a command")
+	}
+	format, err := export.FormatForPath(*out)
+	if err != nil {
+		return err
+	}
+	rates, err := fees.ParseRates(taxRates)
+	if err != nil {
+		return fmt.Errorf("failed to parse -tax_rates: %w", err)
+	}
+
+	var db store.Store
+	if fixtures != "" {
+		mem, err := store.LoadFixtures(fixtures, logger)
+		if err != nil {
+			return fmt.Errorf("failed to load fixtures: %w", err)
+		}
+		db = mem
+	} else {
+		pg, err := postgres.NewPostgres(secrets.PostgresConnectionString, logger)
+		if err != nil {
+			return err
+		}
+		defer pg.CleanUp()
+		db = pg
+	}
+
+	table, err := discord.Export(context.Background(), db, logger, rates, fs.Arg(0), fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := export.Write(f, format, table); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", *out, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", *out)
+	return nil
+}
+
+// runCommandSync diffs the commands in code against those registered with
+// Discord and prints the changes, applying them unless dryRun is set. It only
+// uses the REST API, so it can run alongside a live bot.
+func runCommandSync(logger *zap.SugaredLogger, guildID string, dryRun bool) error {
+	sess, err := discordgo.New(fmt.Sprintf("Bot %s", secrets.DiscordBotToken))
+	if err != nil {
+		return fmt.Errorf("failed to create Discord session: %w", err)
+	}
+	dc := discord.NewDiscord(sess, secrets.DiscordApplicationID, logger, nil)
+	changes, err := dc.SyncCommands(guildID, dryRun)
+	if err != nil {
+		return err
+	}
+
+	scope := "global"
+	if guildID != "" {
+		scope = fmt.Sprintf("guild %s", guildID)
+	}
+	if len(changes) == 0 {
+		fmt.Printf("%s commands are up to date\n", scope)
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if dryRun {
+		fmt.Printf("dry run: %d %s command(s) would change\n", len(changes), scope)
+	} else {
+		fmt.Printf("overwrote %s commands, %d changed\n", scope, len(changes))
+	}
+	return nil
+}
+
 func main() {
 	bot := flag.Bool("bot", false, "set this to enable bot behavior")
 	polling := flag.Bool("polling", false, "set this enable polling behavior")
+	pollScopes := flag.String("poll_scopes", postgres.DefaultRegion, "comma separated regions or datacenters to poll hotlists on")
+	adaptivePolling := flag.Bool("adaptive_polling", false, "with -polling, refresh each item on its own interval based on how fast it sells")
 	production := flag.Bool("production", false, "set this to go to production mode")
+	metricsAddress := flag.String("metrics_address", "", "address to serve Prometheus metrics on at /metrics, e.g. :9100; empty disables it")
+	globalCommands := flag.Bool("global_commands", false, "with -bot, register commands globally instead of per guild")
+	syncCommands := flag.Bool("sync-commands", false, "sync Discord commands with their definitions in code, then exit")
+	syncGuild := flag.String("sync_guild", "", "with -sync-commands, the guild to sync; empty syncs global commands")
+	dryRun := flag.Bool("dry_run", false, "with -sync-commands, print what would change without changing it")
+	commandRoles := flag.String("command_roles", "", "with -bot, roles allowed to run each command, e.g. pricedown=<role id>|<role id>,shopping=<role id>; unlisted commands are open to everyone")
+	taxRates := flag.String("tax_rates", "", "with -bot or export, market tax percentages of cities this week, e.g. kugane=3,gridania=0; unlisted cities are taxed 5%")
+	fixtures := flag.String("fixtures", "", "directory of JSON fixtures to serve from memory instead of Postgres, for development and export")
+	recordUniversalis := flag.String("record_universalis", "", "with -polling, save every Universalis response, scrubbed of player names, to this directory")
+	replayUniversalis := flag.String("replay_universalis", "", "with -polling, serve Universalis responses recorded with -record_universalis from this directory instead of the network")
 	flag.Parse()
 
 	logger, _, err := loggerInit(*production)
@@ -154,22 +416,104 @@ func main() {
 	sugar.Infow("process init:",
 		"bot", *bot,
 		"polling", *polling,
-		"production", *production)
+		"adaptive_polling", *adaptivePolling,
+		"poll_scopes", *pollScopes,
+		"production", *production,
+		"metrics_address", *metricsAddress,
+		"global_commands", *globalCommands,
+		"sync_commands", *syncCommands,
+		"fixtures", *fixtures,
+		"record_universalis", *recordUniversalis,
+		"replay_universalis", *replayUniversalis)
 
-	pg, err := postgres.NewPostgres(secrets.PostgresConnectionString, sugar)
-	defer pg.CleanUp()
-	if err != nil {
-		fmt.Printf("failed to initialize postgres: %v\n", err)
+	if flag.Arg(0) == "backfill" {
+		if err := runBackfill(sugar, flag.Args()[1:]); err != nil {
+			fmt.Printf("backfill failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "export" {
+		if err := runExport(sugar, flag.Args()[1:], *fixtures, *taxRates); err != nil {
+			fmt.Printf("export failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *syncCommands {
+		if err := runCommandSync(sugar, *syncGuild, *dryRun); err != nil {
+			fmt.Printf("failed to sync commands: %v\n", err)
+			os.Exit(1)
+		}
 		return
 	}
-	pg.InitializePriceTables()
 
-	hub := hotlist.NewHotlistHub(pg, sugar)
+	if *metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default.Handler())
+		metricsServer := &http.Server{
+			Addr:    *metricsAddress,
+			Handler: mux,
+		}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				sugar.Errorw("metrics server stopped",
+					"suberror", err)
+			}
+		}()
+		defer metricsServer.Shutdown(context.Background())
+	}
+
+	var db store.Store
+	var pg *postgres.Postgres
+	if *fixtures != "" {
+		// The hotlists are picked by SQL against the full items table.
+		if *polling {
+			fmt.Println("-polling needs Postgres and can't be used with -fixtures")
+			return
+		}
+		mem, err := store.LoadFixtures(*fixtures, sugar)
+		if err != nil {
+			fmt.Printf("failed to load fixtures: %v\n", err)
+			return
+		}
+		db = mem
+	} else {
+		pg, err = postgres.NewPostgres(secrets.PostgresConnectionString, sugar)
+		defer pg.CleanUp()
+		if err != nil {
+			fmt.Printf("failed to initialize postgres: %v\n", err)
+			return
+		}
+		pg.InitializePriceTables()
+		if err := pg.InitializeRegionTables(); err != nil {
+			sugar.Errorw("failed to initialize region tables",
+				"suberror", err)
+		}
+		if err := pg.InitializeSettingsTable(); err != nil {
+			sugar.Errorw("failed to initialize settings table",
+				"suberror", err)
+		}
+		if err := pg.InitializePollStateTable(); err != nil {
+			sugar.Errorw("failed to initialize poll state table",
+				"suberror", err)
+		}
+		db = pg
+	}
+
+	fetch, err := universalisFetch(*recordUniversalis, *replayUniversalis)
+	if err != nil {
+		fmt.Printf("failed to set up Universalis: %v\n", err)
+		return
+	}
+	hub := hotlist.NewHotlistHub(db, fetch, sugar)
 
 	// Universalis polling
 	if *polling {
 		var hotlists []*hotlist.Hotlist
-		hotlists, err = dawntrailTierOneHotlists(pg)
+		hotlists, err = dawntrailTierOneHotlists(pg, strings.Split(*pollScopes, ","))
 		if err != nil {
 			panic(fmt.Sprintf("%s", err))
 		}
@@ -178,7 +522,12 @@ func main() {
 		for _, hotlist := range hotlists {
 			hub.ConfiguredHotlists[hotlist.Name] = hotlist
 		}
-		if err := hub.BeginPollingAll(); err != nil {
+		if *adaptivePolling {
+			err = hub.BeginAdaptivePolling(hotlist.DefaultSchedulerConfig())
+		} else {
+			err = hub.BeginPollingAll()
+		}
+		if err != nil {
 			panic(fmt.Sprintf("%s", err))
 		}
 		sugar.Infow("began polling for hotlists",
@@ -191,7 +540,22 @@ func main() {
 		if err != nil {
 			panic(fmt.Sprintf("failed to connect to Discord: %s", err))
 		}
-		discord := discord.NewDiscord(sess, sugar, pg)
+		limits := discord.DefaultCommandLimitsConfig()
+		limits.CommandRoles, err = parseCommandRoles(*commandRoles)
+		if err != nil {
+			panic(fmt.Sprintf("%s", err))
+		}
+		rates, err := fees.ParseRates(*taxRates)
+		if err != nil {
+			panic(fmt.Sprintf("failed to parse -tax_rates: %s", err))
+		}
+		discord := discord.NewDiscord(sess, secrets.DiscordApplicationID, sugar, db)
+		discord.SetGlobalCommands(*globalCommands)
+		discord.SetCommandLimits(limits)
+		discord.SetFeeRates(rates)
+		if *polling && *adaptivePolling {
+			discord.SetInterestRecorder(hub)
+		}
 		if err := discord.Initialize(); err != nil {
 			panic(fmt.Sprintf("failed to initialize Discord bot user connection: %s", err))
 		}
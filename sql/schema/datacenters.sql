@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS datacenters (
+	name text PRIMARY KEY,
+	region text NOT NULL
+);